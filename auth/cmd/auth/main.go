@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,17 +9,24 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/go-kit/kit/endpoint"
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
 	"github.com/go-kit/log"
 	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 
+	"github.com/F1zm0n/uni-auth/migrations"
 	authv1 "github.com/F1zm0n/uni-auth/pb"
 	"github.com/F1zm0n/uni-auth/pkg/authendpoint"
 	"github.com/F1zm0n/uni-auth/pkg/authservice"
 	"github.com/F1zm0n/uni-auth/pkg/authtransport"
+	"github.com/F1zm0n/uni-auth/pkg/notify"
 	"github.com/F1zm0n/uni-auth/repository/postgres"
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/validate"
 )
 
 // var configPaths = []string{"../auth/config/", "/config."}
@@ -32,6 +40,17 @@ func main() {
 		grpcAddr  = viper.GetString("listen.grpc.port")
 	)
 	postgres := postgres.MustNewPostgresDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := postgres.DB()
+		if err == nil {
+			err = migrations.Run(context.Background(), db, os.Args[2:], os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	postgres.MustMigrateSchema()
 
 	var logger log.Logger
@@ -41,12 +60,44 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
-	// http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
+	db, err := postgres.DB()
+	if err != nil {
+		logger.Log("during", "connect", "err", err)
+		os.Exit(1)
+	}
+
+	policy, err := validate.LoadPasswordPolicy()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+	notifier, err := notify.NewProducerNotifier(viper.GetString("producer.addr"))
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+
+	oauthConfig, err := oauth.LoadConfig()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+
+	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 	var (
-		service     = authservice.New(logger, postgres)
-		endpoints   = authendpoint.New(service, logger)
+		service   = authservice.New(logger, postgres, notifier)
+		idemStore = idempotency.NewPostgresStore(db, viper.GetDuration("idempotency.ttl"))
+		endpoints = authendpoint.New(
+			service,
+			logger,
+			idemStore,
+			policy,
+			func(scope string) endpoint.Middleware {
+				return oauth.Middleware(oauthConfig.Audience, scope)
+			},
+		)
 		grpcServer  = authtransport.NewGRPCServer(endpoints, logger)
-		httpHandler = authtransport.NewHTTPServer(endpoints, logger)
+		httpHandler = authtransport.NewHTTPServer(endpoints, oauth.NewServer(oauthConfig), logger)
 	)
 
 	var g group.Group
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users(
+	id uuid PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	password BYTEA NOT NULL,
+	created_at TIMESTAMPTZ
+);
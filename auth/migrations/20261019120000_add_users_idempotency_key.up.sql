@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255) UNIQUE;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+	key VARCHAR(512) PRIMARY KEY,
+	request_hash BYTEA NOT NULL,
+	response BYTEA,
+	expires_at TIMESTAMPTZ NOT NULL
+);
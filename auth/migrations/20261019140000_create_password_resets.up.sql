@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS password_resets(
+	token_hash BYTEA PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets(user_id);
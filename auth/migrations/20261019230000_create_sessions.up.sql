@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions(
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	device VARCHAR(100) NOT NULL,
+	user_agent VARCHAR(512) NOT NULL,
+	ip VARCHAR(64) NOT NULL,
+	device_hash BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS sessions_user_id_device_hash_idx ON sessions(user_id, device_hash);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS magic_links(
+	token_hash BYTEA PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS magic_links_user_id_idx ON magic_links(user_id);
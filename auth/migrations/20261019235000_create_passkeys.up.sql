@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS passkeys(
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	credential_id BYTEA NOT NULL UNIQUE,
+	public_key BYTEA NOT NULL,
+	attestation_type VARCHAR(32) NOT NULL,
+	aaguid BYTEA NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	transports TEXT NOT NULL DEFAULT '',
+	backup_eligible BOOLEAN NOT NULL DEFAULT false,
+	backup_state BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS passkeys_user_id_idx ON passkeys(user_id);
+CREATE TABLE IF NOT EXISTS passkey_challenges(
+	id uuid PRIMARY KEY,
+	user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+	ceremony VARCHAR(16) NOT NULL,
+	data BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS passkey_challenges_expires_at_idx ON passkey_challenges(expires_at);
@@ -2,75 +2,563 @@ package authendpoint
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
+	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/log"
-	"github.com/sony/gobreaker"
+	"github.com/google/uuid"
 
 	"github.com/F1zm0n/uni-auth/pkg/authservice"
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 )
 
 type Set struct {
-	LoginEndpoint    endpoint.Endpoint
-	RegisterEndpoint endpoint.Endpoint
+	LoginEndpoint          endpoint.Endpoint
+	RegisterEndpoint       endpoint.Endpoint
+	ForgotPasswordEndpoint endpoint.Endpoint
+	ResetPasswordEndpoint  endpoint.Endpoint
+	RefreshEndpoint        endpoint.Endpoint
+	CreateAPIKeyEndpoint   endpoint.Endpoint
+	ListAPIKeysEndpoint    endpoint.Endpoint
+	RevokeAPIKeyEndpoint   endpoint.Endpoint
+	ExchangeAPIKeyEndpoint endpoint.Endpoint
+	IntrospectEndpoint     endpoint.Endpoint
+	ListSessionsEndpoint   endpoint.Endpoint
+	RevokeSessionEndpoint  endpoint.Endpoint
+	RevokeSessionsEndpoint endpoint.Endpoint
+	// RequestMagicLinkEndpoint and ConsumeMagicLinkEndpoint log users in
+	// by a link mailed to them instead of a password.
+	RequestMagicLinkEndpoint endpoint.Endpoint
+	ConsumeMagicLinkEndpoint endpoint.Endpoint
+	// The passkey endpoints are the two steps of the WebAuthn
+	// registration and authentication ceremonies.
+	BeginPasskeyRegistrationEndpoint  endpoint.Endpoint
+	FinishPasskeyRegistrationEndpoint endpoint.Endpoint
+	BeginPasskeyLoginEndpoint         endpoint.Endpoint
+	FinishPasskeyLoginEndpoint        endpoint.Endpoint
 }
 
-func New(svc authservice.Service, logger log.Logger) Set {
+func New(
+	svc authservice.Service,
+	logger log.Logger,
+	idem idempotency.Store,
+	policy validate.PasswordPolicy,
+	internal func(scope string) endpoint.Middleware,
+) Set {
 	var loginEndpoint endpoint.Endpoint
 	{
 		loginEndpoint = makeLoginEndpoint(svc)
-		loginEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		loginEndpoint = resilience.Middleware(
+			"login",
+			resilience.Load("endpoints.login"),
+			logger,
 		)(
 			loginEndpoint,
 		)
+		loginEndpoint = validate.PolicyMiddleware(policy)(loginEndpoint)
 		loginEndpoint = LoggingMiddleware(log.With(logger, "method", "login"))(loginEndpoint)
 	}
 
 	var registerEndpoint endpoint.Endpoint
 	{
 		registerEndpoint = makeRegisterEndpoint(svc)
-		registerEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		registerEndpoint = idempotency.Middleware[RegisterResponse](
+			idem,
+			"register",
 		)(
 			registerEndpoint,
 		)
+		registerEndpoint = resilience.Middleware(
+			"register",
+			resilience.Load("endpoints.register"),
+			logger,
+		)(
+			registerEndpoint,
+		)
+		registerEndpoint = validate.PolicyMiddleware(policy)(registerEndpoint)
+		// only services may register users, on behalf of verified emails
+		registerEndpoint = internal("register")(registerEndpoint)
 		registerEndpoint = LoggingMiddleware(
 			log.With(logger, "method", "register"),
 		)(
 			registerEndpoint,
 		)
 	}
+
+	var forgotPasswordEndpoint endpoint.Endpoint
+	{
+		forgotPasswordEndpoint = makeForgotPasswordEndpoint(svc)
+		forgotPasswordEndpoint = resilience.Middleware(
+			"forgot_password",
+			resilience.Load("endpoints.forgotpassword"),
+			logger,
+		)(
+			forgotPasswordEndpoint,
+		)
+		forgotPasswordEndpoint = validate.PolicyMiddleware(policy)(forgotPasswordEndpoint)
+		forgotPasswordEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "forgot_password"),
+		)(
+			forgotPasswordEndpoint,
+		)
+	}
+
+	var resetPasswordEndpoint endpoint.Endpoint
+	{
+		resetPasswordEndpoint = makeResetPasswordEndpoint(svc)
+		resetPasswordEndpoint = resilience.Middleware(
+			"reset_password",
+			resilience.Load("endpoints.resetpassword"),
+			logger,
+		)(
+			resetPasswordEndpoint,
+		)
+		resetPasswordEndpoint = validate.PolicyMiddleware(policy)(resetPasswordEndpoint)
+		resetPasswordEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "reset_password"),
+		)(
+			resetPasswordEndpoint,
+		)
+	}
+
+	var refreshEndpoint endpoint.Endpoint
+	{
+		refreshEndpoint = makeRefreshEndpoint(svc)
+		refreshEndpoint = resilience.Middleware(
+			"refresh",
+			resilience.Load("endpoints.refresh"),
+			logger,
+		)(
+			refreshEndpoint,
+		)
+		refreshEndpoint = validate.PolicyMiddleware(policy)(refreshEndpoint)
+		refreshEndpoint = LoggingMiddleware(log.With(logger, "method", "refresh"))(refreshEndpoint)
+	}
+
+	var createAPIKeyEndpoint endpoint.Endpoint
+	{
+		createAPIKeyEndpoint = makeCreateAPIKeyEndpoint(svc)
+		createAPIKeyEndpoint = resilience.Middleware(
+			"create_api_key",
+			resilience.Load("endpoints.createapikey"),
+			logger,
+		)(
+			createAPIKeyEndpoint,
+		)
+		createAPIKeyEndpoint = validate.PolicyMiddleware(policy)(createAPIKeyEndpoint)
+		createAPIKeyEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "create_api_key"),
+		)(
+			createAPIKeyEndpoint,
+		)
+	}
+
+	var listAPIKeysEndpoint endpoint.Endpoint
+	{
+		listAPIKeysEndpoint = makeListAPIKeysEndpoint(svc)
+		listAPIKeysEndpoint = resilience.Middleware(
+			"list_api_keys",
+			resilience.Load("endpoints.listapikeys"),
+			logger,
+		)(
+			listAPIKeysEndpoint,
+		)
+		listAPIKeysEndpoint = validate.PolicyMiddleware(policy)(listAPIKeysEndpoint)
+		listAPIKeysEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "list_api_keys"),
+		)(
+			listAPIKeysEndpoint,
+		)
+	}
+
+	var revokeAPIKeyEndpoint endpoint.Endpoint
+	{
+		revokeAPIKeyEndpoint = makeRevokeAPIKeyEndpoint(svc)
+		revokeAPIKeyEndpoint = resilience.Middleware(
+			"revoke_api_key",
+			resilience.Load("endpoints.revokeapikey"),
+			logger,
+		)(
+			revokeAPIKeyEndpoint,
+		)
+		revokeAPIKeyEndpoint = validate.PolicyMiddleware(policy)(revokeAPIKeyEndpoint)
+		revokeAPIKeyEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "revoke_api_key"),
+		)(
+			revokeAPIKeyEndpoint,
+		)
+	}
+
+	var exchangeAPIKeyEndpoint endpoint.Endpoint
+	{
+		exchangeAPIKeyEndpoint = makeExchangeAPIKeyEndpoint(svc)
+		exchangeAPIKeyEndpoint = resilience.Middleware(
+			"exchange_api_key",
+			resilience.Load("endpoints.exchangeapikey"),
+			logger,
+		)(
+			exchangeAPIKeyEndpoint,
+		)
+		exchangeAPIKeyEndpoint = validate.PolicyMiddleware(policy)(exchangeAPIKeyEndpoint)
+		exchangeAPIKeyEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "exchange_api_key"),
+		)(
+			exchangeAPIKeyEndpoint,
+		)
+	}
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = makeIntrospectEndpoint(svc)
+		introspectEndpoint = resilience.Middleware(
+			"introspect",
+			resilience.Load("endpoints.introspect"),
+			logger,
+		)(
+			introspectEndpoint,
+		)
+		introspectEndpoint = validate.PolicyMiddleware(policy)(introspectEndpoint)
+		// RFC 7662 has introspection callers authenticate, so that tokens
+		// cannot be probed
+		introspectEndpoint = internal("introspect")(introspectEndpoint)
+		introspectEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "introspect"),
+		)(
+			introspectEndpoint,
+		)
+	}
+
+	var listSessionsEndpoint endpoint.Endpoint
+	{
+		listSessionsEndpoint = makeListSessionsEndpoint(svc)
+		listSessionsEndpoint = resilience.Middleware(
+			"list_sessions",
+			resilience.Load("endpoints.listsessions"),
+			logger,
+		)(
+			listSessionsEndpoint,
+		)
+		listSessionsEndpoint = validate.PolicyMiddleware(policy)(listSessionsEndpoint)
+		listSessionsEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "list_sessions"),
+		)(
+			listSessionsEndpoint,
+		)
+	}
+
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = makeRevokeSessionEndpoint(svc)
+		revokeSessionEndpoint = resilience.Middleware(
+			"revoke_session",
+			resilience.Load("endpoints.revokesession"),
+			logger,
+		)(
+			revokeSessionEndpoint,
+		)
+		revokeSessionEndpoint = validate.PolicyMiddleware(policy)(revokeSessionEndpoint)
+		revokeSessionEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "revoke_session"),
+		)(
+			revokeSessionEndpoint,
+		)
+	}
+
+	var revokeSessionsEndpoint endpoint.Endpoint
+	{
+		revokeSessionsEndpoint = makeRevokeSessionsEndpoint(svc)
+		revokeSessionsEndpoint = resilience.Middleware(
+			"revoke_sessions",
+			resilience.Load("endpoints.revokesessions"),
+			logger,
+		)(
+			revokeSessionsEndpoint,
+		)
+		revokeSessionsEndpoint = validate.PolicyMiddleware(policy)(revokeSessionsEndpoint)
+		revokeSessionsEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "revoke_sessions"),
+		)(
+			revokeSessionsEndpoint,
+		)
+	}
+
+	var requestMagicLinkEndpoint endpoint.Endpoint
+	{
+		requestMagicLinkEndpoint = makeRequestMagicLinkEndpoint(svc)
+		requestMagicLinkEndpoint = resilience.Middleware(
+			"request_magic_link",
+			resilience.Load("endpoints.requestmagiclink"),
+			logger,
+		)(
+			requestMagicLinkEndpoint,
+		)
+		requestMagicLinkEndpoint = validate.PolicyMiddleware(policy)(requestMagicLinkEndpoint)
+		requestMagicLinkEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "request_magic_link"),
+		)(
+			requestMagicLinkEndpoint,
+		)
+	}
+
+	var consumeMagicLinkEndpoint endpoint.Endpoint
+	{
+		consumeMagicLinkEndpoint = makeConsumeMagicLinkEndpoint(svc)
+		consumeMagicLinkEndpoint = resilience.Middleware(
+			"consume_magic_link",
+			resilience.Load("endpoints.consumemagiclink"),
+			logger,
+		)(
+			consumeMagicLinkEndpoint,
+		)
+		consumeMagicLinkEndpoint = validate.PolicyMiddleware(policy)(consumeMagicLinkEndpoint)
+		consumeMagicLinkEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "consume_magic_link"),
+		)(
+			consumeMagicLinkEndpoint,
+		)
+	}
+	var beginPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		beginPasskeyRegistrationEndpoint = makeBeginPasskeyRegistrationEndpoint(svc)
+		beginPasskeyRegistrationEndpoint = resilience.Middleware(
+			"begin_passkey_registration",
+			resilience.Load("endpoints.beginpasskeyregistration"),
+			logger,
+		)(
+			beginPasskeyRegistrationEndpoint,
+		)
+		beginPasskeyRegistrationEndpoint = validate.PolicyMiddleware(policy)(beginPasskeyRegistrationEndpoint)
+		beginPasskeyRegistrationEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "begin_passkey_registration"),
+		)(
+			beginPasskeyRegistrationEndpoint,
+		)
+	}
+
+	var finishPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		finishPasskeyRegistrationEndpoint = makeFinishPasskeyRegistrationEndpoint(svc)
+		finishPasskeyRegistrationEndpoint = resilience.Middleware(
+			"finish_passkey_registration",
+			resilience.Load("endpoints.finishpasskeyregistration"),
+			logger,
+		)(
+			finishPasskeyRegistrationEndpoint,
+		)
+		finishPasskeyRegistrationEndpoint = validate.PolicyMiddleware(policy)(finishPasskeyRegistrationEndpoint)
+		finishPasskeyRegistrationEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "finish_passkey_registration"),
+		)(
+			finishPasskeyRegistrationEndpoint,
+		)
+	}
+
+	var beginPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		beginPasskeyLoginEndpoint = makeBeginPasskeyLoginEndpoint(svc)
+		beginPasskeyLoginEndpoint = resilience.Middleware(
+			"begin_passkey_login",
+			resilience.Load("endpoints.beginpasskeylogin"),
+			logger,
+		)(
+			beginPasskeyLoginEndpoint,
+		)
+		beginPasskeyLoginEndpoint = validate.PolicyMiddleware(policy)(beginPasskeyLoginEndpoint)
+		beginPasskeyLoginEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "begin_passkey_login"),
+		)(
+			beginPasskeyLoginEndpoint,
+		)
+	}
+
+	var finishPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		finishPasskeyLoginEndpoint = makeFinishPasskeyLoginEndpoint(svc)
+		finishPasskeyLoginEndpoint = resilience.Middleware(
+			"finish_passkey_login",
+			resilience.Load("endpoints.finishpasskeylogin"),
+			logger,
+		)(
+			finishPasskeyLoginEndpoint,
+		)
+		finishPasskeyLoginEndpoint = validate.PolicyMiddleware(policy)(finishPasskeyLoginEndpoint)
+		finishPasskeyLoginEndpoint = LoggingMiddleware(
+			log.With(logger, "method", "finish_passkey_login"),
+		)(
+			finishPasskeyLoginEndpoint,
+		)
+	}
+
 	return Set{
-		RegisterEndpoint: registerEndpoint,
-		LoginEndpoint:    loginEndpoint,
+		RegisterEndpoint:       registerEndpoint,
+		LoginEndpoint:          loginEndpoint,
+		ForgotPasswordEndpoint: forgotPasswordEndpoint,
+		ResetPasswordEndpoint:  resetPasswordEndpoint,
+		RefreshEndpoint:        refreshEndpoint,
+		CreateAPIKeyEndpoint:   createAPIKeyEndpoint,
+		ListAPIKeysEndpoint:    listAPIKeysEndpoint,
+		RevokeAPIKeyEndpoint:   revokeAPIKeyEndpoint,
+		ExchangeAPIKeyEndpoint: exchangeAPIKeyEndpoint,
+		IntrospectEndpoint:     introspectEndpoint,
+		ListSessionsEndpoint:   listSessionsEndpoint,
+		RevokeSessionEndpoint:  revokeSessionEndpoint,
+		RevokeSessionsEndpoint: revokeSessionsEndpoint,
+
+		RequestMagicLinkEndpoint: requestMagicLinkEndpoint,
+		ConsumeMagicLinkEndpoint: consumeMagicLinkEndpoint,
+
+		BeginPasskeyRegistrationEndpoint:  beginPasskeyRegistrationEndpoint,
+		FinishPasskeyRegistrationEndpoint: finishPasskeyRegistrationEndpoint,
+		BeginPasskeyLoginEndpoint:         beginPasskeyLoginEndpoint,
+		FinishPasskeyLoginEndpoint:        finishPasskeyLoginEndpoint,
 	}
 }
 
+// LoginRequest carries the device of the session it starts. UserAgent and
+// IP are those of the request.
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Device    string `json:"device,omitempty"`
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
 }
 
 type LoginResponse struct {
 	Token string `json:"token"`
-	Err   error  `json:"error"`
+	Err   error  `json:"-"`
+}
+
+func (r LoginRequest) Validate(v *validate.Validator) {
+	v.Required("email", r.Email)
+	v.Required("password", r.Password)
+	v.MaxLen("device", r.Device, 100)
+}
+
+type RequestMagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+func (r RequestMagicLinkRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+}
+
+type RequestMagicLinkResponse struct {
+	Err error `json:"-"`
+}
+
+// ConsumeMagicLinkRequest carries the device of the session it starts, as
+// LoginRequest does.
+type ConsumeMagicLinkRequest struct {
+	Token     string `json:"token"`
+	Device    string `json:"device,omitempty"`
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
+}
+
+func (r ConsumeMagicLinkRequest) Validate(v *validate.Validator) {
+	v.Required("token", r.Token)
+	v.MaxLen("device", r.Device, 100)
+}
+
+type ConsumeMagicLinkResponse struct {
+	Token string `json:"token"`
+	Err   error  `json:"-"`
+}
+
+// BeginPasskeyRegistrationRequest carries the caller's token in Token, as
+// CreateAPIKeyRequest does.
+type BeginPasskeyRegistrationRequest struct {
+	Token string `json:"-"`
+}
+
+// PasskeyChallengeResponse starts a passkey ceremony. The client passes
+// Options to the browser and sends its answer back with ChallengeID.
+type PasskeyChallengeResponse struct {
+	ChallengeID uuid.UUID       `json:"challenge_id"`
+	Options     json.RawMessage `json:"options"`
+	Err         error           `json:"-"`
+}
+
+// FinishPasskeyRegistrationRequest carries the credential the browser
+// created, as serialized by PublicKeyCredential.toJSON.
+type FinishPasskeyRegistrationRequest struct {
+	Token       string          `json:"-"`
+	ChallengeID uuid.UUID       `json:"challenge_id"`
+	Name        string          `json:"name,omitempty"`
+	Credential  json.RawMessage `json:"credential"`
+}
+
+func (r FinishPasskeyRegistrationRequest) Validate(v *validate.Validator) {
+	v.UUID("challenge_id", r.ChallengeID)
+	v.MaxLen("name", r.Name, 100)
+	v.Check(len(r.Credential) > 0, "credential", "is required")
+}
+
+type FinishPasskeyRegistrationResponse struct {
+	Passkey authservice.Passkey `json:"passkey"`
+	Err     error               `json:"-"`
+}
+
+// BeginPasskeyLoginRequest is empty, the authenticator picks the user.
+type BeginPasskeyLoginRequest struct{}
+
+// FinishPasskeyLoginRequest carries the assertion the browser made and the
+// device of the session it starts, as LoginRequest does.
+type FinishPasskeyLoginRequest struct {
+	ChallengeID uuid.UUID       `json:"challenge_id"`
+	Credential  json.RawMessage `json:"credential"`
+	Device      string          `json:"device,omitempty"`
+	UserAgent   string          `json:"-"`
+	IP          string          `json:"-"`
+}
+
+func (r FinishPasskeyLoginRequest) Validate(v *validate.Validator) {
+	v.UUID("challenge_id", r.ChallengeID)
+	v.Check(len(r.Credential) > 0, "credential", "is required")
+	v.MaxLen("device", r.Device, 100)
+}
+
+type FinishPasskeyLoginResponse struct {
+	Token string `json:"token"`
+	Err   error  `json:"-"`
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email          string `json:"email"`
+	Password       string `json:"password"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// IdempotencyFingerprint leaves the password out of the stored hash.
+func (r RegisterRequest) IdempotencyFingerprint() interface{} {
+	return r.Email
+}
+
+func (r RegisterRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Password("password", r.Password)
+	v.MaxLen("idempotency_key", r.IdempotencyKey, 255)
 }
 
 type RegisterResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
 }
 
-func (s Set) Login(ctx context.Context, user authservice.User) (string, error) {
+func (s Set) Login(ctx context.Context, user authservice.User, device authservice.Device) (string, error) {
 	resp, err := s.LoginEndpoint(
 		ctx,
-		LoginRequest{Email: user.Email, Password: user.Password},
+		LoginRequest{
+			Email:     user.Email,
+			Password:  user.Password,
+			Device:    device.Name,
+			UserAgent: device.UserAgent,
+			IP:        device.IP,
+		},
 	)
 	if err != nil {
 		return "", err
@@ -79,15 +567,406 @@ func (s Set) Login(ctx context.Context, user authservice.User) (string, error) {
 	return response.Token, response.Err
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+func (r ForgotPasswordRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+}
+
+type ForgotPasswordResponse struct {
+	Err error `json:"-"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r ResetPasswordRequest) Validate(v *validate.Validator) {
+	v.Required("token", r.Token)
+	v.Password("password", r.Password)
+}
+
+type ResetPasswordResponse struct {
+	Err error `json:"-"`
+}
+
+type RefreshRequest struct {
+	Token string `json:"token"`
+}
+
+func (r RefreshRequest) Validate(v *validate.Validator) {
+	v.Required("token", r.Token)
+}
+
+type RefreshResponse struct {
+	Token string `json:"token"`
+	Err   error  `json:"-"`
+}
+
+// CreateAPIKeyRequest carries the caller's token in Token, taken from the
+// X-Api-Token header or session cookie over HTTP.
+type CreateAPIKeyRequest struct {
+	Token  string   `json:"-"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a duration such as 720h, the default of apikeys.ttl
+	// when left out.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+func (r CreateAPIKeyRequest) Validate(v *validate.Validator) {
+	v.Required("name", r.Name)
+	v.MaxLen("name", r.Name, 100)
+	v.Check(len(r.Scopes) > 0, "scopes", "must not be empty")
+	if r.ExpiresIn != "" {
+		d, err := time.ParseDuration(r.ExpiresIn)
+		v.Check(err == nil && d > 0, "expires_in", "must be a positive duration such as 720h")
+	}
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey authservice.APIKey `json:"api_key"`
+	// Key is shown this once, only its prefix is kept in clear.
+	Key string `json:"key"`
+	Err error  `json:"-"`
+}
+
+type ListAPIKeysRequest struct {
+	Token string `json:"-"`
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []authservice.APIKey `json:"api_keys"`
+	Err     error                `json:"-"`
+}
+
+type RevokeAPIKeyRequest struct {
+	Token string    `json:"-"`
+	ID    uuid.UUID `json:"-"`
+}
+
+func (r RevokeAPIKeyRequest) Validate(v *validate.Validator) {
+	v.UUID("id", r.ID)
+}
+
+type RevokeAPIKeyResponse struct {
+	Err error `json:"-"`
+}
+
+type ListSessionsRequest struct {
+	Token string `json:"-"`
+}
+
+type ListSessionsResponse struct {
+	Sessions []authservice.Session `json:"sessions"`
+	Err      error                 `json:"-"`
+}
+
+type RevokeSessionRequest struct {
+	Token string    `json:"-"`
+	ID    uuid.UUID `json:"-"`
+}
+
+func (r RevokeSessionRequest) Validate(v *validate.Validator) {
+	v.UUID("id", r.ID)
+}
+
+type RevokeSessionResponse struct {
+	Err error `json:"-"`
+}
+
+type RevokeSessionsRequest struct {
+	Token string `json:"-"`
+}
+
+type RevokeSessionsResponse struct {
+	Err error `json:"-"`
+}
+
+type ExchangeAPIKeyRequest struct {
+	Key string `json:"key"`
+}
+
+func (r ExchangeAPIKeyRequest) Validate(v *validate.Validator) {
+	v.Required("key", r.Key)
+}
+
+type ExchangeAPIKeyResponse struct {
+	Token string `json:"token"`
+	Err   error  `json:"-"`
+}
+
+type IntrospectRequest struct {
+	Token string `json:"token"`
+}
+
+func (r IntrospectRequest) Validate(v *validate.Validator) {
+	v.Required("token", r.Token)
+}
+
+// IntrospectResponse is the introspection response of RFC 7662, with the
+// revoked member added. Username is the email of users.
+type IntrospectResponse struct {
+	Active    bool     `json:"active"`
+	Subject   string   `json:"sub,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	KeyID     string   `json:"key_id,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	Revoked   bool     `json:"revoked,omitempty"`
+	Err       error    `json:"-"`
+}
+
 var (
 	_ endpoint.Failer = LoginResponse{}
 	_ endpoint.Failer = RegisterResponse{}
+	_ endpoint.Failer = ForgotPasswordResponse{}
+	_ endpoint.Failer = ResetPasswordResponse{}
+	_ endpoint.Failer = RefreshResponse{}
+	_ endpoint.Failer = CreateAPIKeyResponse{}
+	_ endpoint.Failer = ListAPIKeysResponse{}
+	_ endpoint.Failer = RevokeAPIKeyResponse{}
+	_ endpoint.Failer = ExchangeAPIKeyResponse{}
+	_ endpoint.Failer = IntrospectResponse{}
+	_ endpoint.Failer = ListSessionsResponse{}
+	_ endpoint.Failer = RevokeSessionResponse{}
+	_ endpoint.Failer = RevokeSessionsResponse{}
+	_ endpoint.Failer = RequestMagicLinkResponse{}
+	_ endpoint.Failer = ConsumeMagicLinkResponse{}
+	_ endpoint.Failer = PasskeyChallengeResponse{}
+	_ endpoint.Failer = FinishPasskeyRegistrationResponse{}
+	_ endpoint.Failer = FinishPasskeyLoginResponse{}
 )
 
+func (s Set) RequestMagicLink(ctx context.Context, email string) error {
+	resp, err := s.RequestMagicLinkEndpoint(ctx, RequestMagicLinkRequest{Email: email})
+	if err != nil {
+		return err
+	}
+	response := resp.(RequestMagicLinkResponse)
+	return response.Err
+}
+
+func (s Set) ConsumeMagicLink(ctx context.Context, token string, device authservice.Device) (string, error) {
+	resp, err := s.ConsumeMagicLinkEndpoint(
+		ctx,
+		ConsumeMagicLinkRequest{
+			Token:     token,
+			Device:    device.Name,
+			UserAgent: device.UserAgent,
+			IP:        device.IP,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	response := resp.(ConsumeMagicLinkResponse)
+	return response.Token, response.Err
+}
+
+func (s Set) BeginPasskeyRegistration(ctx context.Context, token string) (authservice.PasskeyChallenge, error) {
+	resp, err := s.BeginPasskeyRegistrationEndpoint(ctx, BeginPasskeyRegistrationRequest{Token: token})
+	if err != nil {
+		return authservice.PasskeyChallenge{}, err
+	}
+	response := resp.(PasskeyChallengeResponse)
+	return authservice.PasskeyChallenge{ID: response.ChallengeID, Options: response.Options}, response.Err
+}
+
+func (s Set) FinishPasskeyRegistration(
+	ctx context.Context,
+	token string,
+	challengeID uuid.UUID,
+	name string,
+	credential []byte,
+) (authservice.Passkey, error) {
+	resp, err := s.FinishPasskeyRegistrationEndpoint(
+		ctx,
+		FinishPasskeyRegistrationRequest{
+			Token:       token,
+			ChallengeID: challengeID,
+			Name:        name,
+			Credential:  credential,
+		},
+	)
+	if err != nil {
+		return authservice.Passkey{}, err
+	}
+	response := resp.(FinishPasskeyRegistrationResponse)
+	return response.Passkey, response.Err
+}
+
+func (s Set) BeginPasskeyLogin(ctx context.Context) (authservice.PasskeyChallenge, error) {
+	resp, err := s.BeginPasskeyLoginEndpoint(ctx, BeginPasskeyLoginRequest{})
+	if err != nil {
+		return authservice.PasskeyChallenge{}, err
+	}
+	response := resp.(PasskeyChallengeResponse)
+	return authservice.PasskeyChallenge{ID: response.ChallengeID, Options: response.Options}, response.Err
+}
+
+func (s Set) FinishPasskeyLogin(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	credential []byte,
+	device authservice.Device,
+) (string, error) {
+	resp, err := s.FinishPasskeyLoginEndpoint(
+		ctx,
+		FinishPasskeyLoginRequest{
+			ChallengeID: challengeID,
+			Credential:  credential,
+			Device:      device.Name,
+			UserAgent:   device.UserAgent,
+			IP:          device.IP,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	response := resp.(FinishPasskeyLoginResponse)
+	return response.Token, response.Err
+}
+
+func (s Set) RequestPasswordReset(ctx context.Context, email string) error {
+	resp, err := s.ForgotPasswordEndpoint(ctx, ForgotPasswordRequest{Email: email})
+	if err != nil {
+		return err
+	}
+	response := resp.(ForgotPasswordResponse)
+	return response.Err
+}
+
+func (s Set) ResetPassword(ctx context.Context, token, password string) error {
+	resp, err := s.ResetPasswordEndpoint(
+		ctx,
+		ResetPasswordRequest{Token: token, Password: password},
+	)
+	if err != nil {
+		return err
+	}
+	response := resp.(ResetPasswordResponse)
+	return response.Err
+}
+
+func (s Set) Refresh(ctx context.Context, token string) (string, error) {
+	resp, err := s.RefreshEndpoint(ctx, RefreshRequest{Token: token})
+	if err != nil {
+		return "", err
+	}
+	response := resp.(RefreshResponse)
+	return response.Token, response.Err
+}
+
+func (s Set) CreateAPIKey(
+	ctx context.Context,
+	token string,
+	key authservice.NewAPIKey,
+) (authservice.APIKey, string, error) {
+	req := CreateAPIKeyRequest{Token: token, Name: key.Name, Scopes: key.Scopes}
+	if key.ExpiresIn != 0 {
+		req.ExpiresIn = key.ExpiresIn.String()
+	}
+	resp, err := s.CreateAPIKeyEndpoint(ctx, req)
+	if err != nil {
+		return authservice.APIKey{}, "", err
+	}
+	response := resp.(CreateAPIKeyResponse)
+	return response.APIKey, response.Key, response.Err
+}
+
+func (s Set) ListAPIKeys(ctx context.Context, token string) ([]authservice.APIKey, error) {
+	resp, err := s.ListAPIKeysEndpoint(ctx, ListAPIKeysRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	response := resp.(ListAPIKeysResponse)
+	return response.APIKeys, response.Err
+}
+
+func (s Set) RevokeAPIKey(ctx context.Context, token string, id uuid.UUID) error {
+	resp, err := s.RevokeAPIKeyEndpoint(ctx, RevokeAPIKeyRequest{Token: token, ID: id})
+	if err != nil {
+		return err
+	}
+	response := resp.(RevokeAPIKeyResponse)
+	return response.Err
+}
+
+func (s Set) ListSessions(ctx context.Context, token string) ([]authservice.Session, error) {
+	resp, err := s.ListSessionsEndpoint(ctx, ListSessionsRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	response := resp.(ListSessionsResponse)
+	return response.Sessions, response.Err
+}
+
+func (s Set) RevokeSession(ctx context.Context, token string, id uuid.UUID) error {
+	resp, err := s.RevokeSessionEndpoint(ctx, RevokeSessionRequest{Token: token, ID: id})
+	if err != nil {
+		return err
+	}
+	response := resp.(RevokeSessionResponse)
+	return response.Err
+}
+
+func (s Set) RevokeSessions(ctx context.Context, token string) error {
+	resp, err := s.RevokeSessionsEndpoint(ctx, RevokeSessionsRequest{Token: token})
+	if err != nil {
+		return err
+	}
+	response := resp.(RevokeSessionsResponse)
+	return response.Err
+}
+
+func (s Set) ExchangeAPIKey(ctx context.Context, key string) (string, error) {
+	resp, err := s.ExchangeAPIKeyEndpoint(ctx, ExchangeAPIKeyRequest{Key: key})
+	if err != nil {
+		return "", err
+	}
+	response := resp.(ExchangeAPIKeyResponse)
+	return response.Token, response.Err
+}
+
+func (s Set) Introspect(ctx context.Context, token string) (authservice.Introspection, error) {
+	resp, err := s.IntrospectEndpoint(ctx, IntrospectRequest{Token: token})
+	if err != nil {
+		return authservice.Introspection{}, err
+	}
+	response := resp.(IntrospectResponse)
+	if response.Err != nil {
+		return authservice.Introspection{}, response.Err
+	}
+	in := authservice.Introspection{
+		Active:   response.Active,
+		Subject:  response.Subject,
+		Email:    response.Username,
+		ClientID: response.ClientID,
+		KeyID:    response.KeyID,
+		Audience: response.Audience,
+		Scopes:   strings.Fields(response.Scope),
+		Revoked:  response.Revoked,
+	}
+	if response.ExpiresAt != 0 {
+		in.ExpiresAt = time.Unix(response.ExpiresAt, 0)
+	}
+	return in, nil
+}
+
 func (s Set) Register(ctx context.Context, user authservice.User) error {
 	resp, err := s.RegisterEndpoint(
 		ctx,
-		RegisterRequest{Email: user.Email, Password: user.Password},
+		RegisterRequest{
+			Email:          user.Email,
+			Password:       user.Password,
+			IdempotencyKey: user.IdempotencyKey,
+		},
 	)
 	if err != nil {
 		return err
@@ -103,22 +982,212 @@ func makeLoginEndpoint(s authservice.Service) endpoint.Endpoint {
 			Email:    req.Email,
 			Password: req.Password,
 		}
-		tok, err := s.Login(ctx, user)
+		device := authservice.Device{
+			Name:      req.Device,
+			UserAgent: req.UserAgent,
+			IP:        req.IP,
+		}
+		tok, err := s.Login(ctx, user, device)
 		return LoginResponse{Token: tok, Err: err}, nil
 	}
 }
 
+func makeRequestMagicLinkEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RequestMagicLinkRequest)
+		err = s.RequestMagicLink(ctx, req.Email)
+		return RequestMagicLinkResponse{Err: err}, nil
+	}
+}
+
+func makeConsumeMagicLinkEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ConsumeMagicLinkRequest)
+		device := authservice.Device{
+			Name:      req.Device,
+			UserAgent: req.UserAgent,
+			IP:        req.IP,
+		}
+		tok, err := s.ConsumeMagicLink(ctx, req.Token, device)
+		return ConsumeMagicLinkResponse{Token: tok, Err: err}, nil
+	}
+}
+
+func makeBeginPasskeyRegistrationEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(BeginPasskeyRegistrationRequest)
+		c, err := s.BeginPasskeyRegistration(ctx, req.Token)
+		return PasskeyChallengeResponse{ChallengeID: c.ID, Options: c.Options, Err: err}, nil
+	}
+}
+
+func makeFinishPasskeyRegistrationEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(FinishPasskeyRegistrationRequest)
+		passkey, err := s.FinishPasskeyRegistration(ctx, req.Token, req.ChallengeID, req.Name, req.Credential)
+		return FinishPasskeyRegistrationResponse{Passkey: passkey, Err: err}, nil
+	}
+}
+
+func makeBeginPasskeyLoginEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		c, err := s.BeginPasskeyLogin(ctx)
+		return PasskeyChallengeResponse{ChallengeID: c.ID, Options: c.Options, Err: err}, nil
+	}
+}
+
+func makeFinishPasskeyLoginEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(FinishPasskeyLoginRequest)
+		device := authservice.Device{
+			Name:      req.Device,
+			UserAgent: req.UserAgent,
+			IP:        req.IP,
+		}
+		tok, err := s.FinishPasskeyLogin(ctx, req.ChallengeID, req.Credential, device)
+		return FinishPasskeyLoginResponse{Token: tok, Err: err}, nil
+	}
+}
+
 func makeRegisterEndpoint(s authservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(RegisterRequest)
 		user := authservice.User{
-			Email:    req.Email,
-			Password: req.Password,
+			Email:          req.Email,
+			Password:       req.Password,
+			IdempotencyKey: req.IdempotencyKey,
 		}
 		err = s.Register(ctx, user)
 		return RegisterResponse{Err: err}, nil
 	}
 }
 
-func (r LoginResponse) Failed() error    { return r.Err }
-func (r RegisterResponse) Failed() error { return r.Err }
+func makeForgotPasswordEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ForgotPasswordRequest)
+		err = s.RequestPasswordReset(ctx, req.Email)
+		return ForgotPasswordResponse{Err: err}, nil
+	}
+}
+
+func makeResetPasswordEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ResetPasswordRequest)
+		err = s.ResetPassword(ctx, req.Token, req.Password)
+		return ResetPasswordResponse{Err: err}, nil
+	}
+}
+
+func makeRefreshEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RefreshRequest)
+		tok, err := s.Refresh(ctx, req.Token)
+		return RefreshResponse{Token: tok, Err: err}, nil
+	}
+}
+
+func makeCreateAPIKeyEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(CreateAPIKeyRequest)
+		key := authservice.NewAPIKey{Name: req.Name, Scopes: req.Scopes}
+		if req.ExpiresIn != "" {
+			// checked by Validate
+			key.ExpiresIn, _ = time.ParseDuration(req.ExpiresIn)
+		}
+		created, secret, err := s.CreateAPIKey(ctx, req.Token, key)
+		return CreateAPIKeyResponse{APIKey: created, Key: secret, Err: err}, nil
+	}
+}
+
+func makeListAPIKeysEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListAPIKeysRequest)
+		keys, err := s.ListAPIKeys(ctx, req.Token)
+		return ListAPIKeysResponse{APIKeys: keys, Err: err}, nil
+	}
+}
+
+func makeRevokeAPIKeyEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RevokeAPIKeyRequest)
+		err = s.RevokeAPIKey(ctx, req.Token, req.ID)
+		return RevokeAPIKeyResponse{Err: err}, nil
+	}
+}
+
+func makeListSessionsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListSessionsRequest)
+		sessions, err := s.ListSessions(ctx, req.Token)
+		return ListSessionsResponse{Sessions: sessions, Err: err}, nil
+	}
+}
+
+func makeRevokeSessionEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RevokeSessionRequest)
+		err = s.RevokeSession(ctx, req.Token, req.ID)
+		return RevokeSessionResponse{Err: err}, nil
+	}
+}
+
+func makeRevokeSessionsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RevokeSessionsRequest)
+		err = s.RevokeSessions(ctx, req.Token)
+		return RevokeSessionsResponse{Err: err}, nil
+	}
+}
+
+func makeExchangeAPIKeyEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ExchangeAPIKeyRequest)
+		tok, err := s.ExchangeAPIKey(ctx, req.Key)
+		return ExchangeAPIKeyResponse{Token: tok, Err: err}, nil
+	}
+}
+
+func makeIntrospectEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(IntrospectRequest)
+		in, err := s.Introspect(ctx, req.Token)
+		if err != nil {
+			return IntrospectResponse{Err: err}, nil
+		}
+		resp := IntrospectResponse{
+			Active:   in.Active,
+			Subject:  in.Subject,
+			Username: in.Email,
+			ClientID: in.ClientID,
+			KeyID:    in.KeyID,
+			Audience: in.Audience,
+			Scope:    strings.Join(in.Scopes, " "),
+			Revoked:  in.Revoked,
+		}
+		if !in.ExpiresAt.IsZero() {
+			resp.ExpiresAt = in.ExpiresAt.Unix()
+		}
+		return resp, nil
+	}
+}
+
+func (r LoginResponse) Failed() error          { return r.Err }
+func (r RegisterResponse) Failed() error       { return r.Err }
+func (r ForgotPasswordResponse) Failed() error { return r.Err }
+func (r ResetPasswordResponse) Failed() error  { return r.Err }
+func (r RefreshResponse) Failed() error        { return r.Err }
+func (r CreateAPIKeyResponse) Failed() error   { return r.Err }
+func (r ListAPIKeysResponse) Failed() error    { return r.Err }
+func (r RevokeAPIKeyResponse) Failed() error   { return r.Err }
+func (r ExchangeAPIKeyResponse) Failed() error { return r.Err }
+func (r IntrospectResponse) Failed() error     { return r.Err }
+func (r ListSessionsResponse) Failed() error   { return r.Err }
+func (r RevokeSessionResponse) Failed() error  { return r.Err }
+func (r RevokeSessionsResponse) Failed() error { return r.Err }
+
+func (r RequestMagicLinkResponse) Failed() error { return r.Err }
+func (r ConsumeMagicLinkResponse) Failed() error { return r.Err }
+
+func (r PasskeyChallengeResponse) Failed() error          { return r.Err }
+func (r FinishPasskeyRegistrationResponse) Failed() error { return r.Err }
+func (r FinishPasskeyLoginResponse) Failed() error        { return r.Err }
@@ -0,0 +1,215 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// APIKeyPrefix starts every API key, so that gateways and secret scanners
+// can tell them from other tokens.
+const APIKeyPrefix = "uk_"
+
+// apiKeyPrefixLen is how much of a key is kept in clear, the prefix and 8
+// random characters.
+const apiKeyPrefixLen = len(APIKeyPrefix) + 8
+
+var (
+	ErrInvalidAPIKey  = errors.New("api key is invalid, expired or revoked")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrTooManyAPIKeys = errors.New("too many api keys, revoke some first")
+	ErrUnknownScope   = errors.New("unknown scope")
+	ErrExpiryTooLong  = errors.New("api key expiry exceeds the maximum")
+	// ErrScopedToken is returned when a token exchanged for an API key is
+	// used to manage API keys or to refresh itself.
+	ErrScopedToken = errors.New("tokens of api keys cannot do this")
+)
+
+// NewAPIKey describes a key to create. A zero ExpiresIn takes the default
+// of apikeys.ttl.
+type NewAPIKey struct {
+	Name      string
+	Scopes    []string
+	ExpiresIn time.Duration
+}
+
+// APIKey is a key as shown to its owner, without the key itself.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (s basicService) CreateAPIKey(ctx context.Context, token string, key NewAPIKey) (APIKey, string, error) {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	allowed := viper.GetStringSlice("apikeys.scopes")
+	for _, scope := range key.Scopes {
+		if !slices.Contains(allowed, scope) {
+			return APIKey{}, "", ErrUnknownScope
+		}
+	}
+	ttl := key.ExpiresIn
+	if ttl == 0 {
+		ttl = viper.GetDuration("apikeys.ttl")
+	}
+	if ttl > viper.GetDuration("apikeys.maxttl") {
+		return APIKey{}, "", ErrExpiryTooLong
+	}
+
+	secret, hash, err := newAPIKeySecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	repoKey := repository.APIKey{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Name:      key.Name,
+		Prefix:    secret[:apiKeyPrefixLen],
+		KeyHash:   hash,
+		Scopes:    strings.Join(key.Scopes, " "),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+	err = s.db.CreateAPIKey(ctx, repoKey, viper.GetInt("apikeys.max"))
+	if errors.Is(err, repository.ErrTooManyKeys) {
+		return APIKey{}, "", ErrTooManyAPIKeys
+	}
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return newAPIKey(repoKey), secret, nil
+}
+
+func (s basicService) ListAPIKeys(ctx context.Context, token string) ([]APIKey, error) {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	repoKeys, err := s.db.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(repoKeys))
+	for _, k := range repoKeys {
+		keys = append(keys, newAPIKey(k))
+	}
+	return keys, nil
+}
+
+func (s basicService) RevokeAPIKey(ctx context.Context, token string, id uuid.UUID) error {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return err
+	}
+	err = s.db.RevokeAPIKey(ctx, user.ID, id)
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+func (s basicService) ExchangeAPIKey(ctx context.Context, key string) (string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", ErrInvalidAPIKey
+	}
+	repoKey, err := s.db.UseAPIKey(ctx, hashResetToken(key))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", err
+	}
+	user, err := s.db.GetUserById(ctx, repoKey.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", err
+	}
+	// tokens of keys are short lived, so that revoking a key takes effect
+	// soon, and never outlive their key
+	exp := time.Now().Add(viper.GetDuration("apikeys.tokenttl"))
+	if repoKey.ExpiresAt.Before(exp) {
+		exp = repoKey.ExpiresAt
+	}
+	tok, err := newScopedToken(User{ID: user.ID, Email: user.Email}, repoKey, exp)
+	if err != nil {
+		return "", ErrGeneratingToken
+	}
+	return tok, nil
+}
+
+// owner returns the user of an unscoped token, API keys being managed by
+// their owner only and not by other keys.
+func (s basicService) owner(ctx context.Context, token string) (repository.User, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return repository.User{}, ErrInvalidToken
+	}
+	if _, ok := claims["scope"]; ok {
+		return repository.User{}, ErrScopedToken
+	}
+	if _, err := s.checkSession(ctx, claims); err != nil {
+		return repository.User{}, err
+	}
+	email, _ := claims["email"].(string)
+	user, err := s.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return repository.User{}, ErrInvalidToken
+	}
+	return user, err
+}
+
+// newAPIKeySecret returns a random key and the hash that is stored in its
+// place, hashed like reset tokens.
+func newAPIKeySecret() (key string, hash []byte, err error) {
+	token, _, err := newResetToken()
+	if err != nil {
+		return "", nil, err
+	}
+	key = APIKeyPrefix + token
+	return key, hashResetToken(key), nil
+}
+
+// newScopedToken is NewToken for the key, with its scopes in the space
+// separated scope claim and its id in the key_id claim.
+func newScopedToken(user User, key repository.APIKey, exp time.Time) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["uid"] = user.ID
+	claims["email"] = user.Email
+	claims["scope"] = key.Scopes
+	claims["key_id"] = key.ID
+	claims["exp"] = exp.Unix()
+
+	return token.SignedString([]byte(viper.GetString("auth.secret")))
+}
+
+func newAPIKey(k repository.APIKey) APIKey {
+	return APIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     strings.Fields(k.Scopes),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
@@ -0,0 +1,118 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// newKeyService is newTestService with the apikeys config of a test
+// deployment, and a logged in token of alice.
+func newKeyService(t *testing.T) (Service, *memRepo, string) {
+	repo := newMemRepo(alice)
+	svc := newTestService(t, repo, newMailbox())
+	viper.Set("apikeys.scopes", []string{"chat", "files"})
+	viper.Set("apikeys.ttl", 24*time.Hour)
+	viper.Set("apikeys.maxttl", 30*24*time.Hour)
+	viper.Set("apikeys.tokenttl", 15*time.Minute)
+	viper.Set("apikeys.max", 2)
+	return svc, repo, loggedIn(t, repo, alice)
+}
+
+func TestExchangeAPIKey(t *testing.T) {
+	svc, _, token := newKeyService(t)
+	ctx := context.Background()
+	key, secret, err := svc.CreateAPIKey(ctx, token, NewAPIKey{Name: "ci", Scopes: []string{"chat"}, ExpiresIn: time.Minute})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(secret, APIKeyPrefix) || !strings.HasPrefix(secret, key.Prefix) {
+		t.Errorf("key %q does not start with %q", secret, key.Prefix)
+	}
+
+	scoped, err := svc.ExchangeAPIKey(ctx, secret)
+	if err != nil {
+		t.Fatalf("ExchangeAPIKey: %v", err)
+	}
+	claims, err := ParseToken(scoped)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims["email"] != alice.Email || claims["scope"] != "chat" || claims["key_id"] != key.ID.String() {
+		t.Errorf("claims %v, want alice's with the key's scope and id", claims)
+	}
+	// the token does not outlive its key
+	if exp, _ := claims.GetExpirationTime(); exp.After(key.ExpiresAt.Add(time.Second)) {
+		t.Errorf("token expires %v, after its key %v", exp, key.ExpiresAt)
+	}
+	// nor manage keys or refresh itself
+	if _, _, err := svc.CreateAPIKey(ctx, scoped, NewAPIKey{Name: "more"}); !errors.Is(err, ErrScopedToken) {
+		t.Errorf("CreateAPIKey with a key's token = %v, want %v", err, ErrScopedToken)
+	}
+	if _, err := svc.Refresh(ctx, scoped); !errors.Is(err, ErrScopedToken) {
+		t.Errorf("Refresh of a key's token = %v, want %v", err, ErrScopedToken)
+	}
+
+	for _, bad := range []string{"", "uk_", secret + "x", strings.TrimPrefix(secret, APIKeyPrefix)} {
+		if _, err := svc.ExchangeAPIKey(ctx, bad); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("ExchangeAPIKey(%q) = %v, want %v", bad, err, ErrInvalidAPIKey)
+		}
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	svc, repo, token := newKeyService(t)
+	ctx := context.Background()
+	key, secret, err := svc.CreateAPIKey(ctx, token, NewAPIKey{Name: "ci", Scopes: []string{"chat"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := svc.RevokeAPIKey(ctx, token, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := svc.ExchangeAPIKey(ctx, secret); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("ExchangeAPIKey of a revoked key = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if err := svc.RevokeAPIKey(ctx, token, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("second RevokeAPIKey = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+
+	// keys are revoked by their owner only
+	bob := repository.User{ID: uuid.New(), Email: "bob@example.com"}
+	repo.users[bob.Email] = bob
+	key, _, err = svc.CreateAPIKey(ctx, token, NewAPIKey{Name: "ci"})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := svc.RevokeAPIKey(ctx, loggedIn(t, repo, bob), key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RevokeAPIKey by another user = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+}
+
+func TestCreateAPIKeyLimits(t *testing.T) {
+	svc, _, token := newKeyService(t)
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		key  NewAPIKey
+		want error
+	}{
+		{"unknown scope", NewAPIKey{Scopes: []string{"admin"}}, ErrUnknownScope},
+		{"too long", NewAPIKey{ExpiresIn: 31 * 24 * time.Hour}, ErrExpiryTooLong},
+		{"first", NewAPIKey{}, nil},
+		{"second", NewAPIKey{}, nil},
+		{"past apikeys.max", NewAPIKey{}, ErrTooManyAPIKeys},
+	}
+	for _, tt := range tests {
+		if _, _, err := svc.CreateAPIKey(ctx, token, tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CreateAPIKey = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
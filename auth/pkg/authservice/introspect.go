@@ -0,0 +1,100 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// Introspection describes a token, after RFC 7662. Inactive tokens, be they
+// forged, expired, of removed users or of revoked keys, have nothing set
+// but Revoked.
+type Introspection struct {
+	Active bool
+	// Subject is the user id of user tokens and the client id of service
+	// tokens.
+	Subject string
+	// Email is set for user tokens only.
+	Email string
+	// ClientID is set for service tokens only.
+	ClientID string
+	// KeyID is set for the tokens of API keys only, which are limited to
+	// Scopes. User tokens have no scopes and are not limited.
+	KeyID     string
+	Audience  []string
+	Scopes    []string
+	ExpiresAt time.Time
+	// Revoked tells inactive tokens of revoked API keys and sessions from
+	// the others.
+	Revoked bool
+}
+
+func (s basicService) Introspect(ctx context.Context, token string) (Introspection, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return Introspection{}, nil
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return Introspection{}, nil
+	}
+	audience, _ := claims.GetAudience()
+	scope, _ := claims["scope"].(string)
+	in := Introspection{
+		Active:    true,
+		Audience:  audience,
+		Scopes:    strings.Fields(scope),
+		ExpiresAt: exp.Time,
+	}
+
+	// tokens of services, issued by the oauth package
+	if clientID, _ := claims["client_id"].(string); clientID != "" {
+		in.Subject, in.ClientID = clientID, clientID
+		return in, nil
+	}
+
+	email, _ := claims["email"].(string)
+	user, err := s.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Introspection{}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+	in.Subject, in.Email = user.ID.String(), user.Email
+
+	// tokens of revoked sessions die with their session
+	_, err = s.checkSession(ctx, claims)
+	if errors.Is(err, ErrInvalidToken) {
+		return Introspection{Revoked: true}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	// tokens of API keys die with their key
+	if keyID, ok := claims["key_id"].(string); ok {
+		id, err := uuid.Parse(keyID)
+		if err != nil {
+			return Introspection{}, nil
+		}
+		key, err := s.db.GetAPIKey(ctx, id)
+		if errors.Is(err, repository.ErrTokenNotFound) {
+			return Introspection{Revoked: true}, nil
+		}
+		if err != nil {
+			return Introspection{}, err
+		}
+		if key.RevokedAt != nil || key.UserID != user.ID {
+			return Introspection{Revoked: true}, nil
+		}
+		in.KeyID = key.ID.String()
+	}
+	return in, nil
+}
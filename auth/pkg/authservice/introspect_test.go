@@ -0,0 +1,94 @@
+package authservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+func sign(t *testing.T, claims jwt.MapClaims, secret string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return token
+}
+
+func TestIntrospect(t *testing.T) {
+	svc, repo, token := newKeyService(t)
+	ctx := context.Background()
+	key, secret, err := svc.CreateAPIKey(ctx, token, NewAPIKey{Name: "ci", Scopes: []string{"chat", "files"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	scoped, err := svc.ExchangeAPIKey(ctx, secret)
+	if err != nil {
+		t.Fatalf("ExchangeAPIKey: %v", err)
+	}
+	revokedKey, secret, _ := svc.CreateAPIKey(ctx, token, NewAPIKey{Name: "old"})
+	revokedScoped, _ := svc.ExchangeAPIKey(ctx, secret)
+	if err := svc.RevokeAPIKey(ctx, token, revokedKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	ended := loggedIn(t, repo, alice)
+	if err := svc.RevokeSession(ctx, token, sessionID(ended)); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	service := sign(t, jwt.MapClaims{"client_id": "chat", "aud": "auth", "scope": "introspect", "exp": exp}, "test-secret")
+
+	tests := []struct {
+		name  string
+		token string
+		want  Introspection
+	}{
+		{"user", token, Introspection{Active: true, Subject: alice.ID.String(), Email: alice.Email}},
+		{"api key", scoped, Introspection{
+			Active:  true,
+			Subject: alice.ID.String(),
+			Email:   alice.Email,
+			KeyID:   key.ID.String(),
+			Scopes:  []string{"chat", "files"},
+		}},
+		{"service", service, Introspection{Active: true, Subject: "chat", ClientID: "chat", Audience: []string{"auth"}, Scopes: []string{"introspect"}}},
+		{"revoked api key", revokedScoped, Introspection{Revoked: true}},
+		{"revoked session", ended, Introspection{Revoked: true}},
+		{"forged", sign(t, jwt.MapClaims{"email": alice.Email, "exp": exp}, "guess"), Introspection{}},
+		{"expired", sign(t, jwt.MapClaims{"email": alice.Email, "exp": time.Now().Add(-time.Minute).Unix()}, "test-secret"), Introspection{}},
+		{"removed user", sign(t, jwt.MapClaims{"email": "gone@example.com", "exp": exp}, "test-secret"), Introspection{}},
+		{"bad key id", sign(t, jwt.MapClaims{"email": alice.Email, "key_id": "nope", "scope": "chat", "exp": exp}, "test-secret"), Introspection{}},
+		{"key of another user", sign(t, jwt.MapClaims{"email": "bob@example.com", "key_id": key.ID.String(), "exp": exp}, "test-secret"), Introspection{Revoked: true}},
+		{"unknown key", sign(t, jwt.MapClaims{"email": alice.Email, "key_id": uuid.NewString(), "exp": exp}, "test-secret"), Introspection{Revoked: true}},
+	}
+	repo.users["bob@example.com"] = repository.User{ID: uuid.New(), Email: "bob@example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.Introspect(ctx, tt.token)
+			if err != nil {
+				t.Fatalf("Introspect: %v", err)
+			}
+			if got.Active {
+				if got.ExpiresAt.IsZero() {
+					t.Error("active token without expiry")
+				}
+				got.ExpiresAt = time.Time{}
+			}
+			// no audience or scopes at all, however they are empty
+			if len(got.Audience) == 0 {
+				got.Audience = nil
+			}
+			if len(got.Scopes) == 0 {
+				got.Scopes = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Introspect = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+var ErrInvalidMagicLink = errors.New("login link is invalid or has expired")
+
+func (s basicService) RequestMagicLink(ctx context.Context, email string) error {
+	user, err := s.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// links are hashed and used up like reset tokens
+	token, hash, err := newResetToken()
+	if err != nil {
+		return err
+	}
+	err = s.db.CreateMagicLink(ctx, repository.MagicLink{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(viper.GetDuration("auth.magiclinkttl")),
+	})
+	if err != nil {
+		return err
+	}
+	return s.notifier.SendMagicLink(ctx, user.Email, token)
+}
+
+func (s basicService) ConsumeMagicLink(ctx context.Context, token string, device Device) (string, error) {
+	user, err := s.db.UseMagicLink(ctx, hashResetToken(token))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return "", ErrInvalidMagicLink
+	}
+	if err != nil {
+		return "", err
+	}
+	sid, err := s.startSession(ctx, user, device)
+	if err != nil {
+		return "", err
+	}
+	tok, err := NewToken(User{ID: user.ID, Email: user.Email}, sid)
+	if err != nil {
+		return "", ErrGeneratingToken
+	}
+	return tok, nil
+}
@@ -0,0 +1,96 @@
+package authservice
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+var alice = repository.User{ID: uuid.New(), Email: "alice@example.com"}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	repo, mail := newMemRepo(alice), newMailbox()
+	svc := newTestService(t, repo, mail)
+	ctx := context.Background()
+
+	if err := svc.RequestMagicLink(ctx, alice.Email); err != nil {
+		t.Fatalf("RequestMagicLink: %v", err)
+	}
+	if len(mail.links[alice.Email]) != 1 {
+		t.Fatalf("%d links mailed, want 1", len(mail.links[alice.Email]))
+	}
+	token := mail.links[alice.Email][0]
+	if _, ok := repo.links[hex.EncodeToString(hashResetToken(token))]; !ok || len(repo.links) != 1 {
+		t.Error("the link is not stored by the hash of its token alone")
+	}
+
+	jwt, err := svc.ConsumeMagicLink(ctx, token, Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("ConsumeMagicLink: %v", err)
+	}
+	claims, err := ParseToken(jwt)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims["email"] != alice.Email {
+		t.Errorf("logged in as %v, want %s", claims["email"], alice.Email)
+	}
+	if _, ok := repo.sessions[sessionID(jwt)]; !ok {
+		t.Error("the login started no session")
+	}
+
+	if _, err := svc.ConsumeMagicLink(ctx, token, Device{Name: "laptop"}); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("second use = %v, want %v", err, ErrInvalidMagicLink)
+	}
+}
+
+func TestMagicLinkUsesUpOtherLinks(t *testing.T) {
+	repo, mail := newMemRepo(alice), newMailbox()
+	svc := newTestService(t, repo, mail)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := svc.RequestMagicLink(ctx, alice.Email); err != nil {
+			t.Fatalf("RequestMagicLink: %v", err)
+		}
+	}
+	tokens := mail.links[alice.Email]
+	if _, err := svc.ConsumeMagicLink(ctx, tokens[1], Device{}); err != nil {
+		t.Fatalf("ConsumeMagicLink: %v", err)
+	}
+	if _, err := svc.ConsumeMagicLink(ctx, tokens[0], Device{}); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("older link after a login = %v, want %v", err, ErrInvalidMagicLink)
+	}
+}
+
+func TestMagicLinkInvalid(t *testing.T) {
+	repo, mail := newMemRepo(alice), newMailbox()
+	svc := newTestService(t, repo, mail)
+	ctx := context.Background()
+
+	if err := svc.RequestMagicLink(ctx, "nobody@example.com"); err != nil {
+		t.Errorf("RequestMagicLink for an unknown email = %v, want it to succeed", err)
+	}
+	if len(mail.links) != 0 {
+		t.Errorf("links mailed for an unknown email: %v", mail.links)
+	}
+
+	if _, err := svc.ConsumeMagicLink(ctx, "made-up", Device{}); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("unknown link = %v, want %v", err, ErrInvalidMagicLink)
+	}
+
+	token, hash, err := newResetToken()
+	if err != nil {
+		t.Fatalf("newResetToken: %v", err)
+	}
+	repo.CreateMagicLink(ctx, repository.MagicLink{TokenHash: hash, UserID: alice.ID, ExpiresAt: time.Now().Add(-time.Second)})
+	if _, err := svc.ConsumeMagicLink(ctx, token, Device{}); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("expired link = %v, want %v", err, ErrInvalidMagicLink)
+	}
+}
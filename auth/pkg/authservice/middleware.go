@@ -2,9 +2,11 @@ package authservice
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/google/uuid"
 )
 
 type Middleware func(svc Service) Service
@@ -29,24 +31,327 @@ func (mw loggingMiddleware) Register(ctx context.Context, user User) (err error)
 	return mw.next.Register(ctx, user)
 }
 
-func (mw loggingMiddleware) Login(ctx context.Context, user User) (token string, err error) {
+func (mw loggingMiddleware) Login(ctx context.Context, user User, device Device) (token string, err error) {
 	defer func(start time.Time) {
 		mw.log.Log(
 			"operation", "logging user",
 			"email", user.Email,
+			"device", device.Name,
+			"ip", device.IP,
 			"error", err,
 			"took", time.Since(start),
 		)
 	}(time.Now())
-	return mw.next.Login(ctx, user)
+	return mw.next.Login(ctx, user, device)
+}
+
+func (mw loggingMiddleware) RequestMagicLink(ctx context.Context, email string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "requesting login link",
+			"email", email,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RequestMagicLink(ctx, email)
+}
+
+func (mw loggingMiddleware) ConsumeMagicLink(ctx context.Context, token string, device Device) (_ string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "logging user by link",
+			"device", device.Name,
+			"ip", device.IP,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ConsumeMagicLink(ctx, token, device)
+}
+
+func (mw loggingMiddleware) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "requesting password reset",
+			"email", email,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RequestPasswordReset(ctx, email)
+}
+
+func (mw loggingMiddleware) ResetPassword(ctx context.Context, token, password string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "resetting password",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ResetPassword(ctx, token, password)
+}
+
+func (mw loggingMiddleware) Refresh(ctx context.Context, token string) (_ string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "refreshing token",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Refresh(ctx, token)
+}
+
+func (mw loggingMiddleware) CreateAPIKey(
+	ctx context.Context,
+	token string,
+	key NewAPIKey,
+) (created APIKey, _ string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "creating api key",
+			"key_id", created.ID,
+			"scopes", strings.Join(key.Scopes, " "),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.CreateAPIKey(ctx, token, key)
+}
+
+func (mw loggingMiddleware) ListAPIKeys(ctx context.Context, token string) (_ []APIKey, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing api keys",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ListAPIKeys(ctx, token)
+}
+
+func (mw loggingMiddleware) RevokeAPIKey(ctx context.Context, token string, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "revoking api key",
+			"key_id", id,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RevokeAPIKey(ctx, token, id)
+}
+
+func (mw loggingMiddleware) ExchangeAPIKey(ctx context.Context, key string) (_ string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "exchanging api key",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ExchangeAPIKey(ctx, key)
+}
+
+func (mw loggingMiddleware) Introspect(ctx context.Context, token string) (in Introspection, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "introspecting token",
+			"active", in.Active,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Introspect(ctx, token)
+}
+
+func (mw loggingMiddleware) ListSessions(ctx context.Context, token string) (_ []Session, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing sessions",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ListSessions(ctx, token)
+}
+
+func (mw loggingMiddleware) RevokeSession(ctx context.Context, token string, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "revoking session",
+			"session_id", id,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RevokeSession(ctx, token, id)
+}
+
+func (mw loggingMiddleware) RevokeSessions(ctx context.Context, token string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "revoking all sessions",
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RevokeSessions(ctx, token)
+}
+
+func (mw loggingMiddleware) BeginPasskeyRegistration(ctx context.Context, token string) (c PasskeyChallenge, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "beginning passkey registration",
+			"challenge_id", c.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.BeginPasskeyRegistration(ctx, token)
+}
+
+func (mw loggingMiddleware) FinishPasskeyRegistration(
+	ctx context.Context,
+	token string,
+	challengeID uuid.UUID,
+	name string,
+	credential []byte,
+) (p Passkey, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "finishing passkey registration",
+			"challenge_id", challengeID,
+			"passkey_id", p.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.FinishPasskeyRegistration(ctx, token, challengeID, name, credential)
+}
+
+func (mw loggingMiddleware) BeginPasskeyLogin(ctx context.Context) (c PasskeyChallenge, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "beginning passkey login",
+			"challenge_id", c.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.BeginPasskeyLogin(ctx)
+}
+
+func (mw loggingMiddleware) FinishPasskeyLogin(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	credential []byte,
+	device Device,
+) (_ string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "logging user by passkey",
+			"challenge_id", challengeID,
+			"device", device.Name,
+			"ip", device.IP,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.FinishPasskeyLogin(ctx, challengeID, credential, device)
 }
 
 func (mw instrumentingMiddleware) Register(ctx context.Context, user User) (err error) {
 	return mw.next.Register(ctx, user)
 }
 
-func (mw instrumentingMiddleware) Login(ctx context.Context, user User) (token string, err error) {
-	return mw.next.Login(ctx, user)
+func (mw instrumentingMiddleware) Login(ctx context.Context, user User, device Device) (token string, err error) {
+	return mw.next.Login(ctx, user, device)
+}
+
+func (mw instrumentingMiddleware) RequestMagicLink(ctx context.Context, email string) (err error) {
+	return mw.next.RequestMagicLink(ctx, email)
+}
+
+func (mw instrumentingMiddleware) ConsumeMagicLink(ctx context.Context, token string, device Device) (string, error) {
+	return mw.next.ConsumeMagicLink(ctx, token, device)
+}
+
+func (mw instrumentingMiddleware) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	return mw.next.RequestPasswordReset(ctx, email)
+}
+
+func (mw instrumentingMiddleware) ResetPassword(ctx context.Context, token, password string) (err error) {
+	return mw.next.ResetPassword(ctx, token, password)
+}
+
+func (mw instrumentingMiddleware) Refresh(ctx context.Context, token string) (string, error) {
+	return mw.next.Refresh(ctx, token)
+}
+
+func (mw instrumentingMiddleware) CreateAPIKey(
+	ctx context.Context,
+	token string,
+	key NewAPIKey,
+) (APIKey, string, error) {
+	return mw.next.CreateAPIKey(ctx, token, key)
+}
+
+func (mw instrumentingMiddleware) ListAPIKeys(ctx context.Context, token string) ([]APIKey, error) {
+	return mw.next.ListAPIKeys(ctx, token)
+}
+
+func (mw instrumentingMiddleware) RevokeAPIKey(ctx context.Context, token string, id uuid.UUID) error {
+	return mw.next.RevokeAPIKey(ctx, token, id)
+}
+
+func (mw instrumentingMiddleware) ExchangeAPIKey(ctx context.Context, key string) (string, error) {
+	return mw.next.ExchangeAPIKey(ctx, key)
+}
+
+func (mw instrumentingMiddleware) Introspect(ctx context.Context, token string) (Introspection, error) {
+	return mw.next.Introspect(ctx, token)
+}
+
+func (mw instrumentingMiddleware) ListSessions(ctx context.Context, token string) ([]Session, error) {
+	return mw.next.ListSessions(ctx, token)
+}
+
+func (mw instrumentingMiddleware) RevokeSession(ctx context.Context, token string, id uuid.UUID) error {
+	return mw.next.RevokeSession(ctx, token, id)
+}
+
+func (mw instrumentingMiddleware) RevokeSessions(ctx context.Context, token string) error {
+	return mw.next.RevokeSessions(ctx, token)
+}
+
+func (mw instrumentingMiddleware) BeginPasskeyRegistration(ctx context.Context, token string) (PasskeyChallenge, error) {
+	return mw.next.BeginPasskeyRegistration(ctx, token)
+}
+
+func (mw instrumentingMiddleware) FinishPasskeyRegistration(
+	ctx context.Context,
+	token string,
+	challengeID uuid.UUID,
+	name string,
+	credential []byte,
+) (Passkey, error) {
+	return mw.next.FinishPasskeyRegistration(ctx, token, challengeID, name, credential)
+}
+
+func (mw instrumentingMiddleware) BeginPasskeyLogin(ctx context.Context) (PasskeyChallenge, error) {
+	return mw.next.BeginPasskeyLogin(ctx)
+}
+
+func (mw instrumentingMiddleware) FinishPasskeyLogin(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	credential []byte,
+	device Device,
+) (string, error) {
+	return mw.next.FinishPasskeyLogin(ctx, challengeID, credential, device)
 }
 
 func LoggingMiddleware(l log.Logger) Middleware {
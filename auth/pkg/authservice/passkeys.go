@@ -0,0 +1,349 @@
+package authservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// ceremonies a passkey challenge is issued for
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+var (
+	ErrInvalidPasskey    = errors.New("passkey could not be verified")
+	ErrChallengeNotFound = errors.New("passkey challenge is unknown or has expired")
+)
+
+// PasskeyChallenge starts a passkey ceremony. Options are passed to the
+// browser's navigator.credentials.create or get, the answer is sent back
+// with ID.
+type PasskeyChallenge struct {
+	ID      uuid.UUID       `json:"id"`
+	Options json.RawMessage `json:"options"`
+}
+
+// Passkey is a passkey as shown to its owner.
+type Passkey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (s basicService) BeginPasskeyRegistration(ctx context.Context, token string) (PasskeyChallenge, error) {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	rp, err := relyingParty()
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	wu, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	// keys already registered are refused by the authenticator. Keys must
+	// be resident (discoverable), the login asks without an email and
+	// could not find the others
+	exclude := make([]protocol.CredentialDescriptor, 0, len(wu.credentials))
+	for _, c := range wu.credentials {
+		exclude = append(exclude, c.Descriptor())
+	}
+	options, session, err := rp.BeginRegistration(
+		wu,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	return s.newPasskeyChallenge(ctx, ceremonyRegistration, &user.ID, options, session)
+}
+
+func (s basicService) FinishPasskeyRegistration(
+	ctx context.Context,
+	token string,
+	challengeID uuid.UUID,
+	name string,
+	credential []byte,
+) (Passkey, error) {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return Passkey{}, err
+	}
+	challenge, session, err := s.takePasskeyChallenge(ctx, challengeID, ceremonyRegistration)
+	if err != nil {
+		return Passkey{}, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != user.ID {
+		return Passkey{}, ErrChallengeNotFound
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return Passkey{}, ErrInvalidPasskey
+	}
+	rp, err := relyingParty()
+	if err != nil {
+		return Passkey{}, err
+	}
+	wu, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return Passkey{}, err
+	}
+	cred, err := rp.CreateCredential(wu, session, parsed)
+	if err != nil {
+		return Passkey{}, ErrInvalidPasskey
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	if name == "" {
+		name = "passkey"
+	}
+	repoPasskey := repository.Passkey{
+		ID:              uuid.New(),
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      strings.Join(transports, " "),
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	err = s.db.CreatePasskey(ctx, repoPasskey)
+	if err != nil {
+		// the credential is registered already, to this user or another
+		if errors.Is(err, gorm.ErrDuplicatedKey) ||
+			strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return Passkey{}, ErrInvalidPasskey
+		}
+		return Passkey{}, err
+	}
+	return Passkey{ID: repoPasskey.ID, Name: repoPasskey.Name, CreatedAt: repoPasskey.CreatedAt}, nil
+}
+
+// BeginPasskeyLogin never looks a user up: the allowCredentials of a
+// challenge for a given email would tell who has an account, and the ids
+// of their passkeys.
+func (s basicService) BeginPasskeyLogin(ctx context.Context) (PasskeyChallenge, error) {
+	rp, err := relyingParty()
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	options, session, err := rp.BeginDiscoverableLogin()
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	return s.newPasskeyChallenge(ctx, ceremonyLogin, nil, options, session)
+}
+
+func (s basicService) FinishPasskeyLogin(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	credential []byte,
+	device Device,
+) (string, error) {
+	_, session, err := s.takePasskeyChallenge(ctx, challengeID, ceremonyLogin)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return "", ErrInvalidPasskey
+	}
+	rp, err := relyingParty()
+	if err != nil {
+		return "", err
+	}
+
+	var wu *webAuthnUser
+	cred, err := rp.ValidateDiscoverableLogin(
+		func(_, userHandle []byte) (webauthn.User, error) {
+			id, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			user, err := s.db.GetUserById(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			wu, err = s.webAuthnUser(ctx, user)
+			return wu, err
+		},
+		session,
+		parsed,
+	)
+	if err != nil {
+		return "", ErrInvalidPasskey
+	}
+	// a counter that went backwards hints at a cloned authenticator
+	if cred.Authenticator.CloneWarning {
+		return "", ErrInvalidPasskey
+	}
+
+	passkey, ok := wu.passkey(cred.ID)
+	if !ok {
+		return "", ErrInvalidPasskey
+	}
+	err = s.db.UsePasskey(ctx, passkey.ID, cred.Authenticator.SignCount, cred.Flags.BackupState)
+	if err != nil {
+		return "", err
+	}
+	sid, err := s.startSession(ctx, wu.user, device)
+	if err != nil {
+		return "", err
+	}
+	tok, err := NewToken(User{ID: wu.user.ID, Email: wu.user.Email}, sid)
+	if err != nil {
+		return "", ErrGeneratingToken
+	}
+	return tok, nil
+}
+
+// newPasskeyChallenge keeps session until the ceremony is finished or
+// webauthn.challengettl passes, and returns the options of the ceremony.
+func (s basicService) newPasskeyChallenge(
+	ctx context.Context,
+	ceremony string,
+	userID *uuid.UUID,
+	options any,
+	session *webauthn.SessionData,
+) (PasskeyChallenge, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	challenge := repository.PasskeyChallenge{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      data,
+		ExpiresAt: time.Now().Add(viper.GetDuration("webauthn.challengettl")),
+	}
+	if err := s.db.CreatePasskeyChallenge(ctx, challenge); err != nil {
+		return PasskeyChallenge{}, err
+	}
+	return PasskeyChallenge{ID: challenge.ID, Options: rawOptions}, nil
+}
+
+func (s basicService) takePasskeyChallenge(
+	ctx context.Context,
+	id uuid.UUID,
+	ceremony string,
+) (repository.PasskeyChallenge, webauthn.SessionData, error) {
+	challenge, err := s.db.TakePasskeyChallenge(ctx, id, ceremony)
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return repository.PasskeyChallenge{}, webauthn.SessionData{}, ErrChallengeNotFound
+	}
+	if err != nil {
+		return repository.PasskeyChallenge{}, webauthn.SessionData{}, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.Data, &session); err != nil {
+		return repository.PasskeyChallenge{}, webauthn.SessionData{}, err
+	}
+	return challenge, session, nil
+}
+
+// relyingParty is auth as the WebAuthn relying party of webauthn.rpid.
+// Passkeys are bound to the rpid, changing it loses them all.
+func relyingParty() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          viper.GetString("webauthn.rpid"),
+		RPDisplayName: viper.GetString("webauthn.rpname"),
+		RPOrigins:     viper.GetStringSlice("webauthn.origins"),
+	})
+}
+
+// webAuthnUser is a user with its passkeys, as the webauthn package sees
+// it. Its handle is the user's id.
+type webAuthnUser struct {
+	user        repository.User
+	passkeys    []repository.Passkey
+	credentials []webauthn.Credential
+}
+
+func (s basicService) webAuthnUser(ctx context.Context, user repository.User) (*webAuthnUser, error) {
+	passkeys, err := s.db.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, p := range passkeys {
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Fields(p.Transports) {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+	return &webAuthnUser{user: user, passkeys: passkeys, credentials: credentials}, nil
+}
+
+// passkey returns the passkey of the credential id.
+func (u *webAuthnUser) passkey(credentialID []byte) (repository.Passkey, bool) {
+	for _, p := range u.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return p, true
+		}
+	}
+	return repository.Passkey{}, false
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
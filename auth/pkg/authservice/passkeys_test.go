@@ -0,0 +1,294 @@
+package authservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// testOrigin is the origin of the pages in the tests' webauthn config.
+const testOrigin = "http://localhost:5002"
+
+var b64 = base64.RawURLEncoding
+
+// authenticator is a software passkey authenticator keeping one resident
+// ECDSA P-256 key, answering challenges as a browser would pass them on.
+type authenticator struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	id     []byte
+	user   []byte
+	origin string
+	count  uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &authenticator{t: t, key: key, id: id, origin: testOrigin}
+}
+
+// challenge returns the challenge of the options of a ceremony.
+func (a *authenticator) challenge(options json.RawMessage) string {
+	var o struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &o); err != nil || o.PublicKey.Challenge == "" {
+		a.t.Fatalf("options %s have no challenge: %v", options, err)
+	}
+	return o.PublicKey.Challenge
+}
+
+func (a *authenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// authData is the authenticator data for localhost with flags and the
+// next signature count, followed by rest.
+func (a *authenticator) authData(flags protocol.AuthenticatorFlags, rest []byte) []byte {
+	rpID := sha256.Sum256([]byte("localhost"))
+	a.count++
+	data := append(rpID[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	return append(data, rest...)
+}
+
+// create answers a registration challenge with a new credential, with
+// attestation none.
+func (a *authenticator) create(options json.RawMessage) []byte {
+	var o struct {
+		PublicKey struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	json.Unmarshal(options, &o)
+	a.user, _ = b64.DecodeString(o.PublicKey.User.ID)
+
+	cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("encoding the public key: %v", err)
+	}
+	credential := make([]byte, 16) // an all zero aaguid
+	credential = binary.BigEndian.AppendUint16(credential, uint16(len(a.id)))
+	credential = append(credential, a.id...)
+	credential = append(credential, cose...)
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flags, credential),
+	})
+	if err != nil {
+		a.t.Fatalf("encoding the attestation: %v", err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", a.challenge(options))),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	return body
+}
+
+// get answers a login challenge with a signed assertion of the resident
+// key, naming its user.
+func (a *authenticator) get(options json.RawMessage) []byte {
+	clientData := a.clientData("webauthn.get", a.challenge(options))
+	authData := a.authData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("signing: %v", err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(sig),
+			"userHandle":        b64.EncodeToString(a.user),
+		},
+	})
+	return body
+}
+
+// loggedIn returns a token of a session of user in repo.
+func loggedIn(t *testing.T, repo *memRepo, user repository.User) string {
+	sid := uuid.New()
+	repo.sessions[sid] = repository.Session{ID: sid, UserID: user.ID, CreatedAt: time.Now()}
+	token, err := NewToken(User{ID: user.ID, Email: user.Email}, sid)
+	if err != nil {
+		t.Fatalf("NewToken: %v", err)
+	}
+	return token
+}
+
+// registered returns a service whose user alice registered the passkey of
+// the authenticator it returns.
+func registered(t *testing.T) (Service, *memRepo, *authenticator) {
+	repo := newMemRepo(alice)
+	svc := newTestService(t, repo, newMailbox())
+	ctx := context.Background()
+	token := loggedIn(t, repo, alice)
+
+	a := newAuthenticator(t)
+	c, err := svc.BeginPasskeyRegistration(ctx, token)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if _, err := svc.FinishPasskeyRegistration(ctx, token, c.ID, "laptop", a.create(c.Options)); err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+	return svc, repo, a
+}
+
+// The login asks without an email, only resident keys can answer it.
+func TestPasskeyRegistrationResidentKey(t *testing.T) {
+	repo := newMemRepo(alice)
+	svc := newTestService(t, repo, newMailbox())
+	c, err := svc.BeginPasskeyRegistration(context.Background(), loggedIn(t, repo, alice))
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	var o struct {
+		PublicKey struct {
+			AuthenticatorSelection protocol.AuthenticatorSelection `json:"authenticatorSelection"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(c.Options, &o); err != nil {
+		t.Fatalf("options %s: %v", c.Options, err)
+	}
+	sel := o.PublicKey.AuthenticatorSelection
+	if sel.ResidentKey != protocol.ResidentKeyRequirementRequired || sel.RequireResidentKey == nil || !*sel.RequireResidentKey {
+		t.Errorf("authenticator selection %+v, want a resident key required", sel)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	svc, repo, a := registered(t)
+	ctx := context.Background()
+
+	c, err := svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	credential := a.get(c.Options)
+	token, err := svc.FinishPasskeyLogin(ctx, c.ID, credential, Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims["email"] != alice.Email {
+		t.Errorf("logged in as %v, want %s", claims["email"], alice.Email)
+	}
+	if got := repo.passkeys[0].SignCount; got != a.count {
+		t.Errorf("stored sign count %d, want the authenticator's %d", got, a.count)
+	}
+
+	// a challenge is answered once
+	if _, err := svc.FinishPasskeyLogin(ctx, c.ID, credential, Device{}); !errors.Is(err, ErrChallengeNotFound) {
+		t.Errorf("replayed assertion = %v, want %v", err, ErrChallengeNotFound)
+	}
+}
+
+func TestPasskeyLoginRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the authenticator before it answers
+		tamper func(a *authenticator)
+	}{
+		{"other origin", func(a *authenticator) { a.origin = "https://evil.example.com" }},
+		{"cloned authenticator", func(a *authenticator) { a.count = 0 }},
+		{"other key", func(a *authenticator) {
+			a.key = newAuthenticator(a.t).key
+		}},
+		{"unknown user", func(a *authenticator) {
+			id := uuid.New()
+			a.user = id[:]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, a := registered(t)
+			ctx := context.Background()
+			// a first login moves the stored sign count past zero
+			c, _ := svc.BeginPasskeyLogin(ctx)
+			if _, err := svc.FinishPasskeyLogin(ctx, c.ID, a.get(c.Options), Device{}); err != nil {
+				t.Fatalf("FinishPasskeyLogin: %v", err)
+			}
+
+			tt.tamper(a)
+			c, _ = svc.BeginPasskeyLogin(ctx)
+			if _, err := svc.FinishPasskeyLogin(ctx, c.ID, a.get(c.Options), Device{}); !errors.Is(err, ErrInvalidPasskey) {
+				t.Errorf("FinishPasskeyLogin = %v, want %v", err, ErrInvalidPasskey)
+			}
+		})
+	}
+}
+
+// The login challenge is the same for everyone, it names no credentials
+// that would tell who has an account.
+func TestBeginPasskeyLoginIsDiscoverable(t *testing.T) {
+	svc, repo, _ := registered(t)
+	c, err := svc.BeginPasskeyLogin(context.Background())
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	var o struct {
+		PublicKey map[string]json.RawMessage `json:"publicKey"`
+	}
+	if err := json.Unmarshal(c.Options, &o); err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	if allow, ok := o.PublicKey["allowCredentials"]; ok {
+		t.Errorf("options list credentials: %s", allow)
+	}
+	if challenge := repo.challenges[c.ID]; challenge.UserID != nil {
+		t.Errorf("challenge is bound to user %s", challenge.UserID)
+	}
+}
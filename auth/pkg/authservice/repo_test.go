@@ -0,0 +1,287 @@
+package authservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+
+	"github.com/F1zm0n/uni-auth/pkg/notify"
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// memRepo is an in-memory Repository with what the tests need, like the
+// postgres one it uses links up once. Methods the tests do not need panic
+// on the nil embedded Repository.
+type memRepo struct {
+	repository.Repository
+
+	mu         sync.Mutex
+	users      map[string]repository.User
+	links      map[string]repository.MagicLink
+	sessions   map[uuid.UUID]repository.Session
+	passkeys   []repository.Passkey
+	challenges map[uuid.UUID]repository.PasskeyChallenge
+	keys       map[uuid.UUID]repository.APIKey
+}
+
+func newMemRepo(users ...repository.User) *memRepo {
+	r := &memRepo{
+		users:      make(map[string]repository.User),
+		links:      make(map[string]repository.MagicLink),
+		sessions:   make(map[uuid.UUID]repository.Session),
+		challenges: make(map[uuid.UUID]repository.PasskeyChallenge),
+		keys:       make(map[uuid.UUID]repository.APIKey),
+	}
+	for _, u := range users {
+		r.users[u.Email] = u
+	}
+	return r
+}
+
+func (r *memRepo) GetUserByEmail(_ context.Context, email string) (repository.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[email]
+	if !ok {
+		return repository.User{}, gorm.ErrRecordNotFound
+	}
+	return u, nil
+}
+
+func (r *memRepo) GetUserById(_ context.Context, id uuid.UUID) (repository.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return repository.User{}, gorm.ErrRecordNotFound
+}
+
+func (r *memRepo) CreateMagicLink(_ context.Context, link repository.MagicLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.links[hex.EncodeToString(link.TokenHash)] = link
+	return nil
+}
+
+func (r *memRepo) UseMagicLink(ctx context.Context, tokenHash []byte) (repository.User, error) {
+	r.mu.Lock()
+	link, ok := r.links[hex.EncodeToString(tokenHash)]
+	if !ok || link.UsedAt != nil || !link.ExpiresAt.After(time.Now()) {
+		r.mu.Unlock()
+		return repository.User{}, repository.ErrTokenNotFound
+	}
+	now := time.Now()
+	for k, l := range r.links {
+		if l.UserID == link.UserID && l.UsedAt == nil {
+			l.UsedAt = &now
+			r.links[k] = l
+		}
+	}
+	r.mu.Unlock()
+	return r.GetUserById(ctx, link.UserID)
+}
+
+func (r *memRepo) CreateSession(_ context.Context, session repository.Session) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID] = session
+	return false, nil
+}
+
+func (r *memRepo) TouchSession(_ context.Context, id uuid.UUID) (repository.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok || s.RevokedAt != nil {
+		return repository.Session{}, repository.ErrTokenNotFound
+	}
+	return s, nil
+}
+
+func (r *memRepo) RevokeSession(_ context.Context, userID, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok || s.UserID != userID || s.RevokedAt != nil {
+		return repository.ErrTokenNotFound
+	}
+	now := time.Now()
+	s.RevokedAt = &now
+	r.sessions[id] = s
+	return nil
+}
+
+func (r *memRepo) RevokeSessions(_ context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, s := range r.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &now
+			r.sessions[id] = s
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) CreatePasskey(_ context.Context, passkey repository.Passkey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.passkeys {
+		if bytes.Equal(p.CredentialID, passkey.CredentialID) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	r.passkeys = append(r.passkeys, passkey)
+	return nil
+}
+
+func (r *memRepo) ListPasskeys(_ context.Context, userID uuid.UUID) ([]repository.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var passkeys []repository.Passkey
+	for _, p := range r.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (r *memRepo) UsePasskey(_ context.Context, id uuid.UUID, signCount uint32, backupState bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.passkeys {
+		if p.ID == id {
+			now := time.Now()
+			r.passkeys[i].SignCount = signCount
+			r.passkeys[i].BackupState = backupState
+			r.passkeys[i].LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) CreatePasskeyChallenge(_ context.Context, challenge repository.PasskeyChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.challenges[challenge.ID] = challenge
+	return nil
+}
+
+func (r *memRepo) TakePasskeyChallenge(_ context.Context, id uuid.UUID, ceremony string) (repository.PasskeyChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.challenges[id]
+	if !ok || c.Ceremony != ceremony || !c.ExpiresAt.After(time.Now()) {
+		return repository.PasskeyChallenge{}, repository.ErrTokenNotFound
+	}
+	delete(r.challenges, id)
+	return c, nil
+}
+
+func (r *memRepo) CreateAPIKey(_ context.Context, key repository.APIKey, max int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, k := range r.keys {
+		if k.UserID == key.UserID && k.RevokedAt == nil && k.ExpiresAt.After(time.Now()) {
+			n++
+		}
+	}
+	if n >= max {
+		return repository.ErrTooManyKeys
+	}
+	r.keys[key.ID] = key
+	return nil
+}
+
+func (r *memRepo) RevokeAPIKey(_ context.Context, userID, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return repository.ErrTokenNotFound
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	r.keys[id] = k
+	return nil
+}
+
+func (r *memRepo) UseAPIKey(_ context.Context, keyHash []byte) (repository.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, k := range r.keys {
+		if bytes.Equal(k.KeyHash, keyHash) && k.RevokedAt == nil && k.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			k.LastUsedAt = &now
+			r.keys[id] = k
+			return k, nil
+		}
+	}
+	return repository.APIKey{}, repository.ErrTokenNotFound
+}
+
+func (r *memRepo) GetAPIKey(_ context.Context, id uuid.UUID) (repository.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return repository.APIKey{}, repository.ErrTokenNotFound
+	}
+	return k, nil
+}
+
+// mailbox is a Notifier keeping what it was asked to send.
+type mailbox struct {
+	mu     sync.Mutex
+	links  map[string][]string
+	resets map[string][]string
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{links: make(map[string][]string), resets: make(map[string][]string)}
+}
+
+func (m *mailbox) SendPasswordReset(_ context.Context, email, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets[email] = append(m.resets[email], token)
+	return nil
+}
+
+func (m *mailbox) SendMagicLink(_ context.Context, email, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[email] = append(m.links[email], token)
+	return nil
+}
+
+func (m *mailbox) SendNewDevice(context.Context, notify.NewDevice) error { return nil }
+
+// newTestService returns a service over repo with the config of a test
+// deployment.
+func newTestService(t *testing.T, repo repository.Repository, notifier notify.Notifier) Service {
+	t.Helper()
+	viper.Set("auth.secret", "test-secret")
+	viper.Set("auth.tokenttl", time.Hour)
+	viper.Set("auth.magiclinkttl", 10*time.Minute)
+	viper.Set("webauthn.rpid", "localhost")
+	viper.Set("webauthn.rpname", "universal")
+	viper.Set("webauthn.origins", []string{testOrigin})
+	viper.Set("webauthn.challengettl", 5*time.Minute)
+	t.Cleanup(viper.Reset)
+	return NewBasicService(repo, notifier, log.NewNopLogger())
+}
@@ -2,8 +2,10 @@ package authservice
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
-	"net/mail"
 	"strings"
 	"time"
 
@@ -14,46 +16,112 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/F1zm0n/uni-auth/pkg/notify"
 	"github.com/F1zm0n/uni-auth/repository"
 )
 
 type Service interface {
 	Register(ctx context.Context, user User) error
-	Login(ctx context.Context, user User) (token string, err error)
+	// Login starts a session of the user on device, mailing a security
+	// notice when the user never logged in from it before.
+	Login(ctx context.Context, user User, device Device) (token string, err error)
+	// RequestMagicLink mails a single use login link to the user with
+	// email. Like RequestPasswordReset it succeeds for unknown emails.
+	RequestMagicLink(ctx context.Context, email string) error
+	// ConsumeMagicLink uses up a login link and logs its user in on
+	// device, as Login does.
+	ConsumeMagicLink(ctx context.Context, token string, device Device) (string, error)
+	// RequestPasswordReset mails a reset link to the user with email. It
+	// succeeds for unknown emails too, so it cannot be used to probe for
+	// accounts.
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password with a reset token and ends every
+	// session of its user, whoever knew the old password is logged out.
+	ResetPassword(ctx context.Context, token, password string) error
+	// Refresh exchanges an unexpired token for a new one, for sessions that
+	// outlive a single token. Sessions cannot be refreshed past their
+	// maximum age, auth.sessionmaxage after the login.
+	Refresh(ctx context.Context, token string) (string, error)
+	// CreateAPIKey creates a key for the user of token, returning the key
+	// itself only this once.
+	CreateAPIKey(ctx context.Context, token string, key NewAPIKey) (APIKey, string, error)
+	ListAPIKeys(ctx context.Context, token string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, token string, id uuid.UUID) error
+	// ExchangeAPIKey validates key and returns a short lived token carrying
+	// its scopes.
+	ExchangeAPIKey(ctx context.Context, key string) (string, error)
+	// Introspect tells whether token is active and what it grants, after
+	// RFC 7662, for resource servers without the signing secret. Inactive
+	// tokens are no error.
+	Introspect(ctx context.Context, token string) (Introspection, error)
+	// ListSessions returns the live sessions of the user of token.
+	ListSessions(ctx context.Context, token string) ([]Session, error)
+	// RevokeSession ends a session of the user of token, its tokens can
+	// no longer be refreshed nor used with auth.
+	RevokeSession(ctx context.Context, token string, id uuid.UUID) error
+	// RevokeSessions ends every session of the user of token, its own
+	// included.
+	RevokeSessions(ctx context.Context, token string) error
+	// BeginPasskeyRegistration challenges the authenticator of the user of
+	// token to create a passkey.
+	BeginPasskeyRegistration(ctx context.Context, token string) (PasskeyChallenge, error)
+	// FinishPasskeyRegistration verifies the authenticator's answer to the
+	// challenge and stores the passkey it created under name.
+	FinishPasskeyRegistration(
+		ctx context.Context,
+		token string,
+		challengeID uuid.UUID,
+		name string,
+		credential []byte,
+	) (Passkey, error)
+	// BeginPasskeyLogin challenges an authenticator to log in with one of
+	// the passkeys it keeps, the passkey telling whose it is. The challenge
+	// names no credentials, so that it tells nothing about any account.
+	BeginPasskeyLogin(ctx context.Context) (PasskeyChallenge, error)
+	// FinishPasskeyLogin verifies the authenticator's answer to the
+	// challenge and logs its user in on device, as Login does.
+	FinishPasskeyLogin(
+		ctx context.Context,
+		challengeID uuid.UUID,
+		credential []byte,
+		device Device,
+	) (string, error)
 }
 
 type basicService struct {
-	db repository.Repository
+	db       repository.Repository
+	notifier notify.Notifier
+	logger   log.Logger
 }
 
-func NewBasicService(db repository.Repository) Service {
+func NewBasicService(db repository.Repository, notifier notify.Notifier, logger log.Logger) Service {
 	return basicService{
-		db: db,
+		db:       db,
+		notifier: notifier,
+		logger:   logger,
 	}
 }
 
 var (
-	ErrWrongEmailFmt     = errors.New("wrong email format")
-	ErrWrongPassFmt      = errors.New("password should be minimum 8 length long")
 	ErrInsertingUser     = errors.New("error inserting user")
 	ErrInvalidCreds      = errors.New("invalid credentials")
 	ErrGeneratingToken   = errors.New("error generating jwt token")
 	ErrUserAlreadyExists = errors.New("user with this email already exists")
+	ErrInvalidResetToken = errors.New("password reset link is invalid or has expired")
+	ErrInvalidToken      = errors.New("token is invalid or has expired")
 )
 
 type User struct {
 	ID       uuid.UUID
 	Email    string
 	Password string
-}
-
-func validateEmail(email string) bool {
-	_, err := mail.ParseAddress(email)
-	return err == nil
+	// IdempotencyKey makes Register safe to retry: a second registration
+	// with the same key succeeds instead of failing as a duplicate.
+	IdempotencyKey string
 }
 
 func (s basicService) Register(ctx context.Context, user User) error {
-	repoUser, err := validateUserCreds(user)
+	repoUser, err := newRepoUser(user)
 	if err != nil {
 		return err
 	}
@@ -62,6 +130,9 @@ func (s basicService) Register(ctx context.Context, user User) error {
 	if err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) ||
 			strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			if s.isReplay(ctx, user) {
+				return nil
+			}
 			return ErrUserAlreadyExists
 		}
 
@@ -70,7 +141,20 @@ func (s basicService) Register(ctx context.Context, user User) error {
 	return nil
 }
 
-func (s basicService) Login(ctx context.Context, user User) (string, error) {
+// isReplay reports whether the existing user with this email was created by
+// a registration carrying the same idempotency key.
+func (s basicService) isReplay(ctx context.Context, user User) bool {
+	if user.IdempotencyKey == "" {
+		return false
+	}
+	existing, err := s.db.GetUserByEmail(ctx, user.Email)
+	if err != nil || existing.IdempotencyKey == nil {
+		return false
+	}
+	return *existing.IdempotencyKey == user.IdempotencyKey
+}
+
+func (s basicService) Login(ctx context.Context, user User, device Device) (string, error) {
 	resUser, err := s.db.GetUserByEmail(ctx, user.Email)
 	if err != nil {
 		return "", ErrInvalidCreds
@@ -78,7 +162,11 @@ func (s basicService) Login(ctx context.Context, user User) (string, error) {
 	if err = bcrypt.CompareHashAndPassword(resUser.Password, []byte(user.Password)); err != nil {
 		return "", ErrInvalidCreds
 	}
-	tok, err := NewToken(user)
+	sid, err := s.startSession(ctx, resUser, device)
+	if err != nil {
+		return "", err
+	}
+	tok, err := NewToken(User{ID: resUser.ID, Email: resUser.Email}, sid)
 	if err != nil {
 		return "", ErrGeneratingToken
 	}
@@ -86,13 +174,117 @@ func (s basicService) Login(ctx context.Context, user User) (string, error) {
 	return tok, nil
 }
 
-func NewToken(user User) (string, error) {
+func (s basicService) Refresh(ctx context.Context, token string) (string, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	// tokens of API keys are exchanged again instead, so that they
+	// neither lose their scopes nor outlive their key
+	if _, ok := claims["scope"]; ok {
+		return "", ErrScopedToken
+	}
+	// revoking a session ends its refreshes
+	session, err := s.checkSession(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+	// so does its maximum age, tokens from before sessions cannot tell
+	// when they logged in and run out instead
+	expiresAt := time.Now().Add(viper.GetDuration("auth.tokenttl"))
+	if end, ok := sessionEnd(session); ok {
+		if session.ID == uuid.Nil || !time.Now().Before(end) {
+			return "", ErrSessionExpired
+		}
+		if end.Before(expiresAt) {
+			expiresAt = end
+		}
+	}
+	email, _ := claims["email"].(string)
+	// the account may have been removed since the token was issued
+	resUser, err := s.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	tok, err := newToken(User{ID: resUser.ID, Email: resUser.Email}, session.ID, expiresAt)
+	if err != nil {
+		return "", ErrGeneratingToken
+	}
+	return tok, nil
+}
+
+func (s basicService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, hash, err := newResetToken()
+	if err != nil {
+		return err
+	}
+	err = s.db.CreatePasswordReset(ctx, repository.PasswordReset{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(viper.GetDuration("auth.resetttl")),
+	})
+	if err != nil {
+		return err
+	}
+	return s.notifier.SendPasswordReset(ctx, user.Email, token)
+}
+
+func (s basicService) ResetPassword(ctx context.Context, token, password string) error {
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	err = s.db.ResetPassword(ctx, hashResetToken(token), passHash)
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return ErrInvalidResetToken
+	}
+	return err
+}
+
+// newResetToken returns a random token for the reset link and the hash
+// that is stored in its place.
+func newResetToken() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// NewToken issues a token of user in the session sid, or in none when sid
+// is uuid.Nil.
+func NewToken(user User, sid uuid.UUID) (string, error) {
+	return newToken(user, sid, time.Now().Add(viper.GetDuration("auth.tokenttl")))
+}
+
+// newToken is NewToken expiring at expiresAt.
+func newToken(user User, sid uuid.UUID, expiresAt time.Time) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(viper.GetDuration("auth.tokenttl")).Unix()
+	if sid != uuid.Nil {
+		claims["sid"] = sid
+	}
+	claims["exp"] = expiresAt.Unix()
 
 	tokenString, err := token.SignedString([]byte(viper.GetString("auth.secret")))
 	if err != nil {
@@ -102,28 +294,46 @@ func NewToken(user User) (string, error) {
 	return tokenString, nil
 }
 
-func validateUserCreds(user User) (repository.User, error) {
-	if ok := validateEmail(user.Email); !ok {
-		return repository.User{}, ErrWrongEmailFmt
-	}
-	if len(user.Password) < 8 {
-		return repository.User{}, ErrWrongPassFmt
+// ParseToken verifies a token issued by NewToken and returns its claims.
+func ParseToken(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		token,
+		claims,
+		func(*jwt.Token) (interface{}, error) {
+			return []byte(viper.GetString("auth.secret")), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
+
+// newRepoUser hashes the password of a user. Emails and passwords are
+// checked against the password policy by the endpoint layer beforehand.
+func newRepoUser(user User) (repository.User, error) {
 	passHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return repository.User{}, err
 	}
-	return repository.User{
+	repoUser := repository.User{
 		ID:       uuid.New(),
 		Email:    user.Email,
 		Password: passHash,
-	}, nil
+	}
+	if user.IdempotencyKey != "" {
+		repoUser.IdempotencyKey = &user.IdempotencyKey
+	}
+	return repoUser, nil
 }
 
-func New(logger log.Logger, repo repository.Repository) Service {
+func New(logger log.Logger, repo repository.Repository, notifier notify.Notifier) Service {
 	var svc Service
 	{
-		svc = NewBasicService(repo)
+		svc = NewBasicService(repo, notifier, logger)
 		svc = LoggingMiddleware(logger)(svc)
 		svc = InstrumentingMiddleware()(svc)
 	}
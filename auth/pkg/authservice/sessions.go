@@ -0,0 +1,173 @@
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/uni-auth/pkg/notify"
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// maxUserAgentLen is how much of a user agent is kept.
+const maxUserAgentLen = 512
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session has expired, log in again")
+)
+
+// Device is where a user logs in from. Name is what the user calls it, the
+// rest is taken from the request.
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// Session is a login as shown to its user.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current is the session of the token listing the sessions.
+	Current bool `json:"current"`
+}
+
+// startSession records a login of user from device and mails a notice when
+// the device is new to the user. The login succeeds when the notice fails.
+func (s basicService) startSession(ctx context.Context, user repository.User, device Device) (uuid.UUID, error) {
+	if device.Name == "" {
+		device.Name = "unknown device"
+	}
+	if len(device.UserAgent) > maxUserAgentLen {
+		device.UserAgent = device.UserAgent[:maxUserAgentLen]
+	}
+	sum := sha256.Sum256([]byte(device.Name + "\x00" + device.UserAgent))
+	now := time.Now()
+	session := repository.Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		Device:     device.Name,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		DeviceHash: sum[:],
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	newDevice, err := s.db.CreateSession(ctx, session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if newDevice {
+		err = s.notifier.SendNewDevice(ctx, notify.NewDevice{
+			Email:     user.Email,
+			Device:    device.Name,
+			UserAgent: device.UserAgent,
+			IP:        device.IP,
+			At:        now,
+		})
+		if err != nil {
+			s.logger.Log("operation", "sending new device notice", "error", err)
+		}
+	}
+	return session.ID, nil
+}
+
+// checkSession refuses the tokens of revoked sessions and records the
+// others as seen, returning their session. Tokens issued before sessions
+// have none and pass until they expire, with a zero session.
+func (s basicService) checkSession(ctx context.Context, claims jwt.MapClaims) (repository.Session, error) {
+	sid, ok := claims["sid"].(string)
+	if !ok {
+		return repository.Session{}, nil
+	}
+	id, err := uuid.Parse(sid)
+	if err != nil {
+		return repository.Session{}, ErrInvalidToken
+	}
+	session, err := s.db.TouchSession(ctx, id)
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return repository.Session{}, ErrInvalidToken
+	}
+	return session, err
+}
+
+// sessionEnd is when session must be logged into again, however often its
+// tokens are refreshed. Sessions without a maximum age never end.
+func sessionEnd(session repository.Session) (time.Time, bool) {
+	maxAge := viper.GetDuration("auth.sessionmaxage")
+	if maxAge <= 0 {
+		return time.Time{}, false
+	}
+	return session.CreatedAt.Add(maxAge), true
+}
+
+func (s basicService) ListSessions(ctx context.Context, token string) ([]Session, error) {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	current := sessionID(token)
+	// sessions idle for longer than a token lives cannot be refreshed
+	since := time.Now().Add(-viper.GetDuration("auth.tokenttl"))
+	repoSessions, err := s.db.ListSessions(ctx, user.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(repoSessions))
+	for _, rs := range repoSessions {
+		sessions = append(sessions, Session{
+			ID:         rs.ID,
+			Device:     rs.Device,
+			UserAgent:  rs.UserAgent,
+			IP:         rs.IP,
+			CreatedAt:  rs.CreatedAt,
+			LastSeenAt: rs.LastSeenAt,
+			Current:    rs.ID == current,
+		})
+	}
+	return sessions, nil
+}
+
+func (s basicService) RevokeSession(ctx context.Context, token string, id uuid.UUID) error {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return err
+	}
+	err = s.db.RevokeSession(ctx, user.ID, id)
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s basicService) RevokeSessions(ctx context.Context, token string) error {
+	user, err := s.owner(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.db.RevokeSessions(ctx, user.ID)
+}
+
+// sessionID returns the session of a valid token, or uuid.Nil.
+func sessionID(token string) uuid.UUID {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return uuid.Nil
+	}
+	sid, _ := claims["sid"].(string)
+	id, err := uuid.Parse(sid)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
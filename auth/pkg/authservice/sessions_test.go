@@ -0,0 +1,85 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+func TestRefreshAfterRevokeSession(t *testing.T) {
+	repo := newMemRepo(alice)
+	svc := newTestService(t, repo, newMailbox())
+	ctx := context.Background()
+	laptop, phone := loggedIn(t, repo, alice), loggedIn(t, repo, alice)
+
+	refreshed, err := svc.Refresh(ctx, phone)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if sessionID(refreshed) != sessionID(phone) {
+		t.Errorf("refreshed into session %v, want %v", sessionID(refreshed), sessionID(phone))
+	}
+
+	if err := svc.RevokeSession(ctx, laptop, sessionID(phone)); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	// every token of the session is done, the refreshed one included
+	for _, token := range []string{phone, refreshed} {
+		if _, err := svc.Refresh(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Refresh of a revoked session = %v, want %v", err, ErrInvalidToken)
+		}
+	}
+	if _, err := svc.Refresh(ctx, laptop); err != nil {
+		t.Errorf("Refresh of another session = %v", err)
+	}
+	if err := svc.RevokeSession(ctx, laptop, sessionID(phone)); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("second RevokeSession = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	// sessions are revoked by their user only
+	bob := repository.User{ID: uuid.New(), Email: "bob@example.com"}
+	repo.users[bob.Email] = bob
+	if err := svc.RevokeSession(ctx, loggedIn(t, repo, bob), sessionID(laptop)); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("RevokeSession by another user = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	if err := svc.RevokeSessions(ctx, laptop); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, laptop); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh after RevokeSessions = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestRefreshSessionMaxAge(t *testing.T) {
+	repo := newMemRepo(alice)
+	svc := newTestService(t, repo, newMailbox())
+	viper.Set("auth.sessionmaxage", 2*time.Hour)
+	ctx := context.Background()
+	token := loggedIn(t, repo, alice)
+
+	// refreshed tokens stop at the end of their session
+	session := repo.sessions[sessionID(token)]
+	session.CreatedAt = time.Now().Add(-90 * time.Minute)
+	repo.sessions[session.ID] = session
+	refreshed, err := svc.Refresh(ctx, token)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	claims, _ := ParseToken(refreshed)
+	if exp, _ := claims.GetExpirationTime(); exp.After(session.CreatedAt.Add(2*time.Hour + time.Second)) {
+		t.Errorf("refreshed token expires %v, after its session", exp)
+	}
+
+	session.CreatedAt = time.Now().Add(-3 * time.Hour)
+	repo.sessions[session.ID] = session
+	if _, err := svc.Refresh(ctx, token); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Refresh of an old session = %v, want %v", err, ErrSessionExpired)
+	}
+}
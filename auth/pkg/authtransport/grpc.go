@@ -2,33 +2,57 @@ package authtransport
 
 import (
 	"context"
-	"errors"
+	"strings"
 	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/ratelimit"
 	"github.com/go-kit/kit/transport"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
 	"github.com/go-kit/log"
-	"github.com/sony/gobreaker"
+	"github.com/google/uuid"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	authv1 "github.com/F1zm0n/uni-auth/pb"
 	"github.com/F1zm0n/uni-auth/pkg/authendpoint"
 	"github.com/F1zm0n/uni-auth/pkg/authservice"
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/resilience"
 )
 
 type grpcServer struct {
-	login    grpctransport.Handler
-	register grpctransport.Handler
+	login          grpctransport.Handler
+	register       grpctransport.Handler
+	forgotPassword grpctransport.Handler
+	resetPassword  grpctransport.Handler
+	refresh        grpctransport.Handler
+	createAPIKey   grpctransport.Handler
+	listAPIKeys    grpctransport.Handler
+	revokeAPIKey   grpctransport.Handler
+	exchangeAPIKey grpctransport.Handler
+	introspect     grpctransport.Handler
+	listSessions   grpctransport.Handler
+	revokeSession  grpctransport.Handler
+	revokeSessions grpctransport.Handler
+
+	requestMagicLink grpctransport.Handler
+	consumeMagicLink grpctransport.Handler
+
+	beginPasskeyRegistration  grpctransport.Handler
+	finishPasskeyRegistration grpctransport.Handler
+	beginPasskeyLogin         grpctransport.Handler
+	finishPasskeyLogin        grpctransport.Handler
 	authv1.UnimplementedAuthServiceServer
 }
 
 func NewGRPCServer(endpoints authendpoint.Set, logger log.Logger) authv1.AuthServiceServer {
 	options := []grpctransport.ServerOption{
 		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		grpctransport.ServerBefore(idempotency.GRPCToContext(), oauth.GRPCToContext()),
 	}
 
 	return &grpcServer{
@@ -44,6 +68,108 @@ func NewGRPCServer(endpoints authendpoint.Set, logger log.Logger) authv1.AuthSer
 			encodeGRPCRegisterResponse,
 			options...,
 		),
+		forgotPassword: grpctransport.NewServer(
+			endpoints.ForgotPasswordEndpoint,
+			decodeGRPCForgotPasswordRequest,
+			encodeGRPCForgotPasswordResponse,
+			options...,
+		),
+		resetPassword: grpctransport.NewServer(
+			endpoints.ResetPasswordEndpoint,
+			decodeGRPCResetPasswordRequest,
+			encodeGRPCResetPasswordResponse,
+			options...,
+		),
+		refresh: grpctransport.NewServer(
+			endpoints.RefreshEndpoint,
+			decodeGRPCRefreshRequest,
+			encodeGRPCRefreshResponse,
+			options...,
+		),
+		createAPIKey: grpctransport.NewServer(
+			endpoints.CreateAPIKeyEndpoint,
+			decodeGRPCCreateAPIKeyRequest,
+			encodeGRPCCreateAPIKeyResponse,
+			options...,
+		),
+		listAPIKeys: grpctransport.NewServer(
+			endpoints.ListAPIKeysEndpoint,
+			decodeGRPCListAPIKeysRequest,
+			encodeGRPCListAPIKeysResponse,
+			options...,
+		),
+		revokeAPIKey: grpctransport.NewServer(
+			endpoints.RevokeAPIKeyEndpoint,
+			decodeGRPCRevokeAPIKeyRequest,
+			encodeGRPCRevokeAPIKeyResponse,
+			options...,
+		),
+		exchangeAPIKey: grpctransport.NewServer(
+			endpoints.ExchangeAPIKeyEndpoint,
+			decodeGRPCExchangeAPIKeyRequest,
+			encodeGRPCExchangeAPIKeyResponse,
+			options...,
+		),
+		introspect: grpctransport.NewServer(
+			endpoints.IntrospectEndpoint,
+			decodeGRPCIntrospectRequest,
+			encodeGRPCIntrospectResponse,
+			options...,
+		),
+		listSessions: grpctransport.NewServer(
+			endpoints.ListSessionsEndpoint,
+			decodeGRPCListSessionsRequest,
+			encodeGRPCListSessionsResponse,
+			options...,
+		),
+		revokeSession: grpctransport.NewServer(
+			endpoints.RevokeSessionEndpoint,
+			decodeGRPCRevokeSessionRequest,
+			encodeGRPCRevokeSessionResponse,
+			options...,
+		),
+		revokeSessions: grpctransport.NewServer(
+			endpoints.RevokeSessionsEndpoint,
+			decodeGRPCRevokeSessionsRequest,
+			encodeGRPCRevokeSessionsResponse,
+			options...,
+		),
+		requestMagicLink: grpctransport.NewServer(
+			endpoints.RequestMagicLinkEndpoint,
+			decodeGRPCRequestMagicLinkRequest,
+			encodeGRPCRequestMagicLinkResponse,
+			options...,
+		),
+		consumeMagicLink: grpctransport.NewServer(
+			endpoints.ConsumeMagicLinkEndpoint,
+			decodeGRPCConsumeMagicLinkRequest,
+			encodeGRPCConsumeMagicLinkResponse,
+			options...,
+		),
+		beginPasskeyRegistration: grpctransport.NewServer(
+			endpoints.BeginPasskeyRegistrationEndpoint,
+			decodeGRPCBeginPasskeyRegistrationRequest,
+			encodeGRPCPasskeyChallengeResponse,
+			options...,
+		),
+		finishPasskeyRegistration: grpctransport.NewServer(
+			endpoints.FinishPasskeyRegistrationEndpoint,
+			decodeGRPCFinishPasskeyRegistrationRequest,
+			encodeGRPCFinishPasskeyRegistrationResponse,
+			options...,
+		),
+		beginPasskeyLogin: grpctransport.NewServer(
+			endpoints.BeginPasskeyLoginEndpoint,
+			decodeGRPCBeginPasskeyLoginRequest,
+			encodeGRPCPasskeyChallengeResponse,
+			options...,
+		),
+		finishPasskeyLogin: grpctransport.NewServer(
+			endpoints.FinishPasskeyLoginEndpoint,
+			decodeGRPCFinishPasskeyLoginRequest,
+			encodeGRPCFinishPasskeyLoginResponse,
+			options...,
+		),
 	}
 }
 
@@ -69,16 +195,207 @@ func (s *grpcServer) Register(
 	return rep.(*authv1.RegisterResponse), nil
 }
 
-func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) authservice.Service {
+func (s *grpcServer) ForgotPassword(
+	ctx context.Context,
+	req *authv1.ForgotPasswordRequest,
+) (*authv1.ForgotPasswordResponse, error) {
+	_, rep, err := s.forgotPassword.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ForgotPasswordResponse), nil
+}
+
+func (s *grpcServer) ResetPassword(
+	ctx context.Context,
+	req *authv1.ResetPasswordRequest,
+) (*authv1.ResetPasswordResponse, error) {
+	_, rep, err := s.resetPassword.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ResetPasswordResponse), nil
+}
+
+func (s *grpcServer) Refresh(
+	ctx context.Context,
+	req *authv1.RefreshRequest,
+) (*authv1.RefreshResponse, error) {
+	_, rep, err := s.refresh.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.RefreshResponse), nil
+}
+
+func (s *grpcServer) CreateAPIKey(
+	ctx context.Context,
+	req *authv1.CreateAPIKeyRequest,
+) (*authv1.CreateAPIKeyResponse, error) {
+	_, rep, err := s.createAPIKey.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.CreateAPIKeyResponse), nil
+}
+
+func (s *grpcServer) ListAPIKeys(
+	ctx context.Context,
+	req *authv1.ListAPIKeysRequest,
+) (*authv1.ListAPIKeysResponse, error) {
+	_, rep, err := s.listAPIKeys.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ListAPIKeysResponse), nil
+}
+
+func (s *grpcServer) RevokeAPIKey(
+	ctx context.Context,
+	req *authv1.RevokeAPIKeyRequest,
+) (*authv1.RevokeAPIKeyResponse, error) {
+	_, rep, err := s.revokeAPIKey.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.RevokeAPIKeyResponse), nil
+}
+
+func (s *grpcServer) ExchangeAPIKey(
+	ctx context.Context,
+	req *authv1.ExchangeAPIKeyRequest,
+) (*authv1.ExchangeAPIKeyResponse, error) {
+	_, rep, err := s.exchangeAPIKey.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ExchangeAPIKeyResponse), nil
+}
+
+func (s *grpcServer) Introspect(
+	ctx context.Context,
+	req *authv1.IntrospectRequest,
+) (*authv1.IntrospectResponse, error) {
+	_, rep, err := s.introspect.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.IntrospectResponse), nil
+}
+
+func (s *grpcServer) ListSessions(
+	ctx context.Context,
+	req *authv1.ListSessionsRequest,
+) (*authv1.ListSessionsResponse, error) {
+	_, rep, err := s.listSessions.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ListSessionsResponse), nil
+}
+
+func (s *grpcServer) RevokeSession(
+	ctx context.Context,
+	req *authv1.RevokeSessionRequest,
+) (*authv1.RevokeSessionResponse, error) {
+	_, rep, err := s.revokeSession.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.RevokeSessionResponse), nil
+}
+
+func (s *grpcServer) RevokeSessions(
+	ctx context.Context,
+	req *authv1.RevokeSessionsRequest,
+) (*authv1.RevokeSessionsResponse, error) {
+	_, rep, err := s.revokeSessions.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.RevokeSessionsResponse), nil
+}
+
+func (s *grpcServer) RequestMagicLink(
+	ctx context.Context,
+	req *authv1.RequestMagicLinkRequest,
+) (*authv1.RequestMagicLinkResponse, error) {
+	_, rep, err := s.requestMagicLink.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.RequestMagicLinkResponse), nil
+}
+
+func (s *grpcServer) ConsumeMagicLink(
+	ctx context.Context,
+	req *authv1.ConsumeMagicLinkRequest,
+) (*authv1.ConsumeMagicLinkResponse, error) {
+	_, rep, err := s.consumeMagicLink.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.ConsumeMagicLinkResponse), nil
+}
+
+func (s *grpcServer) BeginPasskeyRegistration(
+	ctx context.Context,
+	req *authv1.BeginPasskeyRegistrationRequest,
+) (*authv1.PasskeyChallengeResponse, error) {
+	_, rep, err := s.beginPasskeyRegistration.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.PasskeyChallengeResponse), nil
+}
+
+func (s *grpcServer) FinishPasskeyRegistration(
+	ctx context.Context,
+	req *authv1.FinishPasskeyRegistrationRequest,
+) (*authv1.FinishPasskeyRegistrationResponse, error) {
+	_, rep, err := s.finishPasskeyRegistration.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.FinishPasskeyRegistrationResponse), nil
+}
+
+func (s *grpcServer) BeginPasskeyLogin(
+	ctx context.Context,
+	req *authv1.BeginPasskeyLoginRequest,
+) (*authv1.PasskeyChallengeResponse, error) {
+	_, rep, err := s.beginPasskeyLogin.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.PasskeyChallengeResponse), nil
+}
+
+func (s *grpcServer) FinishPasskeyLogin(
+	ctx context.Context,
+	req *authv1.FinishPasskeyLoginRequest,
+) (*authv1.FinishPasskeyLoginResponse, error) {
+	_, rep, err := s.finishPasskeyLogin.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*authv1.FinishPasskeyLoginResponse), nil
+}
+
+// NewGRPCClient calls auth over conn, with access tokens of tokens when it
+// is not nil. Register needs them.
+func NewGRPCClient(conn *grpc.ClientConn, tokens *oauth.TokenSource, logger log.Logger) authservice.Service {
 	limiter := ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 100))
 
-	var options []grpctransport.ClientOption
+	options := []grpctransport.ClientOption{
+		grpctransport.ClientBefore(idempotency.ContextToGRPC(), oauth.ContextToGRPC()),
+	}
 
 	var loginEndpoint endpoint.Endpoint
 	{
 		loginEndpoint = grpctransport.NewClient(
 			conn,
-			"pb.Auth",
+			"pb.v1.AuthService",
 			"Login",
 			encodeGRPCLoginRequest,
 			decodeGRPCLoginResponse,
@@ -86,17 +403,18 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) authservice.Service
 			options...,
 		).Endpoint()
 		loginEndpoint = limiter(loginEndpoint)
-		loginEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Login",
-			Timeout: 30 * time.Second,
-		}))(loginEndpoint)
+		loginEndpoint = resilience.Middleware(
+			"client.grpc.login",
+			resilience.Load("clients.login"),
+			logger,
+		)(loginEndpoint)
 	}
 
 	var registerEndpoint endpoint.Endpoint
 	{
 		registerEndpoint = grpctransport.NewClient(
 			conn,
-			"pb.Auth",
+			"pb.v1.AuthService",
 			"Register",
 			encodeGRPCRegisterRequest,
 			decodeGRPCRegisterResponse,
@@ -104,20 +422,368 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) authservice.Service
 			options...,
 		).Endpoint()
 		registerEndpoint = limiter(registerEndpoint)
-		registerEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Register",
-			Timeout: 30 * time.Second,
-		}))(registerEndpoint)
+		registerEndpoint = resilience.Middleware(
+			"client.grpc.register",
+			resilience.Load("clients.register"),
+			logger,
+		)(registerEndpoint)
+	}
+
+	var forgotPasswordEndpoint endpoint.Endpoint
+	{
+		forgotPasswordEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ForgotPassword",
+			encodeGRPCForgotPasswordRequest,
+			decodeGRPCForgotPasswordResponse,
+			authv1.ForgotPasswordResponse{},
+			options...,
+		).Endpoint()
+		forgotPasswordEndpoint = limiter(forgotPasswordEndpoint)
+		forgotPasswordEndpoint = resilience.Middleware(
+			"client.grpc.forgot_password",
+			resilience.Load("clients.forgotpassword"),
+			logger,
+		)(forgotPasswordEndpoint)
 	}
+
+	var resetPasswordEndpoint endpoint.Endpoint
+	{
+		resetPasswordEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ResetPassword",
+			encodeGRPCResetPasswordRequest,
+			decodeGRPCResetPasswordResponse,
+			authv1.ResetPasswordResponse{},
+			options...,
+		).Endpoint()
+		resetPasswordEndpoint = limiter(resetPasswordEndpoint)
+		resetPasswordEndpoint = resilience.Middleware(
+			"client.grpc.reset_password",
+			resilience.Load("clients.resetpassword"),
+			logger,
+		)(resetPasswordEndpoint)
+	}
+
+	var refreshEndpoint endpoint.Endpoint
+	{
+		refreshEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"Refresh",
+			encodeGRPCRefreshRequest,
+			decodeGRPCRefreshResponse,
+			authv1.RefreshResponse{},
+			options...,
+		).Endpoint()
+		refreshEndpoint = limiter(refreshEndpoint)
+		refreshEndpoint = resilience.Middleware(
+			"client.grpc.refresh",
+			resilience.Load("clients.refresh"),
+			logger,
+		)(refreshEndpoint)
+	}
+
+	var createAPIKeyEndpoint endpoint.Endpoint
+	{
+		createAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"CreateAPIKey",
+			encodeGRPCCreateAPIKeyRequest,
+			decodeGRPCCreateAPIKeyResponse,
+			authv1.CreateAPIKeyResponse{},
+			options...,
+		).Endpoint()
+		createAPIKeyEndpoint = limiter(createAPIKeyEndpoint)
+		createAPIKeyEndpoint = resilience.Middleware(
+			"client.grpc.create_api_key",
+			resilience.Load("clients.createapikey"),
+			logger,
+		)(createAPIKeyEndpoint)
+	}
+
+	var listAPIKeysEndpoint endpoint.Endpoint
+	{
+		listAPIKeysEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ListAPIKeys",
+			encodeGRPCListAPIKeysRequest,
+			decodeGRPCListAPIKeysResponse,
+			authv1.ListAPIKeysResponse{},
+			options...,
+		).Endpoint()
+		listAPIKeysEndpoint = limiter(listAPIKeysEndpoint)
+		listAPIKeysEndpoint = resilience.Middleware(
+			"client.grpc.list_api_keys",
+			resilience.Load("clients.listapikeys"),
+			logger,
+		)(listAPIKeysEndpoint)
+	}
+
+	var revokeAPIKeyEndpoint endpoint.Endpoint
+	{
+		revokeAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"RevokeAPIKey",
+			encodeGRPCRevokeAPIKeyRequest,
+			decodeGRPCRevokeAPIKeyResponse,
+			authv1.RevokeAPIKeyResponse{},
+			options...,
+		).Endpoint()
+		revokeAPIKeyEndpoint = limiter(revokeAPIKeyEndpoint)
+		revokeAPIKeyEndpoint = resilience.Middleware(
+			"client.grpc.revoke_api_key",
+			resilience.Load("clients.revokeapikey"),
+			logger,
+		)(revokeAPIKeyEndpoint)
+	}
+
+	var exchangeAPIKeyEndpoint endpoint.Endpoint
+	{
+		exchangeAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ExchangeAPIKey",
+			encodeGRPCExchangeAPIKeyRequest,
+			decodeGRPCExchangeAPIKeyResponse,
+			authv1.ExchangeAPIKeyResponse{},
+			options...,
+		).Endpoint()
+		exchangeAPIKeyEndpoint = limiter(exchangeAPIKeyEndpoint)
+		exchangeAPIKeyEndpoint = resilience.Middleware(
+			"client.grpc.exchange_api_key",
+			resilience.Load("clients.exchangeapikey"),
+			logger,
+		)(exchangeAPIKeyEndpoint)
+	}
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"Introspect",
+			encodeGRPCIntrospectRequest,
+			decodeGRPCIntrospectResponse,
+			authv1.IntrospectResponse{},
+			options...,
+		).Endpoint()
+		introspectEndpoint = limiter(introspectEndpoint)
+		introspectEndpoint = resilience.Middleware(
+			"client.grpc.introspect",
+			resilience.Load("clients.introspect"),
+			logger,
+		)(introspectEndpoint)
+	}
+
+	var listSessionsEndpoint endpoint.Endpoint
+	{
+		listSessionsEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ListSessions",
+			encodeGRPCListSessionsRequest,
+			decodeGRPCListSessionsResponse,
+			authv1.ListSessionsResponse{},
+			options...,
+		).Endpoint()
+		listSessionsEndpoint = limiter(listSessionsEndpoint)
+		listSessionsEndpoint = resilience.Middleware(
+			"client.grpc.list_sessions",
+			resilience.Load("clients.listsessions"),
+			logger,
+		)(listSessionsEndpoint)
+	}
+
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"RevokeSession",
+			encodeGRPCRevokeSessionRequest,
+			decodeGRPCRevokeSessionResponse,
+			authv1.RevokeSessionResponse{},
+			options...,
+		).Endpoint()
+		revokeSessionEndpoint = limiter(revokeSessionEndpoint)
+		revokeSessionEndpoint = resilience.Middleware(
+			"client.grpc.revoke_session",
+			resilience.Load("clients.revokesession"),
+			logger,
+		)(revokeSessionEndpoint)
+	}
+
+	var revokeSessionsEndpoint endpoint.Endpoint
+	{
+		revokeSessionsEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"RevokeSessions",
+			encodeGRPCRevokeSessionsRequest,
+			decodeGRPCRevokeSessionsResponse,
+			authv1.RevokeSessionsResponse{},
+			options...,
+		).Endpoint()
+		revokeSessionsEndpoint = limiter(revokeSessionsEndpoint)
+		revokeSessionsEndpoint = resilience.Middleware(
+			"client.grpc.revoke_sessions",
+			resilience.Load("clients.revokesessions"),
+			logger,
+		)(revokeSessionsEndpoint)
+	}
+
+	var requestMagicLinkEndpoint endpoint.Endpoint
+	{
+		requestMagicLinkEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"RequestMagicLink",
+			encodeGRPCRequestMagicLinkRequest,
+			decodeGRPCRequestMagicLinkResponse,
+			authv1.RequestMagicLinkResponse{},
+			options...,
+		).Endpoint()
+		requestMagicLinkEndpoint = limiter(requestMagicLinkEndpoint)
+		requestMagicLinkEndpoint = resilience.Middleware(
+			"client.grpc.request_magic_link",
+			resilience.Load("clients.requestmagiclink"),
+			logger,
+		)(requestMagicLinkEndpoint)
+	}
+
+	var consumeMagicLinkEndpoint endpoint.Endpoint
+	{
+		consumeMagicLinkEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"ConsumeMagicLink",
+			encodeGRPCConsumeMagicLinkRequest,
+			decodeGRPCConsumeMagicLinkResponse,
+			authv1.ConsumeMagicLinkResponse{},
+			options...,
+		).Endpoint()
+		consumeMagicLinkEndpoint = limiter(consumeMagicLinkEndpoint)
+		consumeMagicLinkEndpoint = resilience.Middleware(
+			"client.grpc.consume_magic_link",
+			resilience.Load("clients.consumemagiclink"),
+			logger,
+		)(consumeMagicLinkEndpoint)
+	}
+
+	var beginPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		beginPasskeyRegistrationEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"BeginPasskeyRegistration",
+			encodeGRPCBeginPasskeyRegistrationRequest,
+			decodeGRPCPasskeyChallengeResponse,
+			authv1.PasskeyChallengeResponse{},
+			options...,
+		).Endpoint()
+		beginPasskeyRegistrationEndpoint = limiter(beginPasskeyRegistrationEndpoint)
+		beginPasskeyRegistrationEndpoint = resilience.Middleware(
+			"client.grpc.begin_passkey_registration",
+			resilience.Load("clients.beginpasskeyregistration"),
+			logger,
+		)(beginPasskeyRegistrationEndpoint)
+	}
+
+	var finishPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		finishPasskeyRegistrationEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"FinishPasskeyRegistration",
+			encodeGRPCFinishPasskeyRegistrationRequest,
+			decodeGRPCFinishPasskeyRegistrationResponse,
+			authv1.FinishPasskeyRegistrationResponse{},
+			options...,
+		).Endpoint()
+		finishPasskeyRegistrationEndpoint = limiter(finishPasskeyRegistrationEndpoint)
+		finishPasskeyRegistrationEndpoint = resilience.Middleware(
+			"client.grpc.finish_passkey_registration",
+			resilience.Load("clients.finishpasskeyregistration"),
+			logger,
+		)(finishPasskeyRegistrationEndpoint)
+	}
+
+	var beginPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		beginPasskeyLoginEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"BeginPasskeyLogin",
+			encodeGRPCBeginPasskeyLoginRequest,
+			decodeGRPCPasskeyChallengeResponse,
+			authv1.PasskeyChallengeResponse{},
+			options...,
+		).Endpoint()
+		beginPasskeyLoginEndpoint = limiter(beginPasskeyLoginEndpoint)
+		beginPasskeyLoginEndpoint = resilience.Middleware(
+			"client.grpc.begin_passkey_login",
+			resilience.Load("clients.beginpasskeylogin"),
+			logger,
+		)(beginPasskeyLoginEndpoint)
+	}
+
+	var finishPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		finishPasskeyLoginEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.v1.AuthService",
+			"FinishPasskeyLogin",
+			encodeGRPCFinishPasskeyLoginRequest,
+			decodeGRPCFinishPasskeyLoginResponse,
+			authv1.FinishPasskeyLoginResponse{},
+			options...,
+		).Endpoint()
+		finishPasskeyLoginEndpoint = limiter(finishPasskeyLoginEndpoint)
+		finishPasskeyLoginEndpoint = resilience.Middleware(
+			"client.grpc.finish_passkey_login",
+			resilience.Load("clients.finishpasskeylogin"),
+			logger,
+		)(finishPasskeyLoginEndpoint)
+	}
+	authenticate := clientAuthentication(tokens)
 	return authendpoint.Set{
-		LoginEndpoint:    loginEndpoint,
-		RegisterEndpoint: registerEndpoint,
+		LoginEndpoint:          authenticate(loginEndpoint),
+		RegisterEndpoint:       authenticate(registerEndpoint),
+		ForgotPasswordEndpoint: authenticate(forgotPasswordEndpoint),
+		ResetPasswordEndpoint:  authenticate(resetPasswordEndpoint),
+		RefreshEndpoint:        authenticate(refreshEndpoint),
+		CreateAPIKeyEndpoint:   authenticate(createAPIKeyEndpoint),
+		ListAPIKeysEndpoint:    authenticate(listAPIKeysEndpoint),
+		RevokeAPIKeyEndpoint:   authenticate(revokeAPIKeyEndpoint),
+		ExchangeAPIKeyEndpoint: authenticate(exchangeAPIKeyEndpoint),
+		IntrospectEndpoint:     authenticate(introspectEndpoint),
+		ListSessionsEndpoint:   authenticate(listSessionsEndpoint),
+		RevokeSessionEndpoint:  authenticate(revokeSessionEndpoint),
+		RevokeSessionsEndpoint: authenticate(revokeSessionsEndpoint),
+
+		RequestMagicLinkEndpoint: authenticate(requestMagicLinkEndpoint),
+		ConsumeMagicLinkEndpoint: authenticate(consumeMagicLinkEndpoint),
+
+		BeginPasskeyRegistrationEndpoint:  authenticate(beginPasskeyRegistrationEndpoint),
+		FinishPasskeyRegistrationEndpoint: authenticate(finishPasskeyRegistrationEndpoint),
+		BeginPasskeyLoginEndpoint:         authenticate(beginPasskeyLoginEndpoint),
+		FinishPasskeyLoginEndpoint:        authenticate(finishPasskeyLoginEndpoint),
 	}
 }
 
 func decodeGRPCRegisterRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	req := grpcReq.(*authv1.RegisterRequest)
-	return authendpoint.RegisterRequest{Email: req.GetEmail(), Password: req.Password}, nil
+	return authendpoint.RegisterRequest{
+		Email:          req.GetEmail(),
+		Password:       req.Password,
+		IdempotencyKey: req.IdempotencyKey,
+	}, nil
 }
 
 func decodeGRPCRegisterResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
@@ -127,7 +793,11 @@ func decodeGRPCRegisterResponse(_ context.Context, grpcReply interface{}) (inter
 
 func encodeGRPCRegisterRequest(_ context.Context, request interface{}) (interface{}, error) {
 	req := request.(authendpoint.RegisterRequest)
-	return &authv1.RegisterRequest{Email: req.Email, Password: req.Password}, nil
+	return &authv1.RegisterRequest{
+		Email:          req.Email,
+		Password:       req.Password,
+		IdempotencyKey: req.IdempotencyKey,
+	}, nil
 }
 
 func encodeGRPCRegisterResponse(_ context.Context, response interface{}) (interface{}, error) {
@@ -142,12 +812,24 @@ func decodeGRPCLoginResponse(_ context.Context, grpcReply interface{}) (interfac
 
 func decodeGRPCLoginRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	req := grpcReq.(*authv1.LoginRequest)
-	return authendpoint.LoginRequest{Email: req.Email, Password: req.Password}, nil
+	return authendpoint.LoginRequest{
+		Email:     req.Email,
+		Password:  req.Password,
+		Device:    req.Device,
+		UserAgent: req.UserAgent,
+		IP:        req.Ip,
+	}, nil
 }
 
 func encodeGRPCLoginRequest(_ context.Context, request interface{}) (interface{}, error) {
 	req := request.(authendpoint.LoginRequest)
-	return &authv1.LoginRequest{Email: req.Email, Password: req.Password}, nil
+	return &authv1.LoginRequest{
+		Email:     req.Email,
+		Password:  req.Password,
+		Device:    req.Device,
+		UserAgent: req.UserAgent,
+		Ip:        req.IP,
+	}, nil
 }
 
 func encodeGRPCLoginResponse(_ context.Context, response interface{}) (interface{}, error) {
@@ -155,16 +837,556 @@ func encodeGRPCLoginResponse(_ context.Context, response interface{}) (interface
 	return &authv1.LoginResponse{Err: errorToString(resp.Err), Token: resp.Token}, nil
 }
 
+func decodeGRPCForgotPasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ForgotPasswordRequest)
+	return authendpoint.ForgotPasswordRequest{Email: req.Email}, nil
+}
+
+func decodeGRPCForgotPasswordResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ForgotPasswordResponse)
+	return authendpoint.ForgotPasswordResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCForgotPasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ForgotPasswordRequest)
+	return &authv1.ForgotPasswordRequest{Email: req.Email}, nil
+}
+
+func encodeGRPCForgotPasswordResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ForgotPasswordResponse)
+	return &authv1.ForgotPasswordResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCResetPasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ResetPasswordRequest)
+	return authendpoint.ResetPasswordRequest{Token: req.Token, Password: req.Password}, nil
+}
+
+func decodeGRPCResetPasswordResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ResetPasswordResponse)
+	return authendpoint.ResetPasswordResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCResetPasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ResetPasswordRequest)
+	return &authv1.ResetPasswordRequest{Token: req.Token, Password: req.Password}, nil
+}
+
+func encodeGRPCResetPasswordResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ResetPasswordResponse)
+	return &authv1.ResetPasswordResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCRefreshRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.RefreshRequest)
+	return authendpoint.RefreshRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCRefreshResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.RefreshResponse)
+	return authendpoint.RefreshResponse{Err: stringToErr(reply.Err), Token: reply.Token}, nil
+}
+
+func encodeGRPCRefreshRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RefreshRequest)
+	return &authv1.RefreshRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCRefreshResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RefreshResponse)
+	return &authv1.RefreshResponse{Err: errorToString(resp.Err), Token: resp.Token}, nil
+}
+
+func decodeGRPCCreateAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.CreateAPIKeyRequest)
+	return authendpoint.CreateAPIKeyRequest{
+		Token:     req.Token,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresIn: req.ExpiresIn,
+	}, nil
+}
+
+func decodeGRPCCreateAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.CreateAPIKeyResponse)
+	return authendpoint.CreateAPIKeyResponse{
+		APIKey: apiKeyFromGRPC(reply.ApiKey),
+		Key:    reply.Key,
+		Err:    stringToErr(reply.Err),
+	}, nil
+}
+
+func encodeGRPCCreateAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.CreateAPIKeyRequest)
+	return &authv1.CreateAPIKeyRequest{
+		Token:     req.Token,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresIn: req.ExpiresIn,
+	}, nil
+}
+
+func encodeGRPCCreateAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.CreateAPIKeyResponse)
+	reply := &authv1.CreateAPIKeyResponse{Key: resp.Key, Err: errorToString(resp.Err)}
+	if resp.Err == nil {
+		reply.ApiKey = apiKeyToGRPC(resp.APIKey)
+	}
+	return reply, nil
+}
+
+func decodeGRPCListAPIKeysRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ListAPIKeysRequest)
+	return authendpoint.ListAPIKeysRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCListAPIKeysResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ListAPIKeysResponse)
+	keys := make([]authservice.APIKey, 0, len(reply.ApiKeys))
+	for _, k := range reply.ApiKeys {
+		keys = append(keys, apiKeyFromGRPC(k))
+	}
+	return authendpoint.ListAPIKeysResponse{APIKeys: keys, Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCListAPIKeysRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ListAPIKeysRequest)
+	return &authv1.ListAPIKeysRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCListAPIKeysResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ListAPIKeysResponse)
+	keys := make([]*authv1.APIKey, 0, len(resp.APIKeys))
+	for _, k := range resp.APIKeys {
+		keys = append(keys, apiKeyToGRPC(k))
+	}
+	return &authv1.ListAPIKeysResponse{ApiKeys: keys, Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCRevokeAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.RevokeAPIKeyRequest)
+	// an unparsable id is left nil for Validate to refuse
+	id, _ := uuid.Parse(req.Id)
+	return authendpoint.RevokeAPIKeyRequest{Token: req.Token, ID: id}, nil
+}
+
+func decodeGRPCRevokeAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.RevokeAPIKeyResponse)
+	return authendpoint.RevokeAPIKeyResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCRevokeAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RevokeAPIKeyRequest)
+	return &authv1.RevokeAPIKeyRequest{Token: req.Token, Id: req.ID.String()}, nil
+}
+
+func encodeGRPCRevokeAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RevokeAPIKeyResponse)
+	return &authv1.RevokeAPIKeyResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCExchangeAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ExchangeAPIKeyRequest)
+	return authendpoint.ExchangeAPIKeyRequest{Key: req.Key}, nil
+}
+
+func decodeGRPCExchangeAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ExchangeAPIKeyResponse)
+	return authendpoint.ExchangeAPIKeyResponse{Token: reply.Token, Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCExchangeAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ExchangeAPIKeyRequest)
+	return &authv1.ExchangeAPIKeyRequest{Key: req.Key}, nil
+}
+
+func encodeGRPCExchangeAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ExchangeAPIKeyResponse)
+	return &authv1.ExchangeAPIKeyResponse{Token: resp.Token, Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCIntrospectRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.IntrospectRequest)
+	return authendpoint.IntrospectRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCIntrospectResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.IntrospectResponse)
+	resp := authendpoint.IntrospectResponse{
+		Active:   reply.Active,
+		Subject:  reply.Sub,
+		Username: reply.Username,
+		ClientID: reply.ClientId,
+		KeyID:    reply.KeyId,
+		Audience: reply.Aud,
+		Scope:    strings.Join(reply.Scopes, " "),
+		Revoked:  reply.Revoked,
+		Err:      stringToErr(reply.Err),
+	}
+	if reply.Exp != nil {
+		resp.ExpiresAt = reply.Exp.AsTime().Unix()
+	}
+	return resp, nil
+}
+
+func encodeGRPCIntrospectRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.IntrospectRequest)
+	return &authv1.IntrospectRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCIntrospectResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.IntrospectResponse)
+	reply := &authv1.IntrospectResponse{
+		Active:   resp.Active,
+		Sub:      resp.Subject,
+		Username: resp.Username,
+		ClientId: resp.ClientID,
+		KeyId:    resp.KeyID,
+		Aud:      resp.Audience,
+		Scopes:   strings.Fields(resp.Scope),
+		Revoked:  resp.Revoked,
+		Err:      errorToString(resp.Err),
+	}
+	if resp.ExpiresAt != 0 {
+		reply.Exp = timestamppb.New(time.Unix(resp.ExpiresAt, 0))
+	}
+	return reply, nil
+}
+
+func decodeGRPCListSessionsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ListSessionsRequest)
+	return authendpoint.ListSessionsRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCListSessionsResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ListSessionsResponse)
+	sessions := make([]authservice.Session, 0, len(reply.Sessions))
+	for _, s := range reply.Sessions {
+		sessions = append(sessions, sessionFromGRPC(s))
+	}
+	return authendpoint.ListSessionsResponse{Sessions: sessions, Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCListSessionsRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ListSessionsRequest)
+	return &authv1.ListSessionsRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCListSessionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ListSessionsResponse)
+	sessions := make([]*authv1.Session, 0, len(resp.Sessions))
+	for _, s := range resp.Sessions {
+		sessions = append(sessions, sessionToGRPC(s))
+	}
+	return &authv1.ListSessionsResponse{Sessions: sessions, Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCRevokeSessionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.RevokeSessionRequest)
+	// an unparsable id is left nil for Validate to refuse
+	id, _ := uuid.Parse(req.Id)
+	return authendpoint.RevokeSessionRequest{Token: req.Token, ID: id}, nil
+}
+
+func decodeGRPCRevokeSessionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.RevokeSessionResponse)
+	return authendpoint.RevokeSessionResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCRevokeSessionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RevokeSessionRequest)
+	return &authv1.RevokeSessionRequest{Token: req.Token, Id: req.ID.String()}, nil
+}
+
+func encodeGRPCRevokeSessionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RevokeSessionResponse)
+	return &authv1.RevokeSessionResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCRevokeSessionsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.RevokeSessionsRequest)
+	return authendpoint.RevokeSessionsRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCRevokeSessionsResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.RevokeSessionsResponse)
+	return authendpoint.RevokeSessionsResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCRevokeSessionsRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RevokeSessionsRequest)
+	return &authv1.RevokeSessionsRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCRevokeSessionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RevokeSessionsResponse)
+	return &authv1.RevokeSessionsResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCRequestMagicLinkRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.RequestMagicLinkRequest)
+	return authendpoint.RequestMagicLinkRequest{Email: req.Email}, nil
+}
+
+func decodeGRPCRequestMagicLinkResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.RequestMagicLinkResponse)
+	return authendpoint.RequestMagicLinkResponse{Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCRequestMagicLinkRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RequestMagicLinkRequest)
+	return &authv1.RequestMagicLinkRequest{Email: req.Email}, nil
+}
+
+func encodeGRPCRequestMagicLinkResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RequestMagicLinkResponse)
+	return &authv1.RequestMagicLinkResponse{Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCConsumeMagicLinkRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.ConsumeMagicLinkRequest)
+	return authendpoint.ConsumeMagicLinkRequest{
+		Token:     req.Token,
+		Device:    req.Device,
+		UserAgent: req.UserAgent,
+		IP:        req.Ip,
+	}, nil
+}
+
+func decodeGRPCConsumeMagicLinkResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.ConsumeMagicLinkResponse)
+	return authendpoint.ConsumeMagicLinkResponse{Token: reply.Token, Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCConsumeMagicLinkRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ConsumeMagicLinkRequest)
+	return &authv1.ConsumeMagicLinkRequest{
+		Token:     req.Token,
+		Device:    req.Device,
+		UserAgent: req.UserAgent,
+		Ip:        req.IP,
+	}, nil
+}
+
+func encodeGRPCConsumeMagicLinkResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ConsumeMagicLinkResponse)
+	return &authv1.ConsumeMagicLinkResponse{Token: resp.Token, Err: errorToString(resp.Err)}, nil
+}
+
+func decodeGRPCBeginPasskeyRegistrationRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.BeginPasskeyRegistrationRequest)
+	return authendpoint.BeginPasskeyRegistrationRequest{Token: req.Token}, nil
+}
+
+func encodeGRPCBeginPasskeyRegistrationRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.BeginPasskeyRegistrationRequest)
+	return &authv1.BeginPasskeyRegistrationRequest{Token: req.Token}, nil
+}
+
+func decodeGRPCPasskeyChallengeResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.PasskeyChallengeResponse)
+	id, _ := uuid.Parse(reply.ChallengeId)
+	return authendpoint.PasskeyChallengeResponse{
+		ChallengeID: id,
+		Options:     reply.Options,
+		Err:         stringToErr(reply.Err),
+	}, nil
+}
+
+func encodeGRPCPasskeyChallengeResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.PasskeyChallengeResponse)
+	reply := &authv1.PasskeyChallengeResponse{Options: resp.Options, Err: errorToString(resp.Err)}
+	if resp.Err == nil {
+		reply.ChallengeId = resp.ChallengeID.String()
+	}
+	return reply, nil
+}
+
+func decodeGRPCFinishPasskeyRegistrationRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.FinishPasskeyRegistrationRequest)
+	// an unparsable id is left nil for Validate to refuse
+	id, _ := uuid.Parse(req.ChallengeId)
+	return authendpoint.FinishPasskeyRegistrationRequest{
+		Token:       req.Token,
+		ChallengeID: id,
+		Name:        req.Name,
+		Credential:  req.Credential,
+	}, nil
+}
+
+func decodeGRPCFinishPasskeyRegistrationResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.FinishPasskeyRegistrationResponse)
+	return authendpoint.FinishPasskeyRegistrationResponse{
+		Passkey: passkeyFromGRPC(reply.Passkey),
+		Err:     stringToErr(reply.Err),
+	}, nil
+}
+
+func encodeGRPCFinishPasskeyRegistrationRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.FinishPasskeyRegistrationRequest)
+	return &authv1.FinishPasskeyRegistrationRequest{
+		Token:       req.Token,
+		ChallengeId: req.ChallengeID.String(),
+		Name:        req.Name,
+		Credential:  req.Credential,
+	}, nil
+}
+
+func encodeGRPCFinishPasskeyRegistrationResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.FinishPasskeyRegistrationResponse)
+	reply := &authv1.FinishPasskeyRegistrationResponse{Err: errorToString(resp.Err)}
+	if resp.Err == nil {
+		reply.Passkey = passkeyToGRPC(resp.Passkey)
+	}
+	return reply, nil
+}
+
+func decodeGRPCBeginPasskeyLoginRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return authendpoint.BeginPasskeyLoginRequest{}, nil
+}
+
+func encodeGRPCBeginPasskeyLoginRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &authv1.BeginPasskeyLoginRequest{}, nil
+}
+
+func decodeGRPCFinishPasskeyLoginRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*authv1.FinishPasskeyLoginRequest)
+	// an unparsable id is left nil for Validate to refuse
+	id, _ := uuid.Parse(req.ChallengeId)
+	return authendpoint.FinishPasskeyLoginRequest{
+		ChallengeID: id,
+		Credential:  req.Credential,
+		Device:      req.Device,
+		UserAgent:   req.UserAgent,
+		IP:          req.Ip,
+	}, nil
+}
+
+func decodeGRPCFinishPasskeyLoginResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*authv1.FinishPasskeyLoginResponse)
+	return authendpoint.FinishPasskeyLoginResponse{Token: reply.Token, Err: stringToErr(reply.Err)}, nil
+}
+
+func encodeGRPCFinishPasskeyLoginRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.FinishPasskeyLoginRequest)
+	return &authv1.FinishPasskeyLoginRequest{
+		ChallengeId: req.ChallengeID.String(),
+		Credential:  req.Credential,
+		Device:      req.Device,
+		UserAgent:   req.UserAgent,
+		Ip:          req.IP,
+	}, nil
+}
+
+func encodeGRPCFinishPasskeyLoginResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.FinishPasskeyLoginResponse)
+	return &authv1.FinishPasskeyLoginResponse{Token: resp.Token, Err: errorToString(resp.Err)}, nil
+}
+
+func sessionToGRPC(s authservice.Session) *authv1.Session {
+	return &authv1.Session{
+		Id:         s.ID.String(),
+		Device:     s.Device,
+		UserAgent:  s.UserAgent,
+		Ip:         s.IP,
+		CreatedAt:  timestamppb.New(s.CreatedAt),
+		LastSeenAt: timestamppb.New(s.LastSeenAt),
+		Current:    s.Current,
+	}
+}
+
+func sessionFromGRPC(s *authv1.Session) authservice.Session {
+	if s == nil {
+		return authservice.Session{}
+	}
+	id, _ := uuid.Parse(s.Id)
+	return authservice.Session{
+		ID:         id,
+		Device:     s.Device,
+		UserAgent:  s.UserAgent,
+		IP:         s.Ip,
+		CreatedAt:  s.CreatedAt.AsTime(),
+		LastSeenAt: s.LastSeenAt.AsTime(),
+		Current:    s.Current,
+	}
+}
+
+func apiKeyToGRPC(k authservice.APIKey) *authv1.APIKey {
+	key := &authv1.APIKey{
+		Id:        k.ID.String(),
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		ExpiresAt: timestamppb.New(k.ExpiresAt),
+		CreatedAt: timestamppb.New(k.CreatedAt),
+	}
+	if k.LastUsedAt != nil {
+		key.LastUsedAt = timestamppb.New(*k.LastUsedAt)
+	}
+	return key
+}
+
+func apiKeyFromGRPC(k *authv1.APIKey) authservice.APIKey {
+	if k == nil {
+		return authservice.APIKey{}
+	}
+	id, _ := uuid.Parse(k.Id)
+	key := authservice.APIKey{
+		ID:        id,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		ExpiresAt: k.ExpiresAt.AsTime(),
+		CreatedAt: k.CreatedAt.AsTime(),
+	}
+	if k.LastUsedAt != nil {
+		t := k.LastUsedAt.AsTime()
+		key.LastUsedAt = &t
+	}
+	return key
+}
+
+func passkeyToGRPC(p authservice.Passkey) *authv1.Passkey {
+	passkey := &authv1.Passkey{
+		Id:        p.ID.String(),
+		Name:      p.Name,
+		CreatedAt: timestamppb.New(p.CreatedAt),
+	}
+	if p.LastUsedAt != nil {
+		passkey.LastUsedAt = timestamppb.New(*p.LastUsedAt)
+	}
+	return passkey
+}
+
+func passkeyFromGRPC(p *authv1.Passkey) authservice.Passkey {
+	if p == nil {
+		return authservice.Passkey{}
+	}
+	id, _ := uuid.Parse(p.Id)
+	passkey := authservice.Passkey{
+		ID:        id,
+		Name:      p.Name,
+		CreatedAt: p.CreatedAt.AsTime(),
+	}
+	if p.LastUsedAt != nil {
+		t := p.LastUsedAt.AsTime()
+		passkey.LastUsedAt = &t
+	}
+	return passkey
+}
+
+// stringToErr and errorToString carry problems in the err fields of the
+// responses, so that gRPC clients see the same errors as HTTP ones.
 func stringToErr(s string) error {
 	if s == "" {
 		return nil
 	}
-	return errors.New(s)
+	return problem.Unmarshal(s)
 }
 
 func errorToString(err error) string {
 	if err == nil {
 		return ""
 	}
-	return err.Error()
+	return problem.Marshal(err2problem(err).WithService(serviceName))
 }
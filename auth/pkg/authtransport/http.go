@@ -6,28 +6,48 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
+	"github.com/google/uuid"
 	"github.com/sony/gobreaker"
 
 	"github.com/F1zm0n/uni-auth/pkg/authendpoint"
 	"github.com/F1zm0n/uni-auth/pkg/authservice"
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 )
 
-func NewHTTPServer(endpoints authendpoint.Set, logger log.Logger) http.Handler {
+const (
+	// tokenHeader and sessionCookie carry the caller's token, as they do
+	// at the gateway.
+	tokenHeader   = "X-Api-Token"
+	sessionCookie = "session"
+)
+
+// NewHTTPServer serves the endpoints and, at /oauth/token, the token
+// endpoint of tokens.
+func NewHTTPServer(endpoints authendpoint.Set, tokens *oauth.Server, logger log.Logger) http.Handler {
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(
+			idempotency.HTTPToContext(),
+			problem.HTTPToContext(),
+			oauth.HTTPToContext(),
+		),
 	}
 	m := http.NewServeMux()
+	m.Handle("/oauth/token", oauth.NewHTTPHandler(tokens, logger))
 	m.Handle("/login", httptransport.NewServer(
 		endpoints.LoginEndpoint,
 		decodeHTTPLoginRequest,
@@ -40,10 +60,114 @@ func NewHTTPServer(endpoints authendpoint.Set, logger log.Logger) http.Handler {
 		encodeHTTPGenericResponse,
 		options...,
 	))
+	m.Handle("/password/forgot", httptransport.NewServer(
+		endpoints.ForgotPasswordEndpoint,
+		decodeHTTPForgotPasswordRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("/password/reset", httptransport.NewServer(
+		endpoints.ResetPasswordEndpoint,
+		decodeHTTPResetPasswordRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("/refresh", httptransport.NewServer(
+		endpoints.RefreshEndpoint,
+		decodeHTTPRefreshRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /keys", httptransport.NewServer(
+		endpoints.CreateAPIKeyEndpoint,
+		decodeHTTPCreateAPIKeyRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /keys", httptransport.NewServer(
+		endpoints.ListAPIKeysEndpoint,
+		decodeHTTPListAPIKeysRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /keys/{id}", httptransport.NewServer(
+		endpoints.RevokeAPIKeyEndpoint,
+		decodeHTTPRevokeAPIKeyRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /keys/exchange", httptransport.NewServer(
+		endpoints.ExchangeAPIKeyEndpoint,
+		decodeHTTPExchangeAPIKeyRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /introspect", httptransport.NewServer(
+		endpoints.IntrospectEndpoint,
+		decodeHTTPIntrospectRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /sessions", httptransport.NewServer(
+		endpoints.ListSessionsEndpoint,
+		decodeHTTPListSessionsRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /sessions/{id}", httptransport.NewServer(
+		endpoints.RevokeSessionEndpoint,
+		decodeHTTPRevokeSessionRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /sessions", httptransport.NewServer(
+		endpoints.RevokeSessionsEndpoint,
+		decodeHTTPRevokeSessionsRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /login/link", httptransport.NewServer(
+		endpoints.RequestMagicLinkEndpoint,
+		decodeHTTPRequestMagicLinkRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /login/link/consume", httptransport.NewServer(
+		endpoints.ConsumeMagicLinkEndpoint,
+		decodeHTTPConsumeMagicLinkRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /passkeys/register/begin", httptransport.NewServer(
+		endpoints.BeginPasskeyRegistrationEndpoint,
+		decodeHTTPBeginPasskeyRegistrationRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /passkeys/register/finish", httptransport.NewServer(
+		endpoints.FinishPasskeyRegistrationEndpoint,
+		decodeHTTPFinishPasskeyRegistrationRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /login/passkey/begin", httptransport.NewServer(
+		endpoints.BeginPasskeyLoginEndpoint,
+		decodeHTTPBeginPasskeyLoginRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /login/passkey/finish", httptransport.NewServer(
+		endpoints.FinishPasskeyLoginEndpoint,
+		decodeHTTPFinishPasskeyLoginRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
 	return m
 }
 
-func NewHTTPClient(instance string, log log.Logger) (authservice.Service, error) {
+// NewHTTPClient calls auth at instance, with access tokens of tokens when
+// it is not nil. Register needs them.
+func NewHTTPClient(instance string, tokens *oauth.TokenSource, log log.Logger) (authservice.Service, error) {
 	if !strings.HasPrefix(instance, "http") {
 		instance = "http://" + instance
 	}
@@ -52,21 +176,28 @@ func NewHTTPClient(instance string, log log.Logger) (authservice.Service, error)
 		return nil, err
 	}
 
-	var options []httptransport.ClientOption
+	options := []httptransport.ClientOption{
+		httptransport.ClientBefore(
+			idempotency.ContextToHTTP(),
+			problem.ContextToHTTP(),
+			oauth.ContextToHTTP(),
+		),
+	}
 
 	var loginEndpoint endpoint.Endpoint
 	{
 		loginEndpoint = httptransport.NewClient(
 			http.MethodGet,
 			copyURL(u, "/login"),
-			encodeHTTPGenericRequest,
+			encodeHTTPLoginRequest,
 			decodeHTTPLoginResponse,
 			options...,
 		).Endpoint()
-		loginEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Login",
-			Timeout: 30 * time.Second,
-		}))(loginEndpoint)
+		loginEndpoint = resilience.Middleware(
+			"client.login",
+			resilience.Load("clients.login"),
+			log,
+		)(loginEndpoint)
 	}
 
 	var registerEndpoint endpoint.Endpoint
@@ -74,58 +205,685 @@ func NewHTTPClient(instance string, log log.Logger) (authservice.Service, error)
 		registerEndpoint = httptransport.NewClient(
 			http.MethodPost,
 			copyURL(u, "/register"),
-			encodeHTTPGenericRequest,
+			encodeHTTPRegisterRequest,
 			decodeHTTPRegisterResponse,
 			options...,
 		).Endpoint()
-		registerEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Register",
-			Timeout: 30 * time.Second,
-		}))(registerEndpoint)
+		registerEndpoint = resilience.Middleware(
+			"client.register",
+			resilience.Load("clients.register"),
+			log,
+		)(registerEndpoint)
+	}
+
+	var forgotPasswordEndpoint endpoint.Endpoint
+	{
+		forgotPasswordEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/password/forgot"),
+			encodeHTTPGenericRequest,
+			decodeHTTPForgotPasswordResponse,
+			options...,
+		).Endpoint()
+		forgotPasswordEndpoint = resilience.Middleware(
+			"client.forgot_password",
+			resilience.Load("clients.forgotpassword"),
+			log,
+		)(forgotPasswordEndpoint)
+	}
+
+	var resetPasswordEndpoint endpoint.Endpoint
+	{
+		resetPasswordEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/password/reset"),
+			encodeHTTPGenericRequest,
+			decodeHTTPResetPasswordResponse,
+			options...,
+		).Endpoint()
+		resetPasswordEndpoint = resilience.Middleware(
+			"client.reset_password",
+			resilience.Load("clients.resetpassword"),
+			log,
+		)(resetPasswordEndpoint)
+	}
+
+	var refreshEndpoint endpoint.Endpoint
+	{
+		refreshEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/refresh"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRefreshResponse,
+			options...,
+		).Endpoint()
+		refreshEndpoint = resilience.Middleware(
+			"client.refresh",
+			resilience.Load("clients.refresh"),
+			log,
+		)(refreshEndpoint)
+	}
+
+	var createAPIKeyEndpoint endpoint.Endpoint
+	{
+		createAPIKeyEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/keys"),
+			encodeHTTPCreateAPIKeyRequest,
+			decodeHTTPCreateAPIKeyResponse,
+			options...,
+		).Endpoint()
+		createAPIKeyEndpoint = resilience.Middleware(
+			"client.create_api_key",
+			resilience.Load("clients.createapikey"),
+			log,
+		)(createAPIKeyEndpoint)
+	}
+
+	var listAPIKeysEndpoint endpoint.Endpoint
+	{
+		listAPIKeysEndpoint = httptransport.NewClient(
+			http.MethodGet,
+			copyURL(u, "/keys"),
+			encodeHTTPListAPIKeysRequest,
+			decodeHTTPListAPIKeysResponse,
+			options...,
+		).Endpoint()
+		listAPIKeysEndpoint = resilience.Middleware(
+			"client.list_api_keys",
+			resilience.Load("clients.listapikeys"),
+			log,
+		)(listAPIKeysEndpoint)
+	}
+
+	var revokeAPIKeyEndpoint endpoint.Endpoint
+	{
+		revokeAPIKeyEndpoint = httptransport.NewClient(
+			http.MethodDelete,
+			copyURL(u, "/keys"),
+			encodeHTTPRevokeAPIKeyRequest,
+			decodeHTTPRevokeAPIKeyResponse,
+			options...,
+		).Endpoint()
+		revokeAPIKeyEndpoint = resilience.Middleware(
+			"client.revoke_api_key",
+			resilience.Load("clients.revokeapikey"),
+			log,
+		)(revokeAPIKeyEndpoint)
 	}
 
+	var exchangeAPIKeyEndpoint endpoint.Endpoint
+	{
+		exchangeAPIKeyEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/keys/exchange"),
+			encodeHTTPGenericRequest,
+			decodeHTTPExchangeAPIKeyResponse,
+			options...,
+		).Endpoint()
+		exchangeAPIKeyEndpoint = resilience.Middleware(
+			"client.exchange_api_key",
+			resilience.Load("clients.exchangeapikey"),
+			log,
+		)(exchangeAPIKeyEndpoint)
+	}
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/introspect"),
+			encodeHTTPGenericRequest,
+			decodeHTTPIntrospectResponse,
+			options...,
+		).Endpoint()
+		introspectEndpoint = resilience.Middleware(
+			"client.introspect",
+			resilience.Load("clients.introspect"),
+			log,
+		)(introspectEndpoint)
+	}
+
+	var listSessionsEndpoint endpoint.Endpoint
+	{
+		listSessionsEndpoint = httptransport.NewClient(
+			http.MethodGet,
+			copyURL(u, "/sessions"),
+			encodeHTTPListSessionsRequest,
+			decodeHTTPListSessionsResponse,
+			options...,
+		).Endpoint()
+		listSessionsEndpoint = resilience.Middleware(
+			"client.list_sessions",
+			resilience.Load("clients.listsessions"),
+			log,
+		)(listSessionsEndpoint)
+	}
+
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = httptransport.NewClient(
+			http.MethodDelete,
+			copyURL(u, "/sessions"),
+			encodeHTTPRevokeSessionRequest,
+			decodeHTTPRevokeSessionResponse,
+			options...,
+		).Endpoint()
+		revokeSessionEndpoint = resilience.Middleware(
+			"client.revoke_session",
+			resilience.Load("clients.revokesession"),
+			log,
+		)(revokeSessionEndpoint)
+	}
+
+	var revokeSessionsEndpoint endpoint.Endpoint
+	{
+		revokeSessionsEndpoint = httptransport.NewClient(
+			http.MethodDelete,
+			copyURL(u, "/sessions"),
+			encodeHTTPRevokeSessionsRequest,
+			decodeHTTPRevokeSessionsResponse,
+			options...,
+		).Endpoint()
+		revokeSessionsEndpoint = resilience.Middleware(
+			"client.revoke_sessions",
+			resilience.Load("clients.revokesessions"),
+			log,
+		)(revokeSessionsEndpoint)
+	}
+
+	var requestMagicLinkEndpoint endpoint.Endpoint
+	{
+		requestMagicLinkEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/login/link"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRequestMagicLinkResponse,
+			options...,
+		).Endpoint()
+		requestMagicLinkEndpoint = resilience.Middleware(
+			"client.request_magic_link",
+			resilience.Load("clients.requestmagiclink"),
+			log,
+		)(requestMagicLinkEndpoint)
+	}
+
+	var consumeMagicLinkEndpoint endpoint.Endpoint
+	{
+		consumeMagicLinkEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/login/link/consume"),
+			encodeHTTPConsumeMagicLinkRequest,
+			decodeHTTPConsumeMagicLinkResponse,
+			options...,
+		).Endpoint()
+		consumeMagicLinkEndpoint = resilience.Middleware(
+			"client.consume_magic_link",
+			resilience.Load("clients.consumemagiclink"),
+			log,
+		)(consumeMagicLinkEndpoint)
+	}
+
+	var beginPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		beginPasskeyRegistrationEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/passkeys/register/begin"),
+			encodeHTTPBeginPasskeyRegistrationRequest,
+			decodeHTTPPasskeyChallengeResponse,
+			options...,
+		).Endpoint()
+		beginPasskeyRegistrationEndpoint = resilience.Middleware(
+			"client.begin_passkey_registration",
+			resilience.Load("clients.beginpasskeyregistration"),
+			log,
+		)(beginPasskeyRegistrationEndpoint)
+	}
+
+	var finishPasskeyRegistrationEndpoint endpoint.Endpoint
+	{
+		finishPasskeyRegistrationEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/passkeys/register/finish"),
+			encodeHTTPFinishPasskeyRegistrationRequest,
+			decodeHTTPFinishPasskeyRegistrationResponse,
+			options...,
+		).Endpoint()
+		finishPasskeyRegistrationEndpoint = resilience.Middleware(
+			"client.finish_passkey_registration",
+			resilience.Load("clients.finishpasskeyregistration"),
+			log,
+		)(finishPasskeyRegistrationEndpoint)
+	}
+
+	var beginPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		beginPasskeyLoginEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/login/passkey/begin"),
+			encodeHTTPGenericRequest,
+			decodeHTTPPasskeyChallengeResponse,
+			options...,
+		).Endpoint()
+		beginPasskeyLoginEndpoint = resilience.Middleware(
+			"client.begin_passkey_login",
+			resilience.Load("clients.beginpasskeylogin"),
+			log,
+		)(beginPasskeyLoginEndpoint)
+	}
+
+	var finishPasskeyLoginEndpoint endpoint.Endpoint
+	{
+		finishPasskeyLoginEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, "/login/passkey/finish"),
+			encodeHTTPFinishPasskeyLoginRequest,
+			decodeHTTPFinishPasskeyLoginResponse,
+			options...,
+		).Endpoint()
+		finishPasskeyLoginEndpoint = resilience.Middleware(
+			"client.finish_passkey_login",
+			resilience.Load("clients.finishpasskeylogin"),
+			log,
+		)(finishPasskeyLoginEndpoint)
+	}
+
+	authenticate := clientAuthentication(tokens)
 	return authendpoint.Set{
-		RegisterEndpoint: registerEndpoint,
-		LoginEndpoint:    loginEndpoint,
+		RegisterEndpoint:       authenticate(registerEndpoint),
+		LoginEndpoint:          authenticate(loginEndpoint),
+		ForgotPasswordEndpoint: authenticate(forgotPasswordEndpoint),
+		ResetPasswordEndpoint:  authenticate(resetPasswordEndpoint),
+		RefreshEndpoint:        authenticate(refreshEndpoint),
+		CreateAPIKeyEndpoint:   authenticate(createAPIKeyEndpoint),
+		ListAPIKeysEndpoint:    authenticate(listAPIKeysEndpoint),
+		RevokeAPIKeyEndpoint:   authenticate(revokeAPIKeyEndpoint),
+		ExchangeAPIKeyEndpoint: authenticate(exchangeAPIKeyEndpoint),
+		IntrospectEndpoint:     authenticate(introspectEndpoint),
+		ListSessionsEndpoint:   authenticate(listSessionsEndpoint),
+		RevokeSessionEndpoint:  authenticate(revokeSessionEndpoint),
+		RevokeSessionsEndpoint: authenticate(revokeSessionsEndpoint),
+
+		RequestMagicLinkEndpoint: authenticate(requestMagicLinkEndpoint),
+		ConsumeMagicLinkEndpoint: authenticate(consumeMagicLinkEndpoint),
+
+		BeginPasskeyRegistrationEndpoint:  authenticate(beginPasskeyRegistrationEndpoint),
+		FinishPasskeyRegistrationEndpoint: authenticate(finishPasskeyRegistrationEndpoint),
+		BeginPasskeyLoginEndpoint:         authenticate(beginPasskeyLoginEndpoint),
+		FinishPasskeyLoginEndpoint:        authenticate(finishPasskeyLoginEndpoint),
 	}, nil
 }
 
+// clientAuthentication attaches the tokens of src to calls, or nothing
+// when src is nil.
+func clientAuthentication(src *oauth.TokenSource) endpoint.Middleware {
+	if src == nil {
+		return func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+	}
+	return oauth.ClientMiddleware(src)
+}
+
 func copyURL(base *url.URL, path string) *url.URL {
 	next := *base
 	next.Path = path
 	return &next
 }
 
+// maxBodyBytes bounds request bodies, the requests here are a few fields.
+const maxBodyBytes = 1 << 16
+
+// decodeJSON decodes a request body, refusing unknown fields and bodies
+// over maxBodyBytes.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.Invalid(err)
+	}
+	return nil
+}
+
 func decodeHTTPLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req authendpoint.LoginRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.UserAgent, req.IP = r.UserAgent(), clientIP(r)
+	return req, nil
+}
+
+// clientIP returns the address of the client, the last of
+// X-Forwarded-For as appended by the gateway, or the peer's otherwise. The
+// entries before it come from the client and could be anything.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(xff[strings.LastIndex(xff, ",")+1:])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func decodeHTTPRequestMagicLinkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.RequestMagicLinkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPConsumeMagicLinkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ConsumeMagicLinkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.UserAgent, req.IP = r.UserAgent(), clientIP(r)
+	return req, nil
 }
 
 func decodeHTTPRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req authendpoint.RegisterRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	// The header takes precedence over the idempotency_key body field.
+	if key := r.Header.Get(idempotency.Header); key != "" {
+		req.IdempotencyKey = key
+	}
+	return req, nil
+}
+
+func decodeHTTPForgotPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ForgotPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPResetPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ResetPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// accessToken returns the token of the caller, from the X-Api-Token header
+// of API clients or the session cookie of browsers, as forwarded by the
+// gateway.
+func accessToken(r *http.Request) string {
+	if token := r.Header.Get(tokenHeader); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func decodeHTTPCreateAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.CreateAPIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.Token = accessToken(r)
+	return req, nil
+}
+
+func decodeHTTPListAPIKeysRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.ListAPIKeysRequest{Token: accessToken(r)}, nil
+}
+
+func decodeHTTPRevokeAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return nil, validate.Errors{"id": "must be a valid id"}
+	}
+	return authendpoint.RevokeAPIKeyRequest{Token: accessToken(r), ID: id}, nil
+}
+
+func decodeHTTPListSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.ListSessionsRequest{Token: accessToken(r)}, nil
+}
+
+func decodeHTTPRevokeSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return nil, validate.Errors{"id": "must be a valid id"}
+	}
+	return authendpoint.RevokeSessionRequest{Token: accessToken(r), ID: id}, nil
+}
+
+func decodeHTTPRevokeSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RevokeSessionsRequest{Token: accessToken(r)}, nil
+}
+
+func decodeHTTPBeginPasskeyRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.BeginPasskeyRegistrationRequest{Token: accessToken(r)}, nil
+}
+
+func decodeHTTPFinishPasskeyRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.FinishPasskeyRegistrationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.Token = accessToken(r)
+	return req, nil
+}
+
+// decodeHTTPBeginPasskeyLoginRequest ignores the body, older clients send
+// an email that is no longer used.
+func decodeHTTPBeginPasskeyLoginRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return authendpoint.BeginPasskeyLoginRequest{}, nil
+}
+
+func decodeHTTPFinishPasskeyLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.FinishPasskeyLoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.UserAgent, req.IP = r.UserAgent(), clientIP(r)
+	return req, nil
+}
+
+func decodeHTTPExchangeAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ExchangeAPIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodeHTTPIntrospectRequest takes the form encoded token=... of RFC 7662
+// as well as JSON.
+func decodeHTTPIntrospectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.IntrospectRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		req.Token = r.PostForm.Get("token")
+		return req, nil
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func decodeHTTPLoginResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp authendpoint.LoginResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
+func decodeHTTPRequestMagicLinkResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.RequestMagicLinkResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPConsumeMagicLinkResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ConsumeMagicLinkResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
 func decodeHTTPRegisterResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp authendpoint.RegisterResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
+func decodeHTTPForgotPasswordResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ForgotPasswordResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPResetPasswordResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ResetPasswordResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRefreshResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.RefreshResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPCreateAPIKeyResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.CreateAPIKeyResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPListAPIKeysResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ListAPIKeysResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeAPIKeyResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.RevokeAPIKeyResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPListSessionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ListSessionsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeSessionResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.RevokeSessionResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeSessionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.RevokeSessionsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPExchangeAPIKeyResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.ExchangeAPIKeyResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPPasskeyChallengeResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.PasskeyChallengeResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPFinishPasskeyRegistrationResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.FinishPasskeyRegistrationResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPFinishPasskeyLoginResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.FinishPasskeyLoginResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPIntrospectResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp authendpoint.IntrospectResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
 func encodeHTTPGenericRequest(_ context.Context, r *http.Request, request interface{}) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(request); err != nil {
@@ -135,6 +893,89 @@ func encodeHTTPGenericRequest(_ context.Context, r *http.Request, request interf
 	return nil
 }
 
+// encodeHTTPLoginRequest passes on the user agent and address of the
+// device logging in, as the gateway does.
+func encodeHTTPLoginRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.LoginRequest)
+	setDeviceHeaders(r, req.UserAgent, req.IP)
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+func encodeHTTPConsumeMagicLinkRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.ConsumeMagicLinkRequest)
+	setDeviceHeaders(r, req.UserAgent, req.IP)
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+func encodeHTTPFinishPasskeyLoginRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.FinishPasskeyLoginRequest)
+	setDeviceHeaders(r, req.UserAgent, req.IP)
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+// setDeviceHeaders sets the headers the server takes a session's device
+// from.
+func setDeviceHeaders(r *http.Request, userAgent, ip string) {
+	if userAgent != "" {
+		r.Header.Set("User-Agent", userAgent)
+	}
+	if ip != "" {
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+}
+
+func encodeHTTPRegisterRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RegisterRequest)
+	if req.IdempotencyKey != "" {
+		r.Header.Set(idempotency.Header, req.IdempotencyKey)
+	}
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+func encodeHTTPCreateAPIKeyRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.CreateAPIKeyRequest).Token)
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+func encodeHTTPListAPIKeysRequest(_ context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.ListAPIKeysRequest).Token)
+	return nil
+}
+
+func encodeHTTPRevokeAPIKeyRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokeAPIKeyRequest)
+	r.Header.Set(tokenHeader, req.Token)
+	r.URL.Path += "/" + req.ID.String()
+	return nil
+}
+
+func encodeHTTPListSessionsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.ListSessionsRequest).Token)
+	return nil
+}
+
+func encodeHTTPRevokeSessionRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokeSessionRequest)
+	r.Header.Set(tokenHeader, req.Token)
+	r.URL.Path += "/" + req.ID.String()
+	return nil
+}
+
+func encodeHTTPRevokeSessionsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.RevokeSessionsRequest).Token)
+	return nil
+}
+
+func encodeHTTPBeginPasskeyRegistrationRequest(_ context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.BeginPasskeyRegistrationRequest).Token)
+	return nil
+}
+
+func encodeHTTPFinishPasskeyRegistrationRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set(tokenHeader, request.(authendpoint.FinishPasskeyRegistrationRequest).Token)
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
 // encodeHTTPGenericResponse is a transport/http.EncodeResponseFunc that encodes
 // the response as JSON to the response writer. Primarily useful in a server.
 func encodeHTTPGenericResponse(
@@ -150,50 +991,51 @@ func encodeHTTPGenericResponse(
 	return json.NewEncoder(w).Encode(response)
 }
 
-func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
-	w.WriteHeader(err2code(err))
-	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
-}
-
-func err2code(err error) int {
-	switch err {
-	case authservice.ErrInvalidCreds:
-		return http.StatusUnauthorized
-	case authservice.ErrGeneratingToken, authservice.ErrInsertingUser:
-		return http.StatusInternalServerError
-	case authservice.ErrWrongEmailFmt, authservice.ErrWrongPassFmt:
-		return http.StatusBadRequest
-	case authservice.ErrUserAlreadyExists:
-		return http.StatusBadRequest
-	}
-
-	return http.StatusInternalServerError
-}
+// serviceName is reported in the problems of this service.
+const serviceName = "auth"
 
-func err2info(err error) error {
-	switch err {
-	case authservice.ErrGeneratingToken, authservice.ErrInsertingUser:
-		return errors.New("internal server error")
-	case authservice.ErrInvalidCreds:
-		return authservice.ErrInvalidCreds
-	case authservice.ErrWrongPassFmt:
-		return authservice.ErrWrongPassFmt
-	case authservice.ErrWrongEmailFmt:
-		return authservice.ErrWrongEmailFmt
-	case authservice.ErrUserAlreadyExists:
-		return authservice.ErrUserAlreadyExists
-	}
-	return errors.New("internal server error")
-}
+var errorEncoder = problem.ErrorEncoder(serviceName, err2problem)
 
-func errorDecoder(r *http.Response) error {
-	var w errorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
-		return err
+func err2problem(err error) *problem.Problem {
+	var fields validate.Errors
+	switch {
+	case errors.Is(err, oauth.ErrInvalidToken):
+		return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+	case errors.Is(err, oauth.ErrInsufficientScope):
+		return problem.Wrap(http.StatusForbidden, problem.CodePermissionDenied, err)
+	case errors.Is(err, authservice.ErrInvalidCreds),
+		errors.Is(err, authservice.ErrInvalidToken),
+		errors.Is(err, authservice.ErrSessionExpired),
+		errors.Is(err, authservice.ErrInvalidAPIKey):
+		return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+	case errors.Is(err, authservice.ErrScopedToken):
+		return problem.Wrap(http.StatusForbidden, problem.CodePermissionDenied, err)
+	case errors.Is(err, authservice.ErrAPIKeyNotFound),
+		errors.Is(err, authservice.ErrSessionNotFound):
+		return problem.Wrap(http.StatusNotFound, problem.CodeNotFound, err)
+	case errors.Is(err, authservice.ErrUnknownScope),
+		errors.Is(err, authservice.ErrExpiryTooLong):
+		return problem.Wrap(http.StatusBadRequest, problem.CodeInvalidArgument, err)
+	case errors.Is(err, authservice.ErrTooManyAPIKeys):
+		return problem.Wrap(http.StatusConflict, problem.CodeConflict, err)
+	case errors.As(err, &fields):
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidArgument, "request validation failed").
+			WithDetails(map[string]any{"fields": fields})
+	case errors.Is(err, authservice.ErrInvalidResetToken),
+		errors.Is(err, authservice.ErrInvalidMagicLink),
+		errors.Is(err, authservice.ErrInvalidPasskey),
+		errors.Is(err, authservice.ErrChallengeNotFound):
+		return problem.Wrap(http.StatusBadRequest, problem.CodeInvalidArgument, err)
+	case errors.Is(err, authservice.ErrUserAlreadyExists):
+		return problem.Wrap(http.StatusConflict, problem.CodeAlreadyExists, err)
+	case errors.Is(err, idempotency.ErrInFlight):
+		return problem.Wrap(http.StatusConflict, problem.CodeConflict, err)
+	case errors.Is(err, idempotency.ErrKeyMismatch):
+		return problem.Wrap(http.StatusUnprocessableEntity, problem.CodeUnprocessable, err)
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return problem.Wrap(http.StatusServiceUnavailable, problem.CodeUnavailable, err)
 	}
-	return errors.New(w.Error)
-}
-
-type errorWrapper struct {
-	Error string `json:"error"`
+	// ErrGeneratingToken, ErrInsertingUser and anything unexpected end up
+	// as internal problems without leaking the cause.
+	return problem.From(err)
 }
@@ -0,0 +1,99 @@
+// Package notify hands messages for users to the mail pipeline.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+type Notifier interface {
+	// SendPasswordReset mails a password reset link carrying token.
+	SendPasswordReset(ctx context.Context, email, token string) error
+	// SendMagicLink mails a login link carrying token.
+	SendMagicLink(ctx context.Context, email, token string) error
+	// SendNewDevice mails a security notice about a login from a new
+	// device.
+	SendNewDevice(ctx context.Context, login NewDevice) error
+}
+
+// NewDevice is a login from a device the user had not logged in from
+// before.
+type NewDevice struct {
+	Email     string    `json:"email"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	At        time.Time `json:"at"`
+}
+
+type producerNotifier struct {
+	client *http.Client
+	base   *url.URL
+}
+
+// NewProducerNotifier returns a Notifier publishing through the producer,
+// which queues the mail for consumer_mailer.
+func NewProducerNotifier(instance string) (Notifier, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	return producerNotifier{
+		client: &http.Client{Timeout: 5 * time.Second},
+		base:   u,
+	}, nil
+}
+
+// tokenPayload carries the token of a reset or login link.
+type tokenPayload struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+func (n producerNotifier) SendPasswordReset(ctx context.Context, email, token string) error {
+	return n.post(ctx, "/reset", tokenPayload{Email: email, Token: token})
+}
+
+func (n producerNotifier) SendMagicLink(ctx context.Context, email, token string) error {
+	return n.post(ctx, "/magiclink", tokenPayload{Email: email, Token: token})
+}
+
+func (n producerNotifier) SendNewDevice(ctx context.Context, login NewDevice) error {
+	return n.post(ctx, "/newdevice", login)
+}
+
+func (n producerNotifier) post(ctx context.Context, path string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	u := *n.base
+	u.Path = path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := problem.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(problem.RequestIDHeader, id)
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return problem.Decode(res)
+	}
+	return nil
+}
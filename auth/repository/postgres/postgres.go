@@ -2,14 +2,19 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/spf13/viper"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 
+	"github.com/F1zm0n/uni-auth/migrations"
 	"github.com/F1zm0n/uni-auth/repository"
 )
 
@@ -44,12 +49,19 @@ func connectPostgres() (*gorm.DB, error) {
 	return db, err
 }
 
+// DB exposes the underlying connection pool for the migrations runner.
+func (p Postgres) DB() (*sql.DB, error) {
+	return p.conn.DB()
+}
+
 func (p Postgres) MustMigrateSchema() {
-	err := p.conn.AutoMigrate(repository.User{})
+	db, err := p.DB()
 	if err != nil {
 		panic(err)
 	}
-	return
+	if err := migrations.Up(context.Background(), db); err != nil {
+		panic(err)
+	}
 }
 
 func (p Postgres) InsertUser(ctx context.Context, user repository.User) error {
@@ -80,3 +92,258 @@ func (p Postgres) GetUserById(ctx context.Context, id uuid.UUID) (repository.Use
 	}
 	return user, nil
 }
+
+func (p Postgres) CreatePasswordReset(ctx context.Context, reset repository.PasswordReset) error {
+	return p.conn.WithContext(ctx).Create(&reset).Error
+}
+
+func (p Postgres) ResetPassword(ctx context.Context, tokenHash, password []byte) error {
+	return p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var reset repository.PasswordReset
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > now()", tokenHash).
+			First(&reset)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return repository.ErrTokenNotFound
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+
+		res = tx.Model(&repository.User{}).
+			Where("id = ?", reset.UserID).
+			Update("password", password)
+		if res.Error != nil {
+			return res.Error
+		}
+		res = tx.Model(&repository.PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", gorm.Expr("now()"))
+		if res.Error != nil {
+			return res.Error
+		}
+		return tx.Model(&repository.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", reset.UserID).
+			Update("revoked_at", gorm.Expr("now()")).Error
+	})
+}
+
+func (p Postgres) CreateMagicLink(ctx context.Context, link repository.MagicLink) error {
+	return p.conn.WithContext(ctx).Create(&link).Error
+}
+
+func (p Postgres) UseMagicLink(ctx context.Context, tokenHash []byte) (repository.User, error) {
+	var user repository.User
+	err := p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var link repository.MagicLink
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > now()", tokenHash).
+			First(&link)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return repository.ErrTokenNotFound
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+
+		res = tx.Model(&repository.MagicLink{}).
+			Where("user_id = ? AND used_at IS NULL", link.UserID).
+			Update("used_at", gorm.Expr("now()"))
+		if res.Error != nil {
+			return res.Error
+		}
+		return tx.Where("id = ?", link.UserID).First(&user).Error
+	})
+	return user, err
+}
+
+func (p Postgres) CreateAPIKey(ctx context.Context, key repository.APIKey, max int) error {
+	return p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the user's row serializes concurrent creations
+		var user repository.User
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", key.UserID).
+			First(&user)
+		if res.Error != nil {
+			return res.Error
+		}
+		var n int64
+		res = tx.Model(&repository.APIKey{}).
+			Where("user_id = ? AND revoked_at IS NULL AND expires_at > now()", key.UserID).
+			Count(&n)
+		if res.Error != nil {
+			return res.Error
+		}
+		if n >= int64(max) {
+			return repository.ErrTooManyKeys
+		}
+		return tx.Create(&key).Error
+	})
+}
+
+func (p Postgres) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]repository.APIKey, error) {
+	var keys []repository.APIKey
+	res := p.conn.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&keys)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return keys, nil
+}
+
+func (p Postgres) RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) error {
+	res := p.conn.WithContext(ctx).Model(&repository.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", gorm.Expr("now()"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.ErrTokenNotFound
+	}
+	return nil
+}
+
+func (p Postgres) UseAPIKey(ctx context.Context, keyHash []byte) (repository.APIKey, error) {
+	var key repository.APIKey
+	res := p.conn.WithContext(ctx).Model(&key).
+		Clauses(clause.Returning{}).
+		Where("key_hash = ? AND revoked_at IS NULL AND expires_at > now()", keyHash).
+		Update("last_used_at", gorm.Expr("now()"))
+	if res.Error != nil {
+		return repository.APIKey{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.APIKey{}, repository.ErrTokenNotFound
+	}
+	return key, nil
+}
+
+func (p Postgres) GetAPIKey(ctx context.Context, id uuid.UUID) (repository.APIKey, error) {
+	var key repository.APIKey
+	err := p.conn.WithContext(ctx).Where("id = ?", id).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return repository.APIKey{}, repository.ErrTokenNotFound
+	}
+	return key, err
+}
+
+func (p Postgres) CreateSession(ctx context.Context, session repository.Session) (bool, error) {
+	var newDevice bool
+	err := p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var seen struct {
+			HasSessions bool
+			HasDevice   bool
+		}
+		res := tx.Raw(
+			`SELECT EXISTS (SELECT 1 FROM sessions WHERE user_id = ?) AS has_sessions,
+				EXISTS (SELECT 1 FROM sessions WHERE user_id = ? AND device_hash = ?) AS has_device`,
+			session.UserID, session.UserID, session.DeviceHash,
+		).Scan(&seen)
+		if res.Error != nil {
+			return res.Error
+		}
+		newDevice = seen.HasSessions && !seen.HasDevice
+		return tx.Create(&session).Error
+	})
+	return newDevice, err
+}
+
+func (p Postgres) ListSessions(ctx context.Context, userID uuid.UUID, since time.Time) ([]repository.Session, error) {
+	var sessions []repository.Session
+	res := p.conn.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, since).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return sessions, nil
+}
+
+func (p Postgres) TouchSession(ctx context.Context, id uuid.UUID) (repository.Session, error) {
+	var session repository.Session
+	res := p.conn.WithContext(ctx).Model(&session).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("last_seen_at", gorm.Expr("now()"))
+	if res.Error != nil {
+		return repository.Session{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.Session{}, repository.ErrTokenNotFound
+	}
+	return session, nil
+}
+
+func (p Postgres) RevokeSession(ctx context.Context, userID, id uuid.UUID) error {
+	res := p.conn.WithContext(ctx).Model(&repository.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", gorm.Expr("now()"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.ErrTokenNotFound
+	}
+	return nil
+}
+
+func (p Postgres) RevokeSessions(ctx context.Context, userID uuid.UUID) error {
+	return p.conn.WithContext(ctx).Model(&repository.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", gorm.Expr("now()")).Error
+}
+
+func (p Postgres) CreatePasskey(ctx context.Context, passkey repository.Passkey) error {
+	return p.conn.WithContext(ctx).Create(&passkey).Error
+}
+
+func (p Postgres) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]repository.Passkey, error) {
+	var passkeys []repository.Passkey
+	res := p.conn.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&passkeys)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return passkeys, nil
+}
+
+func (p Postgres) UsePasskey(ctx context.Context, id uuid.UUID, signCount uint32, backupState bool) error {
+	return p.conn.WithContext(ctx).Model(&repository.Passkey{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": gorm.Expr("now()"),
+		}).Error
+}
+
+func (p Postgres) CreatePasskeyChallenge(ctx context.Context, challenge repository.PasskeyChallenge) error {
+	return p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("expires_at <= now()").Delete(&repository.PasskeyChallenge{})
+		if res.Error != nil {
+			return res.Error
+		}
+		return tx.Create(&challenge).Error
+	})
+}
+
+func (p Postgres) TakePasskeyChallenge(ctx context.Context, id uuid.UUID, ceremony string) (repository.PasskeyChallenge, error) {
+	var challenges []repository.PasskeyChallenge
+	res := p.conn.WithContext(ctx).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND ceremony = ? AND expires_at > now()", id, ceremony).
+		Delete(&challenges)
+	if res.Error != nil {
+		return repository.PasskeyChallenge{}, res.Error
+	}
+	if len(challenges) == 0 {
+		return repository.PasskeyChallenge{}, repository.ErrTokenNotFound
+	}
+	return challenges[0], nil
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/F1zm0n/uni-auth/repository"
+)
+
+// openPostgres connects to the database named by TEST_POSTGRES_DSN and
+// migrates it, the tests are skipped without it.
+func openPostgres(t *testing.T) Postgres {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("opening postgres: %v", err)
+	}
+	p := Postgres{conn: conn}
+	p.MustMigrateSchema()
+	t.Cleanup(func() {
+		if db, err := p.DB(); err == nil {
+			db.Close()
+		}
+	})
+	return p
+}
+
+// newUser inserts a user unique to the test.
+func newUser(t *testing.T, p Postgres) repository.User {
+	t.Helper()
+	user := repository.User{
+		ID:       uuid.New(),
+		Email:    uuid.NewString() + "@example.com",
+		Password: []byte("hash"),
+	}
+	if err := p.InsertUser(context.Background(), user); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	t.Cleanup(func() {
+		p.conn.Where("user_id = ?", user.ID).Delete(&repository.MagicLink{})
+		p.conn.Where("id = ?", user.ID).Delete(&repository.User{})
+	})
+	return user
+}
+
+// newLink stores a magic link of user expiring after ttl.
+func newLink(t *testing.T, p Postgres, user repository.User, ttl time.Duration) []byte {
+	t.Helper()
+	hash := make([]byte, 32)
+	rand.Read(hash)
+	err := p.CreateMagicLink(context.Background(), repository.MagicLink{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		t.Fatalf("CreateMagicLink: %v", err)
+	}
+	return hash
+}
+
+func TestPostgresUseMagicLinkOnce(t *testing.T) {
+	p := openPostgres(t)
+	ctx := context.Background()
+	user := newUser(t, p)
+	hash := newLink(t, p, user, time.Minute)
+
+	got, err := p.UseMagicLink(ctx, hash)
+	if err != nil {
+		t.Fatalf("UseMagicLink: %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("UseMagicLink = user %s, want %s", got.ID, user.ID)
+	}
+	if _, err := p.UseMagicLink(ctx, hash); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("second use = %v, want %v", err, repository.ErrTokenNotFound)
+	}
+}
+
+func TestPostgresUseMagicLinkUsesUpOthers(t *testing.T) {
+	p := openPostgres(t)
+	ctx := context.Background()
+	user := newUser(t, p)
+	older, newer := newLink(t, p, user, time.Minute), newLink(t, p, user, time.Minute)
+	other := newUser(t, p)
+	unrelated := newLink(t, p, other, time.Minute)
+
+	if _, err := p.UseMagicLink(ctx, newer); err != nil {
+		t.Fatalf("UseMagicLink: %v", err)
+	}
+	if _, err := p.UseMagicLink(ctx, older); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("older link of the user = %v, want %v", err, repository.ErrTokenNotFound)
+	}
+	if _, err := p.UseMagicLink(ctx, unrelated); err != nil {
+		t.Errorf("link of another user: %v", err)
+	}
+}
+
+func TestPostgresUseMagicLinkExpired(t *testing.T) {
+	p := openPostgres(t)
+	user := newUser(t, p)
+	hash := newLink(t, p, user, -time.Second)
+	if _, err := p.UseMagicLink(context.Background(), hash); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("expired link = %v, want %v", err, repository.ErrTokenNotFound)
+	}
+}
+
+// Clicks racing on the same link, say from a mail scanner and the user,
+// log in once: the row lock serializes them.
+func TestPostgresUseMagicLinkConcurrently(t *testing.T) {
+	p := openPostgres(t)
+	user := newUser(t, p)
+	hash := newLink(t, p, user, time.Minute)
+
+	const clicks = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, clicks)
+	for i := 0; i < clicks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.UseMagicLink(context.Background(), hash)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var logins int
+	for err := range errs {
+		switch {
+		case err == nil:
+			logins++
+		case !errors.Is(err, repository.ErrTokenNotFound):
+			t.Errorf("UseMagicLink: %v", err)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("%d logins with one link, want 1", logins)
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,9 +13,149 @@ type User struct {
 	Email     string    `gorm:"not null;unique"`
 	Password  []byte    `gorm:"not null"`
 	CreatedAt time.Time
+	// IdempotencyKey is the key of the registration that created the user.
+	IdempotencyKey *string `gorm:"unique"`
+}
+
+// ErrTokenNotFound is returned for tokens that are unknown, expired or
+// already used, and for API keys that are unknown, expired or revoked.
+var ErrTokenNotFound = errors.New("token not found")
+
+// ErrTooManyKeys is returned when a user is at their limit of API keys.
+var ErrTooManyKeys = errors.New("too many api keys")
+
+// PasswordReset is a single use password reset token. Only the token's hash
+// is stored.
+type PasswordReset struct {
+	TokenHash []byte    `gorm:"primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// MagicLink is a single use login link. Only the token's hash is stored.
+type MagicLink struct {
+	TokenHash []byte    `gorm:"primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// APIKey is a long lived key of a machine client. Only the key's hash is
+// stored, Prefix is kept in clear so that users can tell their keys apart.
+type APIKey struct {
+	ID      uuid.UUID `gorm:"type:uuid;"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null"`
+	Name    string    `gorm:"not null"`
+	Prefix  string    `gorm:"not null"`
+	KeyHash []byte    `gorm:"not null;unique"`
+	// Scopes are separated by spaces, as in the scope claim of tokens.
+	Scopes     string    `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Session is a login of a user. DeviceHash identifies the device across
+// sessions, so that logins from new devices can be told apart.
+type Session struct {
+	ID         uuid.UUID `gorm:"type:uuid;"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null"`
+	Device     string    `gorm:"not null"`
+	UserAgent  string    `gorm:"not null"`
+	IP         string    `gorm:"not null"`
+	DeviceHash []byte    `gorm:"not null"`
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+// Passkey is a WebAuthn credential of a user. Transports are separated by
+// spaces.
+type Passkey struct {
+	ID              uuid.UUID `gorm:"type:uuid;"`
+	UserID          uuid.UUID `gorm:"type:uuid;not null"`
+	Name            string    `gorm:"not null"`
+	CredentialID    []byte    `gorm:"not null;unique"`
+	PublicKey       []byte    `gorm:"not null"`
+	AttestationType string    `gorm:"not null"`
+	AAGUID          []byte    `gorm:"column:aaguid;not null"`
+	SignCount       uint32    `gorm:"not null"`
+	Transports      string    `gorm:"not null"`
+	BackupEligible  bool      `gorm:"not null"`
+	BackupState     bool      `gorm:"not null"`
+	CreatedAt       time.Time
+	LastUsedAt      *time.Time
+}
+
+// PasskeyChallenge is the state of a passkey ceremony between its begin and
+// finish steps. Data is opaque to the repository. UserID is nil for logins
+// that let the authenticator pick the user.
+type PasskeyChallenge struct {
+	ID        uuid.UUID  `gorm:"type:uuid;"`
+	UserID    *uuid.UUID `gorm:"type:uuid"`
+	Ceremony  string     `gorm:"not null"`
+	Data      []byte     `gorm:"not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time
 }
 
 type Repository interface {
 	InsertUser(ctx context.Context, user User) error
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	CreatePasswordReset(ctx context.Context, reset PasswordReset) error
+	// ResetPassword sets the password of the user owning the unexpired,
+	// unused reset token and uses the token up, along with any other
+	// tokens of the user. It revokes every session of the user, so that
+	// whoever logged in with the old password is logged out. It returns
+	// ErrTokenNotFound when there is no such token.
+	ResetPassword(ctx context.Context, tokenHash, password []byte) error
+	GetUserById(ctx context.Context, id uuid.UUID) (User, error)
+	CreateMagicLink(ctx context.Context, link MagicLink) error
+	// UseMagicLink returns the user owning the unexpired, unused link
+	// token and uses the token up, along with any other links of the user.
+	// It returns ErrTokenNotFound when there is no such token.
+	UseMagicLink(ctx context.Context, tokenHash []byte) (User, error)
+	// CreateAPIKey stores key unless the user already has max unrevoked,
+	// unexpired keys, in which case it returns ErrTooManyKeys.
+	CreateAPIKey(ctx context.Context, key APIKey, max int) error
+	// ListAPIKeys returns the unrevoked keys of a user, newest first.
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error)
+	// RevokeAPIKey revokes a key of the user, returning ErrTokenNotFound
+	// when the user has no such unrevoked key.
+	RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) error
+	// UseAPIKey returns the unrevoked, unexpired key with the hash and
+	// records it as used, or returns ErrTokenNotFound.
+	UseAPIKey(ctx context.Context, keyHash []byte) (APIKey, error)
+	// GetAPIKey returns a key, revoked or not, or ErrTokenNotFound.
+	GetAPIKey(ctx context.Context, id uuid.UUID) (APIKey, error)
+	// CreateSession stores session and reports whether its device is new:
+	// the user had sessions before, none of them from this device.
+	CreateSession(ctx context.Context, session Session) (newDevice bool, err error)
+	// ListSessions returns the unrevoked sessions of a user seen since
+	// since, most recently seen first.
+	ListSessions(ctx context.Context, userID uuid.UUID, since time.Time) ([]Session, error)
+	// TouchSession records an unrevoked session as seen and returns it,
+	// or returns ErrTokenNotFound.
+	TouchSession(ctx context.Context, id uuid.UUID) (Session, error)
+	// RevokeSession revokes a session of the user, returning
+	// ErrTokenNotFound when the user has no such unrevoked session.
+	RevokeSession(ctx context.Context, userID, id uuid.UUID) error
+	// RevokeSessions revokes every session of the user.
+	RevokeSessions(ctx context.Context, userID uuid.UUID) error
+	CreatePasskey(ctx context.Context, passkey Passkey) error
+	// ListPasskeys returns the passkeys of a user, oldest first.
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]Passkey, error)
+	// UsePasskey records a login with a passkey and the signature counter
+	// and backup state its authenticator reported.
+	UsePasskey(ctx context.Context, id uuid.UUID, signCount uint32, backupState bool) error
+	// CreatePasskeyChallenge stores challenge, dropping expired ones.
+	CreatePasskeyChallenge(ctx context.Context, challenge PasskeyChallenge) error
+	// TakePasskeyChallenge deletes and returns the unexpired challenge of
+	// the ceremony, so that it is answered once only, or returns
+	// ErrTokenNotFound.
+	TakePasskeyChallenge(ctx context.Context, id uuid.UUID, ceremony string) (PasskeyChallenge, error)
 }
@@ -1,4 +1,331 @@
 package main
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/auth"
+	"github.com/F1zm0n/universal-chat/internal/bus"
+	"github.com/F1zm0n/universal-chat/internal/chatendpoint"
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+	"github.com/F1zm0n/universal-chat/internal/chattransport"
+	"github.com/F1zm0n/universal-chat/internal/digest"
+	"github.com/F1zm0n/universal-chat/internal/filter"
+	"github.com/F1zm0n/universal-chat/internal/hub"
+	"github.com/F1zm0n/universal-chat/internal/repository/postgres"
+	"github.com/F1zm0n/universal-chat/internal/storage"
+	"github.com/F1zm0n/universal-chat/internal/thumbnail"
+	"github.com/F1zm0n/universal-chat/internal/webhook"
+	"github.com/F1zm0n/universal-chat/migrations"
+	"github.com/F1zm0n/universal-kit/introspect"
+)
+
+var configPaths = []string{"../chat/config", "/app/config/"}
+
 func main() {
+	parseConfig(configPaths)
+	db := mustNewPostgresDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.Run(context.Background(), db, os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := migrations.Up(context.Background(), db); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	// replica tells this instance's events apart from those of the others
+	replica := uuid.NewString()
+	logger = log.With(logger, "replica", replica)
+	events := mustNewBus(replica, log.With(logger, "component", "bus"))
+	defer events.Close()
+
+	files := chatservice.LoadFiles()
+	{
+		store, err := storage.New(context.Background())
+		if err != nil {
+			logger.Log("during", "storage", "err", err)
+			os.Exit(1)
+		}
+		files.Store = store
+	}
+	thumbnails := thumbnail.NewWorker(
+		256,
+		viper.GetDuration("chat.files.thumbnail.interval"),
+		log.With(logger, "component", "thumbnail"),
+	)
+	files.Thumbnails = thumbnails.Enqueue
+
+	filters, err := filter.Load()
+	if err != nil {
+		logger.Log("during", "filters", "err", err)
+		os.Exit(1)
+	}
+
+	hooksConfig := webhook.LoadConfig()
+	hooks := mustNewHooks(hooksConfig, log.With(logger, "component", "webhook"))
+	defer hooks.Close()
+	integrations := chatservice.LoadIntegrations()
+	integrations.Hooks = hooks
+
+	httpAddr := viper.GetString("listen.http.port")
+	var (
+		users   = mustNewUsers()
+		repo    = postgres.New(db)
+		service = chatservice.New(
+			logger,
+			repo,
+			bus.NewNotifier(events, replica, logger),
+			files,
+			filters,
+			integrations,
+			replica,
+		)
+		connections = hub.New(
+			hub.LoadConfig(),
+			log.With(logger, "component", "hub"),
+			func(ctx context.Context, user string, online bool) {
+				service.SetPresence(ctx, user, online)
+			},
+		)
+		fanout    = bus.NewFanout(connections, viper.GetInt("chat.bus.dedupwindow"))
+		endpoints = chatendpoint.New(service, logger)
+		wsHandler = chattransport.NewWSHandler(
+			endpoints,
+			connections,
+			viper.GetStringSlice("chat.ws.origins"),
+			users,
+			service,
+			logger,
+		)
+		httpHandler = chattransport.NewHTTPServer(endpoints, wsHandler, files.Signer, users, service, logger)
+	)
+
+	var g group.Group
+	{
+		httpListener, err := net.Listen("tcp", ":"+httpAddr)
+		if err != nil {
+			logger.Log("transport", "HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+		g.Add(
+			func() error {
+				logger.Log("transport", "HTTP", "addr", httpAddr)
+				return http.Serve(httpListener, httpHandler)
+			},
+			func(err error) {
+				httpListener.Close()
+			},
+		)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return events.Subscribe(ctx, fanout.Handle)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return thumbnails.Run(ctx, service)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	{
+		// connected users are recorded as seen regularly, so that how long
+		// they have been offline is known after this replica is gone too
+		heartbeat := viper.GetDuration("chat.presence.heartbeat")
+		if heartbeat <= 0 {
+			heartbeat = 30 * time.Second
+		}
+		ticker := time.NewTicker(heartbeat)
+		done := make(chan struct{})
+		g.Add(
+			func() error {
+				for {
+					select {
+					case <-done:
+						return nil
+					case <-ticker.C:
+						service.Seen(context.Background(), connections.Users())
+					}
+				}
+			},
+			func(error) {
+				ticker.Stop()
+				close(done)
+			},
+		)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return hooks.Run(ctx, webhook.NewSender(repo, hooksConfig.Timeout))
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	if cfg := digest.LoadConfig(); cfg.After > 0 {
+		pub, err := digest.NewKafkaPublisher(cfg.Brokers, cfg.Topic)
+		if err != nil {
+			logger.Log("during", "digest", "err", err)
+			os.Exit(1)
+		}
+		sender := digest.NewSender(cfg, repo, pub, log.With(logger, "component", "digest"))
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return sender.Run(ctx)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	{
+		// This function just sits and waits for ctrl-C.
+		cancelInterrupt := make(chan struct{})
+		g.Add(func() error {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			select {
+			case sig := <-c:
+				return fmt.Errorf("received signal %s", sig)
+			case <-cancelInterrupt:
+				return nil
+			}
+		}, func(error) {
+			close(cancelInterrupt)
+		})
+	}
+	logger.Log("exit", g.Run())
+}
+
+func mustNewPostgresDB() *sql.DB {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		viper.GetString("postgres.host"),
+		viper.GetString("postgres.user"),
+		viper.GetString("postgres.password"),
+		viper.GetString("postgres.dbname"),
+		viper.GetString("postgres.port"),
+		viper.GetString("postgres.sslmode"),
+	)
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// mustNewBus connects to the bus chat.bus.kind names. The memory bus only
+// suits a single replica.
+func mustNewBus(replica string, logger log.Logger) bus.Bus {
+	switch kind := viper.GetString("chat.bus.kind"); kind {
+	case "", "memory":
+		return bus.NewMemory()
+	case "kafka":
+		b, err := bus.NewKafka(
+			viper.GetString("chat.bus.brokers"),
+			viper.GetString("chat.bus.topic"),
+			replica,
+			logger,
+		)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	default:
+		panic(fmt.Sprintf("unknown chat.bus.kind %q", kind))
+	}
+}
+
+// hooks queues and makes the calls of bot webhooks.
+type hooks interface {
+	webhook.Queue
+	Run(ctx context.Context, s *webhook.Sender) error
+	Close() error
+}
+
+// mustNewHooks queues bot calls on the queue cfg.Kind names. Calls queued
+// in memory are lost when the replica stops.
+func mustNewHooks(cfg webhook.Config, logger log.Logger) hooks {
+	switch cfg.Kind {
+	case "", "memory":
+		return webhook.NewMemory(cfg, logger)
+	case "kafka":
+		k, err := webhook.NewKafka(cfg, logger)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	default:
+		panic(fmt.Sprintf("unknown chat.webhooks.kind %q", cfg.Kind))
+	}
+}
+
+// mustNewUsers checks user tokens with auth.secret, or by introspection
+// with the auth service at auth.addr.
+func mustNewUsers() auth.Users {
+	if !viper.GetBool("introspection.enabled") {
+		return auth.Secret{}
+	}
+	tokens, err := introspect.NewTokenSource()
+	if err != nil {
+		panic(err)
+	}
+	client, err := introspect.NewClient(viper.GetString("auth.addr"), tokens)
+	if err != nil {
+		panic(err)
+	}
+	return auth.Introspection{Introspector: introspect.NewCache(
+		client,
+		viper.GetDuration("introspection.cachettl"),
+		viper.GetInt("introspection.cachesize"),
+	)}
+}
+
+func parseConfig(paths []string) {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	for _, path := range paths {
+		viper.AddConfigPath(path)
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,158 @@
+// Package auth authenticates chat users with the tokens the auth service
+// issues, checked with auth.secret or by introspection. Users are
+// identified by their email. Bots authenticate with tokens of their own,
+// which chat checks against its database.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-kit/introspect"
+)
+
+const (
+	// TokenHeader carries the token of API clients.
+	TokenHeader = "X-Api-Token"
+	// SessionCookie carries the token of browsers signed in through the
+	// gateway.
+	SessionCookie = "session"
+	// BotScheme is the Authorization scheme of bot tokens, as in
+	// Authorization: Bot <token>.
+	BotScheme = "Bot"
+)
+
+// Users authenticates user tokens, returning the email of the user.
+type Users interface {
+	AuthenticateUser(ctx context.Context, token string) (string, error)
+}
+
+// Secret checks user tokens with auth.secret, see Parse.
+type Secret struct{}
+
+func (Secret) AuthenticateUser(_ context.Context, token string) (string, error) {
+	return Parse(token)
+}
+
+// Introspection checks user tokens with the auth service's Introspect, it
+// needs no auth.secret and refuses the tokens of revoked API keys and ended
+// sessions. Introspector should be an introspect.Cache.
+type Introspection struct {
+	Introspector introspect.Introspector
+}
+
+func (i Introspection) AuthenticateUser(ctx context.Context, token string) (string, error) {
+	in, err := i.Introspector.Introspect(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if !in.Active {
+		return "", ErrUnauthenticated
+	}
+	return user(in.Username)
+}
+
+// Bots authenticates bot tokens, returning the member a token stands for.
+type Bots interface {
+	AuthenticateBot(ctx context.Context, token string) (string, error)
+}
+
+var ErrUnauthenticated = errors.New("missing or invalid token")
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// User returns the authenticated user, or "" when there is none.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// Parse verifies token and returns its user.
+func Parse(token string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		token,
+		claims,
+		func(*jwt.Token) (interface{}, error) {
+			// must match the auth service's auth.secret
+			return []byte(viper.GetString("auth.secret")), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	email, _ := claims["email"].(string)
+	return user(email)
+}
+
+// user returns the user with email, service tokens have none.
+func user(email string) (string, error) {
+	// bots and webhooks take part in rooms under .invalid addresses, no
+	// user may pass for them
+	if email == "" || strings.HasSuffix(email, ".invalid") {
+		return "", ErrUnauthenticated
+	}
+	return email, nil
+}
+
+// FromRequest authenticates r by its bot token, checked with bots, or its
+// token header or session cookie, checked with users. A nil bots refuses
+// bot tokens.
+func FromRequest(ctx context.Context, r *http.Request, users Users, bots Bots) (string, error) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, BotScheme) {
+		if bots == nil {
+			return "", ErrUnauthenticated
+		}
+		member, err := bots.AuthenticateBot(ctx, token)
+		if err != nil {
+			return "", ErrUnauthenticated
+		}
+		return member, nil
+	}
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		if cookie, err := r.Cookie(SessionCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return "", ErrUnauthenticated
+	}
+	return users.AuthenticateUser(ctx, token)
+}
+
+// HTTPToContext puts the user of the request into the context. Requests
+// without a valid token are left alone, Middleware rejects them.
+func HTTPToContext(users Users, bots Bots) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if user, err := FromRequest(ctx, r, users, bots); err == nil {
+			return WithUser(ctx, user)
+		}
+		return ctx
+	}
+}
+
+// Middleware rejects requests without an authenticated user.
+func Middleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if User(ctx) == "" {
+				return nil, ErrUnauthenticated
+			}
+			return next(ctx, request)
+		}
+	}
+}
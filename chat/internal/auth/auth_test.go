@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/F1zm0n/universal-kit/introspect"
+)
+
+type introspections map[string]introspect.Introspection
+
+func (i introspections) Introspect(_ context.Context, token string) (introspect.Introspection, error) {
+	return i[token], nil
+}
+
+func TestIntrospection(t *testing.T) {
+	users := Introspection{Introspector: introspections{
+		"alice":   {Active: true, Username: "alice@example.com"},
+		"ended":   {Revoked: true},
+		"service": {Active: true, ClientID: "consumer"},
+		"bot":     {Active: true, Username: "bot-1@bots.invalid"},
+	}}
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"alice", "alice@example.com"},
+		{"ended", ""},
+		{"service", ""},
+		{"bot", ""},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/rooms", nil)
+			r.Header.Set(TokenHeader, tt.token)
+			got, err := FromRequest(context.Background(), r, users, nil)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("FromRequest = %q, %v, want %v", got, err, ErrUnauthenticated)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("FromRequest = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package bus carries chat events between the replicas of the service.
+//
+// Every replica publishes the events its requests raise to the bus and
+// subscribes to it, delivering what it receives, its own events included,
+// to the connections it holds. A replica therefore never needs to know
+// where the recipients of an event are connected.
+package bus
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+)
+
+// Envelope is an event on its way to the connections of Users.
+type Envelope struct {
+	// ID is unique per published event, subscribers drop envelopes whose
+	// ID they have seen already.
+	ID string `json:"id"`
+	// Origin is the replica that published the envelope.
+	Origin string            `json:"origin"`
+	Users  []string          `json:"users"`
+	Event  chatservice.Event `json:"event"`
+}
+
+// Key orders envelopes: those with the same key are delivered in the order
+// they were published. Room events are keyed by their room, presence
+// events by their user.
+func (e Envelope) Key() string {
+	if e.Event.RoomID != nil {
+		return e.Event.RoomID.String()
+	}
+	return e.Event.Member
+}
+
+type Bus interface {
+	Publish(ctx context.Context, env Envelope) error
+	// Subscribe passes every envelope published from now on to handle,
+	// one at a time, until ctx is done.
+	Subscribe(ctx context.Context, handle func(ctx context.Context, env Envelope)) error
+	Close() error
+}
+
+type notifier struct {
+	bus    Bus
+	origin string
+	logger log.Logger
+}
+
+// NewNotifier returns a chatservice.Notifier publishing events to bus on
+// behalf of the replica origin.
+func NewNotifier(bus Bus, origin string, logger log.Logger) chatservice.Notifier {
+	return notifier{
+		bus:    bus,
+		origin: origin,
+		logger: logger,
+	}
+}
+
+func (n notifier) Notify(ctx context.Context, users []string, ev chatservice.Event) {
+	if len(users) == 0 {
+		return
+	}
+	env := Envelope{
+		ID:     uuid.NewString(),
+		Origin: n.origin,
+		Users:  users,
+		Event:  ev,
+	}
+	// delivery is best effort, like the hub's
+	if err := n.bus.Publish(ctx, env); err != nil {
+		n.logger.Log("during", "publish", "event", ev.Type, "err", err)
+	}
+}
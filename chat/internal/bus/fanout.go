@@ -0,0 +1,86 @@
+package bus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+)
+
+// DefaultDedupWindow is the number of envelope ids a Fanout remembers.
+const DefaultDedupWindow = 10000
+
+// Fanout hands the envelopes a replica receives to its local connections.
+//
+// It drops envelopes it has seen already, since Kafka delivers at least
+// once. Presence is folded across replicas: a user connected to several of
+// them only goes offline once their last replica says so.
+type Fanout struct {
+	local chatservice.Notifier
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+	// ring holds the ids of seen in arrival order, the oldest is
+	// forgotten first.
+	ring []string
+	next int
+	// online holds the replicas each user is connected to.
+	online map[string]map[string]struct{}
+}
+
+// NewFanout returns a Fanout delivering to local, usually the hub, and
+// remembering the last window envelope ids.
+func NewFanout(local chatservice.Notifier, window int) *Fanout {
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+	return &Fanout{
+		local:  local,
+		seen:   make(map[string]struct{}, window),
+		ring:   make([]string, window),
+		online: make(map[string]map[string]struct{}),
+	}
+}
+
+// Handle is meant to be passed to Bus.Subscribe.
+func (f *Fanout) Handle(ctx context.Context, env Envelope) {
+	if !f.accept(env) {
+		return
+	}
+	f.local.Notify(ctx, env.Users, env.Event)
+}
+
+// accept reports whether env should be delivered.
+func (f *Fanout) accept(env Envelope) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.seen[env.ID]; ok {
+		return false
+	}
+	if old := f.ring[f.next]; old != "" {
+		delete(f.seen, old)
+	}
+	f.ring[f.next] = env.ID
+	f.next = (f.next + 1) % len(f.ring)
+	f.seen[env.ID] = struct{}{}
+
+	if env.Event.Type != chatservice.EventPresence || env.Event.Online == nil {
+		return true
+	}
+	user := env.Event.Member
+	replicas := f.online[user]
+	was := len(replicas) > 0
+	if *env.Event.Online {
+		if replicas == nil {
+			replicas = make(map[string]struct{})
+			f.online[user] = replicas
+		}
+		replicas[env.Origin] = struct{}{}
+	} else {
+		delete(replicas, env.Origin)
+		if len(replicas) == 0 {
+			delete(f.online, user)
+		}
+	}
+	return was != (len(replicas) > 0)
+}
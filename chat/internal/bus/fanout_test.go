@@ -0,0 +1,120 @@
+package bus
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+)
+
+// recorder is a chatservice.Notifier remembering what it delivered.
+type recorder struct {
+	events []chatservice.Event
+}
+
+func (r *recorder) Notify(_ context.Context, _ []string, ev chatservice.Event) {
+	r.events = append(r.events, ev)
+}
+
+func message(id string) Envelope {
+	room := uuid.New()
+	return Envelope{
+		ID:     id,
+		Origin: "a",
+		Users:  []string{"alice@example.com"},
+		Event:  chatservice.Event{Type: chatservice.EventMessage, RoomID: &room},
+	}
+}
+
+func presence(id, origin string, online bool) Envelope {
+	return Envelope{
+		ID:     id,
+		Origin: origin,
+		Users:  []string{"bob@example.com"},
+		Event:  chatservice.Event{Type: chatservice.EventPresence, Member: "alice@example.com", Online: &online},
+	}
+}
+
+func TestFanoutDedup(t *testing.T) {
+	local := &recorder{}
+	f := NewFanout(local, 3)
+	ctx := context.Background()
+
+	f.Handle(ctx, message("1"))
+	f.Handle(ctx, message("2"))
+	f.Handle(ctx, message("1"))
+	if len(local.events) != 2 {
+		t.Fatalf("%d events delivered, want the redelivered one dropped", len(local.events))
+	}
+
+	// 1 is forgotten once the window has moved past it
+	for i := 3; i <= 5; i++ {
+		f.Handle(ctx, message(strconv.Itoa(i)))
+	}
+	f.Handle(ctx, message("1"))
+	f.Handle(ctx, message("5"))
+	if len(local.events) != 6 {
+		t.Errorf("%d events delivered, want 6", len(local.events))
+	}
+}
+
+func TestFanoutPresence(t *testing.T) {
+	tests := []struct {
+		env  Envelope
+		want bool
+	}{
+		{presence("1", "a", true), true},
+		// already online through a
+		{presence("2", "b", true), false},
+		// still online through b
+		{presence("3", "a", false), false},
+		{presence("4", "b", false), true},
+		// a replica that never said online
+		{presence("5", "c", false), false},
+		{presence("6", "c", true), true},
+	}
+	local := &recorder{}
+	f := NewFanout(local, 0)
+	for i, tt := range tests {
+		before := len(local.events)
+		f.Handle(context.Background(), tt.env)
+		if got := len(local.events) > before; got != tt.want {
+			t.Errorf("%d: %s online=%v delivered %v, want %v", i, tt.env.Origin, *tt.env.Event.Online, got, tt.want)
+		}
+	}
+}
+
+func TestNotifierMemory(t *testing.T) {
+	m := NewMemory()
+	local := &recorder{}
+	f := NewFanout(local, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribed := make(chan error)
+	go func() { subscribed <- m.Subscribe(ctx, f.Handle) }()
+	// Subscribe registers before it blocks, wait for it
+	for {
+		m.mu.Lock()
+		n := len(m.subs)
+		m.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		runtime.Gosched()
+	}
+
+	NewNotifier(m, "a", log.NewNopLogger()).Notify(ctx, []string{"alice@example.com"}, chatservice.Event{Type: chatservice.EventTyping})
+	if len(local.events) != 1 || local.events[0].Type != chatservice.EventTyping {
+		t.Errorf("delivered %+v, want the typing event", local.events)
+	}
+	cancel()
+	<-subscribed
+	m.Close()
+	if err := m.Publish(context.Background(), message("1")); err != ErrClosed {
+		t.Errorf("Publish after Close = %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,119 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-kit/log"
+)
+
+// messageIDHeader carries the envelope id, like the message-id header of
+// the other services' topics.
+const messageIDHeader = "message-id"
+
+// Kafka shares envelopes between replicas through a topic. Envelopes are
+// keyed by Envelope.Key, so those of a room land on one partition and keep
+// their order.
+//
+// Every replica reads the whole topic in a consumer group of its own,
+// starting at the latest offset: events are only of interest to the
+// connections open when they are published.
+type Kafka struct {
+	producer *kafka.Producer
+	consumer *kafka.Consumer
+	topic    string
+	logger   log.Logger
+}
+
+// NewKafka connects to brokers as the replica named replica.
+func NewKafka(brokers, topic, replica string, logger log.Logger) (*Kafka, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": brokers,
+		// events are stale after a few seconds, do not hold them back
+		"linger.ms":          5,
+		"message.timeout.ms": 10000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":        brokers,
+		"group.id":                 "chat-" + replica,
+		"auto.offset.reset":        "latest",
+		"enable.auto.commit":       false,
+		"allow.auto.create.topics": true,
+	})
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	if err := c.Subscribe(topic, nil); err != nil {
+		p.Close()
+		c.Close()
+		return nil, err
+	}
+
+	k := &Kafka{
+		producer: p,
+		consumer: c,
+		topic:    topic,
+		logger:   logger,
+	}
+	go k.reportDeliveries()
+	return k, nil
+}
+
+func (k *Kafka) Publish(_ context.Context, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return k.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &k.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(env.Key()),
+		Value:          data,
+		Headers: []kafka.Header{
+			{Key: messageIDHeader, Value: []byte(env.ID)},
+		},
+	}, nil)
+}
+
+func (k *Kafka) Subscribe(ctx context.Context, handle func(ctx context.Context, env Envelope)) error {
+	for ctx.Err() == nil {
+		msg, err := k.consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			var kerr kafka.Error
+			if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			k.logger.Log("during", "read", "err", err)
+			continue
+		}
+		var env Envelope
+		if err := json.Unmarshal(msg.Value, &env); err != nil {
+			k.logger.Log("during", "decode", "offset", msg.TopicPartition.Offset, "err", err)
+			continue
+		}
+		handle(ctx, env)
+	}
+	return ctx.Err()
+}
+
+func (k *Kafka) Close() error {
+	k.producer.Flush(5000)
+	k.producer.Close()
+	return k.consumer.Close()
+}
+
+// reportDeliveries logs the envelopes that could not be published. It
+// returns once the producer is closed.
+func (k *Kafka) reportDeliveries() {
+	for e := range k.producer.Events() {
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			k.logger.Log("during", "publish", "err", m.TopicPartition.Error)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("bus closed")
+
+// Memory is a bus within a single process, for running one replica and
+// for tests. Publish delivers to every subscriber before returning, so
+// envelopes arrive in the order they were published.
+type Memory struct {
+	mu     sync.Mutex
+	subs   map[*memorySub]struct{}
+	closed bool
+}
+
+type memorySub struct {
+	ctx    context.Context
+	handle func(ctx context.Context, env Envelope)
+}
+
+func NewMemory() *Memory {
+	return &Memory{subs: make(map[*memorySub]struct{})}
+}
+
+func (m *Memory) Publish(_ context.Context, env Envelope) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for sub := range m.subs {
+		sub.handle(sub.ctx, env)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, handle func(ctx context.Context, env Envelope)) error {
+	sub := &memorySub{ctx: ctx, handle: handle}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	m.subs[sub] = struct{}{}
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.mu.Lock()
+	delete(m.subs, sub)
+	m.mu.Unlock()
+	return ctx.Err()
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,36 @@
+package chatendpoint
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmojiRunes bounds reactions, enough for the longest sequences such as
+// flags and families joined with zero width joiners.
+const maxEmojiRunes = 10
+
+// isEmoji reports whether s looks like a single emoji. It only checks that
+// s is a short run of symbols and emoji modifiers, not that it renders as
+// one glyph.
+func isEmoji(s string) bool {
+	if utf8.RuneCountInString(s) > maxEmojiRunes {
+		return false
+	}
+	symbols := 0
+	for _, r := range s {
+		switch {
+		case r == '\u200d', r == '\ufe0f', r == '\u20e3':
+			// zero width joiner, emoji presentation, keycap
+		case r >= 0x1f3fb && r <= 0x1f3ff, r >= 0xe0020 && r <= 0xe007f:
+			// skin tones, tags of subdivision flags
+		case r == '#', r == '*', r >= '0' && r <= '9':
+			// keycaps start with these
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Regional_Indicator, r):
+			symbols++
+		default:
+			return false
+		}
+	}
+	return symbols > 0 || strings.ContainsRune(s, '\u20e3')
+}
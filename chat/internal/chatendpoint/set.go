@@ -0,0 +1,995 @@
+package chatendpoint
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/auth"
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+	"github.com/F1zm0n/universal-chat/internal/repository"
+	"github.com/F1zm0n/universal-chat/internal/webhook"
+	"github.com/F1zm0n/universal-kit/validate"
+)
+
+// Every endpoint acts on behalf of the user auth put into the context.
+type Set struct {
+	CreateRoomEndpoint  endpoint.Endpoint
+	ListRoomsEndpoint   endpoint.Endpoint
+	JoinRoomEndpoint    endpoint.Endpoint
+	LeaveRoomEndpoint   endpoint.Endpoint
+	OpenDirectEndpoint  endpoint.Endpoint
+	SendMessageEndpoint endpoint.Endpoint
+	HistoryEndpoint     endpoint.Endpoint
+	TypingEndpoint      endpoint.Endpoint
+	MarkReadEndpoint    endpoint.Endpoint
+	UnreadEndpoint      endpoint.Endpoint
+	SyncEndpoint        endpoint.Endpoint
+
+	EditMessageEndpoint   endpoint.Endpoint
+	DeleteMessageEndpoint endpoint.Endpoint
+	MessageEditsEndpoint  endpoint.Endpoint
+	ReactEndpoint         endpoint.Endpoint
+	UnreactEndpoint       endpoint.Endpoint
+	ThreadEndpoint        endpoint.Endpoint
+	SearchEndpoint        endpoint.Endpoint
+
+	RequestUploadEndpoint endpoint.Endpoint
+	UploadEndpoint        endpoint.Endpoint
+	DownloadEndpoint      endpoint.Endpoint
+
+	MembersEndpoint  endpoint.Endpoint
+	SetRoleEndpoint  endpoint.Endpoint
+	MuteEndpoint     endpoint.Endpoint
+	UnmuteEndpoint   endpoint.Endpoint
+	KickEndpoint     endpoint.Endpoint
+	BanEndpoint      endpoint.Endpoint
+	UnbanEndpoint    endpoint.Endpoint
+	AuditLogEndpoint endpoint.Endpoint
+	BlocksEndpoint   endpoint.Endpoint
+	BlockEndpoint    endpoint.Endpoint
+	UnblockEndpoint  endpoint.Endpoint
+
+	CreateBotEndpoint     endpoint.Endpoint
+	BotsEndpoint          endpoint.Endpoint
+	DeleteBotEndpoint     endpoint.Endpoint
+	AddBotEndpoint        endpoint.Endpoint
+	CreateWebhookEndpoint endpoint.Endpoint
+	WebhooksEndpoint      endpoint.Endpoint
+	DeleteWebhookEndpoint endpoint.Endpoint
+	// PostWebhookEndpoint is authenticated by the webhook's token rather
+	// than a user.
+	PostWebhookEndpoint endpoint.Endpoint
+}
+
+const (
+	// MaxBodyLength bounds the length of messages, in characters.
+	MaxBodyLength = 4000
+	// MaxAttachments bounds the attachments of a message.
+	MaxAttachments = 10
+	// MaxReasonLength bounds the reasons given for moderation actions, in
+	// characters.
+	MaxReasonLength = 500
+)
+
+func New(svc chatservice.Service, logger log.Logger) Set {
+	// wrap adds the middlewares every endpoint shares. Authentication runs
+	// before validation, so anonymous requests are told to authenticate
+	// rather than what is wrong with them.
+	wrap := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		e = validate.Middleware()(e)
+		e = auth.Middleware()(e)
+		e = LoggingMiddleware(log.With(logger, "method", method))(e)
+		return e
+	}
+	// public is wrap for endpoints checking credentials of their own
+	public := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		e = validate.Middleware()(e)
+		e = LoggingMiddleware(log.With(logger, "method", method))(e)
+		return e
+	}
+	return Set{
+		CreateRoomEndpoint:  wrap("create_room", makeCreateRoomEndpoint(svc)),
+		ListRoomsEndpoint:   wrap("list_rooms", makeListRoomsEndpoint(svc)),
+		JoinRoomEndpoint:    wrap("join_room", makeJoinRoomEndpoint(svc)),
+		LeaveRoomEndpoint:   wrap("leave_room", makeLeaveRoomEndpoint(svc)),
+		OpenDirectEndpoint:  wrap("open_direct", makeOpenDirectEndpoint(svc)),
+		SendMessageEndpoint: wrap("send_message", makeSendMessageEndpoint(svc)),
+		HistoryEndpoint:     wrap("history", makeHistoryEndpoint(svc)),
+		TypingEndpoint:      wrap("typing", makeTypingEndpoint(svc)),
+		MarkReadEndpoint:    wrap("mark_read", makeMarkReadEndpoint(svc)),
+		UnreadEndpoint:      wrap("unread", makeUnreadEndpoint(svc)),
+		SyncEndpoint:        wrap("sync", makeSyncEndpoint(svc)),
+
+		EditMessageEndpoint:   wrap("edit_message", makeEditMessageEndpoint(svc)),
+		DeleteMessageEndpoint: wrap("delete_message", makeDeleteMessageEndpoint(svc)),
+		MessageEditsEndpoint:  wrap("message_edits", makeMessageEditsEndpoint(svc)),
+		ReactEndpoint:         wrap("react", makeReactEndpoint(svc)),
+		UnreactEndpoint:       wrap("unreact", makeUnreactEndpoint(svc)),
+		ThreadEndpoint:        wrap("thread", makeThreadEndpoint(svc)),
+		SearchEndpoint:        wrap("search", makeSearchEndpoint(svc)),
+
+		RequestUploadEndpoint: wrap("request_upload", makeRequestUploadEndpoint(svc)),
+		UploadEndpoint:        wrap("upload", makeUploadEndpoint(svc)),
+		DownloadEndpoint:      wrap("download", makeDownloadEndpoint(svc)),
+
+		MembersEndpoint:  wrap("members", makeMembersEndpoint(svc)),
+		SetRoleEndpoint:  wrap("set_role", makeSetRoleEndpoint(svc)),
+		MuteEndpoint:     wrap("mute", makeMuteEndpoint(svc)),
+		UnmuteEndpoint:   wrap("unmute", makeUnmuteEndpoint(svc)),
+		KickEndpoint:     wrap("kick", makeKickEndpoint(svc)),
+		BanEndpoint:      wrap("ban", makeBanEndpoint(svc)),
+		UnbanEndpoint:    wrap("unban", makeUnbanEndpoint(svc)),
+		AuditLogEndpoint: wrap("audit_log", makeAuditLogEndpoint(svc)),
+		BlocksEndpoint:   wrap("blocks", makeBlocksEndpoint(svc)),
+		BlockEndpoint:    wrap("block", makeBlockEndpoint(svc)),
+		UnblockEndpoint:  wrap("unblock", makeUnblockEndpoint(svc)),
+
+		CreateBotEndpoint:     wrap("create_bot", makeCreateBotEndpoint(svc)),
+		BotsEndpoint:          wrap("bots", makeBotsEndpoint(svc)),
+		DeleteBotEndpoint:     wrap("delete_bot", makeDeleteBotEndpoint(svc)),
+		AddBotEndpoint:        wrap("add_bot", makeAddBotEndpoint(svc)),
+		CreateWebhookEndpoint: wrap("create_webhook", makeCreateWebhookEndpoint(svc)),
+		WebhooksEndpoint:      wrap("webhooks", makeWebhooksEndpoint(svc)),
+		DeleteWebhookEndpoint: wrap("delete_webhook", makeDeleteWebhookEndpoint(svc)),
+		PostWebhookEndpoint:   public("post_webhook", makePostWebhookEndpoint(svc)),
+	}
+}
+
+type CreateRoomRequest struct {
+	Name string `json:"name"`
+}
+
+func (r CreateRoomRequest) Validate(v *validate.Validator) {
+	v.Required("name", r.Name)
+	v.MaxLen("name", r.Name, 100)
+}
+
+type RoomResponse struct {
+	Room repository.Room `json:"room"`
+	Err  error           `json:"-"`
+}
+
+type ListRoomsRequest struct{}
+
+type ListRoomsResponse struct {
+	Rooms []repository.Room `json:"rooms"`
+	Err   error             `json:"-"`
+}
+
+type RoomRequest struct {
+	RoomID uuid.UUID `json:"-"`
+}
+
+func (r RoomRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+}
+
+type LeaveRoomResponse struct {
+	Err error `json:"-"`
+}
+
+type OpenDirectRequest struct {
+	// Email is the user to talk to.
+	Email string `json:"email"`
+}
+
+func (r OpenDirectRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+}
+
+type SendMessageRequest struct {
+	RoomID uuid.UUID `json:"room_id"`
+	// ParentID is the message replied to, if any.
+	ParentID int64  `json:"parent_id,omitempty"`
+	Body     string `json:"body"`
+	// AttachmentIDs are uploaded attachments sent with the message, which
+	// may then have no body.
+	AttachmentIDs []uuid.UUID `json:"attachment_ids,omitempty"`
+}
+
+func (r SendMessageRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.ParentID >= 0, "parent_id", "must be a message id")
+	if len(r.AttachmentIDs) == 0 {
+		v.Required("body", r.Body)
+	}
+	v.MaxLen("body", r.Body, MaxBodyLength)
+	v.Check(len(r.AttachmentIDs) <= MaxAttachments, "attachment_ids", "must be at most 10")
+	seen := make(map[uuid.UUID]bool, len(r.AttachmentIDs))
+	for _, id := range r.AttachmentIDs {
+		v.Check(id != uuid.Nil && !seen[id], "attachment_ids", "must be distinct attachment ids")
+		seen[id] = true
+	}
+}
+
+type SendMessageResponse struct {
+	Message repository.Message `json:"message"`
+	Err     error              `json:"-"`
+}
+
+type HistoryRequest struct {
+	RoomID uuid.UUID
+	Cursor string
+	Limit  int
+}
+
+func (r HistoryRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.Limit >= 0 && r.Limit <= chatservice.MaxPageSize, "limit", "must be between 1 and 100")
+}
+
+type HistoryResponse struct {
+	chatservice.Page
+	Err error `json:"-"`
+}
+
+type TypingRequest struct {
+	RoomID uuid.UUID `json:"room_id"`
+}
+
+func (r TypingRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+}
+
+type TypingResponse struct {
+	Err error `json:"-"`
+}
+
+type MarkReadRequest struct {
+	RoomID    uuid.UUID `json:"room_id"`
+	MessageID int64     `json:"message_id"`
+}
+
+func (r MarkReadRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.MessageID > 0, "message_id", "must be a message id")
+}
+
+type MarkReadResponse struct {
+	Err error `json:"-"`
+}
+
+type UnreadRequest struct{}
+
+type UnreadResponse struct {
+	Rooms []repository.Unread `json:"rooms"`
+	// Total sums the unread messages of all rooms.
+	Total int   `json:"total"`
+	Err   error `json:"-"`
+}
+
+type SyncRequest struct {
+	// Since is the id of the newest message the client has.
+	Since int64 `json:"since"`
+	Limit int   `json:"limit,omitempty"`
+}
+
+func (r SyncRequest) Validate(v *validate.Validator) {
+	v.Check(r.Since >= 0, "since", "must be a message id")
+	v.Check(r.Limit >= 0 && r.Limit <= chatservice.MaxPageSize, "limit", "must be between 1 and 100")
+}
+
+type SyncResponse struct {
+	chatservice.SyncPage
+	Err error `json:"-"`
+}
+
+type MessageRequest struct {
+	RoomID    uuid.UUID `json:"room_id"`
+	MessageID int64     `json:"message_id"`
+}
+
+func (r MessageRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.MessageID > 0, "message_id", "must be a message id")
+}
+
+type EditMessageRequest struct {
+	MessageRequest
+	Body string `json:"body"`
+}
+
+func (r EditMessageRequest) Validate(v *validate.Validator) {
+	r.MessageRequest.Validate(v)
+	v.Required("body", r.Body)
+	v.MaxLen("body", r.Body, MaxBodyLength)
+}
+
+type DeleteMessageResponse struct {
+	Err error `json:"-"`
+}
+
+type MessageEditsResponse struct {
+	Edits []repository.Edit `json:"edits"`
+	Err   error             `json:"-"`
+}
+
+type ReactionRequest struct {
+	MessageRequest
+	Emoji string `json:"emoji"`
+}
+
+func (r ReactionRequest) Validate(v *validate.Validator) {
+	r.MessageRequest.Validate(v)
+	v.Check(isEmoji(r.Emoji), "emoji", "must be a single emoji")
+}
+
+type ReactionsResponse struct {
+	Reactions []repository.Reaction `json:"reactions"`
+	Err       error                 `json:"-"`
+}
+
+type ThreadRequest struct {
+	RoomID   uuid.UUID
+	ParentID int64
+	Cursor   string
+	Limit    int
+}
+
+func (r ThreadRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.ParentID > 0, "message_id", "must be a message id")
+	v.Check(r.Limit >= 0 && r.Limit <= chatservice.MaxPageSize, "limit", "must be between 1 and 100")
+}
+
+// MaxQueryLength bounds search queries, in characters.
+const MaxQueryLength = 256
+
+type SearchRequest struct {
+	Query string
+	chatservice.SearchFilter
+	Cursor string
+	Limit  int
+}
+
+func (r SearchRequest) Validate(v *validate.Validator) {
+	v.Required("q", r.Query)
+	v.MaxLen("q", r.Query, MaxQueryLength)
+	if r.Sender != "" {
+		v.Email("sender", r.Sender)
+	}
+	v.Check(r.From.IsZero() || r.To.IsZero() || r.From.Before(r.To), "to", "must be after from")
+	v.Check(r.Limit >= 0 && r.Limit <= chatservice.MaxPageSize, "limit", "must be between 1 and 100")
+}
+
+type SearchResponse struct {
+	chatservice.SearchPage
+	Err error `json:"-"`
+}
+
+type RequestUploadRequest struct {
+	RoomID      uuid.UUID `json:"-"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+}
+
+func (r RequestUploadRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Required("name", r.Name)
+	v.MaxLen("name", r.Name, 255)
+	v.Required("content_type", r.ContentType)
+	v.MaxLen("content_type", r.ContentType, 100)
+	v.Check(r.Size > 0, "size", "must be positive")
+}
+
+type RequestUploadResponse struct {
+	chatservice.UploadSlot
+	Err error `json:"-"`
+}
+
+// UploadRequest carries the content of an attachment, read by the service
+// as it is stored.
+type UploadRequest struct {
+	AttachmentID uuid.UUID
+	Content      io.Reader
+}
+
+func (r UploadRequest) Validate(v *validate.Validator) {
+	v.UUID("attachment_id", r.AttachmentID)
+}
+
+type AttachmentResponse struct {
+	Attachment repository.Attachment `json:"attachment"`
+	Err        error                 `json:"-"`
+}
+
+type DownloadRequest struct {
+	AttachmentID uuid.UUID
+	// Thumbnail asks for the thumbnail of an image.
+	Thumbnail bool
+}
+
+func (r DownloadRequest) Validate(v *validate.Validator) {
+	v.UUID("attachment_id", r.AttachmentID)
+}
+
+// DownloadResponse is streamed by the transport, which closes the blob.
+type DownloadResponse struct {
+	Blob chatservice.Blob
+	Err  error
+}
+
+type MembersResponse struct {
+	Members []repository.Member `json:"members"`
+	Err     error               `json:"-"`
+}
+
+// MemberRequest names a member of a room, or for bans any user.
+type MemberRequest struct {
+	RoomID uuid.UUID `json:"-"`
+	Member string    `json:"-"`
+}
+
+func (r MemberRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Email("member", r.Member)
+}
+
+type SetRoleRequest struct {
+	MemberRequest
+	Role string `json:"role"`
+}
+
+func (r SetRoleRequest) Validate(v *validate.Validator) {
+	r.MemberRequest.Validate(v)
+	switch r.Role {
+	case repository.RoleOwner, repository.RoleModerator, repository.RoleMember:
+	default:
+		v.Check(false, "role", "must be owner, moderator or member")
+	}
+}
+
+// ModerateRequest is a kick or ban of a member.
+type ModerateRequest struct {
+	MemberRequest
+	Reason string `json:"reason,omitempty"`
+}
+
+func (r ModerateRequest) Validate(v *validate.Validator) {
+	r.MemberRequest.Validate(v)
+	v.MaxLen("reason", r.Reason, MaxReasonLength)
+}
+
+type MuteRequest struct {
+	ModerateRequest
+	// Duration is how long the member is muted, e.g. 1h30m.
+	Duration string `json:"duration"`
+}
+
+func (r MuteRequest) Validate(v *validate.Validator) {
+	r.ModerateRequest.Validate(v)
+	d, err := time.ParseDuration(r.Duration)
+	v.Check(err == nil && d > 0 && d <= chatservice.MaxMute, "duration", "must be a duration of up to a year, e.g. 1h30m")
+}
+
+type ModerateResponse struct {
+	Err error `json:"-"`
+}
+
+type AuditLogRequest struct {
+	RoomID uuid.UUID
+	Cursor string
+	Limit  int
+}
+
+func (r AuditLogRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Check(r.Limit >= 0 && r.Limit <= chatservice.MaxPageSize, "limit", "must be between 1 and 100")
+}
+
+type AuditLogResponse struct {
+	chatservice.AuditPage
+	Err error `json:"-"`
+}
+
+type BlocksRequest struct{}
+
+type BlocksResponse struct {
+	Blocks []repository.Block `json:"blocks"`
+	Err    error              `json:"-"`
+}
+
+type BlockRequest struct {
+	// Email is the user to block or unblock.
+	Email string `json:"-"`
+}
+
+func (r BlockRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+}
+
+var (
+	botNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,31}$`)
+	commandRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+)
+
+// MaxCommands bounds the slash commands of a bot.
+const MaxCommands = 20
+
+type CreateBotRequest struct {
+	Name string `json:"name"`
+	// WebhookURL is called with the bot's commands and mentions, bots
+	// without one only post.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Commands are the slash commands of the bot, without their slash.
+	Commands []string `json:"commands,omitempty"`
+}
+
+func (r CreateBotRequest) Validate(v *validate.Validator) {
+	v.Check(botNameRe.MatchString(r.Name), "name", "must be 2 to 32 lower case letters, digits, - or _")
+	if r.WebhookURL != "" {
+		v.Check(webhook.CheckURL(r.WebhookURL) == nil, "webhook_url", "must be an https url of a public host")
+		v.MaxLen("webhook_url", r.WebhookURL, 2048)
+	}
+	v.Check(len(r.Commands) <= MaxCommands, "commands", "must be at most 20")
+	seen := make(map[string]bool, len(r.Commands))
+	for _, c := range r.Commands {
+		v.Check(commandRe.MatchString(c) && !seen[c], "commands",
+			"must be distinct lower case letters, digits, - or _, without a slash")
+		seen[c] = true
+	}
+}
+
+type BotResponse struct {
+	chatservice.BotCredentials
+	Err error `json:"-"`
+}
+
+type BotsRequest struct{}
+
+type BotsResponse struct {
+	Bots []repository.Bot `json:"bots"`
+	Err  error            `json:"-"`
+}
+
+type BotRequest struct {
+	BotID uuid.UUID
+}
+
+func (r BotRequest) Validate(v *validate.Validator) {
+	v.UUID("bot_id", r.BotID)
+}
+
+type AddBotRequest struct {
+	RoomID uuid.UUID
+	BotID  uuid.UUID
+}
+
+func (r AddBotRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.UUID("bot_id", r.BotID)
+}
+
+type CreateWebhookRequest struct {
+	RoomID uuid.UUID `json:"-"`
+	Name   string    `json:"name"`
+}
+
+func (r CreateWebhookRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.Required("name", r.Name)
+	v.MaxLen("name", r.Name, 100)
+}
+
+type WebhookResponse struct {
+	chatservice.WebhookCredentials
+	Err error `json:"-"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []repository.Webhook `json:"webhooks"`
+	Err      error                `json:"-"`
+}
+
+type WebhookRequest struct {
+	RoomID    uuid.UUID
+	WebhookID uuid.UUID
+}
+
+func (r WebhookRequest) Validate(v *validate.Validator) {
+	v.UUID("room_id", r.RoomID)
+	v.UUID("webhook_id", r.WebhookID)
+}
+
+type PostWebhookRequest struct {
+	WebhookID uuid.UUID `json:"-"`
+	Token     string    `json:"-"`
+	Text      string    `json:"text"`
+}
+
+func (r PostWebhookRequest) Validate(v *validate.Validator) {
+	v.UUID("webhook_id", r.WebhookID)
+	v.Required("text", r.Text)
+	v.MaxLen("text", r.Text, MaxBodyLength)
+}
+
+type IntegrationResponse struct {
+	Err error `json:"-"`
+}
+
+var (
+	_ endpoint.Failer = RoomResponse{}
+	_ endpoint.Failer = ListRoomsResponse{}
+	_ endpoint.Failer = LeaveRoomResponse{}
+	_ endpoint.Failer = SendMessageResponse{}
+	_ endpoint.Failer = HistoryResponse{}
+	_ endpoint.Failer = TypingResponse{}
+	_ endpoint.Failer = MarkReadResponse{}
+	_ endpoint.Failer = UnreadResponse{}
+	_ endpoint.Failer = SyncResponse{}
+	_ endpoint.Failer = DeleteMessageResponse{}
+	_ endpoint.Failer = MessageEditsResponse{}
+	_ endpoint.Failer = ReactionsResponse{}
+	_ endpoint.Failer = SearchResponse{}
+	_ endpoint.Failer = RequestUploadResponse{}
+	_ endpoint.Failer = AttachmentResponse{}
+	_ endpoint.Failer = DownloadResponse{}
+	_ endpoint.Failer = MembersResponse{}
+	_ endpoint.Failer = ModerateResponse{}
+	_ endpoint.Failer = AuditLogResponse{}
+	_ endpoint.Failer = BlocksResponse{}
+	_ endpoint.Failer = BotResponse{}
+	_ endpoint.Failer = BotsResponse{}
+	_ endpoint.Failer = WebhookResponse{}
+	_ endpoint.Failer = WebhooksResponse{}
+	_ endpoint.Failer = IntegrationResponse{}
+)
+
+func makeCreateRoomEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateRoomRequest)
+		room, err := s.CreateRoom(ctx, auth.User(ctx), req.Name)
+		return RoomResponse{Room: room, Err: err}, nil
+	}
+}
+
+func makeListRoomsEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		rooms, err := s.ListRooms(ctx, auth.User(ctx))
+		return ListRoomsResponse{Rooms: rooms, Err: err}, nil
+	}
+}
+
+func makeJoinRoomEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RoomRequest)
+		room, err := s.JoinRoom(ctx, auth.User(ctx), req.RoomID)
+		return RoomResponse{Room: room, Err: err}, nil
+	}
+}
+
+func makeLeaveRoomEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RoomRequest)
+		err := s.LeaveRoom(ctx, auth.User(ctx), req.RoomID)
+		return LeaveRoomResponse{Err: err}, nil
+	}
+}
+
+func makeOpenDirectEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(OpenDirectRequest)
+		room, err := s.OpenDirect(ctx, auth.User(ctx), req.Email)
+		return RoomResponse{Room: room, Err: err}, nil
+	}
+}
+
+func makeSendMessageEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SendMessageRequest)
+		msg, err := s.SendMessage(ctx, auth.User(ctx), req.RoomID, req.ParentID, req.Body, req.AttachmentIDs)
+		return SendMessageResponse{Message: msg, Err: err}, nil
+	}
+}
+
+func makeHistoryEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(HistoryRequest)
+		page, err := s.History(ctx, auth.User(ctx), req.RoomID, req.Cursor, req.Limit)
+		return HistoryResponse{Page: page, Err: err}, nil
+	}
+}
+
+func makeTypingEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TypingRequest)
+		err := s.Typing(ctx, auth.User(ctx), req.RoomID)
+		return TypingResponse{Err: err}, nil
+	}
+}
+
+func makeMarkReadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MarkReadRequest)
+		err := s.MarkRead(ctx, auth.User(ctx), req.RoomID, req.MessageID)
+		return MarkReadResponse{Err: err}, nil
+	}
+}
+
+func makeUnreadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		rooms, err := s.Unread(ctx, auth.User(ctx))
+		resp := UnreadResponse{Rooms: rooms, Err: err}
+		for _, room := range rooms {
+			resp.Total += room.Count
+		}
+		return resp, nil
+	}
+}
+
+func makeSyncEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SyncRequest)
+		page, err := s.Sync(ctx, auth.User(ctx), req.Since, req.Limit)
+		return SyncResponse{SyncPage: page, Err: err}, nil
+	}
+}
+
+func makeEditMessageEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(EditMessageRequest)
+		msg, err := s.EditMessage(ctx, auth.User(ctx), req.RoomID, req.MessageID, req.Body)
+		return SendMessageResponse{Message: msg, Err: err}, nil
+	}
+}
+
+func makeDeleteMessageEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MessageRequest)
+		err := s.DeleteMessage(ctx, auth.User(ctx), req.RoomID, req.MessageID)
+		return DeleteMessageResponse{Err: err}, nil
+	}
+}
+
+func makeMessageEditsEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MessageRequest)
+		edits, err := s.MessageEdits(ctx, auth.User(ctx), req.RoomID, req.MessageID)
+		return MessageEditsResponse{Edits: edits, Err: err}, nil
+	}
+}
+
+func makeReactEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ReactionRequest)
+		reactions, err := s.React(ctx, auth.User(ctx), req.RoomID, req.MessageID, req.Emoji)
+		return ReactionsResponse{Reactions: reactions, Err: err}, nil
+	}
+}
+
+func makeUnreactEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ReactionRequest)
+		reactions, err := s.Unreact(ctx, auth.User(ctx), req.RoomID, req.MessageID, req.Emoji)
+		return ReactionsResponse{Reactions: reactions, Err: err}, nil
+	}
+}
+
+func makeThreadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ThreadRequest)
+		page, err := s.Thread(ctx, auth.User(ctx), req.RoomID, req.ParentID, req.Cursor, req.Limit)
+		return HistoryResponse{Page: page, Err: err}, nil
+	}
+}
+
+func makeSearchEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SearchRequest)
+		page, err := s.Search(ctx, auth.User(ctx), req.Query, req.SearchFilter, req.Cursor, req.Limit)
+		return SearchResponse{SearchPage: page, Err: err}, nil
+	}
+}
+
+func makeRequestUploadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RequestUploadRequest)
+		slot, err := s.RequestUpload(ctx, auth.User(ctx), req.RoomID, req.Name, req.ContentType, req.Size)
+		return RequestUploadResponse{UploadSlot: slot, Err: err}, nil
+	}
+}
+
+func makeUploadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UploadRequest)
+		a, err := s.Upload(ctx, auth.User(ctx), req.AttachmentID, req.Content)
+		return AttachmentResponse{Attachment: a, Err: err}, nil
+	}
+}
+
+func makeDownloadEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DownloadRequest)
+		blob, err := s.OpenAttachment(ctx, auth.User(ctx), req.AttachmentID, req.Thumbnail)
+		return DownloadResponse{Blob: blob, Err: err}, nil
+	}
+}
+
+func makeMembersEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RoomRequest)
+		members, err := s.Members(ctx, auth.User(ctx), req.RoomID)
+		return MembersResponse{Members: members, Err: err}, nil
+	}
+}
+
+func makeSetRoleEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SetRoleRequest)
+		err := s.SetRole(ctx, auth.User(ctx), req.RoomID, req.Member, req.Role)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeMuteEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MuteRequest)
+		// checked by Validate
+		d, _ := time.ParseDuration(req.Duration)
+		err := s.Mute(ctx, auth.User(ctx), req.RoomID, req.Member, d, req.Reason)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeUnmuteEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MemberRequest)
+		err := s.Unmute(ctx, auth.User(ctx), req.RoomID, req.Member)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeKickEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ModerateRequest)
+		err := s.Kick(ctx, auth.User(ctx), req.RoomID, req.Member, req.Reason)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeBanEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ModerateRequest)
+		err := s.Ban(ctx, auth.User(ctx), req.RoomID, req.Member, req.Reason)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeUnbanEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MemberRequest)
+		err := s.Unban(ctx, auth.User(ctx), req.RoomID, req.Member)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeAuditLogEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuditLogRequest)
+		page, err := s.AuditLog(ctx, auth.User(ctx), req.RoomID, req.Cursor, req.Limit)
+		return AuditLogResponse{AuditPage: page, Err: err}, nil
+	}
+}
+
+func makeBlocksEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		blocks, err := s.Blocks(ctx, auth.User(ctx))
+		return BlocksResponse{Blocks: blocks, Err: err}, nil
+	}
+}
+
+func makeBlockEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BlockRequest)
+		err := s.Block(ctx, auth.User(ctx), req.Email)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeUnblockEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BlockRequest)
+		err := s.Unblock(ctx, auth.User(ctx), req.Email)
+		return ModerateResponse{Err: err}, nil
+	}
+}
+
+func makeCreateBotEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateBotRequest)
+		creds, err := s.CreateBot(ctx, auth.User(ctx), req.Name, req.WebhookURL, req.Commands)
+		return BotResponse{BotCredentials: creds, Err: err}, nil
+	}
+}
+
+func makeBotsEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		bots, err := s.Bots(ctx, auth.User(ctx))
+		return BotsResponse{Bots: bots, Err: err}, nil
+	}
+}
+
+func makeDeleteBotEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BotRequest)
+		err := s.DeleteBot(ctx, auth.User(ctx), req.BotID)
+		return IntegrationResponse{Err: err}, nil
+	}
+}
+
+func makeAddBotEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AddBotRequest)
+		err := s.AddBot(ctx, auth.User(ctx), req.RoomID, req.BotID)
+		return IntegrationResponse{Err: err}, nil
+	}
+}
+
+func makeCreateWebhookEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateWebhookRequest)
+		creds, err := s.CreateWebhook(ctx, auth.User(ctx), req.RoomID, req.Name)
+		return WebhookResponse{WebhookCredentials: creds, Err: err}, nil
+	}
+}
+
+func makeWebhooksEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RoomRequest)
+		webhooks, err := s.Webhooks(ctx, auth.User(ctx), req.RoomID)
+		return WebhooksResponse{Webhooks: webhooks, Err: err}, nil
+	}
+}
+
+func makeDeleteWebhookEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(WebhookRequest)
+		err := s.DeleteWebhook(ctx, auth.User(ctx), req.RoomID, req.WebhookID)
+		return IntegrationResponse{Err: err}, nil
+	}
+}
+
+func makePostWebhookEndpoint(s chatservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PostWebhookRequest)
+		msg, err := s.PostWebhook(ctx, req.WebhookID, req.Token, req.Text)
+		return SendMessageResponse{Message: msg, Err: err}, nil
+	}
+}
+
+func (r RoomResponse) Failed() error        { return r.Err }
+func (r ListRoomsResponse) Failed() error   { return r.Err }
+func (r LeaveRoomResponse) Failed() error   { return r.Err }
+func (r SendMessageResponse) Failed() error { return r.Err }
+func (r HistoryResponse) Failed() error     { return r.Err }
+func (r TypingResponse) Failed() error      { return r.Err }
+func (r MarkReadResponse) Failed() error    { return r.Err }
+func (r UnreadResponse) Failed() error      { return r.Err }
+func (r SyncResponse) Failed() error        { return r.Err }
+
+func (r DeleteMessageResponse) Failed() error { return r.Err }
+func (r MessageEditsResponse) Failed() error  { return r.Err }
+func (r ReactionsResponse) Failed() error     { return r.Err }
+
+func (r SearchResponse) Failed() error        { return r.Err }
+func (r RequestUploadResponse) Failed() error { return r.Err }
+func (r AttachmentResponse) Failed() error    { return r.Err }
+func (r DownloadResponse) Failed() error      { return r.Err }
+
+func (r MembersResponse) Failed() error  { return r.Err }
+func (r ModerateResponse) Failed() error { return r.Err }
+func (r AuditLogResponse) Failed() error { return r.Err }
+func (r BlocksResponse) Failed() error   { return r.Err }
+
+func (r BotResponse) Failed() error         { return r.Err }
+func (r BotsResponse) Failed() error        { return r.Err }
+func (r WebhookResponse) Failed() error     { return r.Err }
+func (r WebhooksResponse) Failed() error    { return r.Err }
+func (r IntegrationResponse) Failed() error { return r.Err }
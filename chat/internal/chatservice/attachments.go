@@ -0,0 +1,352 @@
+package chatservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+	"github.com/F1zm0n/universal-chat/internal/storage"
+	"github.com/F1zm0n/universal-chat/internal/thumbnail"
+	"github.com/F1zm0n/universal-kit/signedurl"
+)
+
+// Files configures attachments.
+type Files struct {
+	Store storage.BlobStore
+	// Signer signs the urls clients upload and download with, the gateway
+	// checks them too.
+	Signer signedurl.Signer
+	// BaseURL is where the gateway serves files, the url of an attachment
+	// is BaseURL/<id>.
+	BaseURL string
+	// URLTTL is how long download urls are valid, UploadTTL how long
+	// upload slots are.
+	URLTTL    time.Duration
+	UploadTTL time.Duration
+	MaxSize   int64
+	// Types are the media types allowed, checked against the content
+	// uploaded.
+	Types []string
+	// ThumbnailSize bounds the width and height of thumbnails.
+	ThumbnailSize int
+	// Thumbnails is handed the images uploaded, nil leaves them to the
+	// thumbnail worker's sweep.
+	Thumbnails func(id uuid.UUID)
+}
+
+// LoadFiles reads the files.secret and chat.files keys, filling in
+// defaults. Store and Thumbnails are left to the caller.
+func LoadFiles() Files {
+	f := Files{
+		Signer:        signedurl.New(viper.GetString("files.secret")),
+		BaseURL:       strings.TrimRight(viper.GetString("chat.files.baseurl"), "/"),
+		URLTTL:        viper.GetDuration("chat.files.urlttl"),
+		UploadTTL:     viper.GetDuration("chat.files.uploadttl"),
+		MaxSize:       viper.GetInt64("chat.files.maxsize"),
+		Types:         viper.GetStringSlice("chat.files.types"),
+		ThumbnailSize: viper.GetInt("chat.files.thumbnail.size"),
+	}
+	if f.URLTTL <= 0 {
+		f.URLTTL = time.Hour
+	}
+	if f.UploadTTL <= 0 {
+		f.UploadTTL = 15 * time.Minute
+	}
+	if f.MaxSize <= 0 {
+		f.MaxSize = 25 << 20
+	}
+	if f.ThumbnailSize <= 0 {
+		f.ThumbnailSize = 320
+	}
+	return f
+}
+
+// UploadSlot is where a client uploads the content of an attachment, with
+// a PUT before ExpiresAt.
+type UploadSlot struct {
+	Attachment repository.Attachment `json:"attachment"`
+	UploadURL  string                `json:"upload_url"`
+	ExpiresAt  time.Time             `json:"expires_at"`
+}
+
+// Blob is the content of an attachment or its thumbnail. Size is -1 when
+// unknown.
+type Blob struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Content     io.ReadCloser
+}
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrNotUploader        = errors.New("only the uploader can upload an attachment")
+	ErrAlreadyUploaded    = errors.New("attachment already uploaded")
+	ErrFileTooLarge       = errors.New("file too large")
+	ErrUnsupportedType    = errors.New("file type not allowed")
+	ErrTypeMismatch       = errors.New("content does not match the declared type")
+	ErrSizeMismatch       = errors.New("content does not match the declared size")
+	ErrInvalidAttachment  = errors.New("attachments must be uploaded by the sender to the room and not sent yet")
+)
+
+// attachmentKey and thumbnailKey are the blob store keys of an attachment.
+func attachmentKey(id uuid.UUID) string { return "attachments/" + id.String() }
+func thumbnailKey(id uuid.UUID) string  { return "thumbnails/" + id.String() }
+
+// mediaType strips the parameters of a content type, returning "" for
+// malformed ones.
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+func (f Files) allowed(contentType string) bool {
+	return contentType != "" && slices.Contains(f.Types, contentType)
+}
+
+// url signs method on resource, the path of a file below BaseURL.
+func (f Files) url(method, resource string, expires time.Time) string {
+	return f.BaseURL + "/" + resource + "?" + f.Signer.Sign(method, resource, expires).Encode()
+}
+
+// sign fills in the download urls of a.
+func (f Files) sign(a *repository.Attachment) {
+	if a.Status != repository.AttachmentReady {
+		return
+	}
+	expires := time.Now().Add(f.URLTTL)
+	a.URL = f.url(http.MethodGet, a.ID.String(), expires)
+	if a.Thumbnail == repository.ThumbnailReady {
+		a.ThumbnailURL = f.url(http.MethodGet, a.ID.String()+"/thumbnail", expires)
+	}
+}
+
+func (s basicService) RequestUpload(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	name, contentType string,
+	size int64,
+) (UploadSlot, error) {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return UploadSlot{}, err
+	}
+	if size > s.files.MaxSize {
+		return UploadSlot{}, ErrFileTooLarge
+	}
+	contentType = mediaType(contentType)
+	if !s.files.allowed(contentType) {
+		return UploadSlot{}, ErrUnsupportedType
+	}
+	a := repository.Attachment{
+		ID:          uuid.New(),
+		RoomID:      roomID,
+		Uploader:    user,
+		Name:        path.Base(strings.ReplaceAll(name, `\`, "/")),
+		ContentType: contentType,
+		Size:        size,
+		Status:      repository.AttachmentPending,
+		Thumbnail:   repository.ThumbnailNone,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.db.CreateAttachment(ctx, a); err != nil {
+		return UploadSlot{}, err
+	}
+	expires := time.Now().Add(s.files.UploadTTL)
+	return UploadSlot{
+		Attachment: a,
+		UploadURL:  s.files.url(http.MethodPut, a.ID.String(), expires),
+		ExpiresAt:  expires,
+	}, nil
+}
+
+func (s basicService) Upload(
+	ctx context.Context,
+	user string,
+	id uuid.UUID,
+	content io.Reader,
+) (repository.Attachment, error) {
+	a, err := s.getAttachment(ctx, id)
+	if err != nil {
+		return repository.Attachment{}, err
+	}
+	if a.Uploader != user {
+		return repository.Attachment{}, ErrNotUploader
+	}
+	if a.Status != repository.AttachmentPending {
+		return repository.Attachment{}, ErrAlreadyUploaded
+	}
+	if err := s.checkMember(ctx, user, a.RoomID); err != nil {
+		return repository.Attachment{}, err
+	}
+
+	// the type is told by the content rather than trusted from the client
+	br := bufio.NewReaderSize(content, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return repository.Attachment{}, err
+	}
+	contentType := mediaType(http.DetectContentType(head))
+	if !s.files.allowed(contentType) {
+		return repository.Attachment{}, ErrUnsupportedType
+	}
+	if contentType != a.ContentType {
+		return repository.Attachment{}, ErrTypeMismatch
+	}
+
+	body := &countingReader{r: io.LimitReader(br, a.Size)}
+	err = s.files.Store.Put(ctx, attachmentKey(id), body, a.Size, contentType)
+	if err == nil && body.n == a.Size {
+		// anything after the declared size is too much
+		if n, _ := br.Read(make([]byte, 1)); n > 0 {
+			err = ErrSizeMismatch
+		}
+	}
+	if err != nil || body.n < a.Size {
+		s.files.Store.Delete(context.WithoutCancel(ctx), attachmentKey(id))
+		if body.n < a.Size {
+			return repository.Attachment{}, ErrSizeMismatch
+		}
+		return repository.Attachment{}, err
+	}
+
+	thumb := repository.ThumbnailNone
+	if thumbnail.Supported(contentType) {
+		thumb = repository.ThumbnailPending
+	}
+	a, err = s.db.MarkUploaded(ctx, id, body.n, contentType, thumb)
+	if errors.Is(err, repository.ErrNotFound) {
+		// a concurrent upload of the same slot got there first
+		return repository.Attachment{}, ErrAlreadyUploaded
+	}
+	if err != nil {
+		return repository.Attachment{}, err
+	}
+	if thumb == repository.ThumbnailPending && s.files.Thumbnails != nil {
+		s.files.Thumbnails(id)
+	}
+	s.files.sign(&a)
+	return a, nil
+}
+
+func (s basicService) OpenAttachment(ctx context.Context, user string, id uuid.UUID, thumb bool) (Blob, error) {
+	a, err := s.getAttachment(ctx, id)
+	if err != nil {
+		return Blob{}, err
+	}
+	// attachments are private to their uploader until they are sent
+	if a.Status != repository.AttachmentReady || (a.MessageID == nil && a.Uploader != user) {
+		return Blob{}, ErrAttachmentNotFound
+	}
+	if err := s.checkMember(ctx, user, a.RoomID); err != nil {
+		return Blob{}, err
+	}
+	blob := Blob{Name: a.Name, ContentType: a.ContentType, Size: a.Size}
+	key := attachmentKey(id)
+	if thumb {
+		if a.Thumbnail != repository.ThumbnailReady {
+			return Blob{}, ErrAttachmentNotFound
+		}
+		key = thumbnailKey(id)
+		blob.ContentType = thumbnail.ContentType
+		blob.Size = -1
+	}
+	blob.Content, err = s.files.Store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Blob{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+func (s basicService) MakeThumbnail(ctx context.Context, id uuid.UUID) error {
+	a, err := s.db.GetAttachment(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if a.Thumbnail != repository.ThumbnailPending {
+		return nil
+	}
+	content, err := s.files.Store.Get(ctx, attachmentKey(id))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	var buf bytes.Buffer
+	err = thumbnail.Make(&buf, content, s.files.ThumbnailSize)
+	if errors.Is(err, thumbnail.ErrInvalidImage) {
+		// retrying will not help, the attachment is served without one
+		if err := s.db.SetThumbnail(ctx, id, repository.ThumbnailFailed); err != nil {
+			return err
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	err = s.files.Store.Put(ctx, thumbnailKey(id), &buf, int64(buf.Len()), thumbnail.ContentType)
+	if err != nil {
+		return err
+	}
+	if err := s.db.SetThumbnail(ctx, id, repository.ThumbnailReady); err != nil {
+		return err
+	}
+	// attachments not sent yet get their thumbnail url with the message
+	if a.MessageID != nil {
+		a.Thumbnail = repository.ThumbnailReady
+		s.files.sign(&a)
+		s.notifyRoom(ctx, a.RoomID, Event{Type: EventThumbnail, RoomID: &a.RoomID, Attachment: &a})
+	}
+	return nil
+}
+
+func (s basicService) SweepUploads(ctx context.Context) ([]uuid.UUID, error) {
+	// slots are dropped a while after their url expired, in case an
+	// upload started just before is still running
+	if _, err := s.db.DeleteStaleUploads(ctx, time.Now().Add(-2*s.files.UploadTTL)); err != nil {
+		return nil, err
+	}
+	// recent ones are likely queued already
+	return s.db.ListPendingThumbnails(ctx, time.Now().Add(-time.Minute), 100)
+}
+
+func (s basicService) getAttachment(ctx context.Context, id uuid.UUID) (repository.Attachment, error) {
+	a, err := s.db.GetAttachment(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Attachment{}, ErrAttachmentNotFound
+	}
+	return a, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
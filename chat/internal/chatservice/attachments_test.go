@@ -0,0 +1,123 @@
+package chatservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/F1zm0n/universal-kit/signedurl"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+	"github.com/F1zm0n/universal-chat/internal/storage"
+)
+
+func testFiles(t *testing.T) Files {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	return Files{
+		Store:     store,
+		Signer:    signedurl.New("test-secret"),
+		BaseURL:   "http://localhost/files",
+		URLTTL:    time.Hour,
+		UploadTTL: time.Hour,
+		MaxSize:   1 << 10,
+		Types:     []string{"text/plain", "image/png"},
+	}
+}
+
+func TestRequestUpload(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindGroup, map[string]string{"alice": repository.RoleMember})
+	svc, _ := newTestService(t, db, testFiles(t))
+	ctx := context.Background()
+
+	slot, err := svc.RequestUpload(ctx, "alice", roomID, `C:\Users\alice\notes.txt`, "text/plain; charset=utf-8", 11)
+	if err != nil {
+		t.Fatalf("RequestUpload: %v", err)
+	}
+	if slot.Attachment.Name != "notes.txt" || slot.Attachment.ContentType != "text/plain" || slot.UploadURL == "" {
+		t.Errorf("slot %+v", slot)
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		contentType string
+		size        int64
+		want        error
+	}{
+		{"not a member", "bob", "text/plain", 11, ErrNotMember},
+		{"too large", "alice", "text/plain", 1<<10 + 1, ErrFileTooLarge},
+		{"type not allowed", "alice", "application/x-msdownload", 11, ErrUnsupportedType},
+		{"malformed type", "alice", "text/", 11, ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.RequestUpload(ctx, tt.user, roomID, "f", tt.contentType, tt.size)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("RequestUpload = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	const text = "hello world"
+	tests := []struct {
+		name    string
+		user    string
+		content string
+		want    error
+	}{
+		{"ok", "alice", text, nil},
+		{"not the uploader", "bob", text, ErrNotUploader},
+		{"content of another type", "alice", "\x89PNG\r\n\x1a\n" + text[8:], ErrTypeMismatch},
+		{"content not allowed", "alice", "%PDF-1.7 hello", ErrUnsupportedType},
+		{"short", "alice", text[:5], ErrSizeMismatch},
+		{"long", "alice", text + "!", ErrSizeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMemRepo()
+			roomID := db.room(repository.RoomKindGroup, map[string]string{
+				"alice": repository.RoleMember,
+				"bob":   repository.RoleMember,
+			})
+			files := testFiles(t)
+			svc, _ := newTestService(t, db, files)
+			ctx := context.Background()
+			slot, err := svc.RequestUpload(ctx, "alice", roomID, "notes.txt", "text/plain", int64(len(text)))
+			if err != nil {
+				t.Fatalf("RequestUpload: %v", err)
+			}
+
+			a, err := svc.Upload(ctx, tt.user, slot.Attachment.ID, strings.NewReader(tt.content))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Upload = %v, want %v", err, tt.want)
+			}
+			blob, err := files.Store.Get(ctx, attachmentKey(slot.Attachment.ID))
+			if err == nil {
+				blob.Close()
+			}
+			if tt.want != nil {
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Error("refused upload left its content in the store")
+				}
+				if db.attachments[slot.Attachment.ID].Status != repository.AttachmentPending {
+					t.Error("refused upload marked uploaded")
+				}
+				return
+			}
+			if a.Status != repository.AttachmentReady || a.URL == "" {
+				t.Errorf("uploaded %+v", a)
+			}
+			if _, err := svc.Upload(ctx, "alice", slot.Attachment.ID, strings.NewReader(text)); !errors.Is(err, ErrAlreadyUploaded) {
+				t.Errorf("second Upload = %v, want %v", err, ErrAlreadyUploaded)
+			}
+		})
+	}
+}
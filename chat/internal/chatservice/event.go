@@ -0,0 +1,70 @@
+package chatservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+const (
+	EventMessage = "message"
+	// EventMessageEdited and EventMessageDeleted carry the message as it
+	// is now, without its body once deleted.
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	// EventReactions carries a message whose reactions changed.
+	EventReactions    = "message.reactions"
+	EventMemberJoined = "member.joined"
+	EventMemberLeft   = "member.left"
+	// EventPresence tells the contacts of Member that they came online or
+	// went offline. It has no room.
+	EventPresence = "presence"
+	// EventTyping tells a room that Member is typing. Clients stop showing
+	// it after a few seconds unless it is repeated.
+	EventTyping = "typing"
+	// EventRead tells the other connections of a user how far they have
+	// read a room.
+	EventRead = "read"
+	// EventThumbnail carries an attachment of a message sent before its
+	// thumbnail was ready.
+	EventThumbnail = "attachment.thumbnail"
+	// EventMemberKicked and EventMemberBanned tell a room, the member
+	// included, that a moderator removed Member.
+	EventMemberKicked = "member.kicked"
+	EventMemberBanned = "member.banned"
+	// EventMemberMuted tells a room that Member may not post until Until,
+	// which is unset once unmuted.
+	EventMemberMuted = "member.muted"
+	// EventMemberRole tells a room that Member now has Role.
+	EventMemberRole = "member.role"
+	// EventMessageFlagged tells the moderators of a room that a filter
+	// flagged Message for Reason.
+	EventMessageFlagged = "message.flagged"
+)
+
+// Event is pushed to the connected clients of the users concerned.
+type Event struct {
+	Type    string              `json:"type"`
+	RoomID  *uuid.UUID          `json:"room_id,omitempty"`
+	Message *repository.Message `json:"message,omitempty"`
+	Member  string              `json:"member,omitempty"`
+	// Online is set on presence events only.
+	Online *bool `json:"online,omitempty"`
+	// LastRead is set on read events only.
+	LastRead int64 `json:"last_read,omitempty"`
+	// Attachment is set on thumbnail events only.
+	Attachment *repository.Attachment `json:"attachment,omitempty"`
+	// Role, Until and Reason are set on moderation events only.
+	Role   string     `json:"role,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// Notifier delivers events to the connections of users. It must not block,
+// the hub drops connections that cannot keep up instead.
+type Notifier interface {
+	Notify(ctx context.Context, users []string, ev Event)
+}
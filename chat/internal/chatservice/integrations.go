@@ -0,0 +1,337 @@
+package chatservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/filter"
+	"github.com/F1zm0n/universal-chat/internal/repository"
+	"github.com/F1zm0n/universal-chat/internal/webhook"
+)
+
+// BotTokenPrefix starts every bot token, telling them apart from the
+// tokens of users.
+const BotTokenPrefix = "bot_"
+
+type Integrations struct {
+	// Hooks queues the calls of bots, nil drops them.
+	Hooks webhook.Queue
+	// HooksURL is where the gateway serves incoming webhooks, the url of
+	// a webhook is HooksURL/<id>/<token>.
+	HooksURL string
+}
+
+// LoadIntegrations reads the chat.hooks key. Hooks is left to the caller.
+func LoadIntegrations() Integrations {
+	return Integrations{
+		HooksURL: strings.TrimRight(viper.GetString("chat.hooks.baseurl"), "/"),
+	}
+}
+
+// BotCredentials are shown once, when the bot is created.
+type BotCredentials struct {
+	Bot repository.Bot `json:"bot"`
+	// Token authenticates the bot, sent as Authorization: Bot <token>.
+	Token string `json:"token"`
+	// Secret signs the calls of the bot's webhook.
+	Secret string `json:"secret"`
+}
+
+// WebhookCredentials are shown once, when the webhook is created.
+type WebhookCredentials struct {
+	Webhook repository.Webhook `json:"webhook"`
+	// URL is where to post, the token in it is all it takes.
+	URL string `json:"url"`
+}
+
+var (
+	ErrBotNotFound     = errors.New("bot not found")
+	ErrBotNameTaken    = errors.New("bot name taken")
+	ErrInvalidWebhook  = errors.New("webhook url must be an https url of a public host")
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrIntegration     = errors.New("bots and webhooks cannot do this")
+)
+
+func (s basicService) CreateBot(
+	ctx context.Context,
+	user, name, webhookURL string,
+	commands []string,
+) (BotCredentials, error) {
+	if IsIntegration(user) {
+		return BotCredentials{}, ErrIntegration
+	}
+	if webhookURL != "" && webhook.CheckURL(webhookURL) != nil {
+		return BotCredentials{}, ErrInvalidWebhook
+	}
+	token, err := newToken(32)
+	if err != nil {
+		return BotCredentials{}, err
+	}
+	token = BotTokenPrefix + token
+	secret, err := newToken(32)
+	if err != nil {
+		return BotCredentials{}, err
+	}
+	bot := repository.Bot{
+		ID:         uuid.New(),
+		Name:       name,
+		Owner:      user,
+		TokenHash:  hashToken(token),
+		WebhookURL: webhookURL,
+		Secret:     secret,
+		Commands:   commands,
+	}
+	err = s.db.CreateBot(ctx, bot)
+	if errors.Is(err, repository.ErrConflict) {
+		return BotCredentials{}, ErrBotNameTaken
+	}
+	if err != nil {
+		return BotCredentials{}, err
+	}
+	// CreatedAt is set by the database
+	if bot, err = s.db.GetBot(ctx, bot.ID); err != nil {
+		return BotCredentials{}, err
+	}
+	return BotCredentials{Bot: bot, Token: token, Secret: secret}, nil
+}
+
+func (s basicService) Bots(ctx context.Context, user string) ([]repository.Bot, error) {
+	return s.db.ListBots(ctx, user)
+}
+
+func (s basicService) DeleteBot(ctx context.Context, user string, id uuid.UUID) error {
+	err := s.db.DeleteBot(ctx, id, user)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrBotNotFound
+	}
+	return err
+}
+
+func (s basicService) AddBot(ctx context.Context, user string, roomID, botID uuid.UUID) error {
+	if _, err := s.moderator(ctx, user, roomID); err != nil {
+		return err
+	}
+	bot, err := s.db.GetBot(ctx, botID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrBotNotFound
+	}
+	if err != nil {
+		return err
+	}
+	banned, err := s.db.IsBanned(ctx, roomID, bot.Member())
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	if err := s.db.AddMember(ctx, roomID, bot.Member()); err != nil {
+		return err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMemberJoined, RoomID: &roomID, Member: bot.Member()})
+	return nil
+}
+
+func (s basicService) CreateWebhook(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	name string,
+) (WebhookCredentials, error) {
+	if _, err := s.moderator(ctx, user, roomID); err != nil {
+		return WebhookCredentials{}, err
+	}
+	token, err := newToken(24)
+	if err != nil {
+		return WebhookCredentials{}, err
+	}
+	w := repository.Webhook{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		Name:      name,
+		CreatedBy: user,
+		TokenHash: hashToken(token),
+	}
+	if err := s.db.CreateWebhook(ctx, w); err != nil {
+		return WebhookCredentials{}, err
+	}
+	if w, err = s.db.GetWebhook(ctx, w.ID); err != nil {
+		return WebhookCredentials{}, err
+	}
+	return WebhookCredentials{
+		Webhook: w,
+		URL:     s.integrations.HooksURL + "/" + w.ID.String() + "/" + token,
+	}, nil
+}
+
+func (s basicService) Webhooks(ctx context.Context, user string, roomID uuid.UUID) ([]repository.Webhook, error) {
+	if _, err := s.moderator(ctx, user, roomID); err != nil {
+		return nil, err
+	}
+	return s.db.ListWebhooks(ctx, roomID)
+}
+
+func (s basicService) DeleteWebhook(ctx context.Context, user string, roomID, id uuid.UUID) error {
+	if _, err := s.moderator(ctx, user, roomID); err != nil {
+		return err
+	}
+	err := s.db.DeleteWebhook(ctx, roomID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+func (s basicService) PostWebhook(
+	ctx context.Context,
+	id uuid.UUID,
+	token, body string,
+) (repository.Message, error) {
+	w, err := s.db.GetWebhook(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Message{}, ErrWebhookNotFound
+	}
+	if err != nil {
+		return repository.Message{}, err
+	}
+	// a wrong token looks like an unknown webhook, not to tell which exist
+	if subtle.ConstantTimeCompare(hashToken(token), w.TokenHash) != 1 {
+		return repository.Message{}, ErrWebhookNotFound
+	}
+	res, err := s.filter(ctx, w.Member(), w.RoomID, nil, body)
+	if err != nil {
+		return repository.Message{}, err
+	}
+	msg := repository.Message{
+		RoomID: w.RoomID,
+		Sender: w.Member(),
+		Body:   body,
+	}
+	if err := s.db.CreateMessage(ctx, &msg); err != nil {
+		return repository.Message{}, err
+	}
+	s.notifyRoom(ctx, w.RoomID, Event{Type: EventMessage, RoomID: &w.RoomID, Message: &msg})
+	if res.Verdict == filter.Flag {
+		if err := s.flagged(ctx, res, msg); err != nil {
+			return repository.Message{}, err
+		}
+	}
+	s.dispatch(ctx, msg)
+	return msg, nil
+}
+
+func (s basicService) AuthenticateBot(ctx context.Context, token string) (string, error) {
+	if !strings.HasPrefix(token, BotTokenPrefix) {
+		return "", ErrInvalidToken
+	}
+	bot, err := s.db.GetBotByToken(ctx, hashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return bot.Member(), nil
+}
+
+// dispatch queues the calls of the bots of the room msg commands or
+// mentions. The messages of bots are left alone, so that bots do not
+// answer each other forever. Like notifyRoom, it is best effort.
+func (s basicService) dispatch(ctx context.Context, msg repository.Message) {
+	if s.integrations.Hooks == nil || strings.HasSuffix(msg.Sender, "@"+repository.BotDomain) {
+		return
+	}
+	command, text := parseCommand(msg.Body)
+	if command == "" && !strings.Contains(msg.Body, "@") {
+		return
+	}
+	bots, err := s.db.ListRoomBots(ctx, msg.RoomID)
+	if err != nil {
+		return
+	}
+	for _, bot := range bots {
+		if bot.WebhookURL == "" {
+			continue
+		}
+		ev := webhook.Event{BotID: bot.ID, RoomID: msg.RoomID, Message: msg}
+		switch {
+		case command != "" && slices.Contains(bot.Commands, command):
+			ev.Type, ev.Command, ev.Text = webhook.EventCommand, command, text
+		case mentions(msg.Body, bot.Name):
+			ev.Type = webhook.EventMention
+		default:
+			continue
+		}
+		s.integrations.Hooks.Queue(ctx, ev)
+	}
+}
+
+// parseCommand splits a body such as "/deploy staging" into the command,
+// deploy, and the rest of it. Bodies not starting with a slash have none.
+func parseCommand(body string) (command, text string) {
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "/") {
+		return "", ""
+	}
+	command = body[1:]
+	if i := strings.IndexFunc(command, unicode.IsSpace); i >= 0 {
+		command, text = command[:i], strings.TrimSpace(command[i:])
+	}
+	return strings.ToLower(command), text
+}
+
+// mentions reports whether body mentions @name, names being made of
+// lower case letters, digits, - and _.
+func mentions(body, name string) bool {
+	body = strings.ToLower(body)
+	at := "@" + name
+	for i := strings.Index(body, at); i >= 0; {
+		end := i + len(at)
+		if (i == 0 || !isNameByte(body[i-1])) && (end == len(body) || !isNameByte(body[end])) {
+			return true
+		}
+		next := strings.Index(body[end:], at)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '-' || b == '_'
+}
+
+// IsIntegration reports whether member is a bot or an incoming webhook.
+func IsIntegration(member string) bool {
+	return strings.HasSuffix(member, "@"+repository.BotDomain) ||
+		strings.HasSuffix(member, "@"+repository.WebhookDomain)
+}
+
+// newToken returns n random bytes, base64 encoded for urls.
+func newToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how tokens are kept, they are random enough to need no
+// salt or stretching.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
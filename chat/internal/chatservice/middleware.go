@@ -0,0 +1,738 @@
+package chatservice
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+type Middleware func(svc Service) Service
+
+type loggingMiddleware struct {
+	next Service
+	log  log.Logger
+}
+
+func LoggingMiddleware(l log.Logger) Middleware {
+	return func(svc Service) Service {
+		return &loggingMiddleware{
+			next: svc,
+			log:  l,
+		}
+	}
+}
+
+func (mw loggingMiddleware) CreateRoom(ctx context.Context, user, name string) (room repository.Room, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "creating room",
+			"user", user,
+			"room", room.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.CreateRoom(ctx, user, name)
+}
+
+func (mw loggingMiddleware) ListRooms(ctx context.Context, user string) (rooms []repository.Room, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing rooms",
+			"user", user,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.ListRooms(ctx, user)
+}
+
+func (mw loggingMiddleware) JoinRoom(ctx context.Context, user string, roomID uuid.UUID) (room repository.Room, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "joining room",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.JoinRoom(ctx, user, roomID)
+}
+
+func (mw loggingMiddleware) LeaveRoom(ctx context.Context, user string, roomID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "leaving room",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.LeaveRoom(ctx, user, roomID)
+}
+
+func (mw loggingMiddleware) OpenDirect(ctx context.Context, user, peer string) (room repository.Room, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "opening direct room",
+			"user", user,
+			"room", room.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.OpenDirect(ctx, user, peer)
+}
+
+func (mw loggingMiddleware) SendMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	parentID int64,
+	body string,
+	attachmentIDs []uuid.UUID,
+) (msg repository.Message, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "sending message",
+			"user", user,
+			"room", roomID,
+			"parent", parentID,
+			"attachments", len(attachmentIDs),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.SendMessage(ctx, user, roomID, parentID, body, attachmentIDs)
+}
+
+func (mw loggingMiddleware) EditMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	body string,
+) (msg repository.Message, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "editing message",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.EditMessage(ctx, user, roomID, messageID, body)
+}
+
+func (mw loggingMiddleware) DeleteMessage(ctx context.Context, user string, roomID uuid.UUID, messageID int64) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "deleting message",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.DeleteMessage(ctx, user, roomID, messageID)
+}
+
+func (mw loggingMiddleware) MessageEdits(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+) (edits []repository.Edit, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing message edits",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.MessageEdits(ctx, user, roomID, messageID)
+}
+
+func (mw loggingMiddleware) React(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	emoji string,
+) (reactions []repository.Reaction, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "reacting",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.React(ctx, user, roomID, messageID, emoji)
+}
+
+func (mw loggingMiddleware) Unreact(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	emoji string,
+) (reactions []repository.Reaction, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "removing reaction",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Unreact(ctx, user, roomID, messageID, emoji)
+}
+
+func (mw loggingMiddleware) History(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	cursor string,
+	limit int,
+) (page Page, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "reading history",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.History(ctx, user, roomID, cursor, limit)
+}
+
+func (mw loggingMiddleware) Typing(ctx context.Context, user string, roomID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "typing",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Typing(ctx, user, roomID)
+}
+
+func (mw loggingMiddleware) SetPresence(ctx context.Context, user string, online bool) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "setting presence",
+			"user", user,
+			"online", online,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.SetPresence(ctx, user, online)
+}
+
+func (mw loggingMiddleware) Seen(ctx context.Context, users []string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "recording presence",
+			"users", len(users),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Seen(ctx, users)
+}
+
+func (mw loggingMiddleware) MarkRead(ctx context.Context, user string, roomID uuid.UUID, messageID int64) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "marking read",
+			"user", user,
+			"room", roomID,
+			"message", messageID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.MarkRead(ctx, user, roomID, messageID)
+}
+
+func (mw loggingMiddleware) Unread(ctx context.Context, user string) (unread []repository.Unread, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "counting unread",
+			"user", user,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Unread(ctx, user)
+}
+
+func (mw loggingMiddleware) Sync(ctx context.Context, user string, since int64, limit int) (page SyncPage, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "syncing",
+			"user", user,
+			"since", since,
+			"messages", len(page.Messages),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Sync(ctx, user, since, limit)
+}
+
+func (mw loggingMiddleware) Thread(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	parentID int64,
+	cursor string,
+	limit int,
+) (page Page, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "reading thread",
+			"user", user,
+			"room", roomID,
+			"parent", parentID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Thread(ctx, user, roomID, parentID, cursor, limit)
+}
+
+func (mw loggingMiddleware) RequestUpload(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	name, contentType string,
+	size int64,
+) (slot UploadSlot, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "requesting upload",
+			"user", user,
+			"room", roomID,
+			"attachment", slot.Attachment.ID,
+			"content_type", contentType,
+			"size", size,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.RequestUpload(ctx, user, roomID, name, contentType, size)
+}
+
+func (mw loggingMiddleware) Upload(
+	ctx context.Context,
+	user string,
+	id uuid.UUID,
+	content io.Reader,
+) (a repository.Attachment, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "uploading",
+			"user", user,
+			"attachment", id,
+			"size", a.Size,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Upload(ctx, user, id, content)
+}
+
+func (mw loggingMiddleware) OpenAttachment(
+	ctx context.Context,
+	user string,
+	id uuid.UUID,
+	thumbnail bool,
+) (blob Blob, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "opening attachment",
+			"user", user,
+			"attachment", id,
+			"thumbnail", thumbnail,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.OpenAttachment(ctx, user, id, thumbnail)
+}
+
+func (mw loggingMiddleware) MakeThumbnail(ctx context.Context, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "making thumbnail",
+			"attachment", id,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.MakeThumbnail(ctx, id)
+}
+
+func (mw loggingMiddleware) SweepUploads(ctx context.Context) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "sweeping uploads",
+			"pending_thumbnails", len(ids),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.SweepUploads(ctx)
+}
+
+func (mw loggingMiddleware) Search(
+	ctx context.Context,
+	user, text string,
+	filter SearchFilter,
+	cursor string,
+	limit int,
+) (page SearchPage, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "searching",
+			"user", user,
+			"room", filter.RoomID,
+			"results", len(page.Results),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Search(ctx, user, text, filter, cursor, limit)
+}
+
+func (mw loggingMiddleware) Members(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+) (members []repository.Member, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing members",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Members(ctx, user, roomID)
+}
+
+func (mw loggingMiddleware) SetRole(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	member, role string,
+) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "setting role",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"role", role,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.SetRole(ctx, user, roomID, member, role)
+}
+
+func (mw loggingMiddleware) Mute(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	member string,
+	d time.Duration,
+	reason string,
+) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "muting member",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"duration", d,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Mute(ctx, user, roomID, member, d, reason)
+}
+
+func (mw loggingMiddleware) Unmute(ctx context.Context, user string, roomID uuid.UUID, member string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "unmuting member",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Unmute(ctx, user, roomID, member)
+}
+
+func (mw loggingMiddleware) Kick(ctx context.Context, user string, roomID uuid.UUID, member, reason string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "kicking member",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Kick(ctx, user, roomID, member, reason)
+}
+
+func (mw loggingMiddleware) Ban(ctx context.Context, user string, roomID uuid.UUID, member, reason string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "banning member",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Ban(ctx, user, roomID, member, reason)
+}
+
+func (mw loggingMiddleware) Unban(ctx context.Context, user string, roomID uuid.UUID, member string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "unbanning member",
+			"user", user,
+			"room", roomID,
+			"member", member,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Unban(ctx, user, roomID, member)
+}
+
+func (mw loggingMiddleware) AuditLog(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	cursor string,
+	limit int,
+) (page AuditPage, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing audit log",
+			"user", user,
+			"room", roomID,
+			"entries", len(page.Entries),
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.AuditLog(ctx, user, roomID, cursor, limit)
+}
+
+func (mw loggingMiddleware) Block(ctx context.Context, user, peer string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "blocking user",
+			"user", user,
+			"peer", peer,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Block(ctx, user, peer)
+}
+
+func (mw loggingMiddleware) Unblock(ctx context.Context, user, peer string) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "unblocking user",
+			"user", user,
+			"peer", peer,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Unblock(ctx, user, peer)
+}
+
+func (mw loggingMiddleware) Blocks(ctx context.Context, user string) (blocks []repository.Block, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing blocks",
+			"user", user,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Blocks(ctx, user)
+}
+
+func (mw loggingMiddleware) CreateBot(
+	ctx context.Context,
+	user, name, webhookURL string,
+	commands []string,
+) (creds BotCredentials, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "creating bot",
+			"user", user,
+			"bot", creds.Bot.ID,
+			"name", name,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.CreateBot(ctx, user, name, webhookURL, commands)
+}
+
+func (mw loggingMiddleware) Bots(ctx context.Context, user string) (bots []repository.Bot, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing bots",
+			"user", user,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Bots(ctx, user)
+}
+
+func (mw loggingMiddleware) DeleteBot(ctx context.Context, user string, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "deleting bot",
+			"user", user,
+			"bot", id,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.DeleteBot(ctx, user, id)
+}
+
+func (mw loggingMiddleware) AddBot(ctx context.Context, user string, roomID, botID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "adding bot",
+			"user", user,
+			"room", roomID,
+			"bot", botID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.AddBot(ctx, user, roomID, botID)
+}
+
+func (mw loggingMiddleware) CreateWebhook(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	name string,
+) (creds WebhookCredentials, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "creating webhook",
+			"user", user,
+			"room", roomID,
+			"webhook", creds.Webhook.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.CreateWebhook(ctx, user, roomID, name)
+}
+
+func (mw loggingMiddleware) Webhooks(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+) (webhooks []repository.Webhook, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "listing webhooks",
+			"user", user,
+			"room", roomID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.Webhooks(ctx, user, roomID)
+}
+
+func (mw loggingMiddleware) DeleteWebhook(ctx context.Context, user string, roomID, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "deleting webhook",
+			"user", user,
+			"room", roomID,
+			"webhook", id,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.DeleteWebhook(ctx, user, roomID, id)
+}
+
+func (mw loggingMiddleware) PostWebhook(
+	ctx context.Context,
+	id uuid.UUID,
+	token, body string,
+) (msg repository.Message, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "posting webhook",
+			"webhook", id,
+			"message", msg.ID,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.PostWebhook(ctx, id, token, body)
+}
+
+func (mw loggingMiddleware) AuthenticateBot(ctx context.Context, token string) (member string, err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"operation", "authenticating bot",
+			"bot", member,
+			"error", err,
+			"took", time.Since(start),
+		)
+	}(time.Now())
+	return mw.next.AuthenticateBot(ctx, token)
+}
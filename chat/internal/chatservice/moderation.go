@@ -0,0 +1,415 @@
+package chatservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/filter"
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+// MaxMute bounds how long members are muted.
+const MaxMute = 365 * 24 * time.Hour
+
+// AuditPage is a page of a room's audit log, paged like Page.
+type AuditPage struct {
+	Entries    []repository.AuditEntry `json:"entries"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+var (
+	ErrNotModerator   = errors.New("only moderators can do this")
+	ErrNotOwner       = errors.New("only the owner can do this")
+	ErrOutranked      = errors.New("can only moderate members of a lower role")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrNotBanned      = errors.New("not banned from this room")
+	ErrMuted          = errors.New("muted in this room")
+	ErrBanned         = errors.New("banned from this room")
+	ErrBlocked        = errors.New("blocked")
+	ErrBlockSelf      = errors.New("cannot block yourself")
+	ErrOwnerLeaving   = errors.New("the owner must hand the room over before leaving")
+	ErrUnmoderated    = errors.New("direct rooms have no moderators")
+	// ErrRejected is wrapped with the reason a filter gave.
+	ErrRejected = errors.New("message rejected")
+)
+
+// rank orders the roles, members not in a room rank lowest.
+func rank(role string) int {
+	switch role {
+	case repository.RoleOwner:
+		return 3
+	case repository.RoleModerator:
+		return 2
+	case repository.RoleMember:
+		return 1
+	}
+	return 0
+}
+
+func (s basicService) Members(ctx context.Context, user string, roomID uuid.UUID) ([]repository.Member, error) {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return nil, err
+	}
+	return s.db.ListRoomMembers(ctx, roomID)
+}
+
+func (s basicService) SetRole(ctx context.Context, user string, roomID uuid.UUID, member, role string) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if err != nil {
+		return err
+	}
+	if actor.Role != repository.RoleOwner {
+		return ErrNotOwner
+	}
+	if _, err := s.target(ctx, actor, roomID, member, true); err != nil {
+		return err
+	}
+	e := s.entry(actor, roomID, repository.AuditRole, member, role)
+	if role == repository.RoleOwner {
+		e.Action = repository.AuditOwner
+		err = s.db.TransferOwnership(ctx, e)
+	} else {
+		err = s.db.SetRole(ctx, e, role)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if role == repository.RoleOwner {
+		s.notifyRoom(ctx, roomID, Event{Type: EventMemberRole, RoomID: &roomID, Member: user, Role: repository.RoleModerator})
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMemberRole, RoomID: &roomID, Member: member, Role: role})
+	return nil
+}
+
+func (s basicService) Mute(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	member string,
+	d time.Duration,
+	reason string,
+) error {
+	until := time.Now().Add(min(d, MaxMute))
+	return s.mute(ctx, user, roomID, member, &until, reason)
+}
+
+func (s basicService) Unmute(ctx context.Context, user string, roomID uuid.UUID, member string) error {
+	return s.mute(ctx, user, roomID, member, nil, "")
+}
+
+// mute mutes member until, or unmutes them for a nil until.
+func (s basicService) mute(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	member string,
+	until *time.Time,
+	reason string,
+) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.target(ctx, actor, roomID, member, true); err != nil {
+		return err
+	}
+	e := s.entry(actor, roomID, repository.AuditMute, member, reason)
+	if until == nil {
+		e.Action = repository.AuditUnmute
+	}
+	e.ExpiresAt = until
+	err = s.db.Mute(ctx, e)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMemberMuted, RoomID: &roomID, Member: member, Until: until})
+	return nil
+}
+
+func (s basicService) Kick(ctx context.Context, user string, roomID uuid.UUID, member, reason string) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.target(ctx, actor, roomID, member, true); err != nil {
+		return err
+	}
+	// the member is told before they are gone from the room
+	ev := Event{Type: EventMemberKicked, RoomID: &roomID, Member: member, Reason: reason}
+	members, _ := s.db.ListMembers(ctx, roomID)
+	err = s.db.Kick(ctx, s.entry(actor, roomID, repository.AuditKick, member, reason))
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, members, ev)
+	return nil
+}
+
+func (s basicService) Ban(ctx context.Context, user string, roomID uuid.UUID, member, reason string) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if err != nil {
+		return err
+	}
+	// users can be banned before they join
+	if _, err := s.target(ctx, actor, roomID, member, false); err != nil {
+		return err
+	}
+	ev := Event{Type: EventMemberBanned, RoomID: &roomID, Member: member, Reason: reason}
+	members, _ := s.db.ListMembers(ctx, roomID)
+	if err := s.db.Ban(ctx, s.entry(actor, roomID, repository.AuditBan, member, reason)); err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, members, ev)
+	return nil
+}
+
+func (s basicService) Unban(ctx context.Context, user string, roomID uuid.UUID, member string) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if err != nil {
+		return err
+	}
+	err = s.db.Unban(ctx, s.entry(actor, roomID, repository.AuditUnban, member, ""))
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNotBanned
+	}
+	return err
+}
+
+func (s basicService) AuditLog(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	cursor string,
+	limit int,
+) (AuditPage, error) {
+	if _, err := s.moderator(ctx, user, roomID); err != nil {
+		return AuditPage{}, err
+	}
+	before, err := decodeCursor(cursor)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	entries, err := s.db.ListAudit(ctx, roomID, before, limit+1)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	page := AuditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = encodeCursor(entries[limit-1].ID)
+	}
+	return page, nil
+}
+
+func (s basicService) Block(ctx context.Context, user, peer string) error {
+	if user == peer {
+		return ErrBlockSelf
+	}
+	return s.db.Block(ctx, repository.AuditEntry{Actor: user, Action: repository.AuditBlock, Target: peer})
+}
+
+func (s basicService) Unblock(ctx context.Context, user, peer string) error {
+	return s.db.Unblock(ctx, repository.AuditEntry{Actor: user, Action: repository.AuditUnblock, Target: peer})
+}
+
+func (s basicService) Blocks(ctx context.Context, user string) ([]repository.Block, error) {
+	return s.db.ListBlocks(ctx, user)
+}
+
+// moderator returns the membership of user in the group room roomID,
+// failing unless they are a moderator or its owner.
+func (s basicService) moderator(ctx context.Context, user string, roomID uuid.UUID) (repository.Member, error) {
+	room, err := s.getRoom(ctx, roomID)
+	if err != nil {
+		return repository.Member{}, err
+	}
+	if room.Kind == repository.RoomKindDirect {
+		return repository.Member{}, ErrUnmoderated
+	}
+	m, err := s.db.GetMember(ctx, roomID, user)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Member{}, ErrNotMember
+	}
+	if err != nil {
+		return repository.Member{}, err
+	}
+	if rank(m.Role) < rank(repository.RoleModerator) {
+		return repository.Member{}, ErrNotModerator
+	}
+	return m, nil
+}
+
+// target returns the membership of member, checking that actor outranks
+// them. Users not in the room fail with ErrMemberNotFound if inRoom.
+func (s basicService) target(
+	ctx context.Context,
+	actor repository.Member,
+	roomID uuid.UUID,
+	member string,
+	inRoom bool,
+) (repository.Member, error) {
+	m, err := s.db.GetMember(ctx, roomID, member)
+	if errors.Is(err, repository.ErrNotFound) {
+		if inRoom {
+			return repository.Member{}, ErrMemberNotFound
+		}
+		m, err = repository.Member{Member: member}, nil
+	}
+	if err != nil {
+		return repository.Member{}, err
+	}
+	if rank(actor.Role) <= rank(m.Role) {
+		return repository.Member{}, ErrOutranked
+	}
+	return m, nil
+}
+
+// canModerate fails with ErrNotSender unless user is a moderator of the
+// room ranking above sender, whose messages they may then delete.
+func (s basicService) canModerate(ctx context.Context, user string, roomID uuid.UUID, sender string) error {
+	actor, err := s.moderator(ctx, user, roomID)
+	if errors.Is(err, ErrNotModerator) || errors.Is(err, ErrUnmoderated) {
+		return ErrNotSender
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.target(ctx, actor, roomID, sender, false)
+	return err
+}
+
+func (s basicService) entry(actor repository.Member, roomID uuid.UUID, action, target, reason string) repository.AuditEntry {
+	return repository.AuditEntry{
+		RoomID: &roomID,
+		Actor:  actor.Member,
+		Action: action,
+		Target: target,
+		Reason: reason,
+	}
+}
+
+// poster returns the membership of user in the room, failing unless they
+// may post there.
+func (s basicService) poster(ctx context.Context, user string, roomID uuid.UUID) (repository.Member, error) {
+	m, err := s.db.GetMember(ctx, roomID, user)
+	if errors.Is(err, repository.ErrNotFound) {
+		if _, err := s.getRoom(ctx, roomID); err != nil {
+			return repository.Member{}, err
+		}
+		return repository.Member{}, ErrNotMember
+	}
+	if err != nil {
+		return repository.Member{}, err
+	}
+	if m.Muted(time.Now()) {
+		return repository.Member{}, ErrMuted
+	}
+	return m, nil
+}
+
+// checkDirect fails with ErrBlocked when roomID is a direct room and one
+// of its members blocked the other.
+func (s basicService) checkDirect(ctx context.Context, user string, roomID uuid.UUID) error {
+	room, err := s.getRoom(ctx, roomID)
+	if err != nil || room.Kind != repository.RoomKindDirect {
+		return err
+	}
+	members, err := s.db.ListMembers(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	for _, peer := range members {
+		if peer == user {
+			continue
+		}
+		blocked, err := s.db.IsBlocked(ctx, user, peer)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// filter runs the filters on a body user is about to post. Rejections are
+// audited here, flagged messages once stored, with flagged.
+func (s basicService) filter(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID *int64,
+	body string,
+) (filter.Result, error) {
+	if s.filters == nil {
+		return filter.Result{}, nil
+	}
+	res := s.filters.Check(ctx, filter.Message{RoomID: roomID, Sender: user, Body: body})
+	if res.Verdict != filter.Reject {
+		return res, nil
+	}
+	err := s.db.Audit(ctx, repository.AuditEntry{
+		RoomID:    &roomID,
+		Actor:     "filter:" + res.Filter,
+		Action:    repository.AuditReject,
+		Target:    user,
+		MessageID: messageID,
+		Reason:    res.Reason,
+	})
+	if err != nil {
+		return filter.Result{}, err
+	}
+	return res, fmt.Errorf("%w: %s", ErrRejected, res.Reason)
+}
+
+// flagged audits a message a filter flagged and tells the room's
+// moderators about it.
+func (s basicService) flagged(ctx context.Context, res filter.Result, msg repository.Message) error {
+	err := s.db.Audit(ctx, repository.AuditEntry{
+		RoomID:    &msg.RoomID,
+		Actor:     "filter:" + res.Filter,
+		Action:    repository.AuditFlag,
+		Target:    msg.Sender,
+		MessageID: &msg.ID,
+		Reason:    res.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	members, err := s.db.ListRoomMembers(ctx, msg.RoomID)
+	if err != nil {
+		return err
+	}
+	var moderators []string
+	for _, m := range members {
+		if rank(m.Role) >= rank(repository.RoleModerator) {
+			moderators = append(moderators, m.Member)
+		}
+	}
+	s.notifier.Notify(ctx, moderators, Event{
+		Type:    EventMessageFlagged,
+		RoomID:  &msg.RoomID,
+		Message: &msg,
+		Reason:  res.Reason,
+	})
+	return nil
+}
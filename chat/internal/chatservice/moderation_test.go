@@ -0,0 +1,140 @@
+package chatservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+var roles = map[string]string{
+	"owner":  repository.RoleOwner,
+	"mod":    repository.RoleModerator,
+	"mod2":   repository.RoleModerator,
+	"member": repository.RoleMember,
+}
+
+// moderations are the actions of moderators on a member.
+var moderations = map[string]func(svc Service, user string, roomID uuid.UUID, member string) error{
+	"kick": func(svc Service, user string, roomID uuid.UUID, member string) error {
+		return svc.Kick(context.Background(), user, roomID, member, "spam")
+	},
+	"mute": func(svc Service, user string, roomID uuid.UUID, member string) error {
+		return svc.Mute(context.Background(), user, roomID, member, time.Hour, "spam")
+	},
+	"ban": func(svc Service, user string, roomID uuid.UUID, member string) error {
+		return svc.Ban(context.Background(), user, roomID, member, "spam")
+	},
+}
+
+// Moderators act on the members ranking below them only.
+func TestModerationRanks(t *testing.T) {
+	tests := []struct {
+		actor, target string
+		want          error
+	}{
+		{"owner", "mod", nil},
+		{"owner", "member", nil},
+		{"mod", "member", nil},
+		{"mod", "mod2", ErrOutranked},
+		{"mod", "owner", ErrOutranked},
+		{"owner", "owner", ErrOutranked},
+		{"member", "member", ErrNotModerator},
+		{"stranger", "member", ErrNotMember},
+	}
+	for name, moderate := range moderations {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.actor+" on "+tt.target, func(t *testing.T) {
+				db := newMemRepo()
+				roomID := db.room(repository.RoomKindGroup, roles)
+				svc, _ := newTestService(t, db, Files{})
+				err := moderate(svc, tt.actor, roomID, tt.target)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("%s = %v, want %v", name, err, tt.want)
+				}
+				if tt.want != nil && len(db.audit) != 0 {
+					t.Errorf("refused %s audited %+v", name, db.audit)
+				}
+			})
+		}
+	}
+}
+
+func TestModerationNonMembers(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindGroup, roles)
+	svc, _ := newTestService(t, db, Files{})
+
+	for _, name := range []string{"kick", "mute"} {
+		if err := moderations[name](svc, "mod", roomID, "stranger"); !errors.Is(err, ErrMemberNotFound) {
+			t.Errorf("%s of a stranger = %v, want %v", name, err, ErrMemberNotFound)
+		}
+	}
+	// users can be banned before they join
+	if err := moderations["ban"](svc, "mod", roomID, "stranger"); err != nil {
+		t.Errorf("ban of a stranger = %v", err)
+	}
+	if !db.banned[roomID]["stranger"] {
+		t.Error("stranger not banned")
+	}
+}
+
+func TestModerationDirect(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindDirect, map[string]string{
+		"alice": repository.RoleMember,
+		"bob":   repository.RoleMember,
+	})
+	svc, _ := newTestService(t, db, Files{})
+	for name, moderate := range moderations {
+		if err := moderate(svc, "alice", roomID, "bob"); !errors.Is(err, ErrUnmoderated) {
+			t.Errorf("%s in a direct room = %v, want %v", name, err, ErrUnmoderated)
+		}
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindGroup, roles)
+	svc, n := newTestService(t, db, Files{})
+	ctx := context.Background()
+
+	if err := svc.SetRole(ctx, "mod", roomID, "member", repository.RoleModerator); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("SetRole by a moderator = %v, want %v", err, ErrNotOwner)
+	}
+	if err := svc.SetRole(ctx, "owner", roomID, "member", repository.RoleModerator); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	if got := db.members[roomID]["member"].Role; got != repository.RoleModerator {
+		t.Errorf("role %q, want %q", got, repository.RoleModerator)
+	}
+	if len(n.events) != 1 || n.events[0].ev.Type != EventMemberRole || len(n.events[0].users) != len(roles) {
+		t.Errorf("notified %+v, want the room told of the role", n.events)
+	}
+}
+
+func TestKickNotifiesKicked(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindGroup, roles)
+	svc, n := newTestService(t, db, Files{})
+	if err := svc.Kick(context.Background(), "mod", roomID, "member", "spam"); err != nil {
+		t.Fatalf("Kick: %v", err)
+	}
+	if _, ok := db.members[roomID]["member"]; ok {
+		t.Error("kicked member still in the room")
+	}
+	if len(n.events) != 1 || n.events[0].ev.Type != EventMemberKicked {
+		t.Fatalf("notified %+v, want a kick", n.events)
+	}
+	told := false
+	for _, u := range n.events[0].users {
+		told = told || u == "member"
+	}
+	if !told {
+		t.Errorf("kick told %v, not the kicked member", n.events[0].users)
+	}
+}
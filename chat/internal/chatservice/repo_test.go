@@ -0,0 +1,242 @@
+package chatservice
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+// memRepo keeps rooms and their members in memory. The methods the tests
+// do not need are left to the embedded nil Repository and panic.
+type memRepo struct {
+	repository.Repository
+
+	mu      sync.Mutex
+	rooms   map[uuid.UUID]repository.Room
+	members map[uuid.UUID]map[string]repository.Member
+	banned  map[uuid.UUID]map[string]bool
+	audit   []repository.AuditEntry
+	// messages holds the ids of the messages of each room, read the read
+	// cursors of its members.
+	messages map[uuid.UUID][]int64
+	read     map[uuid.UUID]map[string]int64
+	// matches are what searches find before they are narrowed to the
+	// rooms of the searcher, newest first.
+	matches     []repository.Message
+	attachments map[uuid.UUID]repository.Attachment
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		rooms:       make(map[uuid.UUID]repository.Room),
+		members:     make(map[uuid.UUID]map[string]repository.Member),
+		banned:      make(map[uuid.UUID]map[string]bool),
+		messages:    make(map[uuid.UUID][]int64),
+		read:        make(map[uuid.UUID]map[string]int64),
+		attachments: make(map[uuid.UUID]repository.Attachment),
+	}
+}
+
+// room adds a room of kind with members by their role.
+func (r *memRepo) room(kind string, roles map[string]string) uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := uuid.New()
+	r.rooms[id] = repository.Room{ID: id, Kind: kind}
+	r.members[id] = make(map[string]repository.Member)
+	for member, role := range roles {
+		r.members[id][member] = repository.Member{Member: member, Role: role}
+	}
+	return id
+}
+
+func (r *memRepo) GetRoom(_ context.Context, id uuid.UUID) (repository.Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room, ok := r.rooms[id]
+	if !ok {
+		return repository.Room{}, repository.ErrNotFound
+	}
+	return room, nil
+}
+
+func (r *memRepo) IsMember(_ context.Context, roomID uuid.UUID, member string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.members[roomID][member]
+	return ok, nil
+}
+
+func (r *memRepo) GetMember(_ context.Context, roomID uuid.UUID, member string) (repository.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[roomID][member]
+	if !ok {
+		return repository.Member{}, repository.ErrNotFound
+	}
+	return m, nil
+}
+
+func (r *memRepo) ListMembers(_ context.Context, roomID uuid.UUID) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var members []string
+	for m := range r.members[roomID] {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (r *memRepo) SetRole(_ context.Context, e repository.AuditEntry, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[*e.RoomID][e.Target]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	m.Role = role
+	r.members[*e.RoomID][e.Target] = m
+	r.audit = append(r.audit, e)
+	return nil
+}
+
+func (r *memRepo) Mute(_ context.Context, e repository.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[*e.RoomID][e.Target]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	m.MutedUntil = e.ExpiresAt
+	r.members[*e.RoomID][e.Target] = m
+	r.audit = append(r.audit, e)
+	return nil
+}
+
+func (r *memRepo) Kick(_ context.Context, e repository.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.members[*e.RoomID][e.Target]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(r.members[*e.RoomID], e.Target)
+	r.audit = append(r.audit, e)
+	return nil
+}
+
+func (r *memRepo) Ban(_ context.Context, e repository.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.members[*e.RoomID], e.Target)
+	if r.banned[*e.RoomID] == nil {
+		r.banned[*e.RoomID] = make(map[string]bool)
+	}
+	r.banned[*e.RoomID][e.Target] = true
+	r.audit = append(r.audit, e)
+	return nil
+}
+
+func (r *memRepo) MarkRead(_ context.Context, roomID uuid.UUID, member string, messageID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.members[roomID][member]; !ok || !slices.Contains(r.messages[roomID], messageID) {
+		return 0, repository.ErrNotFound
+	}
+	if r.read[roomID] == nil {
+		r.read[roomID] = make(map[string]int64)
+	}
+	last := max(r.read[roomID][member], messageID)
+	r.read[roomID][member] = last
+	return last, nil
+}
+
+// SearchMessages narrows matches as the query would.
+func (r *memRepo) SearchMessages(_ context.Context, q repository.SearchQuery) ([]repository.SearchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := []repository.SearchResult{}
+	for _, m := range r.matches {
+		if _, ok := r.members[m.RoomID][q.Member]; !ok {
+			continue
+		}
+		if (q.RoomID != uuid.Nil && m.RoomID != q.RoomID) || (q.Before > 0 && m.ID >= q.Before) {
+			continue
+		}
+		if len(results) == q.Limit {
+			break
+		}
+		results = append(results, repository.SearchResult{Message: m})
+	}
+	return results, nil
+}
+
+func (r *memRepo) ListReactions(context.Context, ...int64) (map[int64][]repository.Reaction, error) {
+	return nil, nil
+}
+
+func (r *memRepo) ListAttachments(context.Context, ...int64) (map[int64][]repository.Attachment, error) {
+	return nil, nil
+}
+
+func (r *memRepo) CreateAttachment(_ context.Context, a repository.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attachments[a.ID] = a
+	return nil
+}
+
+func (r *memRepo) GetAttachment(_ context.Context, id uuid.UUID) (repository.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attachments[id]
+	if !ok {
+		return repository.Attachment{}, repository.ErrNotFound
+	}
+	return a, nil
+}
+
+func (r *memRepo) MarkUploaded(
+	_ context.Context,
+	id uuid.UUID,
+	size int64,
+	contentType, thumbnail string,
+) (repository.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attachments[id]
+	if !ok || a.Status != repository.AttachmentPending {
+		return repository.Attachment{}, repository.ErrNotFound
+	}
+	a.Size, a.ContentType, a.Thumbnail = size, contentType, thumbnail
+	a.Status = repository.AttachmentReady
+	r.attachments[id] = a
+	return a, nil
+}
+
+// notifications records what the service notified.
+type notifications struct {
+	mu     sync.Mutex
+	events []notification
+}
+
+type notification struct {
+	users []string
+	ev    Event
+}
+
+func (n *notifications) Notify(_ context.Context, users []string, ev Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, notification{users: users, ev: ev})
+}
+
+func newTestService(t *testing.T, db repository.Repository, files Files) (Service, *notifications) {
+	t.Helper()
+	n := &notifications{}
+	return NewBasicService(db, n, files, nil, Integrations{}, "test"), n
+}
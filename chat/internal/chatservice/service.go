@@ -0,0 +1,844 @@
+package chatservice
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/filter"
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+type Service interface {
+	CreateRoom(ctx context.Context, user, name string) (repository.Room, error)
+	// ListRooms returns the rooms user is a member of.
+	ListRooms(ctx context.Context, user string) ([]repository.Room, error)
+	JoinRoom(ctx context.Context, user string, roomID uuid.UUID) (repository.Room, error)
+	LeaveRoom(ctx context.Context, user string, roomID uuid.UUID) error
+	// OpenDirect returns the direct room of user and peer, creating it on
+	// first use.
+	OpenDirect(ctx context.Context, user, peer string) (repository.Room, error)
+	// SendMessage runs the message through the filters, stores it and
+	// delivers it to the room's connected members. A non zero parentID makes it a reply in the
+	// thread of that message. The attachments attachmentIDs are sent with
+	// it.
+	SendMessage(
+		ctx context.Context,
+		user string,
+		roomID uuid.UUID,
+		parentID int64,
+		body string,
+		attachmentIDs []uuid.UUID,
+	) (repository.Message, error)
+	// EditMessage replaces the body of a message user sent. The new body
+	// goes through the filters like a new message.
+	EditMessage(ctx context.Context, user string, roomID uuid.UUID, messageID int64, body string) (repository.Message, error)
+	// DeleteMessage turns a message user sent into a tombstone. Moderators
+	// may delete the messages of others.
+	DeleteMessage(ctx context.Context, user string, roomID uuid.UUID, messageID int64) error
+	// MessageEdits returns the previous bodies of a message, oldest first.
+	MessageEdits(ctx context.Context, user string, roomID uuid.UUID, messageID int64) ([]repository.Edit, error)
+	// React adds the reaction of user with emoji to a message and returns
+	// the message's reactions.
+	React(ctx context.Context, user string, roomID uuid.UUID, messageID int64, emoji string) ([]repository.Reaction, error)
+	Unreact(ctx context.Context, user string, roomID uuid.UUID, messageID int64, emoji string) ([]repository.Reaction, error)
+	// History pages through a room's messages, newest first, leaving out
+	// replies. An empty cursor starts at the newest message.
+	History(ctx context.Context, user string, roomID uuid.UUID, cursor string, limit int) (Page, error)
+	// Thread pages through the replies to a message like History.
+	Thread(ctx context.Context, user string, roomID uuid.UUID, parentID int64, cursor string, limit int) (Page, error)
+	// Search pages through the messages of user's rooms matching text,
+	// newest first.
+	Search(ctx context.Context, user, text string, filter SearchFilter, cursor string, limit int) (SearchPage, error)
+	// Typing tells the room's members that user is typing.
+	Typing(ctx context.Context, user string, roomID uuid.UUID) error
+	// SetPresence tells everyone sharing a room with user that they came
+	// online or went offline.
+	SetPresence(ctx context.Context, user string, online bool) error
+	// Seen records that users are still connected to this replica, which
+	// tells how long they have been offline once they are gone.
+	Seen(ctx context.Context, users []string) error
+
+	// MarkRead moves the read cursor of user in the room forward to the
+	// message messageID.
+	MarkRead(ctx context.Context, user string, roomID uuid.UUID, messageID int64) error
+	// Unread returns the read cursor and unread count of every room of user.
+	Unread(ctx context.Context, user string) ([]repository.Unread, error)
+	// Sync returns the messages of user's rooms newer than the message
+	// since, oldest first, for clients catching up after a reconnect. A
+	// zero since returns the unread messages.
+	Sync(ctx context.Context, user string, since int64, limit int) (SyncPage, error)
+
+	// RequestUpload checks that an attachment of size bytes and the media
+	// type contentType is allowed in the room, and returns where to upload
+	// it.
+	RequestUpload(
+		ctx context.Context,
+		user string,
+		roomID uuid.UUID,
+		name, contentType string,
+		size int64,
+	) (UploadSlot, error)
+	// Upload stores the content of an attachment user requested a slot for.
+	Upload(ctx context.Context, user string, id uuid.UUID, content io.Reader) (repository.Attachment, error)
+	// OpenAttachment returns the content of an attachment, or of its
+	// thumbnail, to a member of its room. The caller closes it.
+	OpenAttachment(ctx context.Context, user string, id uuid.UUID, thumbnail bool) (Blob, error)
+	// MakeThumbnail makes the thumbnail of an uploaded image and tells the
+	// room once it is ready.
+	MakeThumbnail(ctx context.Context, id uuid.UUID) error
+	// SweepUploads drops upload slots that expired unused and returns the
+	// images still waiting for a thumbnail.
+	SweepUploads(ctx context.Context) ([]uuid.UUID, error)
+
+	// Members returns the members of a room with their roles.
+	Members(ctx context.Context, user string, roomID uuid.UUID) ([]repository.Member, error)
+	// SetRole makes member a moderator or member of a group room, or hands
+	// the room over to them. Only the owner can.
+	SetRole(ctx context.Context, user string, roomID uuid.UUID, member, role string) error
+	// Mute keeps member from posting in the room for d. Moderators can only
+	// mute, kick and ban members of a lower role.
+	Mute(ctx context.Context, user string, roomID uuid.UUID, member string, d time.Duration, reason string) error
+	Unmute(ctx context.Context, user string, roomID uuid.UUID, member string) error
+	Kick(ctx context.Context, user string, roomID uuid.UUID, member, reason string) error
+	// Ban kicks member and keeps them from joining again.
+	Ban(ctx context.Context, user string, roomID uuid.UUID, member, reason string) error
+	Unban(ctx context.Context, user string, roomID uuid.UUID, member string) error
+	// AuditLog pages through a room's moderation log, newest first. Only
+	// moderators can.
+	AuditLog(ctx context.Context, user string, roomID uuid.UUID, cursor string, limit int) (AuditPage, error)
+	// Block hides the direct room of user and peer and keeps them from
+	// messaging each other there.
+	Block(ctx context.Context, user, peer string) error
+	Unblock(ctx context.Context, user, peer string) error
+	Blocks(ctx context.Context, user string) ([]repository.Block, error)
+
+	// CreateBot registers a bot of user. The rooms it is added to call
+	// webhookURL with their messages starting with one of its commands or
+	// mentioning it, webhookURL must be https and must not name a
+	// loopback, private or link local address.
+	CreateBot(ctx context.Context, user, name, webhookURL string, commands []string) (BotCredentials, error)
+	// Bots returns the bots of user.
+	Bots(ctx context.Context, user string) ([]repository.Bot, error)
+	DeleteBot(ctx context.Context, user string, id uuid.UUID) error
+	// AddBot adds a bot to a room. Only moderators can, bots are removed
+	// by kicking them.
+	AddBot(ctx context.Context, user string, roomID, botID uuid.UUID) error
+	// CreateWebhook returns an incoming webhook posting into the room.
+	// Only moderators can manage webhooks.
+	CreateWebhook(ctx context.Context, user string, roomID uuid.UUID, name string) (WebhookCredentials, error)
+	Webhooks(ctx context.Context, user string, roomID uuid.UUID) ([]repository.Webhook, error)
+	DeleteWebhook(ctx context.Context, user string, roomID, id uuid.UUID) error
+	// PostWebhook posts body into the room of the webhook id, given its
+	// token.
+	PostWebhook(ctx context.Context, id uuid.UUID, token, body string) (repository.Message, error)
+	// AuthenticateBot returns the member a bot token stands for.
+	AuthenticateBot(ctx context.Context, token string) (string, error)
+}
+
+// Page is a slice of a room's history. NextCursor fetches the following,
+// older, page and is empty on the last one.
+type Page struct {
+	Messages   []repository.Message `json:"messages"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// SearchFilter narrows a search, zero fields are left out.
+type SearchFilter struct {
+	RoomID uuid.UUID
+	Sender string
+	// From and To bound when messages were sent, To excluded.
+	From time.Time
+	To   time.Time
+}
+
+// SearchPage is a page of search results, paged like Page.
+type SearchPage struct {
+	Results    []repository.SearchResult `json:"results"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+// SyncPage is a batch of missed messages. LastID is the since of the next
+// batch, More tells whether there is one.
+type SyncPage struct {
+	Messages []repository.Message `json:"messages"`
+	LastID   int64                `json:"last_id"`
+	More     bool                 `json:"more"`
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+var (
+	ErrRoomNotFound    = errors.New("room not found")
+	ErrNotMember       = errors.New("not a member of this room")
+	ErrDirectRoom      = errors.New("direct rooms cannot be joined or left")
+	ErrDirectToSelf    = errors.New("cannot open a direct room with yourself")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotSender       = errors.New("only the sender can change a message")
+	ErrInvalidParent   = errors.New("replies must answer a message of the same room")
+)
+
+// New returns the service of the replica named replica.
+func New(
+	logger log.Logger,
+	db repository.Repository,
+	notifier Notifier,
+	files Files,
+	filters filter.Filter,
+	integrations Integrations,
+	replica string,
+) Service {
+	var svc Service
+	{
+		svc = NewBasicService(db, notifier, files, filters, integrations, replica)
+		svc = LoggingMiddleware(logger)(svc)
+	}
+	return svc
+}
+
+type basicService struct {
+	db           repository.Repository
+	notifier     Notifier
+	files        Files
+	filters      filter.Filter
+	integrations Integrations
+	replica      string
+}
+
+func NewBasicService(
+	db repository.Repository,
+	notifier Notifier,
+	files Files,
+	filters filter.Filter,
+	integrations Integrations,
+	replica string,
+) Service {
+	return basicService{
+		db:           db,
+		notifier:     notifier,
+		files:        files,
+		filters:      filters,
+		integrations: integrations,
+		replica:      replica,
+	}
+}
+
+func (s basicService) CreateRoom(ctx context.Context, user, name string) (repository.Room, error) {
+	room := repository.Room{
+		ID:        uuid.New(),
+		Kind:      repository.RoomKindGroup,
+		Name:      name,
+		CreatedBy: user,
+	}
+	if err := s.db.CreateRoom(ctx, room, user); err != nil {
+		return repository.Room{}, err
+	}
+	// CreatedAt is set by the database
+	return s.db.GetRoom(ctx, room.ID)
+}
+
+func (s basicService) ListRooms(ctx context.Context, user string) ([]repository.Room, error) {
+	return s.db.ListRooms(ctx, user)
+}
+
+func (s basicService) JoinRoom(ctx context.Context, user string, roomID uuid.UUID) (repository.Room, error) {
+	room, err := s.getRoom(ctx, roomID)
+	if err != nil {
+		return repository.Room{}, err
+	}
+	if room.Kind == repository.RoomKindDirect {
+		return repository.Room{}, ErrDirectRoom
+	}
+	banned, err := s.db.IsBanned(ctx, roomID, user)
+	if err != nil {
+		return repository.Room{}, err
+	}
+	if banned {
+		return repository.Room{}, ErrBanned
+	}
+	if err := s.db.AddMember(ctx, roomID, user); err != nil {
+		return repository.Room{}, err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMemberJoined, RoomID: &roomID, Member: user})
+	return room, nil
+}
+
+func (s basicService) LeaveRoom(ctx context.Context, user string, roomID uuid.UUID) error {
+	room, err := s.getRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if room.Kind == repository.RoomKindDirect {
+		return ErrDirectRoom
+	}
+	m, err := s.db.GetMember(ctx, roomID, user)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if m.Role == repository.RoleOwner {
+		members, err := s.db.ListMembers(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		// the last member may leave the room behind
+		if len(members) > 1 {
+			return ErrOwnerLeaving
+		}
+	}
+	if err := s.db.RemoveMember(ctx, roomID, user); err != nil {
+		return err
+	}
+	ev := Event{Type: EventMemberLeft, RoomID: &roomID, Member: user}
+	s.notifier.Notify(ctx, []string{user}, ev)
+	s.notifyRoom(ctx, roomID, ev)
+	return nil
+}
+
+func (s basicService) OpenDirect(ctx context.Context, user, peer string) (repository.Room, error) {
+	if user == peer {
+		return repository.Room{}, ErrDirectToSelf
+	}
+	blocked, err := s.db.IsBlocked(ctx, user, peer)
+	if err != nil {
+		return repository.Room{}, err
+	}
+	if blocked {
+		return repository.Room{}, ErrBlocked
+	}
+	return s.db.GetOrCreateDirectRoom(
+		ctx,
+		repository.Room{ID: uuid.New(), CreatedBy: user},
+		user,
+		peer,
+	)
+}
+
+func (s basicService) SendMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	parentID int64,
+	body string,
+	attachmentIDs []uuid.UUID,
+) (repository.Message, error) {
+	if _, err := s.poster(ctx, user, roomID); err != nil {
+		return repository.Message{}, err
+	}
+	if err := s.checkDirect(ctx, user, roomID); err != nil {
+		return repository.Message{}, err
+	}
+	res, err := s.filter(ctx, user, roomID, nil, body)
+	if err != nil {
+		return repository.Message{}, err
+	}
+	msg := repository.Message{
+		RoomID: roomID,
+		Sender: user,
+		Body:   body,
+	}
+	if parentID != 0 {
+		parent, err := s.db.GetMessage(ctx, roomID, parentID)
+		if errors.Is(err, repository.ErrNotFound) || parent.DeletedAt != nil {
+			return repository.Message{}, ErrInvalidParent
+		}
+		if err != nil {
+			return repository.Message{}, err
+		}
+		// threads do not nest, answering a reply answers its thread
+		if parent.ParentID != nil {
+			parentID = *parent.ParentID
+		}
+		msg.ParentID = &parentID
+	}
+	err = s.db.CreateMessage(ctx, &msg, attachmentIDs...)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Message{}, ErrInvalidAttachment
+	}
+	if err != nil {
+		return repository.Message{}, err
+	}
+	if len(attachmentIDs) > 0 {
+		if err := s.decorateOne(ctx, &msg); err != nil {
+			return repository.Message{}, err
+		}
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMessage, RoomID: &roomID, Message: &msg})
+	if res.Verdict == filter.Flag {
+		if err := s.flagged(ctx, res, msg); err != nil {
+			return repository.Message{}, err
+		}
+	}
+	s.dispatch(ctx, msg)
+	return msg, nil
+}
+
+func (s basicService) EditMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	body string,
+) (repository.Message, error) {
+	if _, err := s.ownMessage(ctx, user, roomID, messageID); err != nil {
+		return repository.Message{}, err
+	}
+	if _, err := s.poster(ctx, user, roomID); err != nil {
+		return repository.Message{}, err
+	}
+	if err := s.checkDirect(ctx, user, roomID); err != nil {
+		return repository.Message{}, err
+	}
+	res, err := s.filter(ctx, user, roomID, &messageID, body)
+	if err != nil {
+		return repository.Message{}, err
+	}
+	msg, err := s.db.EditMessage(ctx, roomID, messageID, body)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return repository.Message{}, err
+	}
+	if err := s.decorateOne(ctx, &msg); err != nil {
+		return repository.Message{}, err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMessageEdited, RoomID: &roomID, Message: &msg})
+	if res.Verdict == filter.Flag {
+		if err := s.flagged(ctx, res, msg); err != nil {
+			return repository.Message{}, err
+		}
+	}
+	return msg, nil
+}
+
+func (s basicService) DeleteMessage(ctx context.Context, user string, roomID uuid.UUID, messageID int64) error {
+	own, err := s.getMessage(ctx, user, roomID, messageID)
+	if err != nil {
+		return err
+	}
+	if own.DeletedAt != nil {
+		return ErrMessageNotFound
+	}
+	if own.Sender != user {
+		if err := s.canModerate(ctx, user, roomID, own.Sender); err != nil {
+			return err
+		}
+	}
+	msg, err := s.db.DeleteMessage(ctx, roomID, messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if own.Sender != user {
+		err := s.db.Audit(ctx, repository.AuditEntry{
+			RoomID:    &roomID,
+			Actor:     user,
+			Action:    repository.AuditDelete,
+			Target:    own.Sender,
+			MessageID: &messageID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventMessageDeleted, RoomID: &roomID, Message: &msg})
+	return nil
+}
+
+func (s basicService) MessageEdits(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+) ([]repository.Edit, error) {
+	if _, err := s.getMessage(ctx, user, roomID, messageID); err != nil {
+		return nil, err
+	}
+	return s.db.ListEdits(ctx, messageID)
+}
+
+func (s basicService) React(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	emoji string,
+) ([]repository.Reaction, error) {
+	if _, err := s.poster(ctx, user, roomID); err != nil {
+		return nil, err
+	}
+	return s.updateReactions(ctx, user, roomID, messageID, func() error {
+		return s.db.AddReaction(ctx, messageID, user, emoji)
+	})
+}
+
+func (s basicService) Unreact(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	emoji string,
+) ([]repository.Reaction, error) {
+	return s.updateReactions(ctx, user, roomID, messageID, func() error {
+		return s.db.RemoveReaction(ctx, messageID, user, emoji)
+	})
+}
+
+// updateReactions runs update on a message user can see and tells the
+// room about the message's reactions.
+func (s basicService) updateReactions(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+	update func() error,
+) ([]repository.Reaction, error) {
+	msg, err := s.getMessage(ctx, user, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(); err != nil {
+		return nil, err
+	}
+	if msg.Reactions, err = s.reactions(ctx, msg.ID); err != nil {
+		return nil, err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventReactions, RoomID: &roomID, Message: &msg})
+	return msg.Reactions, nil
+}
+
+func (s basicService) History(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	cursor string,
+	limit int,
+) (Page, error) {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return Page{}, err
+	}
+	return s.page(ctx, cursor, limit, func(before int64, limit int) ([]repository.Message, error) {
+		return s.db.ListMessages(ctx, roomID, before, limit)
+	})
+}
+
+func (s basicService) Thread(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	parentID int64,
+	cursor string,
+	limit int,
+) (Page, error) {
+	if _, err := s.getMessage(ctx, user, roomID, parentID); err != nil {
+		return Page{}, err
+	}
+	return s.page(ctx, cursor, limit, func(before int64, limit int) ([]repository.Message, error) {
+		return s.db.ListReplies(ctx, parentID, before, limit)
+	})
+}
+
+func (s basicService) Search(
+	ctx context.Context,
+	user, text string,
+	filter SearchFilter,
+	cursor string,
+	limit int,
+) (SearchPage, error) {
+	if filter.RoomID != uuid.Nil {
+		if err := s.checkMember(ctx, user, filter.RoomID); err != nil {
+			return SearchPage{}, err
+		}
+	}
+	before, err := decodeCursor(cursor)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	results, err := s.db.SearchMessages(ctx, repository.SearchQuery{
+		Member: user,
+		Text:   text,
+		RoomID: filter.RoomID,
+		Sender: filter.Sender,
+		From:   filter.From,
+		To:     filter.To,
+		Before: before,
+		Limit:  limit + 1,
+	})
+	if err != nil {
+		return SearchPage{}, err
+	}
+	page := SearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		page.NextCursor = encodeCursor(results[limit-1].ID)
+	}
+	msgs := make([]repository.Message, len(page.Results))
+	for i, r := range page.Results {
+		msgs[i] = r.Message
+	}
+	if err := s.decorate(ctx, msgs); err != nil {
+		return SearchPage{}, err
+	}
+	for i := range page.Results {
+		page.Results[i].Message = msgs[i]
+	}
+	return page, nil
+}
+
+// page reads a page of messages with list, given the id messages must be
+// older than and how many to return.
+func (s basicService) page(
+	ctx context.Context,
+	cursor string,
+	limit int,
+	list func(before int64, limit int) ([]repository.Message, error),
+) (Page, error) {
+	before, err := decodeCursor(cursor)
+	if err != nil {
+		return Page{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	// one extra message tells whether there is a next page
+	msgs, err := list(before, limit+1)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Messages: msgs}
+	if len(msgs) > limit {
+		page.Messages = msgs[:limit]
+		page.NextCursor = encodeCursor(msgs[limit-1].ID)
+	}
+	if err := s.decorate(ctx, page.Messages); err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+func (s basicService) Typing(ctx context.Context, user string, roomID uuid.UUID) error {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return err
+	}
+	s.notifyRoom(ctx, roomID, Event{Type: EventTyping, RoomID: &roomID, Member: user})
+	return nil
+}
+
+func (s basicService) SetPresence(ctx context.Context, user string, online bool) error {
+	if err := s.db.TouchPresence(ctx, s.replica, user); err != nil {
+		return err
+	}
+	contacts, err := s.db.ListContacts(ctx, user)
+	if err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, contacts, Event{Type: EventPresence, Member: user, Online: &online})
+	return nil
+}
+
+func (s basicService) Seen(ctx context.Context, users []string) error {
+	if len(users) == 0 {
+		return nil
+	}
+	return s.db.TouchPresence(ctx, s.replica, users...)
+}
+
+func (s basicService) MarkRead(ctx context.Context, user string, roomID uuid.UUID, messageID int64) error {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return err
+	}
+	lastRead, err := s.db.MarkRead(ctx, roomID, user, messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, []string{user}, Event{Type: EventRead, RoomID: &roomID, LastRead: lastRead})
+	return nil
+}
+
+func (s basicService) Unread(ctx context.Context, user string) ([]repository.Unread, error) {
+	return s.db.ListUnread(ctx, user)
+}
+
+func (s basicService) Sync(ctx context.Context, user string, since int64, limit int) (SyncPage, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	msgs, err := s.db.ListMessagesSince(ctx, user, since, limit+1)
+	if err != nil {
+		return SyncPage{}, err
+	}
+	page := SyncPage{Messages: msgs, LastID: since}
+	if len(msgs) > limit {
+		page.Messages = msgs[:limit]
+		page.More = true
+	}
+	if n := len(page.Messages); n > 0 {
+		page.LastID = page.Messages[n-1].ID
+	}
+	if err := s.decorate(ctx, page.Messages); err != nil {
+		return SyncPage{}, err
+	}
+	return page, nil
+}
+
+// getMessage returns a message of a room user is a member of.
+func (s basicService) getMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+) (repository.Message, error) {
+	if err := s.checkMember(ctx, user, roomID); err != nil {
+		return repository.Message{}, err
+	}
+	msg, err := s.db.GetMessage(ctx, roomID, messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Message{}, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// ownMessage is getMessage for messages user sent and has not deleted.
+func (s basicService) ownMessage(
+	ctx context.Context,
+	user string,
+	roomID uuid.UUID,
+	messageID int64,
+) (repository.Message, error) {
+	msg, err := s.getMessage(ctx, user, roomID, messageID)
+	if err != nil {
+		return repository.Message{}, err
+	}
+	if msg.DeletedAt != nil {
+		return repository.Message{}, ErrMessageNotFound
+	}
+	if msg.Sender != user {
+		return repository.Message{}, ErrNotSender
+	}
+	return msg, nil
+}
+
+// reactions returns the reactions to the message id.
+func (s basicService) reactions(ctx context.Context, id int64) ([]repository.Reaction, error) {
+	reactions, err := s.db.ListReactions(ctx, id)
+	return reactions[id], err
+}
+
+// decorate fills in the reactions and attachments of msgs. Tombstones get
+// neither.
+func (s basicService) decorate(ctx context.Context, msgs []repository.Message) error {
+	ids := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.DeletedAt == nil {
+			ids = append(ids, msg.ID)
+		}
+	}
+	reactions, err := s.db.ListReactions(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	attachments, err := s.db.ListAttachments(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Reactions = reactions[msgs[i].ID]
+		msgs[i].Attachments = attachments[msgs[i].ID]
+		for j := range msgs[i].Attachments {
+			s.files.sign(&msgs[i].Attachments[j])
+		}
+	}
+	return nil
+}
+
+// decorateOne is decorate for a single message.
+func (s basicService) decorateOne(ctx context.Context, msg *repository.Message) error {
+	msgs := []repository.Message{*msg}
+	if err := s.decorate(ctx, msgs); err != nil {
+		return err
+	}
+	*msg = msgs[0]
+	return nil
+}
+
+func (s basicService) getRoom(ctx context.Context, roomID uuid.UUID) (repository.Room, error) {
+	room, err := s.db.GetRoom(ctx, roomID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Room{}, ErrRoomNotFound
+	}
+	return room, err
+}
+
+// checkMember fails with ErrRoomNotFound for unknown rooms and ErrNotMember
+// for rooms user is not in.
+func (s basicService) checkMember(ctx context.Context, user string, roomID uuid.UUID) error {
+	ok, err := s.db.IsMember(ctx, roomID, user)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if _, err := s.getRoom(ctx, roomID); err != nil {
+			return err
+		}
+		return ErrNotMember
+	}
+	return nil
+}
+
+// notifyRoom delivers ev to the room's members. Delivery is best effort,
+// clients catch up on missed events through the history.
+func (s basicService) notifyRoom(ctx context.Context, roomID uuid.UUID, ev Event) {
+	members, err := s.db.ListMembers(ctx, roomID)
+	if err != nil {
+		return
+	}
+	s.notifier.Notify(ctx, members, ev)
+}
+
+// Cursors are opaque to clients, they wrap the id of the last message of
+// the previous page.
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
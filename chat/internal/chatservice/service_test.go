@@ -0,0 +1,99 @@
+package chatservice
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+func TestMarkRead(t *testing.T) {
+	db := newMemRepo()
+	roomID := db.room(repository.RoomKindGroup, map[string]string{"alice": repository.RoleMember})
+	other := db.room(repository.RoomKindGroup, map[string]string{"alice": repository.RoleMember})
+	db.messages[roomID] = []int64{1, 2, 3}
+	db.messages[other] = []int64{4}
+	svc, n := newTestService(t, db, Files{})
+	ctx := context.Background()
+
+	if err := svc.MarkRead(ctx, "alice", roomID, 3); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	// the cursor never moves back
+	if err := svc.MarkRead(ctx, "alice", roomID, 2); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if got := db.read[roomID]["alice"]; got != 3 {
+		t.Errorf("read up to %d, want 3", got)
+	}
+	// the other connections of alice are told where the cursor stands
+	for _, e := range n.events {
+		if e.ev.Type != EventRead || e.ev.LastRead != 3 || len(e.users) != 1 || e.users[0] != "alice" {
+			t.Errorf("notified %+v to %v, want alice told of 3", e.ev, e.users)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		roomID uuid.UUID
+		msg    int64
+		want   error
+	}{
+		{"message of another room", "alice", roomID, 4, ErrMessageNotFound},
+		{"not a member", "bob", roomID, 3, ErrNotMember},
+		{"unknown room", "alice", uuid.New(), 3, ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.MarkRead(ctx, tt.user, tt.roomID, tt.msg); !errors.Is(err, tt.want) {
+				t.Errorf("MarkRead = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Searches only find the messages of the searcher's rooms.
+func TestSearchMembership(t *testing.T) {
+	db := newMemRepo()
+	ours := db.room(repository.RoomKindGroup, map[string]string{"alice": repository.RoleMember})
+	theirs := db.room(repository.RoomKindGroup, map[string]string{"bob": repository.RoleMember})
+	for id := int64(5); id > 0; id-- {
+		roomID := ours
+		if id%2 == 0 {
+			roomID = theirs
+		}
+		db.matches = append(db.matches, repository.Message{ID: id, RoomID: roomID, Body: "hello"})
+	}
+	svc, _ := newTestService(t, db, Files{})
+	ctx := context.Background()
+
+	var ids []int64
+	cursor := ""
+	for {
+		page, err := svc.Search(ctx, "alice", "hello", SearchFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		for _, r := range page.Results {
+			ids = append(ids, r.ID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := []int64{5, 3, 1}; !slices.Equal(ids, want) {
+		t.Errorf("found %v, want %v", ids, want)
+	}
+
+	if _, err := svc.Search(ctx, "alice", "hello", SearchFilter{RoomID: theirs}, "", 0); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Search in another room = %v, want %v", err, ErrNotMember)
+	}
+	if _, err := svc.Search(ctx, "alice", "hello", SearchFilter{}, "garbage", 0); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Search with a bad cursor = %v, want %v", err, ErrInvalidCursor)
+	}
+}
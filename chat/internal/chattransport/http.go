@@ -0,0 +1,799 @@
+package chattransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/universal-chat/internal/auth"
+	"github.com/F1zm0n/universal-chat/internal/chatendpoint"
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/signedurl"
+	"github.com/F1zm0n/universal-kit/validate"
+)
+
+// NewHTTPServer serves the REST API and, at /ws, the WebSocket endpoint.
+// Files are uploaded and downloaded under /files with urls signed by
+// signer, incoming webhooks are posted to /hooks. User tokens are checked
+// with users, bot tokens with bots.
+func NewHTTPServer(
+	endpoints chatendpoint.Set,
+	ws http.Handler,
+	signer signedurl.Signer,
+	users auth.Users,
+	bots auth.Bots,
+	logger log.Logger,
+) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(auth.HTTPToContext(users, bots), problem.HTTPToContext()),
+	}
+	m := http.NewServeMux()
+	m.Handle("GET /rooms", httptransport.NewServer(
+		endpoints.ListRoomsEndpoint,
+		decodeHTTPListRoomsRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms", httptransport.NewServer(
+		endpoints.CreateRoomEndpoint,
+		decodeHTTPCreateRoomRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/direct", httptransport.NewServer(
+		endpoints.OpenDirectEndpoint,
+		decodeHTTPOpenDirectRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/join", httptransport.NewServer(
+		endpoints.JoinRoomEndpoint,
+		decodeHTTPRoomRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/leave", httptransport.NewServer(
+		endpoints.LeaveRoomEndpoint,
+		decodeHTTPRoomRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/messages", httptransport.NewServer(
+		endpoints.HistoryEndpoint,
+		decodeHTTPHistoryRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/messages", httptransport.NewServer(
+		endpoints.SendMessageEndpoint,
+		decodeHTTPSendMessageRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PATCH /rooms/{id}/messages/{mid}", httptransport.NewServer(
+		endpoints.EditMessageEndpoint,
+		decodeHTTPEditMessageRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /rooms/{id}/messages/{mid}", httptransport.NewServer(
+		endpoints.DeleteMessageEndpoint,
+		decodeHTTPMessageRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/messages/{mid}/edits", httptransport.NewServer(
+		endpoints.MessageEditsEndpoint,
+		decodeHTTPMessageRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/messages/{mid}/replies", httptransport.NewServer(
+		endpoints.ThreadEndpoint,
+		decodeHTTPThreadRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /rooms/{id}/messages/{mid}/reactions/{emoji}", httptransport.NewServer(
+		endpoints.ReactEndpoint,
+		decodeHTTPReactionRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /rooms/{id}/messages/{mid}/reactions/{emoji}", httptransport.NewServer(
+		endpoints.UnreactEndpoint,
+		decodeHTTPReactionRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/read", httptransport.NewServer(
+		endpoints.MarkReadEndpoint,
+		decodeHTTPMarkReadRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /unread", httptransport.NewServer(
+		endpoints.UnreadEndpoint,
+		decodeHTTPUnreadRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /search", httptransport.NewServer(
+		endpoints.SearchEndpoint,
+		decodeHTTPSearchRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/attachments", httptransport.NewServer(
+		endpoints.RequestUploadEndpoint,
+		decodeHTTPRequestUploadRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /files/{aid}", httptransport.NewServer(
+		endpoints.UploadEndpoint,
+		decodeHTTPUploadRequest(signer),
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /files/{aid}", httptransport.NewServer(
+		endpoints.DownloadEndpoint,
+		decodeHTTPDownloadRequest(signer, false),
+		encodeHTTPDownloadResponse,
+		options...,
+	))
+	m.Handle("GET /files/{aid}/thumbnail", httptransport.NewServer(
+		endpoints.DownloadEndpoint,
+		decodeHTTPDownloadRequest(signer, true),
+		encodeHTTPDownloadResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/members", httptransport.NewServer(
+		endpoints.MembersEndpoint,
+		decodeHTTPRoomRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /rooms/{id}/members/{member}/role", httptransport.NewServer(
+		endpoints.SetRoleEndpoint,
+		decodeHTTPSetRoleRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/members/{member}/mute", httptransport.NewServer(
+		endpoints.MuteEndpoint,
+		decodeHTTPMuteRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /rooms/{id}/members/{member}/mute", httptransport.NewServer(
+		endpoints.UnmuteEndpoint,
+		decodeHTTPMemberRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/members/{member}/kick", httptransport.NewServer(
+		endpoints.KickEndpoint,
+		decodeHTTPModerateRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /rooms/{id}/bans/{member}", httptransport.NewServer(
+		endpoints.BanEndpoint,
+		decodeHTTPModerateRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /rooms/{id}/bans/{member}", httptransport.NewServer(
+		endpoints.UnbanEndpoint,
+		decodeHTTPMemberRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/audit", httptransport.NewServer(
+		endpoints.AuditLogEndpoint,
+		decodeHTTPAuditLogRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /blocks", httptransport.NewServer(
+		endpoints.BlocksEndpoint,
+		decodeHTTPBlocksRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /blocks/{email}", httptransport.NewServer(
+		endpoints.BlockEndpoint,
+		decodeHTTPBlockRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /blocks/{email}", httptransport.NewServer(
+		endpoints.UnblockEndpoint,
+		decodeHTTPBlockRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /bots", httptransport.NewServer(
+		endpoints.CreateBotEndpoint,
+		decodeHTTPCreateBotRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /bots", httptransport.NewServer(
+		endpoints.BotsEndpoint,
+		decodeHTTPBotsRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /bots/{bid}", httptransport.NewServer(
+		endpoints.DeleteBotEndpoint,
+		decodeHTTPBotRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("PUT /rooms/{id}/bots/{bid}", httptransport.NewServer(
+		endpoints.AddBotEndpoint,
+		decodeHTTPAddBotRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /rooms/{id}/webhooks", httptransport.NewServer(
+		endpoints.CreateWebhookEndpoint,
+		decodeHTTPCreateWebhookRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /rooms/{id}/webhooks", httptransport.NewServer(
+		endpoints.WebhooksEndpoint,
+		decodeHTTPRoomRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("DELETE /rooms/{id}/webhooks/{wid}", httptransport.NewServer(
+		endpoints.DeleteWebhookEndpoint,
+		decodeHTTPWebhookRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("POST /hooks/{wid}/{token}", httptransport.NewServer(
+		endpoints.PostWebhookEndpoint,
+		decodeHTTPPostWebhookRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("GET /ws", ws)
+	return m
+}
+
+// maxBodyBytes bounds request bodies, the largest are messages of
+// chatendpoint.MaxBodyLength characters.
+const maxBodyBytes = 1 << 16
+
+// decodeJSON decodes a request body, refusing unknown fields and bodies
+// over maxBodyBytes.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.Invalid(err)
+	}
+	return nil
+}
+
+// decodeOptionalJSON is decodeJSON for bodies that may be left out.
+func decodeOptionalJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return problem.Invalid(err)
+	}
+	return nil
+}
+
+// roomID parses the {id} path segment.
+func roomID(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return uuid.Nil, validate.Errors{"room_id": "must be a valid id"}
+	}
+	return id, nil
+}
+
+// messageRequest parses the {id} and {mid} path segments.
+func messageRequest(r *http.Request) (chatendpoint.MessageRequest, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return chatendpoint.MessageRequest{}, err
+	}
+	mid, err := strconv.ParseInt(r.PathValue("mid"), 10, 64)
+	if err != nil {
+		return chatendpoint.MessageRequest{}, validate.Errors{"message_id": "must be a message id"}
+	}
+	return chatendpoint.MessageRequest{RoomID: id, MessageID: mid}, nil
+}
+
+// memberRequest parses the {id} and {member} path segments.
+func memberRequest(r *http.Request) (chatendpoint.MemberRequest, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return chatendpoint.MemberRequest{}, err
+	}
+	return chatendpoint.MemberRequest{RoomID: id, Member: r.PathValue("member")}, nil
+}
+
+// botID parses the {bid} path segment.
+func botID(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue("bid"))
+	if err != nil {
+		return uuid.Nil, validate.Errors{"bot_id": "must be a valid id"}
+	}
+	return id, nil
+}
+
+// webhookID parses the {wid} path segment.
+func webhookID(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue("wid"))
+	if err != nil {
+		return uuid.Nil, validate.Errors{"webhook_id": "must be a valid id"}
+	}
+	return id, nil
+}
+
+// pageParams parses the cursor and limit query parameters.
+func pageParams(r *http.Request) (cursor string, limit int, err error) {
+	cursor = r.URL.Query().Get("cursor")
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return "", 0, validate.Errors{"limit": "must be a number"}
+		}
+	}
+	return cursor, limit, nil
+}
+
+func decodeHTTPListRoomsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return chatendpoint.ListRoomsRequest{}, nil
+}
+
+func decodeHTTPCreateRoomRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req chatendpoint.CreateRoomRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPOpenDirectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req chatendpoint.OpenDirectRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPRoomRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.RoomRequest{RoomID: id}, nil
+}
+
+func decodeHTTPHistoryRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.HistoryRequest{RoomID: id, Cursor: cursor, Limit: limit}, nil
+}
+
+func decodeHTTPSendMessageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		ParentID      int64       `json:"parent_id"`
+		Body          string      `json:"body"`
+		AttachmentIDs []uuid.UUID `json:"attachment_ids"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return chatendpoint.SendMessageRequest{
+		RoomID:        id,
+		ParentID:      req.ParentID,
+		Body:          req.Body,
+		AttachmentIDs: req.AttachmentIDs,
+	}, nil
+}
+
+func decodeHTTPMessageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return messageRequest(r)
+}
+
+func decodeHTTPEditMessageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	msg, err := messageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		Body string `json:"body"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return chatendpoint.EditMessageRequest{MessageRequest: msg, Body: req.Body}, nil
+}
+
+func decodeHTTPThreadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	msg, err := messageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.ThreadRequest{
+		RoomID:   msg.RoomID,
+		ParentID: msg.MessageID,
+		Cursor:   cursor,
+		Limit:    limit,
+	}, nil
+}
+
+func decodeHTTPReactionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	msg, err := messageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.ReactionRequest{MessageRequest: msg, Emoji: r.PathValue("emoji")}, nil
+}
+
+func decodeHTTPMarkReadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return chatendpoint.MarkReadRequest{RoomID: id, MessageID: req.MessageID}, nil
+}
+
+func decodeHTTPUnreadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return chatendpoint.UnreadRequest{}, nil
+}
+
+func decodeHTTPSearchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.SearchRequest{
+		Query:  q.Get("q"),
+		Cursor: cursor,
+		Limit:  limit,
+	}
+	req.Sender = q.Get("sender")
+	if id := q.Get("room_id"); id != "" {
+		if req.RoomID, err = uuid.Parse(id); err != nil {
+			return nil, validate.Errors{"room_id": "must be a valid id"}
+		}
+	}
+	for field, t := range map[string]*time.Time{"from": &req.From, "to": &req.To} {
+		if v := q.Get(field); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, validate.Errors{field: "must be an RFC 3339 time"}
+			}
+		}
+	}
+	return req, nil
+}
+
+func decodeHTTPMemberRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return memberRequest(r)
+}
+
+func decodeHTTPSetRoleRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	member, err := memberRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.SetRoleRequest{MemberRequest: member}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPModerateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	member, err := memberRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.ModerateRequest{MemberRequest: member}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPMuteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	member, err := memberRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.MuteRequest{ModerateRequest: chatendpoint.ModerateRequest{MemberRequest: member}}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPAuditLogRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.AuditLogRequest{RoomID: id, Cursor: cursor, Limit: limit}, nil
+}
+
+func decodeHTTPBlocksRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return chatendpoint.BlocksRequest{}, nil
+}
+
+func decodeHTTPBlockRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return chatendpoint.BlockRequest{Email: r.PathValue("email")}, nil
+}
+
+func decodeHTTPCreateBotRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req chatendpoint.CreateBotRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPBotsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return chatendpoint.BotsRequest{}, nil
+}
+
+func decodeHTTPBotRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := botID(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.BotRequest{BotID: id}, nil
+}
+
+func decodeHTTPAddBotRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	bid, err := botID(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.AddBotRequest{RoomID: id, BotID: bid}, nil
+}
+
+func decodeHTTPCreateWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.CreateWebhookRequest{RoomID: id}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	wid, err := webhookID(r)
+	if err != nil {
+		return nil, err
+	}
+	return chatendpoint.WebhookRequest{RoomID: id, WebhookID: wid}, nil
+}
+
+func decodeHTTPPostWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	wid, err := webhookID(r)
+	if err != nil {
+		return nil, err
+	}
+	req := chatendpoint.PostWebhookRequest{WebhookID: wid, Token: r.PathValue("token")}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPRequestUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := roomID(r)
+	if err != nil {
+		return nil, err
+	}
+	var req chatendpoint.RequestUploadRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	req.RoomID = id
+	return req, nil
+}
+
+// signedFile checks the signature of a request to /files, resource is the
+// path below it.
+func signedFile(signer signedurl.Signer, r *http.Request, resource string) (uuid.UUID, error) {
+	if err := signer.Verify(r.Method, resource, r.URL.Query(), time.Now()); err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(r.PathValue("aid"))
+	if err != nil {
+		return uuid.Nil, validate.Errors{"attachment_id": "must be a valid id"}
+	}
+	return id, nil
+}
+
+func decodeHTTPUploadRequest(signer signedurl.Signer) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		id, err := signedFile(signer, r, r.PathValue("aid"))
+		if err != nil {
+			return nil, err
+		}
+		return chatendpoint.UploadRequest{AttachmentID: id, Content: r.Body}, nil
+	}
+}
+
+func decodeHTTPDownloadRequest(signer signedurl.Signer, thumbnail bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		resource := r.PathValue("aid")
+		if thumbnail {
+			resource += "/thumbnail"
+		}
+		id, err := signedFile(signer, r, resource)
+		if err != nil {
+			return nil, err
+		}
+		return chatendpoint.DownloadRequest{AttachmentID: id, Thumbnail: thumbnail}, nil
+	}
+}
+
+// encodeHTTPDownloadResponse streams the blob. Images are shown inline,
+// other files are downloaded, and none of them may run scripts.
+func encodeHTTPDownloadResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(chatendpoint.DownloadResponse)
+	if resp.Err != nil {
+		errorEncoder(ctx, resp.Err, w)
+		return nil
+	}
+	blob := resp.Blob
+	defer blob.Content.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(blob.ContentType, "image/") {
+		disposition = "inline"
+	}
+	h := w.Header()
+	h.Set("Content-Type", blob.ContentType)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": blob.Name}))
+	h.Set("Content-Security-Policy", "sandbox")
+	h.Set("X-Content-Type-Options", "nosniff")
+	// the url is signed, it is only good until it expires
+	h.Set("Cache-Control", "private, max-age=3600")
+	if blob.Size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	}
+	_, err := io.Copy(w, blob.Content)
+	return err
+}
+
+// encodeHTTPGenericResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func encodeHTTPGenericResponse(
+	ctx context.Context,
+	w http.ResponseWriter,
+	response interface{},
+) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		errorEncoder(ctx, f.Failed(), w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// serviceName is reported in the problems of this service.
+const serviceName = "chat"
+
+var errorEncoder = problem.ErrorEncoder(serviceName, err2problem)
+
+func err2problem(err error) *problem.Problem {
+	var fields validate.Errors
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated),
+		errors.Is(err, chatservice.ErrInvalidToken):
+		return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+	case errors.As(err, &fields):
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidArgument, "request validation failed").
+			WithDetails(map[string]any{"fields": fields})
+	case errors.Is(err, chatservice.ErrRoomNotFound),
+		errors.Is(err, chatservice.ErrMessageNotFound),
+		errors.Is(err, chatservice.ErrAttachmentNotFound),
+		errors.Is(err, chatservice.ErrMemberNotFound),
+		errors.Is(err, chatservice.ErrNotBanned),
+		errors.Is(err, chatservice.ErrBotNotFound),
+		errors.Is(err, chatservice.ErrWebhookNotFound):
+		return problem.Wrap(http.StatusNotFound, problem.CodeNotFound, err)
+	case errors.Is(err, chatservice.ErrNotMember),
+		errors.Is(err, chatservice.ErrNotSender),
+		errors.Is(err, chatservice.ErrNotUploader),
+		errors.Is(err, chatservice.ErrNotModerator),
+		errors.Is(err, chatservice.ErrNotOwner),
+		errors.Is(err, chatservice.ErrOutranked),
+		errors.Is(err, chatservice.ErrMuted),
+		errors.Is(err, chatservice.ErrBanned),
+		errors.Is(err, chatservice.ErrBlocked),
+		errors.Is(err, chatservice.ErrIntegration),
+		errors.Is(err, signedurl.ErrInvalidSignature),
+		errors.Is(err, signedurl.ErrExpired):
+		return problem.Wrap(http.StatusForbidden, problem.CodePermissionDenied, err)
+	case errors.Is(err, chatservice.ErrDirectRoom),
+		errors.Is(err, chatservice.ErrDirectToSelf),
+		errors.Is(err, chatservice.ErrInvalidCursor),
+		errors.Is(err, chatservice.ErrInvalidParent),
+		errors.Is(err, chatservice.ErrInvalidAttachment),
+		errors.Is(err, chatservice.ErrSizeMismatch),
+		errors.Is(err, chatservice.ErrUnmoderated),
+		errors.Is(err, chatservice.ErrBlockSelf),
+		errors.Is(err, chatservice.ErrInvalidWebhook):
+		return problem.Wrap(http.StatusBadRequest, problem.CodeInvalidArgument, err)
+	case errors.Is(err, chatservice.ErrAlreadyUploaded),
+		errors.Is(err, chatservice.ErrOwnerLeaving),
+		errors.Is(err, chatservice.ErrBotNameTaken):
+		return problem.Wrap(http.StatusConflict, problem.CodeConflict, err)
+	case errors.Is(err, chatservice.ErrFileTooLarge):
+		return problem.Wrap(http.StatusRequestEntityTooLarge, problem.CodeTooLarge, err)
+	case errors.Is(err, chatservice.ErrUnsupportedType),
+		errors.Is(err, chatservice.ErrTypeMismatch):
+		return problem.Wrap(http.StatusUnsupportedMediaType, problem.CodeUnprocessable, err)
+	case errors.Is(err, chatservice.ErrRejected):
+		return problem.Wrap(http.StatusUnprocessableEntity, problem.CodeUnprocessable, err)
+	}
+	return problem.From(err)
+}
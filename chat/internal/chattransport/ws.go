@@ -0,0 +1,217 @@
+package chattransport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+
+	"github.com/F1zm0n/universal-chat/internal/auth"
+	"github.com/F1zm0n/universal-chat/internal/chatendpoint"
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+	"github.com/F1zm0n/universal-chat/internal/hub"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// Frames sent by clients. Ref is echoed back in the frame answering the
+// frame. Each type only uses some of the fields.
+type clientFrame struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	chatendpoint.SendMessageRequest
+	MessageID int64  `json:"message_id,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	Since     int64  `json:"since,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+func (f clientFrame) message() chatendpoint.MessageRequest {
+	return chatendpoint.MessageRequest{RoomID: f.RoomID, MessageID: f.MessageID}
+}
+
+const (
+	frameSend    = "send"
+	frameTyping  = "typing"
+	frameRead    = "read"
+	frameEdit    = "edit"
+	frameDelete  = "delete"
+	frameReact   = "react"
+	frameUnreact = "unreact"
+	// frameSync is the handshake of reconnecting clients: the messages
+	// newer than since are sent as message events, followed by a synced
+	// frame.
+	frameSync = "sync"
+)
+
+// Frames sent to clients besides the chatservice.Event ones.
+type ackFrame struct {
+	Type    string `json:"type"`
+	Ref     string `json:"ref,omitempty"`
+	Message any    `json:"message,omitempty"`
+}
+
+// syncedFrame ends the answer to a sync frame. Clients send another sync
+// from LastID while More is set.
+type syncedFrame struct {
+	Type   string `json:"type"`
+	Ref    string `json:"ref,omitempty"`
+	LastID int64  `json:"last_id"`
+	More   bool   `json:"more"`
+}
+
+type errorFrame struct {
+	Type  string           `json:"type"`
+	Ref   string           `json:"ref,omitempty"`
+	Error *problem.Problem `json:"error"`
+}
+
+// NewWSHandler upgrades authenticated requests to WebSocket connections
+// served by h. Browsers may only connect from origins, since the session
+// cookie would let any page open a connection otherwise.
+func NewWSHandler(
+	endpoints chatendpoint.Set,
+	h *hub.Hub,
+	origins []string,
+	users auth.Users,
+	bots auth.Bots,
+	logger log.Logger,
+) http.Handler {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, o := range origins {
+		allowed[o] = struct{}{}
+	}
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				// not a browser
+				return true
+			}
+			if _, ok := allowed[origin]; ok {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && u.Host == r.Host
+		},
+	}
+	handle := frameHandler(endpoints)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.FromRequest(r.Context(), r, users, bots)
+		if err != nil {
+			errorEncoder(r.Context(), err, w)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has answered already
+			logger.Log("during", "upgrade", "err", err)
+			return
+		}
+		ctx := auth.WithUser(r.Context(), user)
+		h.Serve(ctx, ws, user, handle)
+	})
+}
+
+func frameHandler(endpoints chatendpoint.Set) hub.Handler {
+	return func(ctx context.Context, c *hub.Conn, data []byte) {
+		var frame clientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.SendJSON(errorFrame{Type: "error", Error: problem.Invalid(err)})
+			return
+		}
+		switch frame.Type {
+		case frameSend:
+			resp, err := call(ctx, endpoints.SendMessageEndpoint, frame.SendMessageRequest)
+			if err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{
+				Type:    "ack",
+				Ref:     frame.Ref,
+				Message: resp.(chatendpoint.SendMessageResponse).Message,
+			})
+		case frameTyping:
+			req := chatendpoint.TypingRequest{RoomID: frame.RoomID}
+			if _, err := call(ctx, endpoints.TypingEndpoint, req); err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{Type: "ack", Ref: frame.Ref})
+		case frameEdit:
+			req := chatendpoint.EditMessageRequest{MessageRequest: frame.message(), Body: frame.Body}
+			resp, err := call(ctx, endpoints.EditMessageEndpoint, req)
+			if err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{
+				Type:    "ack",
+				Ref:     frame.Ref,
+				Message: resp.(chatendpoint.SendMessageResponse).Message,
+			})
+		case frameDelete:
+			if _, err := call(ctx, endpoints.DeleteMessageEndpoint, frame.message()); err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{Type: "ack", Ref: frame.Ref})
+		case frameReact, frameUnreact:
+			e := endpoints.ReactEndpoint
+			if frame.Type == frameUnreact {
+				e = endpoints.UnreactEndpoint
+			}
+			req := chatendpoint.ReactionRequest{MessageRequest: frame.message(), Emoji: frame.Emoji}
+			if _, err := call(ctx, e, req); err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{Type: "ack", Ref: frame.Ref})
+		case frameRead:
+			req := chatendpoint.MarkReadRequest{RoomID: frame.RoomID, MessageID: frame.MessageID}
+			if _, err := call(ctx, endpoints.MarkReadEndpoint, req); err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			c.SendJSON(ackFrame{Type: "ack", Ref: frame.Ref})
+		case frameSync:
+			req := chatendpoint.SyncRequest{Since: frame.Since, Limit: frame.Limit}
+			resp, err := call(ctx, endpoints.SyncEndpoint, req)
+			if err != nil {
+				c.SendJSON(errorFrame{Type: "error", Ref: frame.Ref, Error: err2problem(err)})
+				return
+			}
+			page := resp.(chatendpoint.SyncResponse).SyncPage
+			for i := range page.Messages {
+				msg := &page.Messages[i]
+				c.SendJSON(chatservice.Event{Type: chatservice.EventMessage, RoomID: &msg.RoomID, Message: msg})
+			}
+			c.SendJSON(syncedFrame{Type: "synced", Ref: frame.Ref, LastID: page.LastID, More: page.More})
+		default:
+			c.SendJSON(errorFrame{
+				Type:  "error",
+				Ref:   frame.Ref,
+				Error: problem.Status(http.StatusBadRequest, "unknown frame type "+frame.Type),
+			})
+		}
+	}
+}
+
+// call runs e, folding the error a response failed with into err.
+func call(ctx context.Context, e endpoint.Endpoint, request interface{}) (interface{}, error) {
+	resp, err := e(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := resp.(endpoint.Failer); ok && f.Failed() != nil {
+		return nil, f.Failed()
+	}
+	return resp, nil
+}
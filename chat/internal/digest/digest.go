@@ -0,0 +1,192 @@
+// Package digest mails users who have been away for a while about the
+// messages they missed.
+//
+// Digests are published to a Kafka topic the mailer consumes. Every replica
+// looks for due digests, claiming one in the database before publishing it
+// so a user gets a single digest per absence.
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+type Config struct {
+	// After is how long users are offline before they get a digest, zero
+	// disables digests.
+	After time.Duration
+	// Interval is how often due digests are looked for.
+	Interval time.Duration
+	Brokers  string
+	Topic    string
+}
+
+// LoadConfig reads the chat.digest key, filling in defaults.
+func LoadConfig() Config {
+	cfg := Config{
+		After:    viper.GetDuration("chat.digest.after"),
+		Interval: viper.GetDuration("chat.digest.interval"),
+		Brokers:  viper.GetString("chat.digest.brokers"),
+		Topic:    viper.GetString("chat.digest.topic"),
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "digest"
+	}
+	return cfg
+}
+
+// Digest is the message published for the mailer.
+type Digest struct {
+	Email string `json:"email"`
+	// Since is when the user was last seen.
+	Since  time.Time `json:"since"`
+	Unread int       `json:"unread"`
+	Rooms  []Room    `json:"rooms"`
+}
+
+type Room struct {
+	ID uuid.UUID `json:"room_id"`
+	// Name is the other member for direct rooms.
+	Name   string `json:"name"`
+	Direct bool   `json:"direct"`
+	Unread int    `json:"unread"`
+}
+
+type Publisher interface {
+	// Publish queues d. id is the same for every attempt to publish the
+	// digest, consumers use it to drop duplicates.
+	Publish(ctx context.Context, id string, d Digest) error
+}
+
+type kafkaPublisher struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func NewKafkaPublisher(brokers, topic string) (Publisher, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": brokers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kafkaPublisher{producer: p, topic: topic}, nil
+}
+
+func (p kafkaPublisher) Publish(ctx context.Context, id string, d Digest) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	delivered := make(chan kafka.Event, 1)
+	err = p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Value:          data,
+		Headers: []kafka.Header{
+			{Key: "message-id", Value: []byte(id)},
+		},
+	}, delivered)
+	if err != nil {
+		return err
+	}
+	// digests are rare, wait for each so that failures are reported
+	select {
+	case e := <-delivered:
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			return m.TopicPartition.Error
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sender publishes the digests that are due.
+type Sender struct {
+	cfg    Config
+	db     repository.Repository
+	pub    Publisher
+	logger log.Logger
+}
+
+func NewSender(cfg Config, db repository.Repository, pub Publisher, logger log.Logger) *Sender {
+	return &Sender{
+		cfg:    cfg,
+		db:     db,
+		pub:    pub,
+		logger: logger,
+	}
+}
+
+// Run sends due digests every cfg.Interval until ctx is done.
+func (s *Sender) Run(ctx context.Context) error {
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if err := s.SendDue(ctx); err != nil {
+				s.logger.Log("during", "send digests", "err", err)
+			}
+		}
+	}
+}
+
+// SendDue publishes the digest of every user offline for longer than
+// cfg.After that has not got one for this absence.
+func (s *Sender) SendDue(ctx context.Context) error {
+	digests, err := s.db.ListDigests(ctx, time.Now().Add(-s.cfg.After))
+	if err != nil {
+		return err
+	}
+	for _, d := range digests {
+		claimed, err := s.db.ClaimDigest(ctx, d.Member, d.LastSeen)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			// another replica got to it first
+			continue
+		}
+		id := fmt.Sprintf("digest/%s/%d", d.Member, d.LastSeen.Unix())
+		if err := s.pub.Publish(ctx, id, newDigest(d)); err != nil {
+			// the claim stands, the user is not mailed twice for an absence
+			s.logger.Log("during", "publish", "member", d.Member, "err", err)
+			continue
+		}
+		s.logger.Log("digest", "sent", "member", d.Member, "rooms", len(d.Rooms))
+	}
+	return nil
+}
+
+func newDigest(d repository.Digest) Digest {
+	res := Digest{
+		Email: d.Member,
+		Since: d.LastSeen,
+		Rooms: make([]Room, 0, len(d.Rooms)),
+	}
+	for _, room := range d.Rooms {
+		res.Unread += room.Unread
+		res.Rooms = append(res.Rooms, Room{
+			ID:     room.RoomID,
+			Name:   room.Name,
+			Direct: room.Kind == repository.RoomKindDirect,
+			Unread: room.Unread,
+		})
+	}
+	return res
+}
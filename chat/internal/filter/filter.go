@@ -0,0 +1,107 @@
+// Package filter checks messages before they are stored and broadcast.
+//
+// Filters run in a pipeline, each of them either lets a message through,
+// flags it for the room's moderators or rejects it. The strictest verdict
+// wins.
+package filter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	// Flag delivers the message and tells the room's moderators about it.
+	Flag
+	// Reject refuses the message.
+	Reject
+)
+
+// ParseVerdict reads the action of a filter in the config.
+func ParseVerdict(s string) (Verdict, error) {
+	switch s {
+	case "flag":
+		return Flag, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Allow, fmt.Errorf("unknown filter action %q, want flag or reject", s)
+}
+
+func (v Verdict) String() string {
+	switch v {
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	}
+	return "allow"
+}
+
+// Message is what filters look at.
+type Message struct {
+	RoomID uuid.UUID
+	Sender string
+	Body   string
+}
+
+// Result tells why Filter came to Verdict. Reason is shown to the sender
+// of rejected messages and to moderators.
+type Result struct {
+	Verdict Verdict
+	Filter  string
+	Reason  string
+}
+
+type Filter interface {
+	Check(ctx context.Context, msg Message) Result
+}
+
+// Pipeline runs its filters in order, stopping at the first that rejects.
+type Pipeline []Filter
+
+func (p Pipeline) Check(ctx context.Context, msg Message) Result {
+	var res Result
+	for _, f := range p {
+		r := f.Check(ctx, msg)
+		if r.Verdict > res.Verdict {
+			res = r
+		}
+		if res.Verdict == Reject {
+			break
+		}
+	}
+	return res
+}
+
+// Load builds the pipeline from the chat.filters key. Filters without an
+// action are left out.
+func Load() (Pipeline, error) {
+	var p Pipeline
+	if action := viper.GetString("chat.filters.words.action"); action != "" {
+		v, err := ParseVerdict(action)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, NewWordList(v, viper.GetStringSlice("chat.filters.words.list")))
+	}
+	if action := viper.GetString("chat.filters.spam.action"); action != "" {
+		v, err := ParseVerdict(action)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, NewSpam(v, SpamConfig{
+			MaxLinks:       viper.GetInt("chat.filters.spam.maxlinks"),
+			BlockedDomains: viper.GetStringSlice("chat.filters.spam.blockeddomains"),
+			MaxRepeats:     viper.GetInt("chat.filters.spam.maxrepeats"),
+			RepeatWindow:   viper.GetDuration("chat.filters.spam.repeatwindow"),
+		}))
+	}
+	return p, nil
+}
@@ -0,0 +1,129 @@
+package filter
+
+import (
+	"context"
+	"hash/fnv"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SpamConfig struct {
+	// MaxLinks is the most links a message may hold, zero does not count
+	// them.
+	MaxLinks int
+	// BlockedDomains are domains, their subdomains included, links may not
+	// point to, such as url shorteners.
+	BlockedDomains []string
+	// MaxRepeats is how often a sender may post the same body within
+	// RepeatWindow, in any room. Zero does not look for repeats.
+	MaxRepeats   int
+	RepeatWindow time.Duration
+}
+
+// Spam is a heuristic looking for messages with many links, links to
+// blocked domains, and bodies posted over and over. Repeats are counted
+// per replica, a sender spread over several gets a little more leeway.
+type Spam struct {
+	verdict Verdict
+	cfg     SpamConfig
+
+	mu sync.Mutex
+	// recent holds when each sender posted a body, by its hash.
+	recent map[string]map[uint64][]time.Time
+	swept  time.Time
+}
+
+func NewSpam(verdict Verdict, cfg SpamConfig) *Spam {
+	if cfg.RepeatWindow <= 0 {
+		cfg.RepeatWindow = time.Minute
+	}
+	return &Spam{
+		verdict: verdict,
+		cfg:     cfg,
+		recent:  make(map[string]map[uint64][]time.Time),
+	}
+}
+
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+func (s *Spam) Check(_ context.Context, msg Message) Result {
+	links := linkRe.FindAllString(msg.Body, -1)
+	if s.cfg.MaxLinks > 0 && len(links) > s.cfg.MaxLinks {
+		return s.result("too many links")
+	}
+	for _, link := range links {
+		if s.blocked(link) {
+			return s.result("links to a blocked site")
+		}
+	}
+	if s.cfg.MaxRepeats > 0 && s.repeated(msg.Sender, msg.Body, time.Now()) {
+		return s.result("posted too many times")
+	}
+	return Result{}
+}
+
+func (s *Spam) result(reason string) Result {
+	return Result{Verdict: s.verdict, Filter: "spam", Reason: reason}
+}
+
+func (s *Spam) blocked(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range s.cfg.BlockedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeated records that sender posted body at now and reports whether
+// they did so more than MaxRepeats times within the window.
+func (s *Spam) repeated(sender, body string, now time.Time) bool {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(words(body), " ")))
+	sum := h.Sum64()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := now.Add(-s.cfg.RepeatWindow)
+	if now.Sub(s.swept) > s.cfg.RepeatWindow {
+		s.sweep(since)
+		s.swept = now
+	}
+	bodies := s.recent[sender]
+	if bodies == nil {
+		bodies = make(map[uint64][]time.Time)
+		s.recent[sender] = bodies
+	}
+	times := bodies[sum]
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	times = append(times, now)
+	bodies[sum] = times
+	return len(times) > s.cfg.MaxRepeats
+}
+
+// sweep forgets the posts older than since.
+func (s *Spam) sweep(since time.Time) {
+	for sender, bodies := range s.recent {
+		for sum, times := range bodies {
+			if times[len(times)-1].Before(since) {
+				delete(bodies, sum)
+			}
+		}
+		if len(bodies) == 0 {
+			delete(s.recent, sender)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package filter
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// WordList matches whole words of a list, ignoring case.
+type WordList struct {
+	verdict Verdict
+	words   map[string]struct{}
+}
+
+func NewWordList(verdict Verdict, words []string) *WordList {
+	w := &WordList{verdict: verdict, words: make(map[string]struct{}, len(words))}
+	for _, word := range words {
+		w.words[strings.ToLower(word)] = struct{}{}
+	}
+	return w
+}
+
+func (w *WordList) Check(_ context.Context, msg Message) Result {
+	for _, word := range words(msg.Body) {
+		if _, ok := w.words[word]; ok {
+			return Result{Verdict: w.verdict, Filter: "words", Reason: "contains a banned word"}
+		}
+	}
+	return Result{}
+}
+
+// words splits s into lower case words of letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
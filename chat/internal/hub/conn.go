@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a client's WebSocket connection.
+type Conn struct {
+	hub  *Hub
+	ws   *websocket.Conn
+	user string
+	send chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+func (c *Conn) User() string {
+	return c.user
+}
+
+// Send queues data without waiting. It closes connections whose buffer is
+// full and reports whether data was queued.
+func (c *Conn) Send(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		c.hub.logger.Log("user", c.user, "err", "send buffer full, closing connection")
+		c.close(websocket.CloseTryAgainLater, "client too slow")
+		return false
+	}
+}
+
+// SendJSON queues v encoded as JSON.
+func (c *Conn) SendJSON(v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.hub.logger.Log("during", "encode", "err", err)
+		return false
+	}
+	return c.Send(data)
+}
+
+func (c *Conn) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeText = code, text
+		close(c.done)
+	})
+}
+
+func (c *Conn) readPump(ctx context.Context, handle Handler) {
+	c.ws.SetReadLimit(c.hub.cfg.MaxFrameSize)
+	c.ws.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		handle(ctx, c, data)
+	}
+}
+
+// writePump is the only writer of the connection. It closes the socket
+// when done, which also ends readPump.
+func (c *Conn) writePump() {
+	ticker := time.NewTicker(c.hub.cfg.PongWait * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+	for {
+		select {
+		case data := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			c.ws.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(c.closeCode, c.closeText),
+				time.Now().Add(c.hub.cfg.WriteWait),
+			)
+			return
+		}
+	}
+}
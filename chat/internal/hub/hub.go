@@ -0,0 +1,183 @@
+// Package hub keeps the WebSocket connections of this instance and pushes
+// events to them.
+//
+// Every connection has a bounded send buffer drained by its own writer.
+// Publishing never waits on a connection: one whose buffer is full is too
+// slow to keep up and is closed with CloseTryAgainLater, its client is
+// expected to reconnect and catch up through the history. Incoming frames
+// are handled one at a time, so a client sending faster than it is served
+// is slowed down by TCP flow control.
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+)
+
+type Config struct {
+	// SendBuffer is the number of frames queued per connection.
+	SendBuffer int
+	// MaxFrameSize bounds incoming frames.
+	MaxFrameSize int64
+	WriteWait    time.Duration
+	// PongWait is how long a connection may stay silent, pings are sent
+	// well within it.
+	PongWait time.Duration
+}
+
+// LoadConfig reads the chat.ws key, filling in defaults.
+func LoadConfig() Config {
+	cfg := Config{
+		SendBuffer:   viper.GetInt("chat.ws.sendbuffer"),
+		MaxFrameSize: viper.GetInt64("chat.ws.maxframesize"),
+		WriteWait:    viper.GetDuration("chat.ws.writewait"),
+		PongWait:     viper.GetDuration("chat.ws.pongwait"),
+	}
+	if cfg.SendBuffer <= 0 {
+		cfg.SendBuffer = 256
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = 16 << 10
+	}
+	if cfg.WriteWait <= 0 {
+		cfg.WriteWait = 10 * time.Second
+	}
+	if cfg.PongWait <= 0 {
+		cfg.PongWait = 60 * time.Second
+	}
+	return cfg
+}
+
+// Handler handles a frame received on c.
+type Handler func(ctx context.Context, c *Conn, data []byte)
+
+// PresenceFunc is called when the first connection of user to this instance
+// opens and when the last one closes.
+type PresenceFunc func(ctx context.Context, user string, online bool)
+
+type Hub struct {
+	cfg      Config
+	logger   log.Logger
+	presence PresenceFunc
+
+	mu    sync.RWMutex
+	conns map[string]map[*Conn]struct{}
+}
+
+// New returns a hub reporting presence changes to presence, which may be
+// nil.
+func New(cfg Config, logger log.Logger, presence PresenceFunc) *Hub {
+	return &Hub{
+		cfg:      cfg,
+		logger:   logger,
+		presence: presence,
+		conns:    make(map[string]map[*Conn]struct{}),
+	}
+}
+
+var _ chatservice.Notifier = (*Hub)(nil)
+
+// Notify sends ev to every connection of users.
+func (h *Hub) Notify(_ context.Context, users []string, ev chatservice.Event) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		h.logger.Log("during", "notify", "err", err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, user := range users {
+		for c := range h.conns[user] {
+			c.Send(data)
+		}
+	}
+}
+
+// Serve runs the connection of user until it is closed, passing incoming
+// frames to handle.
+func (h *Hub) Serve(ctx context.Context, ws *websocket.Conn, user string, handle Handler) {
+	c := &Conn{
+		hub:  h,
+		ws:   ws,
+		user: user,
+		send: make(chan []byte, h.cfg.SendBuffer),
+		done: make(chan struct{}),
+	}
+	// the request is done by the time the connection closes
+	presenceCtx := context.WithoutCancel(ctx)
+	if h.register(c) {
+		h.setPresence(presenceCtx, user, true)
+	}
+	defer func() {
+		if h.unregister(c) {
+			h.setPresence(presenceCtx, user, false)
+		}
+	}()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		c.writePump()
+	}()
+	c.readPump(ctx, handle)
+	c.close(websocket.CloseNormalClosure, "")
+	<-written
+}
+
+// register adds c and reports whether it is the first connection of its
+// user.
+func (h *Hub) register(c *Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	first := len(h.conns[c.user]) == 0
+	if first {
+		h.conns[c.user] = make(map[*Conn]struct{})
+	}
+	h.conns[c.user][c] = struct{}{}
+	return first
+}
+
+// unregister removes c and reports whether it was the last connection of
+// its user.
+func (h *Hub) unregister(c *Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns[c.user], c)
+	if len(h.conns[c.user]) > 0 {
+		return false
+	}
+	delete(h.conns, c.user)
+	return true
+}
+
+func (h *Hub) setPresence(ctx context.Context, user string, online bool) {
+	if h.presence != nil {
+		h.presence(ctx, user, online)
+	}
+}
+
+// Connected reports whether user has a connection to this instance.
+func (h *Hub) Connected(user string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns[user]) > 0
+}
+
+// Users returns the users connected to this instance.
+func (h *Hub) Users() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	users := make([]string, 0, len(h.conns))
+	for user := range h.conns {
+		users = append(users, user)
+	}
+	return users
+}
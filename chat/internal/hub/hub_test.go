@@ -0,0 +1,129 @@
+package hub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+
+	"github.com/F1zm0n/universal-chat/internal/chatservice"
+)
+
+type presenceChange struct {
+	user   string
+	online bool
+}
+
+// serve returns a hub and the address of a server connecting the user of
+// the query to it. Frames are echoed back.
+func serve(t *testing.T, cfg Config) (*Hub, chan presenceChange, string) {
+	changes := make(chan presenceChange, 10)
+	h := New(cfg, log.NewNopLogger(), func(_ context.Context, user string, online bool) {
+		changes <- presenceChange{user, online}
+	})
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h.Serve(r.Context(), ws, r.URL.Query().Get("user"), func(_ context.Context, c *Conn, data []byte) {
+			c.Send(data)
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return h, changes, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, addr, user string) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial(addr+"?user="+user, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func read(t *testing.T, ws *websocket.Conn) string {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data)
+}
+
+func wantPresence(t *testing.T, changes chan presenceChange, want presenceChange) {
+	select {
+	case got := <-changes:
+		if got != want {
+			t.Errorf("presence %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no presence change, want %+v", want)
+	}
+}
+
+func TestHub(t *testing.T) {
+	h, changes, addr := serve(t, LoadConfig())
+	laptop := dial(t, addr, "alice")
+	wantPresence(t, changes, presenceChange{"alice", true})
+	phone := dial(t, addr, "alice")
+	// the echo is served once the connection is registered
+	phone.WriteMessage(websocket.TextMessage, []byte("ping"))
+	if got := read(t, phone); got != "ping" {
+		t.Fatalf("echo %q", got)
+	}
+
+	h.Notify(context.Background(), []string{"alice", "bob"}, chatservice.Event{Type: chatservice.EventTyping})
+	for _, ws := range []*websocket.Conn{laptop, phone} {
+		if got := read(t, ws); !strings.Contains(got, `"typing"`) {
+			t.Errorf("received %s, want the typing event", got)
+		}
+	}
+	if !h.Connected("alice") || h.Connected("bob") {
+		t.Errorf("Connected alice %v, bob %v", h.Connected("alice"), h.Connected("bob"))
+	}
+
+	// alice stays online until her last connection closes
+	laptop.Close()
+	phone.WriteMessage(websocket.TextMessage, []byte("ping"))
+	read(t, phone)
+	select {
+	case got := <-changes:
+		t.Fatalf("presence %+v with a connection left", got)
+	default:
+	}
+	phone.Close()
+	wantPresence(t, changes, presenceChange{"alice", false})
+	if len(h.Users()) != 0 {
+		t.Errorf("users %v after closing", h.Users())
+	}
+}
+
+func TestSendFull(t *testing.T) {
+	h := New(Config{SendBuffer: 1}, log.NewNopLogger(), nil)
+	c := &Conn{hub: h, user: "alice", send: make(chan []byte, 1), done: make(chan struct{})}
+	if !c.Send([]byte("1")) {
+		t.Fatal("first frame not queued")
+	}
+	if c.Send([]byte("2")) {
+		t.Fatal("frame queued past the buffer")
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("slow connection left open")
+	}
+	if c.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("closed with %d, want %d", c.closeCode, websocket.CloseTryAgainLater)
+	}
+	if c.Send([]byte("3")) {
+		t.Error("frame queued on a closed connection")
+	}
+}
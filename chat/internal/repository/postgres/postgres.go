@@ -0,0 +1,1141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint failures.
+const uniqueViolation = "23505"
+
+type Postgres struct {
+	db *sqlx.DB
+}
+
+// New wraps a pool opened with the pgx stdlib driver.
+func New(db *sql.DB) *Postgres {
+	return &Postgres{db: sqlx.NewDb(db, "pgx")}
+}
+
+func (p *Postgres) CreateRoom(ctx context.Context, room repository.Room, members ...string) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExecContext(
+			ctx,
+			`INSERT INTO rooms (id,kind,name,created_by) VALUES (:id,:kind,:name,:created_by)`,
+			room,
+		)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			role := repository.RoleMember
+			if room.Kind == repository.RoomKindGroup && member == room.CreatedBy {
+				role = repository.RoleOwner
+			}
+			if err := addMember(ctx, tx, room.ID, member, role); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Postgres) GetOrCreateDirectRoom(
+	ctx context.Context,
+	room repository.Room,
+	a, b string,
+) (repository.Room, error) {
+	if b < a {
+		a, b = b, a
+	}
+	key := a + "\n" + b
+	var res repository.Room
+	err := p.inTx(ctx, func(tx *sqlx.Tx) error {
+		// concurrent first messages race here, the unique key lets only
+		// one of them create the room
+		created, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO rooms (id,kind,name,direct_key,created_by) VALUES ($1,$2,'',$3,$4)
+			ON CONFLICT (direct_key) DO NOTHING`,
+			room.ID,
+			repository.RoomKindDirect,
+			key,
+			room.CreatedBy,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := created.RowsAffected(); err != nil {
+			return err
+		} else if n == 1 {
+			for _, member := range []string{a, b} {
+				if err := addMember(ctx, tx, room.ID, member, repository.RoleMember); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.GetContext(
+			ctx,
+			&res,
+			`SELECT id,kind,name,created_by,created_at FROM rooms WHERE direct_key=$1`,
+			key,
+		)
+	})
+	return res, err
+}
+
+func (p *Postgres) GetRoom(ctx context.Context, id uuid.UUID) (repository.Room, error) {
+	var room repository.Room
+	err := p.db.GetContext(
+		ctx,
+		&room,
+		`SELECT id,kind,name,created_by,created_at FROM rooms WHERE id=$1`,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Room{}, repository.ErrNotFound
+	}
+	return room, err
+}
+
+func (p *Postgres) ListRooms(ctx context.Context, member string) ([]repository.Room, error) {
+	rooms := []repository.Room{}
+	err := p.db.SelectContext(
+		ctx,
+		&rooms,
+		`SELECT r.id,r.kind,r.name,r.created_by,r.created_at
+		FROM rooms r JOIN room_members m ON m.room_id=r.id
+		WHERE m.member=$1 AND NOT EXISTS (
+			SELECT 1 FROM room_members o JOIN blocks b ON b.blocked=o.member
+			WHERE r.kind='direct' AND o.room_id=r.id AND b.blocker=$1
+		)
+		ORDER BY r.created_at DESC`,
+		member,
+	)
+	return rooms, err
+}
+
+func (p *Postgres) AddMember(ctx context.Context, roomID uuid.UUID, member string) error {
+	return addMember(ctx, p.db, roomID, member, repository.RoleMember)
+}
+
+func (p *Postgres) RemoveMember(ctx context.Context, roomID uuid.UUID, member string) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`DELETE FROM room_members WHERE room_id=$1 AND member=$2`,
+		roomID,
+		member,
+	)
+	return err
+}
+
+func (p *Postgres) IsMember(ctx context.Context, roomID uuid.UUID, member string) (bool, error) {
+	var ok bool
+	err := p.db.GetContext(
+		ctx,
+		&ok,
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id=$1 AND member=$2)`,
+		roomID,
+		member,
+	)
+	return ok, err
+}
+
+func (p *Postgres) ListMembers(ctx context.Context, roomID uuid.UUID) ([]string, error) {
+	members := []string{}
+	err := p.db.SelectContext(
+		ctx,
+		&members,
+		`SELECT member FROM room_members WHERE room_id=$1 ORDER BY joined_at`,
+		roomID,
+	)
+	return members, err
+}
+
+const memberColumns = `member,role,muted_until,joined_at`
+
+func (p *Postgres) GetMember(ctx context.Context, roomID uuid.UUID, member string) (repository.Member, error) {
+	var m repository.Member
+	err := p.db.GetContext(
+		ctx,
+		&m,
+		`SELECT `+memberColumns+` FROM room_members WHERE room_id=$1 AND member=$2`,
+		roomID,
+		member,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Member{}, repository.ErrNotFound
+	}
+	return m, err
+}
+
+func (p *Postgres) ListRoomMembers(ctx context.Context, roomID uuid.UUID) ([]repository.Member, error) {
+	members := []repository.Member{}
+	err := p.db.SelectContext(
+		ctx,
+		&members,
+		`SELECT `+memberColumns+` FROM room_members WHERE room_id=$1
+		ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, joined_at`,
+		roomID,
+	)
+	return members, err
+}
+
+func (p *Postgres) IsBanned(ctx context.Context, roomID uuid.UUID, member string) (bool, error) {
+	var ok bool
+	err := p.db.GetContext(
+		ctx,
+		&ok,
+		`SELECT EXISTS (SELECT 1 FROM room_bans WHERE room_id=$1 AND member=$2)`,
+		roomID,
+		member,
+	)
+	return ok, err
+}
+
+func (p *Postgres) IsBlocked(ctx context.Context, a, b string) (bool, error) {
+	var ok bool
+	err := p.db.GetContext(
+		ctx,
+		&ok,
+		`SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocker=$1 AND blocked=$2) OR (blocker=$2 AND blocked=$1)
+		)`,
+		a,
+		b,
+	)
+	return ok, err
+}
+
+func (p *Postgres) ListBlocks(ctx context.Context, blocker string) ([]repository.Block, error) {
+	blocks := []repository.Block{}
+	err := p.db.SelectContext(
+		ctx,
+		&blocks,
+		`SELECT blocked,created_at FROM blocks WHERE blocker=$1 ORDER BY created_at DESC`,
+		blocker,
+	)
+	return blocks, err
+}
+
+func (p *Postgres) SetRole(ctx context.Context, e repository.AuditEntry, role string) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.ExecContext(
+			ctx,
+			`UPDATE room_members SET role=$3 WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+			role,
+		))
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) TransferOwnership(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.ExecContext(
+			ctx,
+			`UPDATE room_members SET role=$3,muted_until=NULL WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+			repository.RoleOwner,
+		))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE room_members SET role=$3 WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Actor,
+			repository.RoleModerator,
+		)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Mute(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.ExecContext(
+			ctx,
+			`UPDATE room_members SET muted_until=$3 WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+			e.ExpiresAt,
+		))
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Kick(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.ExecContext(
+			ctx,
+			`DELETE FROM room_members WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+		))
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Ban(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO room_bans (room_id,member,banned_by,reason) VALUES ($1,$2,$3,$4)
+			ON CONFLICT (room_id,member) DO UPDATE SET banned_by=$3,reason=$4`,
+			e.RoomID,
+			e.Target,
+			e.Actor,
+			e.Reason,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM room_members WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+		)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Unban(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.ExecContext(
+			ctx,
+			`DELETE FROM room_bans WHERE room_id=$1 AND member=$2`,
+			e.RoomID,
+			e.Target,
+		))
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Block(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO blocks (blocker,blocked) VALUES ($1,$2) ON CONFLICT DO NOTHING`,
+			e.Actor,
+			e.Target,
+		)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Unblock(ctx context.Context, e repository.AuditEntry) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`DELETE FROM blocks WHERE blocker=$1 AND blocked=$2`,
+			e.Actor,
+			e.Target,
+		)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, e)
+	})
+}
+
+func (p *Postgres) Audit(ctx context.Context, e repository.AuditEntry) error {
+	return audit(ctx, p.db, e)
+}
+
+func (p *Postgres) ListAudit(
+	ctx context.Context,
+	roomID uuid.UUID,
+	before int64,
+	limit int,
+) ([]repository.AuditEntry, error) {
+	entries := []repository.AuditEntry{}
+	err := p.db.SelectContext(
+		ctx,
+		&entries,
+		`SELECT id,room_id,actor,action,target,message_id,reason,expires_at,created_at
+		FROM moderation_log
+		WHERE room_id=$1 AND ($2::BIGINT=0 OR id<$2::BIGINT)
+		ORDER BY id DESC LIMIT $3`,
+		roomID,
+		before,
+		limit,
+	)
+	return entries, err
+}
+
+func (p *Postgres) ListContacts(ctx context.Context, member string) ([]string, error) {
+	contacts := []string{}
+	err := p.db.SelectContext(
+		ctx,
+		&contacts,
+		`SELECT DISTINCT o.member FROM room_members m
+		JOIN room_members o ON o.room_id=m.room_id
+		WHERE m.member=$1`,
+		member,
+	)
+	return contacts, err
+}
+
+func (p *Postgres) CreateMessage(
+	ctx context.Context,
+	msg *repository.Message,
+	attachmentIDs ...uuid.UUID,
+) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.QueryRowxContext(
+			ctx,
+			`INSERT INTO messages (room_id,parent_id,sender,body) VALUES ($1,$2,$3,$4)
+			RETURNING id,created_at`,
+			msg.RoomID,
+			msg.ParentID,
+			msg.Sender,
+			msg.Body,
+		).Scan(&msg.ID, &msg.CreatedAt)
+		if err != nil || len(attachmentIDs) == 0 {
+			return err
+		}
+		ids := make([]string, len(attachmentIDs))
+		for i, id := range attachmentIDs {
+			ids[i] = id.String()
+		}
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE attachments SET message_id=$1
+			WHERE id=ANY($2::UUID[]) AND room_id=$3 AND uploader=$4 AND status=$5 AND message_id IS NULL`,
+			msg.ID,
+			ids,
+			msg.RoomID,
+			msg.Sender,
+			repository.AttachmentReady,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != int64(len(attachmentIDs)) {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// messageColumns selects the message m, serving tombstones without their
+// body.
+const messageColumns = `m.id,m.room_id,m.parent_id,m.sender,
+	CASE WHEN m.deleted_at IS NULL THEN m.body ELSE '' END AS body,
+	m.created_at,m.edited_at,m.deleted_at,
+	(SELECT count(*) FROM messages r WHERE r.parent_id=m.id AND r.deleted_at IS NULL) AS replies`
+
+func (p *Postgres) GetMessage(ctx context.Context, roomID uuid.UUID, id int64) (repository.Message, error) {
+	return getMessage(ctx, p.db, roomID, id)
+}
+
+func getMessage(ctx context.Context, db sqlx.QueryerContext, roomID uuid.UUID, id int64) (repository.Message, error) {
+	var msg repository.Message
+	err := sqlx.GetContext(
+		ctx,
+		db,
+		&msg,
+		`SELECT `+messageColumns+` FROM messages m WHERE m.room_id=$1 AND m.id=$2`,
+		roomID,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Message{}, repository.ErrNotFound
+	}
+	return msg, err
+}
+
+func (p *Postgres) EditMessage(
+	ctx context.Context,
+	roomID uuid.UUID,
+	id int64,
+	body string,
+) (repository.Message, error) {
+	var msg repository.Message
+	err := p.inTx(ctx, func(tx *sqlx.Tx) error {
+		// the lock keeps concurrent edits from losing a body
+		var old string
+		err := tx.GetContext(
+			ctx,
+			&old,
+			`SELECT body FROM messages WHERE room_id=$1 AND id=$2 AND deleted_at IS NULL FOR UPDATE`,
+			roomID,
+			id,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO message_edits (message_id,body) VALUES ($1,$2)`, id, old)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE messages SET body=$2,edited_at=now() WHERE id=$1`, id, body)
+		if err != nil {
+			return err
+		}
+		msg, err = getMessage(ctx, tx, roomID, id)
+		return err
+	})
+	return msg, err
+}
+
+func (p *Postgres) DeleteMessage(ctx context.Context, roomID uuid.UUID, id int64) (repository.Message, error) {
+	var msg repository.Message
+	err := p.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE messages SET deleted_at=now() WHERE room_id=$1 AND id=$2 AND deleted_at IS NULL`,
+			roomID,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return repository.ErrNotFound
+		}
+		msg, err = getMessage(ctx, tx, roomID, id)
+		return err
+	})
+	return msg, err
+}
+
+func (p *Postgres) ListEdits(ctx context.Context, messageID int64) ([]repository.Edit, error) {
+	edits := []repository.Edit{}
+	err := p.db.SelectContext(
+		ctx,
+		&edits,
+		`SELECT body,edited_at FROM message_edits WHERE message_id=$1 ORDER BY id`,
+		messageID,
+	)
+	return edits, err
+}
+
+func (p *Postgres) ListMessages(
+	ctx context.Context,
+	roomID uuid.UUID,
+	before int64,
+	limit int,
+) ([]repository.Message, error) {
+	msgs := []repository.Message{}
+	query := `SELECT ` + messageColumns + ` FROM messages m
+	WHERE m.room_id=$1 AND m.parent_id IS NULL AND ($2::BIGINT=0 OR m.id<$2::BIGINT)
+	ORDER BY m.id DESC LIMIT $3`
+	err := p.db.SelectContext(ctx, &msgs, query, roomID, before, limit)
+	return msgs, err
+}
+
+func (p *Postgres) ListReplies(
+	ctx context.Context,
+	parentID int64,
+	before int64,
+	limit int,
+) ([]repository.Message, error) {
+	msgs := []repository.Message{}
+	query := `SELECT ` + messageColumns + ` FROM messages m
+	WHERE m.parent_id=$1 AND ($2::BIGINT=0 OR m.id<$2::BIGINT)
+	ORDER BY m.id DESC LIMIT $3`
+	err := p.db.SelectContext(ctx, &msgs, query, parentID, before, limit)
+	return msgs, err
+}
+
+func (p *Postgres) AddReaction(ctx context.Context, messageID int64, member, emoji string) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO reactions (message_id,member,emoji) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`,
+		messageID,
+		member,
+		emoji,
+	)
+	return err
+}
+
+func (p *Postgres) RemoveReaction(ctx context.Context, messageID int64, member, emoji string) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`DELETE FROM reactions WHERE message_id=$1 AND member=$2 AND emoji=$3`,
+		messageID,
+		member,
+		emoji,
+	)
+	return err
+}
+
+func (p *Postgres) ListReactions(ctx context.Context, messageIDs ...int64) (map[int64][]repository.Reaction, error) {
+	res := make(map[int64][]repository.Reaction, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return res, nil
+	}
+	var rows []struct {
+		MessageID int64  `db:"message_id"`
+		Emoji     string `db:"emoji"`
+		Member    string `db:"member"`
+	}
+	err := p.db.SelectContext(
+		ctx,
+		&rows,
+		`SELECT message_id,emoji,member FROM reactions WHERE message_id=ANY($1)
+		ORDER BY message_id,created_at`,
+		messageIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		reactions := res[row.MessageID]
+		i := slices.IndexFunc(reactions, func(r repository.Reaction) bool { return r.Emoji == row.Emoji })
+		if i < 0 {
+			reactions = append(reactions, repository.Reaction{Emoji: row.Emoji})
+			i = len(reactions) - 1
+		}
+		reactions[i].Count++
+		reactions[i].Users = append(reactions[i].Users, row.Member)
+		res[row.MessageID] = reactions
+	}
+	for _, reactions := range res {
+		// ties keep the order the emojis were first used in
+		slices.SortStableFunc(reactions, func(a, b repository.Reaction) int { return b.Count - a.Count })
+	}
+	return res, nil
+}
+
+// attachmentColumns selects the attachment a.
+const attachmentColumns = `a.id,a.room_id,a.message_id,a.uploader,a.name,a.content_type,a.size,
+	a.status,a.thumbnail,a.created_at`
+
+func (p *Postgres) CreateAttachment(ctx context.Context, a repository.Attachment) error {
+	_, err := p.db.NamedExecContext(
+		ctx,
+		`INSERT INTO attachments (id,room_id,uploader,name,content_type,size,status,thumbnail)
+		VALUES (:id,:room_id,:uploader,:name,:content_type,:size,:status,:thumbnail)`,
+		a,
+	)
+	return err
+}
+
+func (p *Postgres) GetAttachment(ctx context.Context, id uuid.UUID) (repository.Attachment, error) {
+	var a repository.Attachment
+	err := p.db.GetContext(
+		ctx,
+		&a,
+		`SELECT `+attachmentColumns+` FROM attachments a
+		LEFT JOIN messages m ON m.id=a.message_id
+		WHERE a.id=$1 AND m.deleted_at IS NULL`,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Attachment{}, repository.ErrNotFound
+	}
+	return a, err
+}
+
+func (p *Postgres) MarkUploaded(
+	ctx context.Context,
+	id uuid.UUID,
+	size int64,
+	contentType, thumbnail string,
+) (repository.Attachment, error) {
+	var a repository.Attachment
+	err := p.db.GetContext(
+		ctx,
+		&a,
+		`UPDATE attachments a SET status=$2,size=$3,content_type=$4,thumbnail=$5
+		WHERE a.id=$1 AND a.status=$6
+		RETURNING `+attachmentColumns,
+		id,
+		repository.AttachmentReady,
+		size,
+		contentType,
+		thumbnail,
+		repository.AttachmentPending,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Attachment{}, repository.ErrNotFound
+	}
+	return a, err
+}
+
+func (p *Postgres) SetThumbnail(ctx context.Context, id uuid.UUID, thumbnail string) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE attachments SET thumbnail=$2 WHERE id=$1`, id, thumbnail)
+	return err
+}
+
+func (p *Postgres) ListAttachments(
+	ctx context.Context,
+	messageIDs ...int64,
+) (map[int64][]repository.Attachment, error) {
+	res := make(map[int64][]repository.Attachment)
+	if len(messageIDs) == 0 {
+		return res, nil
+	}
+	var attachments []repository.Attachment
+	err := p.db.SelectContext(
+		ctx,
+		&attachments,
+		`SELECT `+attachmentColumns+` FROM attachments a
+		WHERE a.message_id=ANY($1)
+		ORDER BY a.created_at,a.id`,
+		messageIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		res[*a.MessageID] = append(res[*a.MessageID], a)
+	}
+	return res, nil
+}
+
+func (p *Postgres) ListPendingThumbnails(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	err := p.db.SelectContext(
+		ctx,
+		&ids,
+		`SELECT id FROM attachments
+		WHERE thumbnail=$1 AND status=$2 AND created_at<$3
+		ORDER BY created_at LIMIT $4`,
+		repository.ThumbnailPending,
+		repository.AttachmentReady,
+		before,
+		limit,
+	)
+	return ids, err
+}
+
+func (p *Postgres) DeleteStaleUploads(ctx context.Context, before time.Time) (int64, error) {
+	res, err := p.db.ExecContext(
+		ctx,
+		`DELETE FROM attachments WHERE status=$1 AND created_at<$2`,
+		repository.AttachmentPending,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// The matches ts_headline finds are marked with control characters, which
+// are taken out of bodies first, so the excerpt can be escaped before the
+// marks become HTML.
+const (
+	startSel = "\x02"
+	stopSel  = "\x03"
+
+	headlineOptions = `StartSel="` + startSel + `",StopSel="` + stopSel + `",` +
+		`MaxWords=35,MinWords=15,MaxFragments=2,FragmentDelimiter=" … "`
+)
+
+var highlightReplacer = strings.NewReplacer(startSel, "<mark>", stopSel, "</mark>")
+
+func (p *Postgres) SearchMessages(ctx context.Context, q repository.SearchQuery) ([]repository.SearchResult, error) {
+	var (
+		roomID   *uuid.UUID
+		from, to *time.Time
+	)
+	if q.RoomID != uuid.Nil {
+		roomID = &q.RoomID
+	}
+	if !q.From.IsZero() {
+		from = &q.From
+	}
+	if !q.To.IsZero() {
+		to = &q.To
+	}
+	results := []repository.SearchResult{}
+	err := p.db.SelectContext(
+		ctx,
+		&results,
+		`SELECT `+messageColumns+`,
+			ts_headline('simple', translate(m.body, $9, ''), tsq, $10) AS highlight
+		FROM messages m
+		JOIN room_members rm ON rm.room_id=m.room_id AND rm.member=$1,
+			websearch_to_tsquery('simple', $2) tsq
+		WHERE m.search @@ tsq AND m.deleted_at IS NULL
+			AND ($3::UUID IS NULL OR m.room_id=$3::UUID)
+			AND ($4='' OR m.sender=$4)
+			AND ($5::TIMESTAMPTZ IS NULL OR m.created_at>=$5::TIMESTAMPTZ)
+			AND ($6::TIMESTAMPTZ IS NULL OR m.created_at<$6::TIMESTAMPTZ)
+			AND ($7::BIGINT=0 OR m.id<$7::BIGINT)
+		ORDER BY m.id DESC LIMIT $8`,
+		q.Member,
+		q.Text,
+		roomID,
+		q.Sender,
+		from,
+		to,
+		q.Before,
+		q.Limit,
+		startSel+stopSel,
+		headlineOptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Highlight = highlightReplacer.Replace(html.EscapeString(results[i].Highlight))
+	}
+	return results, nil
+}
+
+func (p *Postgres) ListMessagesSince(
+	ctx context.Context,
+	member string,
+	since int64,
+	limit int,
+) ([]repository.Message, error) {
+	msgs := []repository.Message{}
+	query := `SELECT ` + messageColumns + `
+	FROM messages m JOIN room_members rm ON rm.room_id=m.room_id
+	WHERE rm.member=$1 AND m.id>(CASE WHEN $2::BIGINT=0 THEN rm.last_read ELSE $2::BIGINT END)
+	ORDER BY m.id LIMIT $3`
+	err := p.db.SelectContext(ctx, &msgs, query, member, since, limit)
+	return msgs, err
+}
+
+func (p *Postgres) MarkRead(
+	ctx context.Context,
+	roomID uuid.UUID,
+	member string,
+	messageID int64,
+) (int64, error) {
+	var lastRead int64
+	err := p.db.GetContext(
+		ctx,
+		&lastRead,
+		`UPDATE room_members rm SET last_read=GREATEST(rm.last_read, m.id)
+		FROM messages m
+		WHERE m.id=$3 AND m.room_id=$1 AND rm.room_id=$1 AND rm.member=$2
+		RETURNING rm.last_read`,
+		roomID,
+		member,
+		messageID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrNotFound
+	}
+	return lastRead, err
+}
+
+func (p *Postgres) ListUnread(ctx context.Context, member string) ([]repository.Unread, error) {
+	unread := []repository.Unread{}
+	err := p.db.SelectContext(
+		ctx,
+		&unread,
+		`SELECT rm.room_id,rm.last_read,count(m.id) AS unread
+		FROM room_members rm
+		LEFT JOIN messages m ON m.room_id=rm.room_id AND m.id>rm.last_read AND m.sender<>rm.member
+			AND m.deleted_at IS NULL
+		WHERE rm.member=$1
+		GROUP BY rm.room_id,rm.last_read
+		ORDER BY rm.room_id`,
+		member,
+	)
+	return unread, err
+}
+
+func (p *Postgres) TouchPresence(ctx context.Context, replica string, members ...string) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		for _, member := range members {
+			_, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO presence (member,replica) VALUES ($1,$2)
+				ON CONFLICT (member,replica) DO UPDATE SET seen_at=now()`,
+				member,
+				replica,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Postgres) ListDigests(ctx context.Context, offlineBefore time.Time) ([]repository.Digest, error) {
+	var rows []struct {
+		Member   string    `db:"member"`
+		LastSeen time.Time `db:"last_seen"`
+		repository.DigestRoom
+	}
+	err := p.db.SelectContext(
+		ctx,
+		&rows,
+		`WITH seen AS (
+			SELECT member,max(seen_at) AS last_seen FROM presence
+			GROUP BY member HAVING max(seen_at)<$1
+		)
+		SELECT s.member,s.last_seen,r.id AS room_id,r.kind,
+			CASE WHEN r.kind='direct' THEN (
+				SELECT o.member FROM room_members o
+				WHERE o.room_id=r.id AND o.member<>s.member LIMIT 1
+			) ELSE r.name END AS name,
+			count(m.id) AS unread
+		FROM seen s
+		JOIN room_members rm ON rm.member=s.member
+		JOIN rooms r ON r.id=rm.room_id
+		JOIN messages m ON m.room_id=rm.room_id AND m.id>rm.last_read
+			AND m.sender<>rm.member AND m.created_at>s.last_seen AND m.deleted_at IS NULL
+		LEFT JOIN digests d ON d.member=s.member
+		WHERE d.sent_at IS NULL OR d.sent_at<s.last_seen
+		GROUP BY s.member,s.last_seen,r.id,r.kind,r.name
+		ORDER BY s.member,unread DESC`,
+		offlineBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var digests []repository.Digest
+	for _, row := range rows {
+		if n := len(digests); n == 0 || digests[n-1].Member != row.Member {
+			digests = append(digests, repository.Digest{Member: row.Member, LastSeen: row.LastSeen})
+		}
+		d := &digests[len(digests)-1]
+		d.Rooms = append(d.Rooms, row.DigestRoom)
+	}
+	return digests, nil
+}
+
+func (p *Postgres) ClaimDigest(ctx context.Context, member string, lastSeen time.Time) (bool, error) {
+	res, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO digests (member,sent_at) VALUES ($1,now())
+		ON CONFLICT (member) DO UPDATE SET sent_at=now() WHERE digests.sent_at<$2`,
+		member,
+		lastSeen,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func addMember(ctx context.Context, db sqlx.ExecerContext, roomID uuid.UUID, member, role string) error {
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO room_members (room_id,member,role) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`,
+		roomID,
+		member,
+		role,
+	)
+	return err
+}
+
+func audit(ctx context.Context, db sqlx.ExecerContext, e repository.AuditEntry) error {
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO moderation_log (room_id,actor,action,target,message_id,reason,expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		e.RoomID,
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.MessageID,
+		e.Reason,
+		e.ExpiresAt,
+	)
+	return err
+}
+
+// expectRow turns updates that matched no row into ErrNotFound.
+func expectRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+const botColumns = `id,name,owner,token_hash,webhook_url,secret,commands,created_at`
+
+func (p *Postgres) CreateBot(ctx context.Context, b repository.Bot) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO bots (id,name,owner,token_hash,webhook_url,secret,commands)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		b.ID,
+		b.Name,
+		b.Owner,
+		b.TokenHash,
+		b.WebhookURL,
+		b.Secret,
+		b.Commands,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (p *Postgres) GetBot(ctx context.Context, id uuid.UUID) (repository.Bot, error) {
+	return p.getBot(ctx, `SELECT `+botColumns+` FROM bots WHERE id=$1`, id)
+}
+
+func (p *Postgres) GetBotByToken(ctx context.Context, tokenHash []byte) (repository.Bot, error) {
+	return p.getBot(ctx, `SELECT `+botColumns+` FROM bots WHERE token_hash=$1`, tokenHash)
+}
+
+func (p *Postgres) getBot(ctx context.Context, query string, arg any) (repository.Bot, error) {
+	var b repository.Bot
+	err := p.db.GetContext(ctx, &b, query, arg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Bot{}, repository.ErrNotFound
+	}
+	return b, err
+}
+
+func (p *Postgres) ListBots(ctx context.Context, owner string) ([]repository.Bot, error) {
+	bots := []repository.Bot{}
+	err := p.db.SelectContext(
+		ctx,
+		&bots,
+		`SELECT `+botColumns+` FROM bots WHERE owner=$1 ORDER BY name`,
+		owner,
+	)
+	return bots, err
+}
+
+func (p *Postgres) ListRoomBots(ctx context.Context, roomID uuid.UUID) ([]repository.Bot, error) {
+	bots := []repository.Bot{}
+	err := p.db.SelectContext(
+		ctx,
+		&bots,
+		`SELECT b.id,b.name,b.owner,b.token_hash,b.webhook_url,b.secret,b.commands,b.created_at
+		FROM bots b JOIN room_members m ON m.member=b.name||'@'||$2::TEXT
+		WHERE m.room_id=$1`,
+		roomID,
+		repository.BotDomain,
+	)
+	return bots, err
+}
+
+func (p *Postgres) DeleteBot(ctx context.Context, id uuid.UUID, owner string) error {
+	return p.inTx(ctx, func(tx *sqlx.Tx) error {
+		var name string
+		err := tx.GetContext(
+			ctx,
+			&name,
+			`DELETE FROM bots WHERE id=$1 AND owner=$2 RETURNING name`,
+			id,
+			owner,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM room_members WHERE member=$1`,
+			repository.Bot{Name: name}.Member(),
+		)
+		return err
+	})
+}
+
+const webhookColumns = `id,room_id,name,created_by,token_hash,created_at`
+
+func (p *Postgres) CreateWebhook(ctx context.Context, w repository.Webhook) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO webhooks (id,room_id,name,created_by,token_hash) VALUES ($1,$2,$3,$4,$5)`,
+		w.ID,
+		w.RoomID,
+		w.Name,
+		w.CreatedBy,
+		w.TokenHash,
+	)
+	return err
+}
+
+func (p *Postgres) GetWebhook(ctx context.Context, id uuid.UUID) (repository.Webhook, error) {
+	var w repository.Webhook
+	err := p.db.GetContext(ctx, &w, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Webhook{}, repository.ErrNotFound
+	}
+	return w, err
+}
+
+func (p *Postgres) ListWebhooks(ctx context.Context, roomID uuid.UUID) ([]repository.Webhook, error) {
+	webhooks := []repository.Webhook{}
+	err := p.db.SelectContext(
+		ctx,
+		&webhooks,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE room_id=$1 ORDER BY created_at`,
+		roomID,
+	)
+	return webhooks, err
+}
+
+func (p *Postgres) DeleteWebhook(ctx context.Context, roomID, id uuid.UUID) error {
+	return expectRow(p.db.ExecContext(
+		ctx,
+		`DELETE FROM webhooks WHERE room_id=$1 AND id=$2`,
+		roomID,
+		id,
+	))
+}
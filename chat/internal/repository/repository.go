@@ -0,0 +1,412 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RoomKindGroup  = "group"
+	RoomKindDirect = "direct"
+)
+
+// Room is a group room anyone can join, or the direct room of two users.
+// Users are identified by the email their token carries.
+type Room struct {
+	ID   uuid.UUID `db:"id" json:"id"`
+	Kind string    `db:"kind" json:"kind"`
+	// Name is empty for direct rooms.
+	Name      string    `db:"name" json:"name,omitempty"`
+	CreatedBy string    `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Member is a user in a room. Members of direct rooms are all RoleMember.
+type Member struct {
+	Member string `db:"member" json:"member"`
+	Role   string `db:"role" json:"role"`
+	// MutedUntil is set while the member may not post.
+	MutedUntil *time.Time `db:"muted_until" json:"muted_until,omitempty"`
+	JoinedAt   time.Time  `db:"joined_at" json:"joined_at"`
+}
+
+// Muted reports whether m may not post at now.
+func (m Member) Muted(now time.Time) bool {
+	return m.MutedUntil != nil && m.MutedUntil.After(now)
+}
+
+// Block is a user blocked by another.
+type Block struct {
+	Blocked   string    `db:"blocked" json:"email"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Moderation actions, as recorded in the audit log.
+const (
+	AuditRole    = "member.role"
+	AuditOwner   = "room.owner"
+	AuditMute    = "member.mute"
+	AuditUnmute  = "member.unmute"
+	AuditKick    = "member.kick"
+	AuditBan     = "member.ban"
+	AuditUnban   = "member.unban"
+	AuditBlock   = "user.block"
+	AuditUnblock = "user.unblock"
+	AuditDelete  = "message.delete"
+	AuditFlag    = "message.flag"
+	AuditReject  = "message.reject"
+)
+
+// AuditEntry records a moderation action of Actor on Target, a user. The
+// message filters act as filter:<name>, e.g. filter:spam.
+type AuditEntry struct {
+	ID int64 `db:"id" json:"id"`
+	// RoomID is nil for blocks.
+	RoomID    *uuid.UUID `db:"room_id" json:"room_id,omitempty"`
+	Actor     string     `db:"actor" json:"actor"`
+	Action    string     `db:"action" json:"action"`
+	Target    string     `db:"target" json:"target,omitempty"`
+	MessageID *int64     `db:"message_id" json:"message_id,omitempty"`
+	// Reason holds the new role of role changes.
+	Reason string `db:"reason" json:"reason,omitempty"`
+	// ExpiresAt is when a mute ends.
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+type Message struct {
+	// ID orders the messages of a room.
+	ID     int64     `db:"id" json:"id"`
+	RoomID uuid.UUID `db:"room_id" json:"room_id"`
+	// ParentID is the first message of the thread a reply belongs to.
+	ParentID *int64 `db:"parent_id" json:"parent_id,omitempty"`
+	Sender   string `db:"sender" json:"sender"`
+	// Body is empty once the message is deleted.
+	Body      string     `db:"body" json:"body"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	EditedAt  *time.Time `db:"edited_at" json:"edited_at,omitempty"`
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+	// Replies counts the replies of a thread's first message.
+	Replies     int          `db:"replies" json:"replies,omitempty"`
+	Reactions   []Reaction   `db:"-" json:"reactions,omitempty"`
+	Attachments []Attachment `db:"-" json:"attachments,omitempty"`
+}
+
+const (
+	AttachmentPending = "pending"
+	AttachmentReady   = "ready"
+
+	ThumbnailNone    = "none"
+	ThumbnailPending = "pending"
+	ThumbnailReady   = "ready"
+	ThumbnailFailed  = "failed"
+)
+
+// Attachment is a file uploaded to a room, its content is kept in a blob
+// store. It is pending until uploaded.
+type Attachment struct {
+	ID     uuid.UUID `db:"id" json:"id"`
+	RoomID uuid.UUID `db:"room_id" json:"room_id"`
+	// MessageID is set once the attachment is sent.
+	MessageID   *int64    `db:"message_id" json:"message_id,omitempty"`
+	Uploader    string    `db:"uploader" json:"uploader"`
+	Name        string    `db:"name" json:"name"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size" json:"size"`
+	Status      string    `db:"status" json:"status"`
+	Thumbnail   string    `db:"thumbnail" json:"-"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// URL and ThumbnailURL are signed for whoever the attachment is
+	// served to, ThumbnailURL once the thumbnail is ready.
+	URL          string `db:"-" json:"url,omitempty"`
+	ThumbnailURL string `db:"-" json:"thumbnail_url,omitempty"`
+}
+
+// SearchQuery finds the messages matching Text in the rooms Member is in.
+// Zero filters are left out.
+type SearchQuery struct {
+	Member string
+	// Text is in the web search syntax: words, "quoted phrases", or and
+	// -excluded words.
+	Text   string
+	RoomID uuid.UUID
+	Sender string
+	// From and To bound when messages were sent, To excluded.
+	From time.Time
+	To   time.Time
+	// Before pages like ListMessages.
+	Before int64
+	Limit  int
+}
+
+// SearchResult is a message found by a search. Highlight is an excerpt of
+// its body, HTML escaped, with the matches wrapped in <mark> elements.
+type SearchResult struct {
+	Message
+	Highlight string `db:"highlight" json:"highlight"`
+}
+
+// Reaction aggregates the reactions to a message with one emoji.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// Edit is a body a message had before it was edited at EditedAt.
+type Edit struct {
+	Body     string    `db:"body" json:"body"`
+	EditedAt time.Time `db:"edited_at" json:"edited_at"`
+}
+
+// Unread is where a member stands in a room.
+type Unread struct {
+	RoomID uuid.UUID `db:"room_id" json:"room_id"`
+	// LastRead is the id of the newest message read, 0 before the first.
+	LastRead int64 `db:"last_read" json:"last_read"`
+	// Count leaves out the member's own messages.
+	Count int `db:"unread" json:"unread"`
+}
+
+// Bots and incoming webhooks take part in rooms under addresses of these
+// domains, which no user can have.
+const (
+	BotDomain     = "bots.invalid"
+	WebhookDomain = "hooks.invalid"
+)
+
+// Bot is an integration posting through the API with a token. The rooms it
+// is added to send it their commands and mentions on WebhookURL, signed with
+// Secret.
+type Bot struct {
+	ID         uuid.UUID `db:"id" json:"id"`
+	Name       string    `db:"name" json:"name"`
+	Owner      string    `db:"owner" json:"owner"`
+	TokenHash  []byte    `db:"token_hash" json:"-"`
+	WebhookURL string    `db:"webhook_url" json:"webhook_url,omitempty"`
+	Secret     string    `db:"secret" json:"-"`
+	Commands   Commands  `db:"commands" json:"commands"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// Member is the address the bot takes part in rooms with.
+func (b Bot) Member() string {
+	return b.Name + "@" + BotDomain
+}
+
+// Commands are the slash commands of a bot without their slash, stored
+// space separated.
+type Commands []string
+
+func (c Commands) Value() (driver.Value, error) {
+	return strings.Join(c, " "), nil
+}
+
+func (c *Commands) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		*c = strings.Fields(src)
+	case []byte:
+		*c = strings.Fields(string(src))
+	case nil:
+		*c = nil
+	default:
+		return fmt.Errorf("cannot scan %T into Commands", src)
+	}
+	return nil
+}
+
+// Webhook is an incoming webhook, posting into RoomID whoever holds its
+// token.
+type Webhook struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	RoomID    uuid.UUID `db:"room_id" json:"room_id"`
+	Name      string    `db:"name" json:"name"`
+	CreatedBy string    `db:"created_by" json:"created_by"`
+	TokenHash []byte    `db:"token_hash" json:"-"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Member is the address the webhook posts with.
+func (w Webhook) Member() string {
+	return w.ID.String() + "@" + WebhookDomain
+}
+
+// Digest sums up what a member missed since they were last seen.
+type Digest struct {
+	Member   string
+	LastSeen time.Time
+	Rooms    []DigestRoom
+}
+
+type DigestRoom struct {
+	RoomID uuid.UUID `db:"room_id"`
+	Kind   string    `db:"kind"`
+	// Name is the other member for direct rooms.
+	Name   string `db:"name"`
+	Unread int    `db:"unread"`
+}
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+type Repository interface {
+	// CreateRoom inserts room along with its first members. The creator of
+	// a group room owns it.
+	CreateRoom(ctx context.Context, room Room, members ...string) error
+	// GetOrCreateDirectRoom returns the direct room of a and b, creating it
+	// as room when there is none yet.
+	GetOrCreateDirectRoom(ctx context.Context, room Room, a, b string) (Room, error)
+	// GetRoom returns ErrNotFound for unknown rooms.
+	GetRoom(ctx context.Context, id uuid.UUID) (Room, error)
+	// ListRooms returns the rooms member is in, most recent first, leaving
+	// out the direct rooms with users member blocked.
+	ListRooms(ctx context.Context, member string) ([]Room, error)
+	// AddMember is a no-op for existing members.
+	AddMember(ctx context.Context, roomID uuid.UUID, member string) error
+	RemoveMember(ctx context.Context, roomID uuid.UUID, member string) error
+	IsMember(ctx context.Context, roomID uuid.UUID, member string) (bool, error)
+	ListMembers(ctx context.Context, roomID uuid.UUID) ([]string, error)
+	// GetMember returns ErrNotFound when member is not in the room.
+	GetMember(ctx context.Context, roomID uuid.UUID, member string) (Member, error)
+	// ListRoomMembers returns the members of a room with their roles,
+	// owner first.
+	ListRoomMembers(ctx context.Context, roomID uuid.UUID) ([]Member, error)
+	IsBanned(ctx context.Context, roomID uuid.UUID, member string) (bool, error)
+	// IsBlocked reports whether either of a and b blocked the other.
+	IsBlocked(ctx context.Context, a, b string) (bool, error)
+	ListBlocks(ctx context.Context, blocker string) ([]Block, error)
+
+	// The moderation actions below record e in the audit log along with
+	// the change they make, the room, users and reason are taken from it.
+	// Those acting on a member return ErrNotFound when e.Target is not in
+	// the room.
+
+	// SetRole gives e.Target role.
+	SetRole(ctx context.Context, e AuditEntry, role string) error
+	// TransferOwnership makes e.Target the owner of the room, and its owner
+	// e.Actor a moderator.
+	TransferOwnership(ctx context.Context, e AuditEntry) error
+	// Mute keeps e.Target from posting until e.ExpiresAt, nil unmutes.
+	Mute(ctx context.Context, e AuditEntry) error
+	// Kick removes e.Target from the room.
+	Kick(ctx context.Context, e AuditEntry) error
+	// Ban removes e.Target from the room, if they are in it, and keeps
+	// them from joining again.
+	Ban(ctx context.Context, e AuditEntry) error
+	// Unban returns ErrNotFound when e.Target is not banned.
+	Unban(ctx context.Context, e AuditEntry) error
+	// Block and Unblock are no-ops, audited all the same, when e.Actor
+	// has or has not blocked e.Target.
+	Block(ctx context.Context, e AuditEntry) error
+	Unblock(ctx context.Context, e AuditEntry) error
+	// Audit records an action taken by other means, e.g. a message deleted
+	// by a moderator.
+	Audit(ctx context.Context, e AuditEntry) error
+	// ListAudit pages through the audit log of a room like ListMessages.
+	ListAudit(ctx context.Context, roomID uuid.UUID, before int64, limit int) ([]AuditEntry, error)
+	// ListContacts returns everyone sharing a room with member, member
+	// included.
+	ListContacts(ctx context.Context, member string) ([]string, error)
+	// CreateMessage stores msg, filling in its ID and CreatedAt, and sends
+	// the attachments attachmentIDs with it. It returns ErrNotFound unless
+	// they are all ready, unsent and were uploaded to the room by the
+	// sender.
+	CreateMessage(ctx context.Context, msg *Message, attachmentIDs ...uuid.UUID) error
+	// GetMessage returns ErrNotFound for messages that are not in the room.
+	GetMessage(ctx context.Context, roomID uuid.UUID, id int64) (Message, error)
+	// EditMessage replaces the body of a message that is not deleted,
+	// keeping the previous one in its edits.
+	EditMessage(ctx context.Context, roomID uuid.UUID, id int64, body string) (Message, error)
+	// DeleteMessage turns a message into a tombstone.
+	DeleteMessage(ctx context.Context, roomID uuid.UUID, id int64) (Message, error)
+	// ListEdits returns the previous bodies of a message, oldest first.
+	ListEdits(ctx context.Context, messageID int64) ([]Edit, error)
+	// ListMessages returns up to limit messages of a room older than the
+	// message before, newest first, leaving out replies. A zero before
+	// starts at the newest.
+	ListMessages(ctx context.Context, roomID uuid.UUID, before int64, limit int) ([]Message, error)
+	// ListReplies pages through the replies of a thread like ListMessages.
+	ListReplies(ctx context.Context, parentID int64, before int64, limit int) ([]Message, error)
+
+	// AddReaction is a no-op for existing reactions.
+	AddReaction(ctx context.Context, messageID int64, member, emoji string) error
+	RemoveReaction(ctx context.Context, messageID int64, member, emoji string) error
+	// ListReactions returns the reactions to each of messageIDs, most used
+	// first.
+	ListReactions(ctx context.Context, messageIDs ...int64) (map[int64][]Reaction, error)
+	// CreateAttachment stores a pending attachment.
+	CreateAttachment(ctx context.Context, a Attachment) error
+	// GetAttachment returns ErrNotFound for unknown attachments and those
+	// of deleted messages.
+	GetAttachment(ctx context.Context, id uuid.UUID) (Attachment, error)
+	// MarkUploaded makes a pending attachment ready, with the size and
+	// content type of what was uploaded. It returns ErrNotFound when the
+	// attachment is not pending.
+	MarkUploaded(ctx context.Context, id uuid.UUID, size int64, contentType, thumbnail string) (Attachment, error)
+	SetThumbnail(ctx context.Context, id uuid.UUID, thumbnail string) error
+	// ListAttachments returns the attachments of each of messageIDs.
+	ListAttachments(ctx context.Context, messageIDs ...int64) (map[int64][]Attachment, error)
+	// ListPendingThumbnails returns the ids of up to limit attachments
+	// uploaded before, whose thumbnail is still pending.
+	ListPendingThumbnails(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error)
+	// DeleteStaleUploads drops the attachments still pending that were
+	// created before.
+	DeleteStaleUploads(ctx context.Context, before time.Time) (int64, error)
+
+	// SearchMessages returns up to limit messages matching q, newest first,
+	// leaving out deleted ones.
+	SearchMessages(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+
+	// ListMessagesSince returns up to limit messages of the rooms member is
+	// in newer than since, oldest first. A zero since starts after the
+	// member's read cursor of each room.
+	ListMessagesSince(ctx context.Context, member string, since int64, limit int) ([]Message, error)
+
+	// MarkRead moves the read cursor of member forward to messageID and
+	// returns where it stands. It returns ErrNotFound when the message is
+	// not in the room.
+	MarkRead(ctx context.Context, roomID uuid.UUID, member string, messageID int64) (int64, error)
+	ListUnread(ctx context.Context, member string) ([]Unread, error)
+
+	// TouchPresence records that members are connected to replica.
+	TouchPresence(ctx context.Context, replica string, members ...string) error
+	// ListDigests returns the members last seen before offlineBefore that
+	// got messages since, and no digest of them yet.
+	ListDigests(ctx context.Context, offlineBefore time.Time) ([]Digest, error)
+	// ClaimDigest records that the digest of member for the time they were
+	// last seen is being sent. It reports false when it was claimed already.
+	ClaimDigest(ctx context.Context, member string, lastSeen time.Time) (bool, error)
+
+	// CreateBot returns ErrConflict when the name is taken.
+	CreateBot(ctx context.Context, b Bot) error
+	// GetBot and GetBotByToken return ErrNotFound for unknown bots.
+	GetBot(ctx context.Context, id uuid.UUID) (Bot, error)
+	GetBotByToken(ctx context.Context, tokenHash []byte) (Bot, error)
+	ListBots(ctx context.Context, owner string) ([]Bot, error)
+	// ListRoomBots returns the bots that are members of a room.
+	ListRoomBots(ctx context.Context, roomID uuid.UUID) ([]Bot, error)
+	// DeleteBot removes the bot of owner from its rooms too. It returns
+	// ErrNotFound when owner has no such bot.
+	DeleteBot(ctx context.Context, id uuid.UUID, owner string) error
+	CreateWebhook(ctx context.Context, w Webhook) error
+	// GetWebhook returns ErrNotFound for unknown webhooks.
+	GetWebhook(ctx context.Context, id uuid.UUID) (Webhook, error)
+	ListWebhooks(ctx context.Context, roomID uuid.UUID) ([]Webhook, error)
+	// DeleteWebhook returns ErrNotFound when the room has no such webhook.
+	DeleteWebhook(ctx context.Context, roomID, id uuid.UUID) error
+}
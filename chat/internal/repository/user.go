@@ -1,3 +0,0 @@
-package repository
-
-type UserRepository interface{}
@@ -0,0 +1,46 @@
+// Package storage keeps the content of attachments and their thumbnails
+// out of the database, on the local disk or in an S3 compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/viper"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs under keys made of path segments, such as
+// attachments/<id>.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob
+	// there. A negative size is unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound for unknown keys. The caller closes the
+	// reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete is a no-op for unknown keys.
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store chat.storage.kind names.
+func New(ctx context.Context) (BlobStore, error) {
+	switch kind := viper.GetString("chat.storage.kind"); kind {
+	case "", "local":
+		return NewLocal(viper.GetString("chat.storage.local.dir"))
+	case "s3":
+		return NewS3(ctx, S3Config{
+			Endpoint:  viper.GetString("chat.storage.s3.endpoint"),
+			Region:    viper.GetString("chat.storage.s3.region"),
+			Bucket:    viper.GetString("chat.storage.s3.bucket"),
+			AccessKey: viper.GetString("chat.storage.s3.accesskey"),
+			SecretKey: viper.GetString("chat.storage.s3.secretkey"),
+			UseSSL:    viper.GetBool("chat.storage.s3.usessl"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown chat.storage.kind %q", kind)
+	}
+}
@@ -0,0 +1,73 @@
+// Package thumbnail scales uploaded images down for previews.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ContentType is the type of the thumbnails Make writes.
+const ContentType = "image/jpeg"
+
+// MaxPixels bounds the images decoded, a small file can hold a huge image.
+const MaxPixels = 50_000_000
+
+var ErrInvalidImage = errors.New("not a supported image")
+
+// Supported reports whether thumbnails can be made of images of the media
+// type contentType.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Make writes a JPEG of the image read from r, scaled down to fit a size x
+// size square. Transparent parts turn white.
+func Make(w io.Writer, r io.Reader, size int) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", ErrInvalidImage, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	width, height := fit(cfg.Width, cfg.Height, size)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+}
+
+// fit scales width and height down to at most size, keeping their ratio.
+// Smaller images keep their size.
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
@@ -0,0 +1,79 @@
+package thumbnail
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+)
+
+// Maker is the part of the chat service the worker drives.
+type Maker interface {
+	// MakeThumbnail makes the thumbnail of the attachment id.
+	MakeThumbnail(ctx context.Context, id uuid.UUID) error
+	// SweepUploads drops upload slots that expired unused and returns the
+	// images still waiting for a thumbnail.
+	SweepUploads(ctx context.Context) ([]uuid.UUID, error)
+}
+
+// Worker makes thumbnails in the background. Uploads handled by this
+// replica are queued, those it missed, because the queue was full or a
+// replica stopped, are found by a regular sweep. A thumbnail made twice by
+// two replicas is only wasted work.
+type Worker struct {
+	queue    chan uuid.UUID
+	interval time.Duration
+	logger   log.Logger
+}
+
+// NewWorker returns a worker queueing up to queue attachments and sweeping
+// every interval.
+func NewWorker(queue int, interval time.Duration, logger log.Logger) *Worker {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Worker{
+		queue:    make(chan uuid.UUID, queue),
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Enqueue never blocks, the attachment is left to the sweep when the queue
+// is full.
+func (w *Worker) Enqueue(id uuid.UUID) {
+	select {
+	case w.queue <- id:
+	default:
+	}
+}
+
+// Run makes the thumbnails with m until ctx is done.
+func (w *Worker) Run(ctx context.Context, m Maker) error {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case id := <-w.queue:
+			w.make(ctx, m, id)
+		case <-t.C:
+			ids, err := m.SweepUploads(ctx)
+			if err != nil {
+				w.logger.Log("during", "sweep", "err", err)
+				continue
+			}
+			for _, id := range ids {
+				w.make(ctx, m, id)
+			}
+		}
+	}
+}
+
+func (w *Worker) make(ctx context.Context, m Maker, id uuid.UUID) {
+	if err := m.MakeThumbnail(ctx, id); err != nil {
+		w.logger.Log("during", "thumbnail", "attachment", id, "err", err)
+	}
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+)
+
+// messageIDHeader carries the call id, like the message-id header of the
+// other services' topics.
+const messageIDHeader = "message-id"
+
+// Kafka queues calls on cfg.Topic, keyed by bot so that the calls of a bot
+// keep their order. The replicas share a consumer group, each call is made
+// by one of them.
+//
+// Retries wait on a topic of their own, so that a call waiting for its
+// next attempt does not hold back new ones. A retry read before it is due
+// pauses its partition until then, the consumer keeps polling meanwhile so
+// that it stays in its group.
+type Kafka struct {
+	cfg      Config
+	producer *kafka.Producer
+	calls    *kafka.Consumer
+	retries  *kafka.Consumer
+	logger   log.Logger
+
+	// mu guards closed, the consumers of a closed Kafka are not resumed
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewKafka(cfg Config, logger log.Logger) (*Kafka, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": cfg.Brokers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	k := &Kafka{cfg: cfg, producer: p, logger: logger}
+	for topic, c := range map[string]**kafka.Consumer{
+		cfg.Topic:            &k.calls,
+		cfg.Topic + "-retry": &k.retries,
+	} {
+		*c, err = kafka.NewConsumer(&kafka.ConfigMap{
+			"bootstrap.servers":        cfg.Brokers,
+			"group.id":                 "chat-webhooks",
+			"auto.offset.reset":        "earliest",
+			"allow.auto.create.topics": true,
+			// offsets are stored once a call is made or queued again, a
+			// postponed retry must be read again after a restart
+			"enable.auto.offset.store": false,
+		})
+		if err == nil {
+			err = (*c).Subscribe(topic, nil)
+		}
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+	}
+	go k.reportDeliveries()
+	return k, nil
+}
+
+func (k *Kafka) Queue(_ context.Context, ev Event) error {
+	return k.publish(k.cfg.Topic, Call{ID: uuid.NewString(), Event: ev})
+}
+
+func (k *Kafka) publish(topic string, call Call) error {
+	data, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	return k.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            []byte(call.Event.BotID.String()),
+		Value:          data,
+		Headers: []kafka.Header{
+			{Key: messageIDHeader, Value: []byte(call.ID)},
+		},
+	}, nil)
+}
+
+// Run makes the queued calls with s until ctx is done.
+func (k *Kafka) Run(ctx context.Context, s *Sender) error {
+	errc := make(chan error, 2)
+	for _, c := range []*kafka.Consumer{k.calls, k.retries} {
+		go func() {
+			errc <- k.consume(ctx, c, s)
+		}()
+	}
+	err := <-errc
+	<-errc
+	return err
+}
+
+func (k *Kafka) consume(ctx context.Context, c *kafka.Consumer, s *Sender) error {
+	for ctx.Err() == nil {
+		msg, err := c.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			var kerr kafka.Error
+			if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			k.logger.Log("during", "read", "err", err)
+			continue
+		}
+		var call Call
+		if err := json.Unmarshal(msg.Value, &call); err != nil {
+			k.logger.Log("during", "decode", "offset", msg.TopicPartition.Offset, "err", err)
+			continue
+		}
+		// retries are queued in the order they are due, give or take
+		// their backoff
+		if wait := time.Until(call.NotBefore); wait > 0 {
+			if err := k.postpone(c, msg.TopicPartition, wait); err != nil {
+				k.logger.Log("during", "postpone", "call", call.ID, "err", err)
+			}
+			continue
+		}
+		k.deliver(ctx, s, call)
+		if _, err := c.StoreMessage(msg); err != nil {
+			k.logger.Log("during", "store offset", "call", call.ID, "err", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// postpone pauses the partition of tp for wait and rewinds it to tp, the
+// message there is read again once the partition is resumed.
+func (k *Kafka) postpone(c *kafka.Consumer, tp kafka.TopicPartition, wait time.Duration) error {
+	tp.Error = nil
+	partitions := []kafka.TopicPartition{tp}
+	if err := c.Pause(partitions); err != nil {
+		return err
+	}
+	if err := c.Seek(tp, 0); err != nil {
+		return err
+	}
+	time.AfterFunc(wait, func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if k.closed {
+			return
+		}
+		// fails when the partition was assigned elsewhere meanwhile, its
+		// new consumer reads the retry again
+		if err := c.Resume(partitions); err != nil {
+			k.logger.Log("during", "resume", "partition", tp.Partition, "err", err)
+		}
+	})
+	return nil
+}
+
+func (k *Kafka) deliver(ctx context.Context, s *Sender, call Call) {
+	err := s.Deliver(ctx, call)
+	if err == nil {
+		return
+	}
+	next, ok := k.cfg.retry(call, time.Now())
+	if errors.Is(err, ErrPermanent) || !ok {
+		k.logger.Log("during", "deliver", "call", call.ID, "attempt", call.Attempt, "err", err, "dropped", true)
+		return
+	}
+	k.logger.Log("during", "deliver", "call", call.ID, "attempt", call.Attempt, "err", err, "retry_at", next.NotBefore)
+	if err := k.publish(k.cfg.Topic+"-retry", next); err != nil {
+		k.logger.Log("during", "retry", "call", call.ID, "err", err)
+	}
+}
+
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	k.closed = true
+	k.mu.Unlock()
+	k.producer.Flush(5000)
+	k.producer.Close()
+	for _, c := range []*kafka.Consumer{k.calls, k.retries} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// reportDeliveries logs the calls that could not be queued. It returns
+// once the producer is closed.
+func (k *Kafka) reportDeliveries() {
+	for e := range k.producer.Events() {
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			k.logger.Log("during", "publish", "err", m.TopicPartition.Error)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+)
+
+// ErrQueueFull is returned by Memory.Queue when calls come in faster than
+// they are made.
+var ErrQueueFull = errors.New("webhook queue full")
+
+// Memory keeps calls within the replica, for running without Kafka. Calls
+// still queued or waiting for a retry are lost when it stops.
+type Memory struct {
+	cfg    Config
+	calls  chan Call
+	logger log.Logger
+}
+
+func NewMemory(cfg Config, logger log.Logger) *Memory {
+	return &Memory{
+		cfg:    cfg,
+		calls:  make(chan Call, 1024),
+		logger: logger,
+	}
+}
+
+func (m *Memory) Queue(_ context.Context, ev Event) error {
+	return m.push(Call{ID: uuid.NewString(), Event: ev})
+}
+
+func (m *Memory) push(call Call) error {
+	select {
+	case m.calls <- call:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run makes the queued calls with s until ctx is done.
+func (m *Memory) Run(ctx context.Context, s *Sender) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case call := <-m.calls:
+			go m.deliver(ctx, s, call)
+		}
+	}
+}
+
+func (m *Memory) deliver(ctx context.Context, s *Sender, call Call) {
+	err := s.Deliver(ctx, call)
+	if err == nil {
+		return
+	}
+	next, ok := m.cfg.retry(call, time.Now())
+	if errors.Is(err, ErrPermanent) || !ok {
+		m.logger.Log("during", "deliver", "call", call.ID, "attempt", call.Attempt, "err", err, "dropped", true)
+		return
+	}
+	m.logger.Log("during", "deliver", "call", call.ID, "attempt", call.Attempt, "err", err, "retry_at", next.NotBefore)
+	time.AfterFunc(time.Until(next.NotBefore), func() {
+		if err := m.push(next); err != nil {
+			m.logger.Log("during", "retry", "call", call.ID, "err", err)
+		}
+	})
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,280 @@
+// Package webhook delivers the outgoing webhook calls of bots: the slash
+// commands and mentions of the rooms they were added to.
+//
+// Calls are queued on a Kafka topic and made by whichever replica consumes
+// them. Failed calls are queued again on a retry topic, delayed by a
+// backoff doubling with every attempt, until Config.MaxAttempts. Every call
+// is signed with the secret of its bot, see Sign.
+//
+// Webhooks are https urls of public hosts, chat must not be made to call
+// the services on its own network, see CheckURL and NewSender.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-chat/internal/repository"
+)
+
+const (
+	EventCommand = "command"
+	EventMention = "mention"
+)
+
+const (
+	// DeliveryHeader carries the id of a call, the same for every attempt
+	// so that bots can drop duplicates.
+	DeliveryHeader = "X-Chat-Delivery"
+	// TimestampHeader carries when the call was made, in unix seconds.
+	TimestampHeader = "X-Chat-Timestamp"
+	// SignatureHeader carries Sign of the call, prefixed with sha256=.
+	SignatureHeader = "X-Chat-Signature"
+)
+
+// Event is the body of a call.
+type Event struct {
+	Type    string             `json:"type"`
+	BotID   uuid.UUID          `json:"bot_id"`
+	RoomID  uuid.UUID          `json:"room_id"`
+	Message repository.Message `json:"message"`
+	// Command, without its slash, and Text, the rest of the message, are
+	// set on command events only.
+	Command string `json:"command,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// Call is an event queued for delivery.
+type Call struct {
+	ID      string `json:"id"`
+	Event   Event  `json:"event"`
+	Attempt int    `json:"attempt"`
+	// NotBefore delays retries.
+	NotBefore time.Time `json:"not_before,omitempty"`
+}
+
+type Queue interface {
+	// Queue publishes ev for delivery to its bot.
+	Queue(ctx context.Context, ev Event) error
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the call made at ts with
+// body, keyed by the secret of the bot. Bots compute it over the
+// TimestampHeader, a dot and the raw body, and refuse calls whose
+// timestamp is more than a few minutes off.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type Config struct {
+	// Kind is kafka, or memory to keep calls within a single replica,
+	// which loses those still queued when it stops.
+	Kind    string
+	Brokers string
+	// Topic holds new calls, Topic-retry those that failed.
+	Topic       string
+	MaxAttempts int
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+	// Timeout bounds every call.
+	Timeout time.Duration
+}
+
+// LoadConfig reads the chat.webhooks key, filling in defaults.
+func LoadConfig() Config {
+	cfg := Config{
+		Kind:        viper.GetString("chat.webhooks.kind"),
+		Brokers:     viper.GetString("chat.webhooks.brokers"),
+		Topic:       viper.GetString("chat.webhooks.topic"),
+		MaxAttempts: viper.GetInt("chat.webhooks.maxattempts"),
+		Backoff:     viper.GetDuration("chat.webhooks.backoff"),
+		Timeout:     viper.GetDuration("chat.webhooks.timeout"),
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "chat-webhooks"
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return cfg
+}
+
+// maxBackoff bounds the delay between attempts.
+const maxBackoff = time.Hour
+
+// retry returns the next attempt of call, failing at now, or false once
+// its attempts are used up.
+func (cfg Config) retry(call Call, now time.Time) (Call, bool) {
+	call.Attempt++
+	if call.Attempt >= cfg.MaxAttempts {
+		return Call{}, false
+	}
+	delay := cfg.Backoff << (call.Attempt - 1)
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	call.NotBefore = now.Add(delay)
+	return call, true
+}
+
+var (
+	// ErrPermanent is wrapped by the errors of calls not worth retrying.
+	ErrPermanent  = errors.New("permanent failure")
+	ErrInvalidURL = errors.New("webhook url must be an https url of a public host")
+	// ErrNotPublic is wrapped by the errors of calls to loopback, private
+	// or link local addresses.
+	ErrNotPublic = errors.New("webhook address is not public")
+)
+
+// CheckURL refuses webhook urls that are not https, or whose host is an
+// address that is not public. Names are checked when they are called, as
+// they may resolve elsewhere by then.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return ErrInvalidURL
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !public(ip) {
+		return ErrInvalidURL
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+func public(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// publicOnly is the net.Dialer Control refusing addresses that are not
+// public. It sees the address a name resolved to right before connecting,
+// a name cannot be pointed at an internal address after it was checked.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !public(ip) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, host)
+	}
+	return nil
+}
+
+// Bots looks up the bot of a call, a repository.Repository does.
+type Bots interface {
+	GetBot(ctx context.Context, id uuid.UUID) (repository.Bot, error)
+}
+
+// Sender makes calls.
+type Sender struct {
+	bots   Bots
+	client *http.Client
+}
+
+// NewSender returns a Sender connecting to public addresses only.
+func NewSender(bots Bots, timeout time.Duration) *Sender {
+	return newSender(bots, timeout, publicOnly)
+}
+
+// newSender dials with control, the tests call their local servers.
+func newSender(bots Bots, timeout time.Duration, control func(network, address string, c syscall.RawConn) error) *Sender {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+	return &Sender{
+		bots: bots,
+		client: &http.Client{
+			Timeout: timeout,
+			// no Proxy, a proxy would connect for us and skip the check
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+			},
+			// a redirect would carry the signature somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Deliver makes call. Calls of bots deleted since, or without a webhook,
+// fail with ErrPermanent, as do those the bot refused with a 4xx.
+func (s *Sender) Deliver(ctx context.Context, call Call) error {
+	bot, err := s.bots.GetBot(ctx, call.Event.BotID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("%w: bot %s is gone", ErrPermanent, call.Event.BotID)
+	}
+	if err != nil {
+		return err
+	}
+	if bot.WebhookURL == "" {
+		return fmt.Errorf("%w: bot %s has no webhook", ErrPermanent, bot.ID)
+	}
+	// bots created before webhooks had to be https are not called
+	if u, err := url.Parse(bot.WebhookURL); err != nil || u.Scheme != "https" {
+		return fmt.Errorf("%w: bot %s: %w", ErrPermanent, bot.ID, ErrInvalidURL)
+	}
+	body, err := json.Marshal(call.Event)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPermanent, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPermanent, err)
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, call.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(bot.Secret, ts, body))
+
+	resp, err := s.client.Do(req)
+	if errors.Is(err, ErrNotPublic) {
+		return fmt.Errorf("%w: %w", ErrPermanent, err)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return fmt.Errorf("%w: webhook answered %s", ErrPermanent, resp.Status)
+	}
+	return fmt.Errorf("webhook answered %s", resp.Status)
+}
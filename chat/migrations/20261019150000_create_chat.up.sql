@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS rooms(
+	id UUID PRIMARY KEY,
+	kind VARCHAR(10) NOT NULL CHECK (kind IN ('group', 'direct')),
+	name VARCHAR(100) NOT NULL DEFAULT '',
+	-- direct_key is the sorted pair of members of a direct room, so that two
+	-- users share a single one
+	direct_key VARCHAR(511) UNIQUE,
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS room_members(
+	room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+	member VARCHAR(255) NOT NULL,
+	joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (room_id, member)
+);
+
+CREATE INDEX IF NOT EXISTS room_members_member_idx ON room_members(member);
+
+CREATE TABLE IF NOT EXISTS messages(
+	id BIGSERIAL PRIMARY KEY,
+	room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+	sender VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- history is paged by id within a room
+CREATE INDEX IF NOT EXISTS messages_room_id_idx ON messages(room_id, id DESC);
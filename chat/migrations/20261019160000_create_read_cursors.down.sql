@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS digests;
+DROP TABLE IF EXISTS presence;
+ALTER TABLE room_members DROP COLUMN IF EXISTS last_read;
@@ -0,0 +1,16 @@
+-- last_read is the id of the newest message the member has read in the room
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read BIGINT NOT NULL DEFAULT 0;
+
+-- seen_at is touched by every replica holding connections of the member, so
+-- the newest one is when they were last online
+CREATE TABLE IF NOT EXISTS presence(
+	member VARCHAR(255) NOT NULL,
+	replica VARCHAR(64) NOT NULL,
+	seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (member, replica)
+);
+
+CREATE TABLE IF NOT EXISTS digests(
+	member VARCHAR(255) PRIMARY KEY,
+	sent_at TIMESTAMPTZ NOT NULL
+);
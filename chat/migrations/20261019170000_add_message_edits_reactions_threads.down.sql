@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS message_edits;
+DROP INDEX IF EXISTS messages_parent_id_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS edited_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS parent_id;
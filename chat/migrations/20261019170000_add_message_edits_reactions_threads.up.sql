@@ -0,0 +1,26 @@
+-- replies of a thread point at its first message, threads do not nest
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+-- deleted messages stay as tombstones, their body is no longer served
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS messages_parent_id_idx ON messages(parent_id, id DESC)
+	WHERE parent_id IS NOT NULL;
+
+-- message_edits keeps the bodies messages had before each edit
+CREATE TABLE IF NOT EXISTS message_edits(
+	id BIGSERIAL PRIMARY KEY,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	body TEXT NOT NULL,
+	edited_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_message_id_idx ON message_edits(message_id, id);
+
+CREATE TABLE IF NOT EXISTS reactions(
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	member VARCHAR(255) NOT NULL,
+	emoji VARCHAR(32) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (message_id, member, emoji)
+);
@@ -0,0 +1,21 @@
+-- attachments are uploaded into a slot before the message carrying them is
+-- sent, message_id is set then
+CREATE TABLE IF NOT EXISTS attachments(
+	id UUID PRIMARY KEY,
+	room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+	message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+	uploader VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','ready')),
+	-- none for files that are not images
+	thumbnail VARCHAR(10) NOT NULL DEFAULT 'none'
+		CHECK (thumbnail IN ('none','pending','ready','failed')),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments(message_id)
+	WHERE message_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS attachments_pending_idx ON attachments(created_at)
+	WHERE status='pending' OR thumbnail='pending';
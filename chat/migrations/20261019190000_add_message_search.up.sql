@@ -0,0 +1,6 @@
+-- the simple configuration does not stem, so search works alike for every
+-- language the messages are written in
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search TSVECTOR
+	GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED;
+
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN(search);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS room_bans;
+ALTER TABLE room_members DROP COLUMN IF EXISTS muted_until;
+ALTER TABLE room_members DROP COLUMN IF EXISTS role;
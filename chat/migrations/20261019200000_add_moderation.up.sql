@@ -0,0 +1,44 @@
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role VARCHAR(10) NOT NULL DEFAULT 'member'
+	CHECK (role IN ('owner','moderator','member'));
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ;
+
+-- group rooms created so far are owned by their creator, while a member
+UPDATE room_members m SET role='owner'
+FROM rooms r
+WHERE r.id=m.room_id AND r.kind='group' AND m.member=r.created_by;
+
+-- banned users cannot join the room again until unbanned
+CREATE TABLE IF NOT EXISTS room_bans(
+	room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+	member VARCHAR(255) NOT NULL,
+	banned_by VARCHAR(255) NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (room_id, member)
+);
+
+-- blocks are between users, they hide the direct room of the two
+CREATE TABLE IF NOT EXISTS blocks(
+	blocker VARCHAR(255) NOT NULL,
+	blocked VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (blocker, blocked)
+);
+
+CREATE INDEX IF NOT EXISTS blocks_blocked_idx ON blocks(blocked);
+
+-- moderation_log audits every moderation action, those of the message
+-- filters included. Blocks have no room.
+CREATE TABLE IF NOT EXISTS moderation_log(
+	id BIGSERIAL PRIMARY KEY,
+	room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	target VARCHAR(255) NOT NULL DEFAULT '',
+	message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS moderation_log_room_id_idx ON moderation_log(room_id, id DESC);
@@ -0,0 +1,28 @@
+-- bots post through the API with a token and get the commands and mentions
+-- of the rooms they were added to on their webhook. Tokens are kept hashed,
+-- secrets sign the webhook calls and are kept as they are.
+CREATE TABLE IF NOT EXISTS bots(
+	id UUID PRIMARY KEY,
+	name VARCHAR(32) NOT NULL UNIQUE,
+	owner VARCHAR(255) NOT NULL,
+	token_hash BYTEA NOT NULL UNIQUE,
+	webhook_url TEXT NOT NULL DEFAULT '',
+	secret VARCHAR(64) NOT NULL,
+	-- space separated, without their slash
+	commands TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bots_owner_idx ON bots(owner);
+
+-- incoming webhooks post into a room, whoever holds their url can
+CREATE TABLE IF NOT EXISTS webhooks(
+	id UUID PRIMARY KEY,
+	room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	token_hash BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_room_id_idx ON webhooks(room_id);
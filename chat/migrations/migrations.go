@@ -0,0 +1,36 @@
+// Package migrations holds the SQL migrations of the chat service.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io"
+
+	"github.com/F1zm0n/universal-kit/migrate"
+)
+
+// service identifies this service's rows in the shared schema_migrations
+// table.
+const service = "chat"
+
+//go:embed *.sql
+var files embed.FS
+
+// Up applies all pending embedded migrations. Services call it on boot.
+func Up(ctx context.Context, db *sql.DB) error {
+	m, err := migrate.New(db, service, files)
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx)
+}
+
+// Run executes the migrate subcommand given its arguments, e.g. ["up"].
+func Run(ctx context.Context, db *sql.DB, args []string, w io.Writer) error {
+	m, err := migrate.New(db, service, files)
+	if err != nil {
+		return err
+	}
+	return m.Run(ctx, args, w)
+}
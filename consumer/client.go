@@ -0,0 +1,45 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// NewForwardEndpoint posts the messages it is called with, as they are, to
+// target, attaching the tokens of src.
+func NewForwardEndpoint(target string, src *oauth.TokenSource) (endpoint.Endpoint, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	forward := httptransport.NewClient(
+		http.MethodPost,
+		u,
+		encodeHTTPMessage,
+		decodeHTTPResponse,
+		httptransport.ClientBefore(oauth.ContextToHTTP()),
+	).Endpoint()
+	return oauth.ClientMiddleware(src)(forward), nil
+}
+
+func encodeHTTPMessage(_ context.Context, r *http.Request, request interface{}) error {
+	r.Header.Set("Content-Type", "application/json")
+	r.Body = io.NopCloser(bytes.NewReader(request.([]byte)))
+	return nil
+}
+
+func decodeHTTPResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	return nil, nil
+}
@@ -4,22 +4,45 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/spf13/viper"
 
+	"github.com/F1zm0n/universal-kit/oauth"
 	receiver "github.com/F1zm0n/universal-receiver"
 )
 
-var (
-	configPaths = []string{"/app/config", "../consumer/config"}
-	topics      = []string{"mail", "ver", "reg"}
-)
+var configPaths = []string{"/app/config", "../consumer/config"}
 
 func main() {
 	sl := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	parseConfig(configPaths)
 
-	rec := receiver.NewKafkaConsumer(sl, topics)
+	// the mailer and auth only take calls with tokens for them
+	mailerTokens, err := oauth.LoadTokenSource("mailer", "mail")
+	if err != nil {
+		panic(err)
+	}
+	authTokens, err := oauth.LoadTokenSource("auth", "register")
+	if err != nil {
+		panic(err)
+	}
+	forward := make(map[string]endpoint.Endpoint)
+	for topic, target := range map[string]struct {
+		url    string
+		tokens *oauth.TokenSource
+	}{
+		"mail": {viper.GetString("req.http.urls.mail"), mailerTokens},
+		"ver":  {viper.GetString("req.http.urls.verify"), mailerTokens},
+		"reg":  {viper.GetString("req.http.urls.register"), authTokens},
+	} {
+		forward[topic], err = receiver.NewForwardEndpoint(target.url, target.tokens)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	rec := receiver.NewKafkaConsumer(sl, forward)
 
 	rec.Consume()
 }
@@ -1,18 +1,12 @@
 package receiver
 
 import (
-	"bytes"
+	"context"
 	"log"
 	"log/slog"
-	"net/http"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/spf13/viper"
-)
-
-var (
-	mailerUrl   = viper.GetString("req.http.urls.mail")
-	mailerTopic = viper.GetString("kafka.topics.mailer")
+	"github.com/go-kit/kit/endpoint"
 )
 
 type Consumer interface {
@@ -22,9 +16,12 @@ type Consumer interface {
 type kafkaConsumer struct {
 	cons *kafka.Consumer
 	sl   *slog.Logger
+	// forward maps the topics to the endpoints their messages are posted
+	// to, see NewForwardEndpoint
+	forward map[string]endpoint.Endpoint
 }
 
-func NewKafkaConsumer(sl *slog.Logger, topics []string) Consumer {
+func NewKafkaConsumer(sl *slog.Logger, forward map[string]endpoint.Endpoint) Consumer {
 	c, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers":       "kafka:9092,kafka:29092,localhost:9092",
 		"group.id":                "myGroup",
@@ -35,6 +32,10 @@ func NewKafkaConsumer(sl *slog.Logger, topics []string) Consumer {
 		panic(err)
 	}
 
+	topics := make([]string, 0, len(forward))
+	for topic := range forward {
+		topics = append(topics, topic)
+	}
 	err = c.SubscribeTopics(topics, nil)
 	if err != nil {
 		log.Fatal(err)
@@ -42,63 +43,29 @@ func NewKafkaConsumer(sl *slog.Logger, topics []string) Consumer {
 
 	sl.Info("connected to kafka queue")
 	return &kafkaConsumer{
-		sl:   sl,
-		cons: c,
+		sl:      sl,
+		cons:    c,
+		forward: forward,
 	}
 }
 
 func (c kafkaConsumer) Consume() {
 	for {
 		msg, err := c.cons.ReadMessage(-1)
-		if err == nil {
-			c.sl.Info(
-				"received message from kafka queue",
-				slog.String("topic", *msg.TopicPartition.Topic),
-			)
-			switch *msg.TopicPartition.Topic {
-			case "mail":
-				l := c.sl.With(slog.String("topic", "mail"))
-				l.Info("sending request")
-				err := sendReq(http.MethodPost, "http://mailer:5001/mail", msg.Value)
-				if err != nil {
-					l.Error("error sending req", slog.String("err", err.Error()))
-					continue
-				}
-			case "ver":
-				l := c.sl.With(slog.String("topic", "verify"))
-				l.Info("sending request")
-				err := sendReq(http.MethodPost, "http://mailer:5001/verify", msg.Value)
-				if err != nil {
-					l.Error("error sending req", slog.String("err", err.Error()))
-					continue
-				}
-			case "register":
-				l := c.sl.With(slog.String("topic", "register"))
-				l.Info("sending request")
-				err := sendReq(http.MethodPost, "http://auth:5001/register", msg.Value)
-				if err != nil {
-					l.Error("error sending req", slog.String("err", err.Error()))
-					continue
-				} else {
-					c.sl.Info("error consuming", slog.Any("error", err))
-				}
-			}
+		if err != nil {
+			c.sl.Info("error consuming", slog.Any("error", err))
+			continue
+		}
+		topic := *msg.TopicPartition.Topic
+		l := c.sl.With(slog.String("topic", topic))
+		l.Info("received message from kafka queue")
+		forward, ok := c.forward[topic]
+		if !ok {
+			l.Error("no endpoint for topic")
+			continue
+		}
+		if _, err := forward(context.Background(), msg.Value); err != nil {
+			l.Error("error sending req", slog.String("err", err.Error()))
 		}
 	}
 }
-
-func sendReq(method string, url string, data []byte) error {
-	req, err := http.NewRequest(method, url, bytes.NewReader(data))
-	if err != nil {
-		return ErrSendingReq
-	}
-	req.Header.Add("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return ErrSendingReq
-	}
-	if res.StatusCode != 200 {
-		return ErrStatusIsNot200
-	}
-	return nil
-}
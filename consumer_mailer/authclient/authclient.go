@@ -0,0 +1,116 @@
+// Package authclient registers verified users with the auth service, as an
+// OAuth2 client holding auth's register scope.
+package authclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/consume_mail/repository"
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+const (
+	// Audience and Scope are what the tokens for Register must be issued
+	// for, see auth's oauth.clients.
+	Audience = "auth"
+	Scope    = "register"
+)
+
+// RegisterRequest is auth's /register request. A non empty IdempotencyKey
+// makes retries register the user once.
+type RegisterRequest struct {
+	Email          string `json:"email"`
+	Password       string `json:"password"`
+	IdempotencyKey string `json:"-"`
+}
+
+// NewTokenSource returns the token source for Register, with the
+// credentials of the oauth key.
+func NewTokenSource() (*oauth.TokenSource, error) {
+	return oauth.LoadTokenSource(Audience, Scope)
+}
+
+// NewRegisterEndpoint calls /register of auth at instance, attaching the
+// tokens of src. It answers nil, or the problem auth answered with.
+func NewRegisterEndpoint(instance string, src *oauth.TokenSource) (endpoint.Endpoint, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/register"
+	register := httptransport.NewClient(
+		http.MethodPost,
+		u,
+		encodeHTTPRegisterRequest,
+		decodeHTTPRegisterResponse,
+		httptransport.ClientBefore(
+			idempotency.ContextToHTTP(),
+			problem.ContextToHTTP(),
+			oauth.ContextToHTTP(),
+		),
+	).Endpoint()
+	return oauth.ClientMiddleware(src)(register), nil
+}
+
+// Verify registers the user behind the verification link id with register,
+// a register endpoint, and marks the link verified in the same transaction.
+// It is safe to call repeatedly with the same id: once the link is verified
+// further calls succeed without side effects, and the auth call carries the
+// id as its idempotency key so a retry after a failed commit registers the
+// user once.
+func Verify(ctx context.Context, db repository.Repository, register endpoint.Endpoint, id uuid.UUID) error {
+	return db.WithTx(ctx, func(repo repository.Repository) error {
+		user, err := repo.GetByVerId(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.Verified() {
+			return nil
+		}
+		_, err = register(ctx, RegisterRequest{
+			Email:          user.Email,
+			Password:       user.Password,
+			IdempotencyKey: id.String(),
+		})
+		if err != nil {
+			return err
+		}
+		return repo.MarkVerified(ctx, id)
+	})
+}
+
+func encodeHTTPRegisterRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(RegisterRequest)
+	if req.IdempotencyKey != "" {
+		r.Header.Set(idempotency.Header, req.IdempotencyKey)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Body = io.NopCloser(&buf)
+	return nil
+}
+
+func decodeHTTPRegisterResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	return nil, nil
+}
@@ -0,0 +1,81 @@
+package authclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/consume_mail/repository"
+)
+
+var errWeakPassword = errors.New("password too weak")
+
+// registrar stands in for auth's register endpoint, refusing the
+// passwords in refuse.
+type registrar struct {
+	calls  []RegisterRequest
+	refuse map[string]bool
+}
+
+func (r *registrar) register(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(RegisterRequest)
+	r.calls = append(r.calls, req)
+	if r.refuse[req.Password] {
+		return nil, errWeakPassword
+	}
+	return nil, nil
+}
+
+func TestVerifyRegistersOnce(t *testing.T) {
+	ctx := context.Background()
+	db, auth := repository.NewMemoryRepository(), &registrar{}
+	link := repository.VerEntity{VerId: uuid.New(), Email: "alice@example.com", Password: "correct horse"}
+	if err := db.CreateLink(ctx, link); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Verify(ctx, db, auth.register, link.VerId); err != nil {
+			t.Fatalf("Verify %d: %v", i, err)
+		}
+	}
+	if len(auth.calls) != 1 {
+		t.Fatalf("%d registrations, want 1", len(auth.calls))
+	}
+	want := RegisterRequest{Email: link.Email, Password: link.Password, IdempotencyKey: link.VerId.String()}
+	if auth.calls[0] != want {
+		t.Errorf("registered %+v, want %+v", auth.calls[0], want)
+	}
+	got, _ := db.GetByVerId(ctx, link.VerId)
+	if !got.Verified() || got.Password != "" {
+		t.Errorf("link after Verify = %+v, want it verified without the password", got)
+	}
+}
+
+// A sign-up auth refused leaves its link pending, and the next sign-up of
+// the address replaces it.
+func TestVerifyRefusedSignUpCanRetry(t *testing.T) {
+	ctx := context.Background()
+	db := repository.NewMemoryRepository()
+	auth := &registrar{refuse: map[string]bool{"1234": true}}
+	weak := repository.VerEntity{VerId: uuid.New(), Email: "alice@example.com", Password: "1234"}
+	if err := db.CreateLink(ctx, weak); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if err := Verify(ctx, db, auth.register, weak.VerId); !errors.Is(err, errWeakPassword) {
+		t.Fatalf("Verify = %v, want %v", err, errWeakPassword)
+	}
+
+	retry := repository.VerEntity{VerId: uuid.New(), Email: weak.Email, Password: "correct horse"}
+	if err := db.CreateLink(ctx, retry); err != nil {
+		t.Fatalf("CreateLink after a refused sign-up: %v", err)
+	}
+	if err := Verify(ctx, db, auth.register, retry.VerId); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := Verify(ctx, db, auth.register, weak.VerId); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("replaced link = %v, want %v", err, repository.ErrNotFound)
+	}
+}
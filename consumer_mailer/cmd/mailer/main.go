@@ -2,18 +2,69 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/consume_mail/authclient"
+	"github.com/F1zm0n/consume_mail/migrations"
+	"github.com/F1zm0n/consume_mail/repository"
 	mailservice "github.com/F1zm0n/consume_mail/service"
 	"github.com/F1zm0n/consume_mail/transport"
 )
 
+var configPaths = []string{"../consumer_mailer/config", "/app/config/"}
+
 func main() {
+	if err := parseConfig(configPaths); err != nil {
+		fmt.Fprintln(os.Stderr, "error reading config:", err)
+		os.Exit(1)
+	}
+	db := repository.MustNewPostgresDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.Run(context.Background(), db, os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	sl := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{}))
-	svc := mailservice.New(sl)
-	cons := transport.NewKafkaConsumer(sl, "mail", svc)
+	if err := migrations.Up(context.Background(), db); err != nil {
+		sl.Error("error migrating schema", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	tokens, err := authclient.NewTokenSource()
+	if err != nil {
+		sl.Error("error reading config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	register, err := authclient.NewRegisterEndpoint(viper.GetString("auth.addr"), tokens)
+	if err != nil {
+		sl.Error("error reading config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	svc := mailservice.New(sl, repository.NewPostgresRepository(db), register)
+	ledger := repository.NewPostgresLedger(db)
+	cons := transport.NewKafkaConsumer(sl, svc, ledger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cons.Run(ctx); err != nil {
+		sl.Error("error consuming kafka", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+}
 
-	go cons.ConsumeMail(context.Background())
-	cons.ConsumeVer(context.Background())
+func parseConfig(paths []string) error {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	for _, path := range paths {
+		viper.AddConfigPath(path)
+	}
+	return viper.ReadInConfig()
 }
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS verification(
+	email VARCHAR(100) NOT NULL UNIQUE,
+	password VARCHAR(100) NOT NULL,
+	ver_id uuid PRIMARY KEY
+);
@@ -0,0 +1 @@
+ALTER TABLE verification DROP COLUMN IF EXISTS verified_at;
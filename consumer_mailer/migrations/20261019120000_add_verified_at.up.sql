@@ -0,0 +1 @@
+ALTER TABLE verification ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ;
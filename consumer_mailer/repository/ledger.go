@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Ledger records which messages a consumer already handled, so that Kafka
+// redeliveries and replays can be skipped.
+type Ledger interface {
+	Processed(ctx context.Context, consumer, id string) (bool, error)
+	MarkProcessed(ctx context.Context, consumer, id string) error
+}
+
+type PostgresLedger struct {
+	db *sql.DB
+}
+
+func NewPostgresLedger(db *sql.DB) Ledger {
+	return &PostgresLedger{
+		db: db,
+	}
+}
+
+func (l PostgresLedger) Processed(ctx context.Context, consumer, id string) (bool, error) {
+	var exists bool
+	err := l.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT 1 FROM processed_messages WHERE consumer=$1 AND message_id=$2)",
+		consumer,
+		id,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (l PostgresLedger) MarkProcessed(ctx context.Context, consumer, id string) error {
+	_, err := l.db.ExecContext(
+		ctx,
+		"INSERT INTO processed_messages (consumer,message_id) VALUES ($1,$2) ON CONFLICT DO NOTHING",
+		consumer,
+		id,
+	)
+	return err
+}
+
+type MemoryLedger struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func NewMemoryLedger() Ledger {
+	return &MemoryLedger{
+		seen: make(map[string]struct{}),
+	}
+}
+
+func (l *MemoryLedger) Processed(ctx context.Context, consumer, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.seen[consumer+"/"+id]
+	return ok, nil
+}
+
+func (l *MemoryLedger) MarkProcessed(ctx context.Context, consumer, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen[consumer+"/"+id] = struct{}{}
+	return nil
+}
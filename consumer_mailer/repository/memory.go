@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository is an in-process Repository for tests and local runs.
+// Transactions are serialised and applied to a copy of the data, which
+// replaces the original only on commit.
+type MemoryRepository struct {
+	mu   sync.Mutex
+	rows map[uuid.UUID]VerEntity
+}
+
+func NewMemoryRepository() Repository {
+	return &MemoryRepository{
+		rows: make(map[uuid.UUID]VerEntity),
+	}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := memoryTx{rows: make(map[uuid.UUID]VerEntity, len(r.rows))}
+	for id, row := range r.rows {
+		tx.rows[id] = row
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.rows = tx.rows
+	return nil
+}
+
+func (r *MemoryRepository) CreateLink(ctx context.Context, user VerEntity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return memoryTx{rows: r.rows}.CreateLink(ctx, user)
+}
+
+func (r *MemoryRepository) GetByVerId(ctx context.Context, id uuid.UUID) (VerEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return memoryTx{rows: r.rows}.GetByVerId(ctx, id)
+}
+
+func (r *MemoryRepository) DeleteByVerId(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return memoryTx{rows: r.rows}.DeleteByVerId(ctx, id)
+}
+
+func (r *MemoryRepository) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return memoryTx{rows: r.rows}.MarkVerified(ctx, id)
+}
+
+// memoryTx operates on rows without locking; callers hold the lock.
+type memoryTx struct {
+	rows map[uuid.UUID]VerEntity
+}
+
+func (t memoryTx) WithTx(_ context.Context, fn func(Repository) error) error {
+	return fn(t)
+}
+
+func (t memoryTx) CreateLink(ctx context.Context, user VerEntity) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := t.rows[user.VerId]; ok {
+		return ErrAlreadyExists
+	}
+	for id, row := range t.rows {
+		if row.Email != user.Email {
+			continue
+		}
+		if row.Verified() {
+			return ErrAlreadyExists
+		}
+		delete(t.rows, id)
+	}
+	t.rows[user.VerId] = user
+	return nil
+}
+
+func (t memoryTx) GetByVerId(ctx context.Context, id uuid.UUID) (VerEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return VerEntity{}, err
+	}
+	row, ok := t.rows[id]
+	if !ok {
+		return VerEntity{}, ErrNotFound
+	}
+	return row, nil
+}
+
+func (t memoryTx) DeleteByVerId(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := t.rows[id]; !ok {
+		return ErrNotFound
+	}
+	delete(t.rows, id)
+	return nil
+}
+
+func (t memoryTx) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	row, ok := t.rows[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if row.VerifiedAt == nil {
+		now := time.Now()
+		row.VerifiedAt = &now
+	}
+	row.Password = ""
+	t.rows[id] = row
+	return nil
+}
@@ -1,9 +1,19 @@
 package repository
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type VerEntity struct {
 	Email    string
 	Password string
 	VerId    uuid.UUID
+	// VerifiedAt is set once the verification link was used.
+	VerifiedAt *time.Time
+}
+
+func (e VerEntity) Verified() bool {
+	return e.VerifiedAt != nil
 }
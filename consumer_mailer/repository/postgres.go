@@ -3,100 +3,147 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
 )
 
-type Repository interface {
-	CreateLink(u VerEntity) (*sql.Tx, error)
-	GetByVerId(id uuid.UUID) (VerEntity, error)
-	DeleteByVerId(id uuid.UUID) (*sql.Tx, error)
+// uniqueViolation is the Postgres error code for unique constraint failures.
+const uniqueViolation = "23505"
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 type PostgresRepository struct {
 	db *sql.DB
+	q  querier
 }
 
-func (r PostgresRepository) mustInitSchema() {
-	schema := `
-	CREATE TABLE verification(
-		email VARCHAR(100) NOT NULL UNIQUE,
-		password VARCHAR(100) NOT NULL,
-		ver_id uuid PRIMARY KEY
-	);
-	`
-	_, err := r.db.Exec(schema)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func NewPostgresRepository() Repository {
+func MustNewPostgresDB() *sql.DB {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		"postgres",
-		"postgres",
-		"password",
-		"users",
-		"5432",
-		"disable",
+		viper.GetString("postgres.host"),
+		viper.GetString("postgres.user"),
+		viper.GetString("postgres.password"),
+		viper.GetString("postgres.dbname"),
+		viper.GetString("postgres.port"),
+		viper.GetString("postgres.sslmode"),
 	)
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
-	repo := PostgresRepository{
+	return db
+}
+
+func NewPostgresRepository(db *sql.DB) Repository {
+	return &PostgresRepository{
 		db: db,
+		q:  db,
 	}
-	repo.mustInitSchema()
+}
 
-	return &repo
+func (r PostgresRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	if _, ok := r.q.(*sql.Tx); ok {
+		return fn(r)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(PostgresRepository{db: r.db, q: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r PostgresRepository) CreateLink(user VerEntity) (*sql.Tx, error) {
-	tx, err := r.db.BeginTx(context.Background(), &sql.TxOptions{})
-	_, err = tx.Exec(
-		"INSERT INTO verification (email,password,ver_id) VALUES ($1,$2,$3)",
+func (r PostgresRepository) CreateLink(ctx context.Context, user VerEntity) error {
+	res, err := r.q.ExecContext(
+		ctx,
+		`INSERT INTO verification (email,password,ver_id) VALUES ($1,$2,$3)
+		ON CONFLICT (email) DO UPDATE SET password=EXCLUDED.password,ver_id=EXCLUDED.ver_id
+		WHERE verification.verified_at IS NULL`,
 		user.Email,
 		user.Password,
 		user.VerId,
 	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrAlreadyExists
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return tx, nil
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// no row when the email is verified already
+	if rows != 1 {
+		return ErrAlreadyExists
+	}
+	return nil
 }
 
-func (r PostgresRepository) GetByVerId(id uuid.UUID) (VerEntity, error) {
+func (r PostgresRepository) GetByVerId(ctx context.Context, id uuid.UUID) (VerEntity, error) {
 	var user VerEntity
-	err := r.db.QueryRow("SELECT ver_id,email,password FROM verification WHERE ver_id=$1", id).
-		Scan(&user.VerId,
-			&user.Email,
-			&user.Password,
-		)
+	err := r.q.QueryRowContext(
+		ctx,
+		"SELECT ver_id,email,password,verified_at FROM verification WHERE ver_id=$1",
+		id,
+	).Scan(
+		&user.VerId,
+		&user.Email,
+		&user.Password,
+		&user.VerifiedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VerEntity{}, ErrNotFound
+	}
 	if err != nil {
 		return VerEntity{}, err
 	}
 	return user, nil
 }
 
-func (r PostgresRepository) DeleteByVerId(id uuid.UUID) (*sql.Tx, error) {
-	tx, err := r.db.BeginTx(context.Background(), nil)
+func (r PostgresRepository) DeleteByVerId(ctx context.Context, id uuid.UUID) error {
+	res, err := r.q.ExecContext(ctx, "DELETE FROM verification WHERE ver_id=$1", id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	res, err := tx.Exec("DELETE FROM verification WHERE ver_id=$1", id)
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r PostgresRepository) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	res, err := r.q.ExecContext(
+		ctx,
+		"UPDATE verification SET verified_at=COALESCE(verified_at,now()),password='' WHERE ver_id=$1",
+		id,
+	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	rows, err := res.RowsAffected()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if rows != 1 {
-		return nil, fmt.Errorf("error deleting verification")
+		return ErrNotFound
 	}
-	return tx, nil
+	return nil
 }
@@ -0,0 +1,36 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/F1zm0n/consume_mail/migrations"
+	"github.com/F1zm0n/consume_mail/repository"
+	"github.com/F1zm0n/consume_mail/repository/repotest"
+)
+
+// TestPostgresRepository runs against the database named by
+// TEST_POSTGRES_DSN, whose verification table it empties.
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening postgres: %v", err)
+	}
+	defer db.Close()
+	if err := migrations.Up(context.Background(), db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		if _, err := db.Exec("DELETE FROM verification"); err != nil {
+			t.Fatalf("emptying verification: %v", err)
+		}
+		return repository.NewPostgresRepository(db)
+	})
+}
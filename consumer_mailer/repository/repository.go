@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound      = errors.New("verification not found")
+	ErrAlreadyExists = errors.New("verification already exists")
+)
+
+type Repository interface {
+	// CreateLink stores a verification link. A pending link for the same
+	// email is replaced, so a failed or abandoned sign-up does not hold the
+	// address. It fails with ErrAlreadyExists when the email is verified or
+	// the id is taken.
+	CreateLink(ctx context.Context, u VerEntity) error
+	GetByVerId(ctx context.Context, id uuid.UUID) (VerEntity, error)
+	DeleteByVerId(ctx context.Context, id uuid.UUID) error
+	// MarkVerified records that the link was used and clears the stored
+	// password. Marking an already verified link is a no-op.
+	MarkVerified(ctx context.Context, id uuid.UUID) error
+	// WithTx runs fn against a Repository bound to a single transaction. The
+	// transaction commits when fn returns nil and rolls back otherwise. fn
+	// must only use the Repository it is given.
+	WithTx(ctx context.Context, fn func(Repository) error) error
+}
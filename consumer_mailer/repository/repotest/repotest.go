@@ -0,0 +1,261 @@
+// Package repotest is the conformance suite every repository.Repository
+// implementation must pass. Call Run from the implementation's tests.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/F1zm0n/consume_mail/repository"
+)
+
+var errAbort = errors.New("abort transaction")
+
+// Run exercises newRepo against the Repository contract. newRepo must
+// return an empty repository for every call.
+func Run(t *testing.T, newRepo func(t *testing.T) repository.Repository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.Repository)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"GetMissing", testGetMissing},
+		{"DuplicateVerId", testDuplicateVerId},
+		{"ReplacePending", testReplacePending},
+		{"DuplicateVerifiedEmail", testDuplicateVerifiedEmail},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"MarkVerified", testMarkVerified},
+		{"MarkVerifiedMissing", testMarkVerifiedMissing},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"TxNested", testTxNested},
+		{"CanceledContext", testCanceledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func newEntity() repository.VerEntity {
+	id := uuid.New()
+	return repository.VerEntity{
+		VerId:    id,
+		Email:    id.String() + "@example.com",
+		Password: "password",
+	}
+}
+
+func testCreateAndGet(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	want := newEntity()
+	if err := repo.CreateLink(ctx, want); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	got, err := repo.GetByVerId(ctx, want.VerId)
+	if err != nil {
+		t.Fatalf("GetByVerId: %v", err)
+	}
+	if got != want {
+		t.Fatalf("GetByVerId = %+v, want %+v", got, want)
+	}
+}
+
+func testGetMissing(t *testing.T, repo repository.Repository) {
+	_, err := repo.GetByVerId(context.Background(), uuid.New())
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetByVerId err = %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testDuplicateVerId(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	first := newEntity()
+	if err := repo.CreateLink(ctx, first); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	second := newEntity()
+	second.VerId = first.VerId
+	if err := repo.CreateLink(ctx, second); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("CreateLink err = %v, want %v", err, repository.ErrAlreadyExists)
+	}
+}
+
+func testReplacePending(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	first := newEntity()
+	if err := repo.CreateLink(ctx, first); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	second := newEntity()
+	second.Email, second.Password = first.Email, "other password"
+	if err := repo.CreateLink(ctx, second); err != nil {
+		t.Fatalf("CreateLink for a pending email: %v", err)
+	}
+	if _, err := repo.GetByVerId(ctx, first.VerId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("replaced link err = %v, want %v", err, repository.ErrNotFound)
+	}
+	got, err := repo.GetByVerId(ctx, second.VerId)
+	if err != nil {
+		t.Fatalf("GetByVerId: %v", err)
+	}
+	if got != second {
+		t.Fatalf("GetByVerId = %+v, want %+v", got, second)
+	}
+}
+
+func testDuplicateVerifiedEmail(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	first := newEntity()
+	if err := repo.CreateLink(ctx, first); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if err := repo.MarkVerified(ctx, first.VerId); err != nil {
+		t.Fatalf("MarkVerified: %v", err)
+	}
+	second := newEntity()
+	second.Email = first.Email
+	if err := repo.CreateLink(ctx, second); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("CreateLink err = %v, want %v", err, repository.ErrAlreadyExists)
+	}
+}
+
+func testDelete(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ent := newEntity()
+	if err := repo.CreateLink(ctx, ent); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if err := repo.DeleteByVerId(ctx, ent.VerId); err != nil {
+		t.Fatalf("DeleteByVerId: %v", err)
+	}
+	if _, err := repo.GetByVerId(ctx, ent.VerId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetByVerId after delete err = %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testDeleteMissing(t *testing.T, repo repository.Repository) {
+	err := repo.DeleteByVerId(context.Background(), uuid.New())
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("DeleteByVerId err = %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testMarkVerified(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ent := newEntity()
+	if err := repo.CreateLink(ctx, ent); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if err := repo.MarkVerified(ctx, ent.VerId); err != nil {
+		t.Fatalf("MarkVerified: %v", err)
+	}
+	first, err := repo.GetByVerId(ctx, ent.VerId)
+	if err != nil {
+		t.Fatalf("GetByVerId: %v", err)
+	}
+	if !first.Verified() {
+		t.Fatal("entity is not verified after MarkVerified")
+	}
+	if first.Password != "" {
+		t.Fatal("password is kept after MarkVerified")
+	}
+	if err := repo.MarkVerified(ctx, ent.VerId); err != nil {
+		t.Fatalf("second MarkVerified: %v", err)
+	}
+	second, err := repo.GetByVerId(ctx, ent.VerId)
+	if err != nil {
+		t.Fatalf("GetByVerId: %v", err)
+	}
+	if !second.VerifiedAt.Equal(*first.VerifiedAt) {
+		t.Fatalf("second MarkVerified moved VerifiedAt from %v to %v", first.VerifiedAt, second.VerifiedAt)
+	}
+}
+
+func testMarkVerifiedMissing(t *testing.T, repo repository.Repository) {
+	err := repo.MarkVerified(context.Background(), uuid.New())
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("MarkVerified err = %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testTxCommit(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ent := newEntity()
+	err := repo.WithTx(ctx, func(tx repository.Repository) error {
+		if err := tx.CreateLink(ctx, ent); err != nil {
+			return err
+		}
+		_, err := tx.GetByVerId(ctx, ent.VerId)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if _, err := repo.GetByVerId(ctx, ent.VerId); err != nil {
+		t.Fatalf("GetByVerId after commit: %v", err)
+	}
+}
+
+func testTxRollback(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	kept := newEntity()
+	if err := repo.CreateLink(ctx, kept); err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	created := newEntity()
+	err := repo.WithTx(ctx, func(tx repository.Repository) error {
+		if err := tx.CreateLink(ctx, created); err != nil {
+			return err
+		}
+		if err := tx.DeleteByVerId(ctx, kept.VerId); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+	if _, err := repo.GetByVerId(ctx, created.VerId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("rolled back insert is visible: err = %v", err)
+	}
+	if _, err := repo.GetByVerId(ctx, kept.VerId); err != nil {
+		t.Fatalf("rolled back delete is visible: %v", err)
+	}
+}
+
+func testTxNested(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ent := newEntity()
+	err := repo.WithTx(ctx, func(tx repository.Repository) error {
+		if err := tx.WithTx(ctx, func(inner repository.Repository) error {
+			return inner.CreateLink(ctx, ent)
+		}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+	if _, err := repo.GetByVerId(ctx, ent.VerId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("nested insert outlived outer rollback: err = %v", err)
+	}
+}
+
+func testCanceledContext(t *testing.T, repo repository.Repository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := repo.CreateLink(ctx, newEntity()); err == nil {
+		t.Fatal("CreateLink succeeded with a canceled context")
+	}
+	err := repo.WithTx(ctx, func(repository.Repository) error { return nil })
+	if err == nil {
+		t.Fatal("WithTx succeeded with a canceled context")
+	}
+}
@@ -39,6 +39,56 @@ func (mw loggingMiddleware) SendEmail(ctx context.Context, ver VerDto) (err erro
 	return mw.next.SendEmail(ctx, ver)
 }
 
+func (mw loggingMiddleware) SendPasswordReset(ctx context.Context, reset ResetDto) (err error) {
+	defer func(start time.Time) {
+		mw.logger.Info(
+			"sending password reset",
+			slog.String("email", reset.Email),
+			slog.Duration("took", time.Since(start)),
+			slog.Any("error", err),
+		)
+	}(time.Now())
+	return mw.next.SendPasswordReset(ctx, reset)
+}
+
+func (mw loggingMiddleware) SendMagicLink(ctx context.Context, link MagicLinkDto) (err error) {
+	defer func(start time.Time) {
+		mw.logger.Info(
+			"sending login link",
+			slog.String("email", link.Email),
+			slog.Duration("took", time.Since(start)),
+			slog.Any("error", err),
+		)
+	}(time.Now())
+	return mw.next.SendMagicLink(ctx, link)
+}
+
+func (mw loggingMiddleware) SendDigest(ctx context.Context, digest DigestDto) (err error) {
+	defer func(start time.Time) {
+		mw.logger.Info(
+			"sending chat digest",
+			slog.String("email", digest.Email),
+			slog.Int("unread", digest.Unread),
+			slog.Duration("took", time.Since(start)),
+			slog.Any("error", err),
+		)
+	}(time.Now())
+	return mw.next.SendDigest(ctx, digest)
+}
+
+func (mw loggingMiddleware) SendNewDevice(ctx context.Context, login NewDeviceDto) (err error) {
+	defer func(start time.Time) {
+		mw.logger.Info(
+			"sending new device notice",
+			slog.String("email", login.Email),
+			slog.String("device", login.Device),
+			slog.Duration("took", time.Since(start)),
+			slog.Any("error", err),
+		)
+	}(time.Now())
+	return mw.next.SendNewDevice(ctx, login)
+}
+
 func LoggingMiddleware(log *slog.Logger) Middleware {
 	return func(s Service) Service {
 		return &loggingMiddleware{
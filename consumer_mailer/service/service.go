@@ -1,24 +1,29 @@
 package mailservice
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"html"
 	"log/slog"
-	"net/http"
 	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/google/uuid"
 	"github.com/jordan-wright/email"
 
+	"github.com/F1zm0n/consume_mail/authclient"
 	"github.com/F1zm0n/consume_mail/repository"
 )
 
-func New(log *slog.Logger) Service {
+// New returns the service, registering verified users with register, an
+// authclient register endpoint.
+func New(log *slog.Logger, db repository.Repository, register endpoint.Endpoint) Service {
 	var svc Service
 	{
-		svc = NewBaseService()
+		svc = NewBaseService(db, register)
 		svc = LoggingMiddleware(log)(svc)
 	}
 	return svc
@@ -27,11 +32,21 @@ func New(log *slog.Logger) Service {
 type Service interface {
 	SendEmail(ctx context.Context, ver VerDto) error
 	VerifyMail(ctx context.Context, id uuid.UUID) error
+	SendPasswordReset(ctx context.Context, reset ResetDto) error
+	// SendMagicLink mails a single use login link.
+	SendMagicLink(ctx context.Context, link MagicLinkDto) error
+	// SendDigest mails a chat user the unread messages they got while
+	// offline.
+	SendDigest(ctx context.Context, digest DigestDto) error
+	// SendNewDevice mails a security notice about a login from a device
+	// the user had not logged in from before.
+	SendNewDevice(ctx context.Context, login NewDeviceDto) error
 }
 
 type baseService struct {
-	mailer MailSender
-	db     repository.Repository
+	mailer   MailSender
+	db       repository.Repository
+	register endpoint.Endpoint
 }
 
 type VerDto struct {
@@ -42,62 +57,47 @@ type VerDto struct {
 type Verify struct {
 	VerId uuid.UUID `json:"ver_id"`
 }
-type AuthPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+type ResetDto struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+type NewDeviceDto struct {
+	Email     string    `json:"email"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	At        time.Time `json:"at"`
+}
+type MagicLinkDto struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+type DigestDto struct {
+	Email  string          `json:"email"`
+	Since  time.Time       `json:"since"`
+	Unread int             `json:"unread"`
+	Rooms  []DigestRoomDto `json:"rooms"`
+}
+type DigestRoomDto struct {
+	RoomID uuid.UUID `json:"room_id"`
+	Name   string    `json:"name"`
+	Direct bool      `json:"direct"`
+	Unread int       `json:"unread"`
 }
 
-func NewBaseService() Service {
-	db := repository.NewPostgresRepository()
+func NewBaseService(db repository.Repository, register endpoint.Endpoint) Service {
 	mailer := NewGmailSender()
 	return &baseService{
-		db:     db,
-		mailer: mailer,
+		db:       db,
+		mailer:   mailer,
+		register: register,
 	}
 }
 
+// VerifyMail registers the user behind a verification link, see
+// authclient.Verify.
 func (s baseService) VerifyMail(ctx context.Context, id uuid.UUID) error {
-	user, err := s.db.GetByVerId(id)
-	if err != nil {
-		return err
-	}
-	// authLink := fmt.Sprintf("%s%s",
-	// 	viper.GetString("req.auth.addres"),
-	// 	viper.GetString("req.auth.create"),
-	// )
-	payload := AuthPayload{
-		Email:    user.Email,
-		Password: user.Password,
-	}
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequest(
-		http.MethodPost,
-		"http://auth:8081/register",
-		bytes.NewReader(b),
-	)
-	if err != nil {
-		return err
-	}
-	tx, err := s.db.DeleteByVerId(id)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer tx.Commit()
-	cli := http.DefaultClient
-	res, err := cli.Do(req)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if res.StatusCode != 200 {
-		tx.Rollback()
-		return fmt.Errorf("error status code is not 200:%s", res.Status)
-	}
-	return nil
+	return authclient.Verify(ctx, s.db, s.register, id)
 }
 
 func (s baseService) SendEmail(ctx context.Context, ver VerDto) error {
@@ -106,31 +106,95 @@ func (s baseService) SendEmail(ctx context.Context, ver VerDto) error {
 		Email:    ver.Email,
 		Password: ver.Password,
 	}
-	fmt.Println(user)
-	tx, err := s.db.CreateLink(user)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return s.db.WithTx(ctx, func(repo repository.Repository) error {
+		if err := repo.CreateLink(ctx, user); err != nil {
+			return err
+		}
 
-	subject := "verify your email addres "
-	content := fmt.Sprintf(`
+		subject := "verify your email addres "
+		content := fmt.Sprintf(`
 		<h1>verify your email</h1>
 		<p>Link<a href="http://%s%s?id=%v">Verify</a></p>
 		`, "localhost:5002",
-		"/verify",
-		user.VerId,
+			"/verify",
+			user.VerId,
+		)
+		to := []string{user.Email}
+		return s.mailer.SendMail(subject, content, to, nil, nil, nil)
+	})
+}
+
+func (s baseService) SendPasswordReset(_ context.Context, reset ResetDto) error {
+	subject := "reset your password"
+	content := fmt.Sprintf(`
+	<h1>reset your password</h1>
+	<p>Someone asked to reset the password of your account. If it was not you, ignore this email.</p>
+	<p>Link<a href="http://%s%s?token=%s">Reset password</a></p>
+	`, "localhost:5002",
+		"/reset",
+		url.QueryEscape(reset.Token),
 	)
-	to := []string{user.Email}
-	err = s.mailer.SendMail(subject, content, to, nil, nil, nil)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := tx.Commit(); err != nil {
-		return err
+	return s.mailer.SendMail(subject, content, []string{reset.Email}, nil, nil, nil)
+}
+
+func (s baseService) SendMagicLink(_ context.Context, link MagicLinkDto) error {
+	subject := "your login link"
+	content := fmt.Sprintf(`
+	<h1>log in to your account</h1>
+	<p>Someone asked to log in to your account with this email. The link works once and expires in a few minutes. If it was not you, ignore this email.</p>
+	<p>Link<a href="http://%s%s?token=%s">Log in</a></p>
+	`, "localhost:5002",
+		"/login/link/open",
+		url.QueryEscape(link.Token),
+	)
+	return s.mailer.SendMail(subject, content, []string{link.Email}, nil, nil, nil)
+}
+
+func (s baseService) SendDigest(_ context.Context, digest DigestDto) error {
+	var rooms strings.Builder
+	for _, room := range digest.Rooms {
+		name := html.EscapeString(room.Name)
+		if room.Direct {
+			name = "from " + name
+		} else {
+			name = "in " + name
+		}
+		fmt.Fprintf(&rooms, "<li>%d %s</li>\n", room.Unread, name)
 	}
-	return nil
+	subject := fmt.Sprintf("you have %d unread messages", digest.Unread)
+	content := fmt.Sprintf(`
+	<h1>you have %d unread messages</h1>
+	<p>Since you were last online on %s you got:</p>
+	<ul>
+	%s</ul>
+	<p>Link<a href="http://%s">Open chat</a></p>
+	`, digest.Unread,
+		digest.Since.UTC().Format("January 2 at 15:04 MST"),
+		rooms.String(),
+		"localhost:5002",
+	)
+	return s.mailer.SendMail(subject, content, []string{digest.Email}, nil, nil, nil)
+}
+
+func (s baseService) SendNewDevice(_ context.Context, login NewDeviceDto) error {
+	subject := "new login to your account"
+	content := fmt.Sprintf(`
+	<h1>new login to your account</h1>
+	<p>Your account was logged in to from a new device on %s:</p>
+	<ul>
+	<li>device: %s</li>
+	<li>browser: %s</li>
+	<li>ip address: %s</li>
+	</ul>
+	<p>If it was not you, log out the device and change your password.</p>
+	<p>Link<a href="http://%s">Manage your sessions</a></p>
+	`, login.At.UTC().Format("January 2 at 15:04 MST"),
+		html.EscapeString(login.Device),
+		html.EscapeString(login.UserAgent),
+		html.EscapeString(login.IP),
+		"localhost:5002",
+	)
+	return s.mailer.SendMail(subject, content, []string{login.Email}, nil, nil, nil)
 }
 
 const (
@@ -3,112 +3,176 @@ package transport
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/spf13/viper"
 
+	"github.com/F1zm0n/consume_mail/repository"
 	mailservice "github.com/F1zm0n/consume_mail/service"
 )
 
+// messageIDHeader carries the producer-assigned id used for deduplication.
+const messageIDHeader = "message-id"
+
+// readTimeout bounds each poll, so that consumers notice ctx being done.
+const readTimeout = time.Second
+
 type Consumer interface {
-	ConsumeMail(ctx context.Context)
-	ConsumeVer(ctx context.Context)
+	// Run consumes the mail topics until ctx is done or a subscription
+	// cannot be set up.
+	Run(ctx context.Context) error
 }
 
 type kafkaConsumer struct {
-	consM *kafka.Consumer
-	consV *kafka.Consumer
-	sl    *slog.Logger
-	svc   mailservice.Service
+	sl     *slog.Logger
+	svc    mailservice.Service
+	ledger repository.Ledger
 }
 
-func NewKafkaConsumer(sl *slog.Logger, topic string, svc mailservice.Service) Consumer {
-	cm, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": "kafka:9092,kafka:29092",
-		"group.id":          "mail",
-		"auto.offset.reset": "earliest",
-	})
-	if err != nil {
-		panic(err)
+func NewKafkaConsumer(
+	sl *slog.Logger,
+	svc mailservice.Service,
+	ledger repository.Ledger,
+) Consumer {
+	return &kafkaConsumer{
+		sl:     sl,
+		svc:    svc,
+		ledger: ledger,
 	}
+}
 
-	cv, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": "kafka:9092,kafka:29092",
-		"group.id":          "ver",
-		"auto.offset.reset": "earliest",
-	})
-	if err != nil {
-		panic(err)
+func (c kafkaConsumer) Run(ctx context.Context) error {
+	subscriptions := []struct {
+		topic, group string
+		handle       func(ctx context.Context, value []byte) error
+	}{
+		{"mail", "mail", decode(c.svc.SendEmail)},
+		{"ver", "ver", decode(func(ctx context.Context, ver mailservice.Verify) error {
+			return c.svc.VerifyMail(ctx, ver.VerId)
+		})},
+		{"reset", "reset", decode(c.svc.SendPasswordReset)},
+		{"digest", "digest", decode(c.svc.SendDigest)},
+		{"newdevice", "newdevice", decode(c.svc.SendNewDevice)},
+		{"magiclink", "magiclink", decode(c.svc.SendMagicLink)},
 	}
 
-	err = cm.Subscribe("mail", nil)
-	if err != nil {
-		log.Fatal(err)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(subscriptions))
+	for _, s := range subscriptions {
+		go func(topic, group string, handle func(context.Context, []byte) error) {
+			errs <- c.subscribe(ctx, topic, group, handle)
+		}(s.topic, s.group, s.handle)
 	}
+	// the first subscription to stop takes the others down with it
+	err := <-errs
+	cancel()
+	for range subscriptions[1:] {
+		<-errs
+	}
+	return err
+}
 
-	err = cv.Subscribe("ver", nil)
+// subscribe reads topic as a member of group, passing every message's value
+// to decodeAndHandle, until ctx is done. The group also names the consumer
+// in the ledger.
+func (c kafkaConsumer) subscribe(
+	ctx context.Context,
+	topic, group string,
+	decodeAndHandle func(ctx context.Context, value []byte) error,
+) error {
+	cons, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": viper.GetString("kafka.brokers"),
+		"group.id":          group,
+		"auto.offset.reset": "earliest",
+	})
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("creating %s consumer: %w", topic, err)
 	}
-
-	sl.Info("connected to kafka queue")
-	return &kafkaConsumer{
-		sl:    sl,
-		consM: cm,
-		consV: cv,
-		svc:   svc,
+	defer cons.Close()
+	if err := cons.Subscribe(topic, nil); err != nil {
+		return fmt.Errorf("subscribing to %s: %w", topic, err)
 	}
-}
 
-func (c kafkaConsumer) ConsumeVer(ctx context.Context) {
-	for {
-		msg, err := c.consV.ReadMessage(-1)
-		if err == nil {
-			c.sl.Info(
-				"received message from kafka queue",
-				slog.String("topic", *msg.TopicPartition.Topic),
-			)
-			l := c.sl.With(slog.String("topic", "verify"))
-			l.Info("sending request")
-			var ver mailservice.Verify
-			err := json.Unmarshal(msg.Value, &ver)
+	l := c.sl.With(slog.String("topic", topic))
+	l.Info("subscribed to kafka topic")
+	for ctx.Err() == nil {
+		msg, err := cons.ReadMessage(readTimeout)
+		var kerr kafka.Error
+		if errors.As(err, &kerr) && kerr.IsTimeout() {
+			continue
+		}
+		if err != nil {
+			l.Error("error reading kafka message", slog.String("error", err.Error()))
+			continue
+		}
+		l.Info("received message from kafka queue")
+		c.handle(ctx, l, group, msg, func() error {
+			err := decodeAndHandle(ctx, msg.Value)
 			if err != nil {
-				l.Error("error unmarshalling kafka message", slog.String("error", err.Error()))
-				continue
+				l.Error("error handling kafka message", slog.String("error", err.Error()))
 			}
-			err = c.svc.VerifyMail(ctx, ver.VerId)
-			if err != nil {
-				l.Error("error verifying email", slog.String("error", err.Error()))
-				continue
+			return err
+		})
+	}
+	return nil
+}
 
-			}
+// decode adapts a service method to the raw message values.
+func decode[T any](fn func(ctx context.Context, v T) error) func(ctx context.Context, value []byte) error {
+	return func(ctx context.Context, value []byte) error {
+		var v T
+		if err := json.Unmarshal(value, &v); err != nil {
+			return fmt.Errorf("unmarshalling message: %w", err)
 		}
+		return fn(ctx, v)
 	}
 }
 
-func (c kafkaConsumer) ConsumeMail(ctx context.Context) {
-	for {
-		msg, err := c.consM.ReadMessage(-1)
-		if err == nil {
-			c.sl.Info(
-				"received message from kafka queue",
-				slog.String("topic", *msg.TopicPartition.Topic),
-			)
-			l := c.sl.With(slog.String("topic", "mail"))
-			l.Info("sending request")
-			var verDto mailservice.VerDto
-			err := json.Unmarshal(msg.Value, &verDto)
-			if err != nil {
-				l.Error("error unmarshalling kafka message", slog.String("error", err.Error()))
-				continue
-			}
-			err = c.svc.SendEmail(ctx, verDto)
-			if err != nil {
-				l.Error("error sending email", slog.String("error", err.Error()))
-				continue
+// handle runs fn unless the ledger shows msg was already processed by
+// consumer, and records it once fn succeeds.
+func (c kafkaConsumer) handle(
+	ctx context.Context,
+	l *slog.Logger,
+	consumer string,
+	msg *kafka.Message,
+	fn func() error,
+) {
+	id := messageID(msg)
+	l = l.With(slog.String("message_id", id))
+	done, err := c.ledger.Processed(ctx, consumer, id)
+	if err != nil {
+		l.Error("error reading message ledger", slog.String("error", err.Error()))
+		return
+	}
+	if done {
+		l.Info("skipping already processed message")
+		return
+	}
+	if err := fn(); err != nil {
+		return
+	}
+	if err := c.ledger.MarkProcessed(ctx, consumer, id); err != nil {
+		l.Error("error recording processed message", slog.String("error", err.Error()))
+	}
+}
 
-			}
+// messageID prefers the producer-assigned id and falls back to the message
+// position, which still deduplicates redeliveries of the same offset.
+func messageID(msg *kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == messageIDHeader {
+			return string(h.Value)
 		}
 	}
+	return fmt.Sprintf(
+		"%s/%d/%d",
+		*msg.TopicPartition.Topic,
+		msg.TopicPartition.Partition,
+		msg.TopicPartition.Offset,
+	)
 }
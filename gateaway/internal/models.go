@@ -6,5 +6,5 @@ type RegisterRequest struct {
 }
 
 type RegisterResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
 }
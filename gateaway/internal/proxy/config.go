@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Route maps a gateway path to an upstream pool.
+type Route struct {
+	Name string `mapstructure:"name"`
+	// Method is the route's HTTP method, or AnyMethod.
+	Method string `mapstructure:"method"`
+	// Path is an echo route path. A trailing /* forwards the matched
+	// remainder, appended to Rewrite.
+	Path     string `mapstructure:"path"`
+	Upstream string `mapstructure:"upstream"`
+	// Rewrite replaces the path sent upstream; empty keeps the original.
+	Rewrite string `mapstructure:"rewrite"`
+	// Auth puts the route behind JWT authentication.
+	Auth bool `mapstructure:"auth"`
+	// Scope is the scope API keys need for the route, routes without one
+	// refuse API keys.
+	Scope string `mapstructure:"scope"`
+	// Signed only lets requests through whose url is signed with the
+	// files.secret, the resource signed being the path matched by /*.
+	Signed bool `mapstructure:"signed"`
+	// MaxBody bounds request bodies in bytes, zero leaves them unbounded.
+	MaxBody int64 `mapstructure:"maxbody"`
+}
+
+// AnyMethod routes every method, e.g. for whole APIs behind a path prefix.
+const AnyMethod = "*"
+
+// Upstream is a pool of instances, given either as a static list of
+// host:port or base URLs, or as a DNS SRV name resolved every TTL.
+type Upstream struct {
+	Static []string      `mapstructure:"static"`
+	SRV    string        `mapstructure:"srv"`
+	TTL    time.Duration `mapstructure:"ttl"`
+}
+
+type Config struct {
+	Routes    []Route             `mapstructure:"routes"`
+	Upstreams map[string]Upstream `mapstructure:"upstreams"`
+	// DialTimeout, ResponseHeaderTimeout and IdleConnTimeout configure the
+	// transport shared by all routes.
+	DialTimeout           time.Duration `mapstructure:"dialtimeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"responseheadertimeout"`
+	IdleConnTimeout       time.Duration `mapstructure:"idleconntimeout"`
+}
+
+// LoadConfig reads the routes, upstreams and proxy keys.
+func LoadConfig() (Config, error) {
+	var cfg Config
+	if err := viper.UnmarshalKey("routes", &cfg.Routes); err != nil {
+		return Config{}, err
+	}
+	if err := viper.UnmarshalKey("upstreams", &cfg.Upstreams); err != nil {
+		return Config{}, err
+	}
+	cfg.DialTimeout = viper.GetDuration("proxy.dialtimeout")
+	cfg.ResponseHeaderTimeout = viper.GetDuration("proxy.responseheadertimeout")
+	cfg.IdleConnTimeout = viper.GetDuration("proxy.idleconntimeout")
+
+	for _, r := range cfg.Routes {
+		if _, ok := cfg.Upstreams[r.Upstream]; !ok {
+			return Config{}, fmt.Errorf("route %s: unknown upstream %q", r.Name, r.Upstream)
+		}
+	}
+	return cfg, nil
+}
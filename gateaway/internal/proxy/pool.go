@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/dnssrv"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/go-kit/log"
+)
+
+const defaultSRVTTL = 30 * time.Second
+
+var ErrNoUpstream = errors.New("upstream has neither static instances nor an srv name")
+
+// Pool balances requests round robin over the instances of an upstream,
+// tracking them through a go-kit sd.Instancer.
+type Pool struct {
+	instancer  sd.Instancer
+	endpointer *sd.DefaultEndpointer
+	balancer   lb.Balancer
+}
+
+func NewPool(u Upstream, logger log.Logger) (*Pool, error) {
+	var instancer sd.Instancer
+	switch {
+	case u.SRV != "":
+		ttl := u.TTL
+		if ttl <= 0 {
+			ttl = defaultSRVTTL
+		}
+		instancer = dnssrv.NewInstancer(u.SRV, ttl, logger)
+	case len(u.Static) > 0:
+		instancer = sd.FixedInstancer(u.Static)
+	default:
+		return nil, ErrNoUpstream
+	}
+	endpointer := sd.NewEndpointer(instancer, instanceFactory, logger)
+	return &Pool{
+		instancer:  instancer,
+		endpointer: endpointer,
+		balancer:   lb.NewRoundRobin(endpointer),
+	}, nil
+}
+
+// Next returns the base URL of the next instance.
+func (p *Pool) Next() (*url.URL, error) {
+	e, err := p.balancer.Endpoint()
+	if err != nil {
+		return nil, err
+	}
+	u, err := e(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return u.(*url.URL), nil
+}
+
+func (p *Pool) Close() {
+	p.endpointer.Close()
+	p.instancer.Stop()
+}
+
+// instanceFactory turns an instance into an endpoint yielding its base URL,
+// so that go-kit's endpoint cache and balancers can pick proxy targets.
+func instanceFactory(instance string) (endpoint.Endpoint, io.Closer, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(context.Context, interface{}) (interface{}, error) {
+		return u, nil
+	}, nil, nil
+}
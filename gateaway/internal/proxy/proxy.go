@@ -0,0 +1,90 @@
+// Package proxy forwards gateway routes to upstream service pools.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/sd/lb"
+
+	"github.com/F1zm0n/universal-gateaway/internal/transport"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+type (
+	targetKey     struct{}
+	targetPathKey struct{}
+)
+
+// WithTargetPath sets the path the proxied request is sent to, overriding
+// the incoming one.
+func WithTargetPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, targetPathKey{}, path)
+}
+
+// NewTransport returns the transport shared by all routes. It bounds dialing
+// and waiting for response headers but not the whole exchange, so streamed
+// and upgraded connections are not cut off.
+func NewTransport(cfg Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: cfg.DialTimeout}).DialContext
+	t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	t.IdleConnTimeout = cfg.IdleConnTimeout
+	return t
+}
+
+// New returns a handler proxying requests to the next instance of pool.
+// Upstream status codes and bodies pass through unchanged and hop-by-hop
+// headers are stripped by httputil.ReverseProxy.
+func New(pool *Pool, transport http.RoundTripper) http.Handler {
+	rp := &httputil.ReverseProxy{
+		Transport:    transport,
+		Rewrite:      rewrite,
+		ErrorHandler: errorHandler,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := pool.Next()
+		if err != nil {
+			errorHandler(w, r, err)
+			return
+		}
+		rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), targetKey{}, target)))
+	})
+}
+
+func rewrite(r *httputil.ProxyRequest) {
+	target := r.In.Context().Value(targetKey{}).(*url.URL)
+	r.SetURL(target)
+	if path, ok := r.In.Context().Value(targetPathKey{}).(string); ok {
+		r.Out.URL.Path = strings.TrimRight(target.Path, "/") + path
+		r.Out.URL.RawPath = ""
+	}
+	r.SetXForwarded()
+}
+
+func errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var p *problem.Problem
+	switch {
+	case errors.Is(err, lb.ErrNoEndpoints):
+		p = problem.New(http.StatusServiceUnavailable, problem.CodeUnavailable, "no upstream instances available")
+	case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+		p = problem.New(http.StatusGatewayTimeout, problem.CodeTimeout, "upstream timed out")
+	default:
+		p = problem.New(http.StatusBadGateway, problem.CodeBadGateway, "upstream unreachable")
+	}
+	p.Service = transport.ServiceName
+	p.RequestID = r.Header.Get(problem.RequestIDHeader)
+	log.Println("proxy:", r.URL.Path, err)
+	problem.Write(w, p)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
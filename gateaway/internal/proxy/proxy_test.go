@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// upstream answers with its name and the path and forwarded host it got.
+func upstream(t *testing.T, name string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path+" "+r.Header.Get("X-Forwarded-Host"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gateway.example.com"+path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	a, b := upstream(t, "a"), strings.TrimPrefix(upstream(t, "b"), "http://")
+	pool, err := NewPool(Upstream{Static: []string{a, b}}, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	h := New(pool, NewTransport(Config{}))
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		code, body := get(t, h, "/a/chat/rooms")
+		if code != http.StatusOK {
+			t.Fatalf("status %d: %s", code, body)
+		}
+		if want := " /a/chat/rooms gateway.example.com"; !strings.HasSuffix(body, want) {
+			t.Errorf("upstream got %q, want the path and host passed on", body)
+		}
+		seen[strings.Fields(body)[0]]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("instances served %v, want 2 each", seen)
+	}
+}
+
+func TestRouteRewrite(t *testing.T) {
+	pool, _ := NewPool(Upstream{Static: []string{upstream(t, "chat") + "/base/"}}, log.NewNopLogger())
+	t.Cleanup(pool.Close)
+	h := New(pool, NewTransport(Config{}))
+	tests := []struct {
+		route Route
+		path  string
+		want  string
+	}{
+		{Route{Path: "/a/chat/*", Rewrite: "/v1"}, "/a/chat/rooms/1", "/base/v1/rooms/1"},
+		{Route{Path: "/a/health", Rewrite: "/healthz"}, "/a/health", "/base/healthz"},
+		{Route{Path: "/a/chat/*"}, "/a/chat/rooms", "/base/a/chat/rooms"},
+	}
+	for _, tt := range tests {
+		e := echo.New()
+		e.GET(tt.route.Path, tt.route.Handler(h))
+		_, body := get(t, e, tt.path)
+		if got := strings.Fields(body)[1]; got != tt.want {
+			t.Errorf("%s routed %s to %s, want %s", tt.route.Path, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	t.Cleanup(slow.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		instance string
+		want     int
+		code     string
+	}{
+		{"unreachable", closed.URL, http.StatusBadGateway, problem.CodeBadGateway},
+		{"slow", slow.URL, http.StatusGatewayTimeout, problem.CodeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, _ := NewPool(Upstream{Static: []string{tt.instance}}, log.NewNopLogger())
+			t.Cleanup(pool.Close)
+			h := New(pool, NewTransport(Config{ResponseHeaderTimeout: 50 * time.Millisecond}))
+			status, body := get(t, h, "/a/chat/rooms")
+			var p problem.Problem
+			json.Unmarshal([]byte(body), &p)
+			if status != tt.want || p.Code != tt.code {
+				t.Errorf("status %d, problem %+v, want %d %s", status, p, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("upstreams", map[string]any{"chat": map[string]any{"static": []string{"chat:8080"}}})
+	viper.Set("routes", []map[string]any{{"name": "chat", "path": "/a/chat/*", "upstream": "chat"}})
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if len(cfg.Routes) != 1 || cfg.Upstreams["chat"].Static[0] != "chat:8080" {
+		t.Errorf("config %+v", cfg)
+	}
+
+	viper.Set("routes", []map[string]any{{"name": "files", "path": "/a/files/*", "upstream": "files"}})
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig accepted a route to an unknown upstream")
+	}
+	if _, err := NewPool(Upstream{}, log.NewNopLogger()); err != ErrNoUpstream {
+		t.Errorf("NewPool without instances = %v, want %v", err, ErrNoUpstream)
+	}
+}
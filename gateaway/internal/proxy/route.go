@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Handler adapts h to echo for the route, rewriting the upstream path when
+// the route asks for it.
+func (rt Route) Handler(h http.Handler) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if rt.Rewrite != "" {
+			path := rt.Rewrite
+			if strings.HasSuffix(rt.Path, "/*") {
+				path = strings.TrimRight(path, "/") + "/" + c.Param("*")
+			}
+			r = r.WithContext(WithTargetPath(r.Context(), path))
+		}
+		h.ServeHTTP(c.Response(), r)
+		return nil
+	}
+}
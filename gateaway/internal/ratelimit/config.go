@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	// Store is either memory or redis.
+	Store string `mapstructure:"store"`
+	Redis struct {
+		Addr     string `mapstructure:"addr"`
+		Password string `mapstructure:"password"`
+		DB       int    `mapstructure:"db"`
+	} `mapstructure:"redis"`
+	// Global applies to every request.
+	Global Limit `mapstructure:"global"`
+	// Routes holds per route budgets by route name.
+	Routes map[string]Limit `mapstructure:"routes"`
+}
+
+// LoadConfig reads the ratelimit key.
+func LoadConfig() (Config, error) {
+	var cfg Config
+	err := viper.UnmarshalKey("ratelimit", &cfg)
+	return cfg, err
+}
+
+// NewStore returns the store selected by the config.
+func NewStore(cfg Config) (Store, error) {
+	switch cfg.Store {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		return NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})), nil
+	}
+	return nil, fmt.Errorf("unknown ratelimit store %q", cfg.Store)
+}
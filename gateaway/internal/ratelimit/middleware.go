@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	headerRetryAfter = "Retry-After"
+)
+
+// Middleware limits requests against the bucket of name, keyed by the
+// authenticated user when JWTAuthentication has run and by client IP
+// otherwise. It fails open when the store is unavailable.
+func Middleware(store Store, name string, l Limit) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if !l.Enabled() {
+			return next
+		}
+		return func(c echo.Context) error {
+			res, err := store.Take(c.Request().Context(), name+":"+subject(c), l)
+			if err != nil {
+				log.Println("ratelimit:", name, err)
+				return next(c)
+			}
+			setHeaders(c.Response().Header(), res)
+			if !res.Allowed {
+				c.Response().Header().Set(headerRetryAfter, seconds(res.RetryAfter))
+				return problem.New(http.StatusTooManyRequests, problem.CodeRateLimited, "rate limit exceeded").
+					WithDetails(map[string]any{"retry_after": math.Ceil(res.RetryAfter.Seconds())})
+			}
+			return next(c)
+		}
+	}
+}
+
+func subject(c echo.Context) string {
+	for _, key := range []string{"uid", "email"} {
+		if v, ok := c.Get(key).(string); ok && v != "" {
+			return "user:" + v
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// setHeaders reports the most restrictive of the limits a request went
+// through, as several middlewares may apply to the same route.
+func setHeaders(h http.Header, res Result) {
+	if prev := h.Get(HeaderRemaining); prev != "" {
+		if n, err := strconv.Atoi(prev); err == nil && n < res.Remaining {
+			return
+		}
+	}
+	h.Set(HeaderLimit, strconv.Itoa(res.Limit))
+	h.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+	h.Set(HeaderReset, seconds(res.Reset))
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,69 @@
+// Package ratelimit implements token bucket rate limiting for the gateway.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket refilled with Rate tokens every Per, holding at
+// most Burst tokens.
+type Limit struct {
+	Rate  int           `mapstructure:"rate"`
+	Per   time.Duration `mapstructure:"per"`
+	Burst int           `mapstructure:"burst"`
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Per > 0
+}
+
+// interval is the time it takes to refill a single token.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Rate)
+}
+
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+// Result describes the state of a bucket after taking a token from it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available, set only
+	// when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets by key.
+type Store interface {
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// take refills a bucket holding tokens as of last and tries to take one
+// token from it at now. It is shared by the stores so they agree on the
+// arithmetic.
+func take(tokens float64, last, now time.Time, l Limit) (float64, Result) {
+	burst := float64(l.burst())
+	if elapsed := now.Sub(last); elapsed > 0 {
+		tokens = math.Min(burst, tokens+float64(elapsed)/float64(l.interval()))
+	}
+	res := Result{Limit: l.burst()}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(l.interval()))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((burst - tokens) * float64(l.interval()))
+	return tokens, res
+}
@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+func TestTake(t *testing.T) {
+	l := Limit{Rate: 10, Per: time.Second, Burst: 5}
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       Result
+	}{
+		{
+			name:       "full bucket",
+			tokens:     5,
+			wantTokens: 4,
+			want:       Result{Allowed: true, Limit: 5, Remaining: 4, Reset: 100 * time.Millisecond},
+		},
+		{
+			name:       "empty bucket",
+			tokens:     0,
+			wantTokens: 0,
+			want:       Result{Limit: 5, Reset: 500 * time.Millisecond, RetryAfter: 100 * time.Millisecond},
+		},
+		{
+			name:       "partly refilled",
+			tokens:     0,
+			elapsed:    50 * time.Millisecond,
+			wantTokens: 0.5,
+			want:       Result{Limit: 5, Reset: 450 * time.Millisecond, RetryAfter: 50 * time.Millisecond},
+		},
+		{
+			name:       "refilled one token",
+			tokens:     0,
+			elapsed:    100 * time.Millisecond,
+			wantTokens: 0,
+			want:       Result{Allowed: true, Limit: 5, Reset: 500 * time.Millisecond},
+		},
+		{
+			name:       "refill capped at burst",
+			tokens:     1,
+			elapsed:    time.Hour,
+			wantTokens: 4,
+			want:       Result{Allowed: true, Limit: 5, Remaining: 4, Reset: 100 * time.Millisecond},
+		},
+		{
+			name:       "clock going backwards refills nothing",
+			tokens:     0,
+			elapsed:    -time.Second,
+			wantTokens: 0,
+			want:       Result{Limit: 5, Reset: 500 * time.Millisecond, RetryAfter: 100 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, res := take(tt.tokens, t0, t0.Add(tt.elapsed), l)
+			if diff := tokens - tt.wantTokens; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("tokens = %v, want %v", tokens, tt.wantTokens)
+			}
+			if !near(res, tt.want) {
+				t.Errorf("result = %+v, want %+v", res, tt.want)
+			}
+		})
+	}
+}
+
+// near compares results up to float rounding of the durations.
+func near(a, b Result) bool {
+	within := func(x, y time.Duration) bool {
+		d := x - y
+		return d < time.Microsecond && d > -time.Microsecond
+	}
+	return a.Allowed == b.Allowed && a.Limit == b.Limit && a.Remaining == b.Remaining &&
+		within(a.Reset, b.Reset) && within(a.RetryAfter, b.RetryAfter)
+}
+
+func TestBurstDefaultsToRate(t *testing.T) {
+	if got := (Limit{Rate: 3, Per: time.Second}).burst(); got != 3 {
+		t.Errorf("burst = %d, want the rate 3", got)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	l := Limit{Rate: 2, Per: time.Second}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if res, _ := s.Take(ctx, "a", l); !res.Allowed {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	res, _ := s.Take(ctx, "a", l)
+	if res.Allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 500ms", res.RetryAfter)
+	}
+	if res, _ := s.Take(ctx, "b", l); !res.Allowed {
+		t.Error("another key shares the exhausted bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res, _ := s.Take(ctx, "a", l); !res.Allowed {
+		t.Error("request after the refill interval was refused")
+	}
+	if res, _ := s.Take(ctx, "a", l); res.Allowed {
+		t.Error("a single refilled token was taken twice")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	l := Limit{Rate: 1, Per: time.Second}
+	s.Take(context.Background(), "a", l)
+
+	now = now.Add(sweepInterval + time.Second)
+	s.Take(context.Background(), "b", l)
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+func TestMiddleware(t *testing.T) {
+	l := Limit{Rate: 1, Per: time.Minute}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	serve := func(mw echo.MiddlewareFunc, ip, uid string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if uid != "" {
+			c.Set("uid", uid)
+		}
+		return rec, mw(ok)(c)
+	}
+
+	t.Run("limits by ip", func(t *testing.T) {
+		mw := Middleware(NewMemoryStore(), "login", l)
+		if _, err := serve(mw, "10.0.0.1", ""); err != nil {
+			t.Fatalf("first request: %v", err)
+		}
+		rec, err := serve(mw, "10.0.0.1", "")
+		var p *problem.Problem
+		if !errors.As(err, &p) || p.Status != http.StatusTooManyRequests {
+			t.Fatalf("second request err = %v, want a 429 problem", err)
+		}
+		if got := rec.Header().Get(headerRetryAfter); got != "60" {
+			t.Errorf("Retry-After = %q, want 60", got)
+		}
+		if got := rec.Header().Get(HeaderRemaining); got != "0" {
+			t.Errorf("%s = %q, want 0", HeaderRemaining, got)
+		}
+		if _, err := serve(mw, "10.0.0.2", ""); err != nil {
+			t.Errorf("another ip was limited: %v", err)
+		}
+	})
+
+	t.Run("limits by user", func(t *testing.T) {
+		mw := Middleware(NewMemoryStore(), "login", l)
+		serve(mw, "10.0.0.1", "alice")
+		// the same user from another address shares the budget
+		if _, err := serve(mw, "10.0.0.2", "alice"); err == nil {
+			t.Error("user got a second budget from another ip")
+		}
+		if _, err := serve(mw, "10.0.0.1", "bob"); err != nil {
+			t.Errorf("another user behind the same ip was limited: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mw := Middleware(NewMemoryStore(), "login", Limit{})
+		for i := 0; i < 5; i++ {
+			if _, err := serve(mw, "10.0.0.1", ""); err != nil {
+				t.Fatalf("request %d: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("fails open", func(t *testing.T) {
+		mw := Middleware(failingStore{}, "login", l)
+		if rec, err := serve(mw, "10.0.0.1", ""); err != nil || rec.Code != http.StatusOK {
+			t.Errorf("request with the store down = %d, %v, want it through", rec.Code, err)
+		}
+	})
+}
+
+func TestSetHeadersKeepsTightest(t *testing.T) {
+	h := http.Header{}
+	setHeaders(h, Result{Limit: 100, Remaining: 3, Reset: time.Second})
+	setHeaders(h, Result{Limit: 10, Remaining: 7, Reset: time.Second})
+	if got := h.Get(HeaderLimit); got != "100" {
+		t.Errorf("%s = %q, want the tighter limit's 100", HeaderLimit, got)
+	}
+	setHeaders(h, Result{Limit: 5, Remaining: 1, Reset: time.Second})
+	if got := h.Get(HeaderRemaining); got != "1" {
+		t.Errorf("%s = %q, want 1", HeaderRemaining, got)
+	}
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript is the Lua counterpart of take, so that the refill and the
+// decrement happen atomically on the shared bucket.
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / interval)
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * interval)
+end
+local reset = math.ceil((burst - tokens) * interval)
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.max(1, reset))
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// RedisStore keeps buckets in Redis, sharing budgets between gateway
+// replicas.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	vals, err := takeScript.Run(
+		ctx,
+		s.client,
+		[]string{"ratelimit:" + key},
+		l.burst(),
+		strconv.FormatFloat(float64(l.interval())/float64(time.Millisecond), 'f', -1, 64),
+		time.Now().UnixMilli(),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    vals[0] == 1,
+		Limit:      l.burst(),
+		Remaining:  int(vals[1]),
+		Reset:      time.Duration(vals[2]) * time.Millisecond,
+		RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,127 @@
+// Package session keeps the auth token of browser clients in a cookie, as
+// an alternative to sending it in the X-Api-Token header.
+//
+// Cookies are sent by browsers on their own, so requests authenticated by
+// the cookie must prove they come from our pages with a double submit CSRF
+// token: the X-CSRF-Token header, or the _csrf form field, has to match the
+// _csrf cookie.
+package session
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// Cookie holds the access token. It is HttpOnly so scripts never see
+	// the token.
+	Cookie = "session"
+	// CSRFCookie holds the CSRF token. Scripts read it to echo it back in
+	// CSRFHeader.
+	CSRFCookie = "_csrf"
+	CSRFHeader = "X-CSRF-Token"
+	// TokenHeader carries the token of API clients.
+	TokenHeader = "X-Api-Token"
+)
+
+// Token returns the access token of the request, preferring the header over
+// the cookie.
+func Token(c echo.Context) string {
+	if token := c.Request().Header.Get(TokenHeader); token != "" {
+		return token
+	}
+	if cookie, err := c.Cookie(Cookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// FromCookie reports whether the request is authenticated by the cookie
+// rather than the header.
+func FromCookie(c echo.Context) bool {
+	if c.Request().Header.Get(TokenHeader) != "" {
+		return false
+	}
+	_, err := c.Cookie(Cookie)
+	return err == nil
+}
+
+// CSRFToken returns the token set by a CSRF middleware.
+func CSRFToken(c echo.Context) string {
+	token, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+	return token
+}
+
+// Manager writes the session cookies.
+type Manager struct {
+	// Secure marks cookies Secure, it is off for plain http development.
+	Secure bool
+}
+
+func NewManager(secure bool) Manager {
+	return Manager{Secure: secure}
+}
+
+// Set stores token in the session cookie, expiring with the token.
+func (m Manager) Set(c echo.Context, token string) {
+	cookie := m.cookie(token)
+	cookie.Expires = Expiry(token)
+	c.SetCookie(cookie)
+}
+
+func (m Manager) Clear(c echo.Context) {
+	cookie := m.cookie("")
+	cookie.MaxAge = -1
+	c.SetCookie(cookie)
+}
+
+func (m Manager) cookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     Cookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   m.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// CSRF checks the double submit token of unsafe requests, unless skipper
+// says otherwise. Safe requests get a token issued.
+func (m Manager) CSRF(skipper middleware.Skipper) echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        skipper,
+		TokenLookup:    "header:" + CSRFHeader + ",form:_csrf",
+		CookieName:     CSRFCookie,
+		CookiePath:     "/",
+		CookieSecure:   m.Secure,
+		CookieSameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CookieCSRF is CSRF for routes shared with API clients: only requests
+// authenticated by the session cookie are checked.
+func (m Manager) CookieCSRF() echo.MiddlewareFunc {
+	return m.CSRF(func(c echo.Context) bool {
+		return !FromCookie(c)
+	})
+}
+
+// Expiry returns when token expires, or the zero time if it does not say.
+// The token is not verified, it is only used for cookies of tokens the auth
+// service just issued.
+func Expiry(token string) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return time.Time{}
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}
+	}
+	return exp.Time
+}
@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// APIKeyPrefix starts the API keys of the auth service.
+const APIKeyPrefix = "uk_"
+
+// tokenMargin is how long before they expire cached tokens are exchanged
+// again, so that none expires on its way upstream.
+const tokenMargin = 30 * time.Second
+
+// KeyExchanger exchanges API keys for short lived tokens carrying their
+// scopes.
+type KeyExchanger interface {
+	ExchangeAPIKey(ctx context.Context, key string) (string, error)
+}
+
+// APIKeys authenticates API clients by the API key in
+// Authorization: Bearer uk_..., as an alternative to tokens. Keys are
+// exchanged with the auth service, and the tokens cached until shortly
+// before they expire.
+type APIKeys struct {
+	exchanger KeyExchanger
+	max       int
+
+	mu     sync.Mutex
+	tokens map[[sha256.Size]byte]cachedToken
+}
+
+type cachedToken struct {
+	token string
+	until time.Time
+}
+
+// NewAPIKeys caches the tokens of at most max keys.
+func NewAPIKeys(exchanger KeyExchanger, max int) *APIKeys {
+	if max <= 0 {
+		max = 10000
+	}
+	return &APIKeys{
+		exchanger: exchanger,
+		max:       max,
+		tokens:    make(map[[sha256.Size]byte]cachedToken),
+	}
+}
+
+// Middleware swaps the API key of a request for its token in the
+// X-Api-Token header, where JWTAuthentication and the services behind the
+// gateway look for it. The key itself is not forwarded. It must run before
+// CookieCSRF and JWTAuthentication, requests without a key pass untouched.
+func (k *APIKeys) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		scheme, key, ok := strings.Cut(r.Header.Get(echo.HeaderAuthorization), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(key, APIKeyPrefix) {
+			return next(c)
+		}
+		token, err := k.token(r.Context(), key)
+		if err != nil {
+			return err
+		}
+		r.Header.Del(echo.HeaderAuthorization)
+		r.Header.Set(session.TokenHeader, token)
+		return next(c)
+	}
+}
+
+func (k *APIKeys) token(ctx context.Context, key string) (string, error) {
+	sum := sha256.Sum256([]byte(key))
+	now := time.Now()
+	k.mu.Lock()
+	cached, ok := k.tokens[sum]
+	k.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		return cached.token, nil
+	}
+
+	token, err := k.exchanger.ExchangeAPIKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	until := session.Expiry(token).Add(-tokenMargin)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.tokens) >= k.max {
+		for s, t := range k.tokens {
+			if !now.Before(t.until) {
+				delete(k.tokens, s)
+			}
+		}
+		// still full of live tokens, they are cheap to exchange again
+		if len(k.tokens) >= k.max {
+			clear(k.tokens)
+		}
+	}
+	k.tokens[sum] = cachedToken{token: token, until: until}
+	return token, nil
+}
+
+// RequireScope refuses the tokens of API keys lacking scope. The tokens of
+// users carry no scopes and pass, routes with an empty scope refuse every
+// API key. It must run after JWTAuthentication.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			scopes, ok := c.Get("scopes").([]string)
+			if ok && (scope == "" || !slices.Contains(scopes, scope)) {
+				return problem.New(http.StatusForbidden, problem.CodePermissionDenied,
+					"api key lacks the scope of this route")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// ServiceName is reported in the problems of the gateway itself, those of
+// the services behind it keep theirs.
+const ServiceName = "gateway"
+
+// ErrorHandler is the echo.HTTPErrorHandler of the gateway. It writes every
+// error as a problem, so that clients get the same envelope as from the
+// services behind it.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	var (
+		p       *problem.Problem
+		httpErr *echo.HTTPError
+	)
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &httpErr):
+		p = problem.Status(httpErr.Code, fmt.Sprint(httpErr.Message))
+	default:
+		log.Println("gateway:", c.Request().URL.Path, err)
+		p = problem.From(err)
+	}
+	next := *p.WithService(ServiceName)
+	next.RequestID = c.Request().Header.Get(problem.RequestIDHeader)
+	if err := problem.Write(c.Response(), &next); err != nil {
+		log.Println("gateway: writing problem:", err)
+	}
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-kit/introspect"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// Introspection authenticates requests by introspecting their token with
+// the auth service, as an alternative to JWTAuthentication that needs no
+// auth.secret and sees revoked API keys and ended sessions. It accepts the
+// same tokens and sets the same values. introspector should be an
+// introspect.Cache.
+func Introspection(introspector introspect.Introspector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := session.Token(c)
+			if token == "" {
+				return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized")
+			}
+			in, err := introspector.Introspect(c.Request().Context(), token)
+			if err != nil {
+				return err
+			}
+			// tokens of services are no users
+			if !in.Active || in.Username == "" {
+				return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized")
+			}
+			c.Set("email", in.Username)
+			if in.KeyID != "" {
+				c.Set("scopes", strings.Fields(in.Scope))
+			}
+			return next(c)
+		}
+	}
+}
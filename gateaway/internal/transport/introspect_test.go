@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-kit/introspect"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+type introspections map[string]introspect.Introspection
+
+func (i introspections) Introspect(_ context.Context, token string) (introspect.Introspection, error) {
+	return i[token], nil
+}
+
+func TestIntrospection(t *testing.T) {
+	auth := introspections{
+		"alice":   {Active: true, Username: "alice@example.com"},
+		"key":     {Active: true, Username: "alice@example.com", KeyID: "k1", Scope: "chat files"},
+		"revoked": {Revoked: true},
+		"service": {Active: true, ClientID: "consumer"},
+	}
+	tests := []struct {
+		token      string
+		wantEmail  string
+		wantScopes []string
+	}{
+		{token: "alice", wantEmail: "alice@example.com"},
+		{token: "key", wantEmail: "alice@example.com", wantScopes: []string{"chat", "files"}},
+		{token: "revoked"},
+		{token: "service"},
+		{token: "unknown"},
+		{token: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/a/chat/rooms", nil)
+			if tt.token != "" {
+				r.Header.Set(session.TokenHeader, tt.token)
+			}
+			c := echo.New().NewContext(r, httptest.NewRecorder())
+			var email string
+			var scopes []string
+			err := Introspection(auth)(func(c echo.Context) error {
+				email, _ = c.Get("email").(string)
+				scopes, _ = c.Get("scopes").([]string)
+				return nil
+			})(c)
+
+			if tt.wantEmail == "" {
+				var p *problem.Problem
+				if !errors.As(err, &p) || p.Status != http.StatusUnauthorized {
+					t.Errorf("err = %v, want a 401 problem", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v, want the request through", err)
+			}
+			if email != tt.wantEmail || !reflect.DeepEqual(scopes, tt.wantScopes) {
+				t.Errorf("email %q, scopes %v, want %q, %v", email, scopes, tt.wantEmail, tt.wantScopes)
+			}
+		})
+	}
+}
@@ -3,21 +3,37 @@ package transport
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-kit/problem"
 )
 
+// JWTAuthentication accepts the token of API clients in the X-Api-Token
+// header and that of browsers in the session cookie. Routes using it need
+// session.Manager.CookieCSRF as well. The scopes of tokens exchanged for
+// API keys are kept for RequireScope.
 func JWTAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		token := c.Request().Header.Get("X-Api-Token")
-		claims, err := ParseToken(token)
+		claims, err := ParseToken(session.Token(c))
 		if err != nil {
 			log.Println(err)
-			return err
+			return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+		}
+		email, ok := claims["email"].(string)
+		if !ok {
+			return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "token has no email claim")
 		}
-		email := claims["email"].(string)
 		c.Set("email", email)
+		if scope, ok := claims["scope"].(string); ok {
+			c.Set("scopes", strings.Fields(scope))
+		}
 		return next(c)
 	}
 }
@@ -27,9 +43,10 @@ func ParseToken(tokenStr string) (jwt.MapClaims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unauthorized")
 		}
-		secret := "nigga"
+		// must match the auth service's auth.secret
+		secret := viper.GetString("auth.secret")
 		if secret == "" {
-			log.Fatal("no secret is set, set the env secret")
+			log.Fatal("no secret is set, set auth.secret in the config")
 		}
 		return []byte(secret), nil
 	})
@@ -44,3 +61,33 @@ func ParseToken(tokenStr string) (jwt.MapClaims, error) {
 
 	return nil, fmt.Errorf("unauthorized")
 }
+
+// RequestID makes sure every request carries an X-Request-ID, generating one
+// when the client did not send it, and echoes it back in the response.
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(echo.HeaderXRequestID)
+		if id == "" {
+			id = uuid.NewString()
+			c.Request().Header.Set(echo.HeaderXRequestID, id)
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		return next(c)
+	}
+}
+
+// BodyLimit refuses request bodies over n bytes, before they reach the
+// upstream when the client announced their length.
+func BodyLimit(n int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			if r.ContentLength > n {
+				return problem.New(http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+					fmt.Sprintf("request body exceeds %d bytes", n))
+			}
+			r.Body = http.MaxBytesReader(c.Response(), r.Body, n)
+			return next(c)
+		}
+	}
+}
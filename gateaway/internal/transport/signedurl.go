@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/signedurl"
+)
+
+// SignedURL refuses requests whose url is not signed by signer, chat signs
+// the file urls with the same secret. The resource signed is the path
+// matched by the route's trailing /*.
+//
+// Only the signature is checked here, chat decides whether the
+// authenticated user may see the file, i.e. is a member of its room.
+func SignedURL(signer signedurl.Signer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			err := signer.Verify(r.Method, c.Param("*"), r.URL.Query(), time.Now())
+			if errors.Is(err, signedurl.ErrExpired) {
+				return problem.New(http.StatusForbidden, problem.CodePermissionDenied, "url expired, fetch a fresh one")
+			}
+			if err != nil {
+				return problem.Wrap(http.StatusForbidden, problem.CodePermissionDenied, err)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/signedurl"
+)
+
+func TestSignedURL(t *testing.T) {
+	signer := signedurl.New("secret")
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	serve := func(method, target string) error {
+		e := echo.New()
+		var err error
+		e.Add(method, "/c/files/*", func(c echo.Context) error {
+			err = SignedURL(signer)(ok)(c)
+			return err
+		})
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+		return err
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		expires time.Time
+		path    string
+		wantErr bool
+	}{
+		{"signed", http.MethodGet, time.Now().Add(time.Minute), "att-1", false},
+		{"expired", http.MethodGet, time.Now().Add(-time.Minute), "att-1", true},
+		{"other file", http.MethodGet, time.Now().Add(time.Minute), "att-2", true},
+		{"download url used to upload", http.MethodPut, time.Now().Add(time.Minute), "att-1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := signer.Sign(http.MethodGet, "att-1", tt.expires)
+			err := serve(tt.method, "/c/files/"+tt.path+"?"+q.Encode())
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("err = %v, want the request through", err)
+				}
+				return
+			}
+			var p *problem.Problem
+			if !errors.As(err, &p) || p.Status != http.StatusForbidden {
+				t.Errorf("err = %v, want a 403 problem", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-gateaway/internal/transport"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// SessionResponse describes the cookie session. CSRFToken is to be sent back
+// in the X-CSRF-Token header of unsafe requests.
+type SessionResponse struct {
+	Authenticated bool       `json:"authenticated"`
+	Email         string     `json:"email,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CSRFToken     string     `json:"csrf_token"`
+}
+
+// RegisterAPI adds the session API under g:
+//
+//	GET    /                the current session and a CSRF token
+//	POST   /                log in with {"email", "password"}, setting the
+//	                        cookie
+//	POST   /link            mail a login link with {"email"}
+//	POST   /link/consume    log in with {"token"} of a login link, setting
+//	                        the cookie
+//	POST   /passkey/begin   challenge a passkey, the authenticator picks the
+//	                        user and the body is ignored
+//	POST   /passkey/finish  log in with {"challenge_id", "credential"} of the
+//	                        browser's answer, setting the cookie
+//	POST   /refresh         replace the cookie's token before it expires
+//	DELETE /                log out
+//
+// Every unsafe request needs the CSRF token, including the log in, so get
+// the session first.
+func (h *Handler) RegisterAPI(g *echo.Group) {
+	g.Use(h.sessions.CSRF(nil))
+	g.GET("", h.getSession)
+	g.POST("", h.createSession)
+	g.POST("/link", h.requestSessionLink)
+	g.POST("/link/consume", h.createSessionFromLink)
+	g.POST("/passkey/begin", h.beginSessionPasskey)
+	g.POST("/passkey/finish", h.createSessionFromPasskey)
+	g.POST("/refresh", h.refreshSession)
+	g.DELETE("", h.deleteSession)
+}
+
+func (h *Handler) getSession(c echo.Context) error {
+	resp := SessionResponse{CSRFToken: session.CSRFToken(c)}
+	if cookie, err := c.Cookie(session.Cookie); err == nil {
+		if claims, err := transport.ParseToken(cookie.Value); err == nil {
+			resp.Authenticated = true
+			resp.Email, _ = claims["email"].(string)
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				resp.ExpiresAt = &exp.Time
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) createSession(c echo.Context) error {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		// Device names the session, for the user to tell their sessions
+		// apart.
+		Device string `json:"device"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, c.Request().Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return problem.Invalid(err)
+	}
+
+	token, err := h.client.Login(requestContext(c), req.Email, req.Password, deviceOf(c, req.Device))
+	if err != nil {
+		return err
+	}
+	return h.sessionCreated(c, token)
+}
+
+func (h *Handler) requestSessionLink(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, c.Request().Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return problem.Invalid(err)
+	}
+
+	if err := h.client.RequestMagicLink(requestContext(c), req.Email); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (h *Handler) createSessionFromLink(c echo.Context) error {
+	var req struct {
+		Token  string `json:"token"`
+		Device string `json:"device"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, c.Request().Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return problem.Invalid(err)
+	}
+
+	token, err := h.client.ConsumeMagicLink(requestContext(c), req.Token, deviceOf(c, req.Device))
+	if err != nil {
+		return err
+	}
+	return h.sessionCreated(c, token)
+}
+
+func (h *Handler) beginSessionPasskey(c echo.Context) error {
+	challenge, err := h.client.BeginPasskeyLogin(requestContext(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, challenge)
+}
+
+func (h *Handler) createSessionFromPasskey(c echo.Context) error {
+	var req struct {
+		ChallengeID string          `json:"challenge_id"`
+		Credential  json.RawMessage `json:"credential"`
+		Device      string          `json:"device"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, c.Request().Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return problem.Invalid(err)
+	}
+
+	token, err := h.client.FinishPasskeyLogin(requestContext(c), req.ChallengeID, req.Credential, deviceOf(c, req.Device))
+	if err != nil {
+		return err
+	}
+	return h.sessionCreated(c, token)
+}
+
+func (h *Handler) refreshSession(c echo.Context) error {
+	cookie, err := c.Cookie(session.Cookie)
+	if err != nil {
+		return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "no session")
+	}
+	token, err := h.client.Refresh(requestContext(c), cookie.Value)
+	if err != nil {
+		return err
+	}
+	return h.sessionCreated(c, token)
+}
+
+func (h *Handler) sessionCreated(c echo.Context, token string) error {
+	h.sessions.Set(c, token)
+	claims, err := transport.ParseToken(token)
+	if err != nil {
+		return err
+	}
+	resp := SessionResponse{Authenticated: true, CSRFToken: session.CSRFToken(c)}
+	resp.Email, _ = claims["email"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		resp.ExpiresAt = &exp.Time
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) deleteSession(c echo.Context) error {
+	if err := h.endSession(c); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// endSession revokes the session of the cookie's token with auth and clears
+// the cookie, a copy of the token must not outlive the logout. Tokens auth
+// refuses are as good as revoked already.
+func (h *Handler) endSession(c echo.Context) error {
+	if cookie, err := c.Cookie(session.Cookie); err == nil {
+		err := h.client.RevokeSession(requestContext(c), cookie.Value)
+		var p *problem.Problem
+		if err != nil && !(errors.As(err, &p) && p.Status == http.StatusUnauthorized) {
+			return err
+		}
+	}
+	h.sessions.Clear(c)
+	return nil
+}
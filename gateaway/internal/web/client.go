@@ -0,0 +1,257 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/F1zm0n/universal-gateaway/internal/proxy"
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-gateaway/internal/transport"
+	"github.com/F1zm0n/universal-kit/introspect"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// idempotencyHeader matches the header the producer deduplicates sign ups
+// on.
+const idempotencyHeader = "Idempotency-Key"
+
+// Client calls the services behind the gateway on behalf of the pages.
+// Failed calls return the upstream's *problem.Problem.
+type Client struct {
+	producer *proxy.Pool
+	auth     *proxy.Pool
+	http     *http.Client
+	tokens   *oauth.TokenSource
+}
+
+// NewClient returns a client of the producer and auth pools. tokens are
+// the gateway's service tokens for introspection, which needs none else.
+func NewClient(producer, auth *proxy.Pool, transport http.RoundTripper, tokens *oauth.TokenSource) *Client {
+	return &Client{
+		producer: producer,
+		auth:     auth,
+		http:     &http.Client{Transport: transport},
+		tokens:   tokens,
+	}
+}
+
+// Register queues the verification mail of a new account.
+func (c *Client) Register(ctx context.Context, email, password, key string) error {
+	header := make(http.Header)
+	if key != "" {
+		header.Set(idempotencyHeader, key)
+	}
+	body := map[string]string{"email": email, "password": password}
+	return c.do(ctx, c.producer, http.MethodPost, "/mail", header, body, nil)
+}
+
+// Verify confirms the email behind a verification link.
+func (c *Client) Verify(ctx context.Context, id string) error {
+	return c.do(ctx, c.producer, http.MethodGet, "/verify?id="+url.QueryEscape(id), nil, nil, nil)
+}
+
+// Device is what a user logs in from, auth records it with the session.
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// Login returns the session token of a user, starting a session on device.
+func (c *Client) Login(ctx context.Context, email, password string, device Device) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"email": email, "password": password, "device": device.Name}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/login", device.header(), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// RequestMagicLink mails a login link to the user with email, if any.
+func (c *Client) RequestMagicLink(ctx context.Context, email string) error {
+	body := map[string]string{"email": email}
+	return c.do(ctx, c.auth, http.MethodPost, "/login/link", nil, body, nil)
+}
+
+// ConsumeMagicLink returns the session token of the user of a login link,
+// starting a session on device as Login does.
+func (c *Client) ConsumeMagicLink(ctx context.Context, token string, device Device) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"token": token, "device": device.Name}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/login/link/consume", device.header(), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// PasskeyChallenge starts a passkey ceremony. Options go to the browser's
+// WebAuthn API, its answer goes back with ChallengeID.
+type PasskeyChallenge struct {
+	ChallengeID string          `json:"challenge_id"`
+	Options     json.RawMessage `json:"options"`
+}
+
+// BeginPasskeyLogin challenges an authenticator to log in with any passkey
+// it keeps.
+func (c *Client) BeginPasskeyLogin(ctx context.Context) (PasskeyChallenge, error) {
+	var resp PasskeyChallenge
+	body := map[string]string{}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/login/passkey/begin", nil, body, &resp); err != nil {
+		return PasskeyChallenge{}, err
+	}
+	return resp, nil
+}
+
+// FinishPasskeyLogin returns the session token of the user whose
+// authenticator answered the challenge, starting a session on device as
+// Login does.
+func (c *Client) FinishPasskeyLogin(
+	ctx context.Context,
+	challengeID string,
+	credential json.RawMessage,
+	device Device,
+) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]any{"challenge_id": challengeID, "credential": credential, "device": device.Name}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/login/passkey/finish", device.header(), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// header carries the user agent and address of the device, auth takes them
+// from the headers, as when it is called directly.
+func (d Device) header() http.Header {
+	return http.Header{
+		"User-Agent":      {d.UserAgent},
+		"X-Forwarded-For": {d.IP},
+	}
+}
+
+// Refresh exchanges an unexpired token for a new one.
+func (c *Client) Refresh(ctx context.Context, token string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"token": token}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/refresh", nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// RevokeSession ends the session of token with auth, so that neither the
+// token nor any copy of it can be refreshed or used with auth again. Tokens
+// without a session have nothing to revoke.
+func (c *Client) RevokeSession(ctx context.Context, token string) error {
+	claims, err := transport.ParseToken(token)
+	if err != nil {
+		return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+	}
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return nil
+	}
+	header := http.Header{session.TokenHeader: {token}}
+	return c.do(ctx, c.auth, http.MethodDelete, "/sessions/"+url.PathEscape(sid), header, nil, nil)
+}
+
+// ExchangeAPIKey returns a short lived token for an API key.
+func (c *Client) ExchangeAPIKey(ctx context.Context, key string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"key": key}
+	if err := c.do(ctx, c.auth, http.MethodPost, "/keys/exchange", nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// Introspect describes a token, with a service token of the gateway.
+func (c *Client) Introspect(ctx context.Context, token string) (introspect.Introspection, error) {
+	serviceToken, err := c.tokens.Token(ctx)
+	if err != nil {
+		return introspect.Introspection{}, problem.Wrap(http.StatusBadGateway, problem.CodeBadGateway, err)
+	}
+	body := map[string]string{"token": token}
+	var resp introspect.Introspection
+	if err := c.do(oauth.NewContext(ctx, serviceToken), c.auth, http.MethodPost, "/introspect", nil, body, &resp); err != nil {
+		return introspect.Introspection{}, err
+	}
+	return resp, nil
+}
+
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	body := map[string]string{"email": email}
+	return c.do(ctx, c.auth, http.MethodPost, "/password/forgot", nil, body, nil)
+}
+
+func (c *Client) ResetPassword(ctx context.Context, token, password string) error {
+	body := map[string]string{"token": token, "password": password}
+	return c.do(ctx, c.auth, http.MethodPost, "/password/reset", nil, body, nil)
+}
+
+func (c *Client) do(
+	ctx context.Context,
+	pool *proxy.Pool,
+	method, path string,
+	header http.Header,
+	in, out any,
+) error {
+	base, err := pool.Next()
+	if err != nil {
+		return problem.Wrap(http.StatusServiceUnavailable, problem.CodeUnavailable, err)
+	}
+	target, err := base.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(in); err != nil {
+			return err
+		}
+		body = &buf
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id := problem.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(problem.RequestIDHeader, id)
+	}
+	oauth.ContextToHTTP()(ctx, req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return problem.Wrap(http.StatusBadGateway, problem.CodeBadGateway, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return problem.Decode(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
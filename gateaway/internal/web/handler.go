@@ -0,0 +1,231 @@
+// Package web serves browser clients: the gateway's pages and the session
+// API of script clients using cookies. Forms post back to the page they were
+// rendered on; htmx requests get the form fragment back so errors show in
+// place, plain posts get the whole page.
+package web
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-gateaway/view"
+)
+
+type Handler struct {
+	client   *Client
+	sessions session.Manager
+}
+
+func NewHandler(client *Client, sessions session.Manager) *Handler {
+	return &Handler{client: client, sessions: sessions}
+}
+
+// Register adds the pages to e. mw runs before every page, after the CSRF
+// check and the session lookup. limit returns the rate limit of the API
+// route of the given name, the forms posting credentials or tokens or
+// mailing someone share its budget so that there is no way around it.
+func (h *Handler) Register(e *echo.Echo, limit func(route string) echo.MiddlewareFunc, mw ...echo.MiddlewareFunc) {
+	e.StaticFS("/static", echo.MustSubFS(view.Static, "static"))
+
+	g := e.Group("", h.sessions.CSRF(nil), loadSession)
+	g.Use(mw...)
+
+	g.GET("/", h.index)
+	g.GET("/register", h.registerPage)
+	g.POST("/register", h.register, limit("register"))
+	g.GET("/check-email", h.checkEmail)
+	g.GET("/verify", h.verify, limit("verify"))
+	g.GET("/login", h.loginPage)
+	g.POST("/login", h.login, limit("login"))
+	g.POST("/logout", h.logout)
+	g.GET("/login/link", h.magicLinkPage)
+	g.POST("/login/link", h.magicLink, limit("magiclink"))
+	g.GET("/login/link/open", h.magicLoginPage)
+	g.POST("/login/link/open", h.magicLogin, limit("magiclink"))
+	g.GET("/forgot", h.forgotPage)
+	g.POST("/forgot", h.forgot, limit("magiclink"))
+	g.GET("/reset", h.resetPage)
+	g.POST("/reset", h.reset, limit("magiclink"))
+	g.GET("/account", h.account)
+}
+
+func (h *Handler) index(c echo.Context) error {
+	if sessionFrom(c).SignedIn() {
+		return redirect(c, "/account")
+	}
+	return redirect(c, "/register")
+}
+
+func (h *Handler) registerPage(c echo.Context) error {
+	f := newForm(c)
+	// the key is fixed per rendered form, so double submits are deduplicated
+	// by the producer
+	f.Values["idempotency_key"] = uuid.NewString()
+	return h.renderRegister(c, http.StatusOK, f)
+}
+
+func (h *Handler) register(c echo.Context) error {
+	f := newForm(c, "email", "idempotency_key")
+	password := c.FormValue("password")
+	if password != c.FormValue("confirm") {
+		f.Errors["confirm"] = "does not match the password"
+		return h.renderRegister(c, http.StatusUnprocessableEntity, f)
+	}
+
+	err := h.client.Register(requestContext(c), f.Value("email"), password, f.Value("idempotency_key"))
+	if err != nil {
+		setError(&f, err)
+		return h.renderRegister(c, statusOf(err), f)
+	}
+	return redirect(c, "/check-email?email="+url.QueryEscape(f.Value("email")))
+}
+
+func (h *Handler) renderRegister(c echo.Context, status int, f view.Form) error {
+	return render(c, status, view.RegisterPage(sessionFrom(c).Session, f), view.RegisterForm(f))
+}
+
+func (h *Handler) checkEmail(c echo.Context) error {
+	return render(c, http.StatusOK, view.CheckEmailPage(sessionFrom(c).Session, c.QueryParam("email")), nil)
+}
+
+func (h *Handler) verify(c echo.Context) error {
+	var msg string
+	status := http.StatusOK
+	if err := h.client.Verify(requestContext(c), c.QueryParam("id")); err != nil {
+		msg, status = message(err), statusOf(err)
+	}
+	return render(c, status, view.VerifyResultPage(sessionFrom(c).Session, msg), nil)
+}
+
+func (h *Handler) loginPage(c echo.Context) error {
+	return h.renderLogin(c, http.StatusOK, newForm(c))
+}
+
+func (h *Handler) login(c echo.Context) error {
+	f := newForm(c, "email")
+	token, err := h.client.Login(requestContext(c), f.Value("email"), c.FormValue("password"), deviceOf(c, ""))
+	if err != nil {
+		setError(&f, err)
+		return h.renderLogin(c, statusOf(err), f)
+	}
+	h.sessions.Set(c, token)
+	return redirect(c, "/account")
+}
+
+func (h *Handler) renderLogin(c echo.Context, status int, f view.Form) error {
+	return render(c, status, view.LoginPage(sessionFrom(c).Session, f), view.LoginForm(f))
+}
+
+func (h *Handler) logout(c echo.Context) error {
+	if err := h.endSession(c); err != nil {
+		return err
+	}
+	return redirect(c, "/login")
+}
+
+func (h *Handler) magicLinkPage(c echo.Context) error {
+	return render(c, http.StatusOK, view.MagicLinkPage(sessionFrom(c).Session, newForm(c), false), nil)
+}
+
+func (h *Handler) magicLink(c echo.Context) error {
+	f := newForm(c, "email")
+	err := h.client.RequestMagicLink(requestContext(c), f.Value("email"))
+	if err != nil {
+		setError(&f, err)
+	}
+	return render(
+		c,
+		statusOf(err),
+		view.MagicLinkPage(sessionFrom(c).Session, f, err == nil),
+		view.MagicLinkForm(f, err == nil),
+	)
+}
+
+// magicLoginPage only shows the link's form, opening the link must not use
+// it up.
+func (h *Handler) magicLoginPage(c echo.Context) error {
+	f := newForm(c)
+	f.Values["token"] = c.QueryParam("token")
+	return h.renderMagicLogin(c, http.StatusOK, f)
+}
+
+func (h *Handler) magicLogin(c echo.Context) error {
+	f := newForm(c, "token")
+	token, err := h.client.ConsumeMagicLink(requestContext(c), f.Value("token"), deviceOf(c, ""))
+	if err != nil {
+		setError(&f, err)
+		return h.renderMagicLogin(c, statusOf(err), f)
+	}
+	h.sessions.Set(c, token)
+	return redirect(c, "/account")
+}
+
+func (h *Handler) renderMagicLogin(c echo.Context, status int, f view.Form) error {
+	return render(c, status, view.MagicLoginPage(sessionFrom(c).Session, f), view.MagicLoginForm(f))
+}
+
+func (h *Handler) forgotPage(c echo.Context) error {
+	f := newForm(c)
+	f.Values["email"] = sessionFrom(c).Email
+	return render(c, http.StatusOK, view.ForgotPasswordPage(sessionFrom(c).Session, f, false), nil)
+}
+
+func (h *Handler) forgot(c echo.Context) error {
+	f := newForm(c, "email")
+	err := h.client.ForgotPassword(requestContext(c), f.Value("email"))
+	if err != nil {
+		setError(&f, err)
+	}
+	return render(
+		c,
+		statusOf(err),
+		view.ForgotPasswordPage(sessionFrom(c).Session, f, err == nil),
+		view.ForgotPasswordForm(f, err == nil),
+	)
+}
+
+func (h *Handler) resetPage(c echo.Context) error {
+	f := newForm(c)
+	f.Values["token"] = c.QueryParam("token")
+	return h.renderReset(c, http.StatusOK, f, false)
+}
+
+func (h *Handler) reset(c echo.Context) error {
+	f := newForm(c, "token")
+	password := c.FormValue("password")
+	if password != c.FormValue("confirm") {
+		f.Errors["confirm"] = "does not match the password"
+		return h.renderReset(c, http.StatusUnprocessableEntity, f, false)
+	}
+
+	err := h.client.ResetPassword(requestContext(c), f.Value("token"), password)
+	if err != nil {
+		setError(&f, err)
+		return h.renderReset(c, statusOf(err), f, false)
+	}
+	// tokens issued before the reset stay valid until they expire, signing
+	// out here at least ends the session of this browser
+	h.sessions.Clear(c)
+	return h.renderReset(c, http.StatusOK, f, true)
+}
+
+func (h *Handler) renderReset(c echo.Context, status int, f view.Form, done bool) error {
+	return render(
+		c,
+		status,
+		view.ResetPasswordPage(sessionFrom(c).Session, f, done),
+		view.ResetPasswordForm(f, done),
+	)
+}
+
+func (h *Handler) account(c echo.Context) error {
+	s := sessionFrom(c)
+	if !s.SignedIn() {
+		return redirect(c, "/login")
+	}
+	return render(c, http.StatusOK, view.AccountPage(s.Session, s.Expires), nil)
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-gateaway/view"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// render writes page, or fragment for htmx requests when there is one.
+// htmx does not swap error responses, fragments are always sent as 200.
+func render(c echo.Context, status int, page, fragment templ.Component) error {
+	component := page
+	if fragment != nil && isHTMX(c) {
+		component, status = fragment, http.StatusOK
+	}
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return component.Render(c.Request().Context(), c.Response())
+}
+
+// redirect sends the browser to path after a form post. htmx follows
+// HX-Redirect with a full page load instead of swapping the response in.
+func redirect(c echo.Context, path string) error {
+	if isHTMX(c) {
+		c.Response().Header().Set("HX-Redirect", path)
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Redirect(http.StatusSeeOther, path)
+}
+
+func isHTMX(c echo.Context) bool {
+	return c.Request().Header.Get("HX-Request") == "true"
+}
+
+// requestContext carries the request id to the upstream calls.
+func requestContext(c echo.Context) context.Context {
+	return problem.WithRequestID(c.Request().Context(), c.Request().Header.Get(problem.RequestIDHeader))
+}
+
+// deviceOf is the device of the user behind c, called name.
+func deviceOf(c echo.Context, name string) Device {
+	return Device{Name: name, UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+}
+
+// newForm returns a form echoing the submitted values of fields.
+func newForm(c echo.Context, fields ...string) view.Form {
+	f := view.Form{
+		CSRF:   session.CSRFToken(c),
+		Values: make(map[string]string, len(fields)),
+		Errors: make(map[string]string),
+	}
+	for _, field := range fields {
+		f.Values[field] = c.FormValue(field)
+	}
+	return f
+}
+
+// setError shows err on the form, per field when the upstream rejected
+// single fields.
+func setError(f *view.Form, err error) {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		if fields, ok := p.Details["fields"].(map[string]any); ok {
+			for field, msg := range fields {
+				if s, ok := msg.(string); ok {
+					f.Errors[field] = s
+				}
+			}
+			return
+		}
+	}
+	f.Error = message(err)
+}
+
+// message is what users are told about err. Server side failures are logged
+// and not described.
+func message(err error) string {
+	var p *problem.Problem
+	if errors.As(err, &p) && p.Status < http.StatusInternalServerError {
+		return p.Detail
+	}
+	log.Println("gateway: web:", err)
+	return "Something went wrong on our side, please try again later."
+}
+
+func statusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		return p.Status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/F1zm0n/universal-gateaway/internal/session"
+	"github.com/F1zm0n/universal-gateaway/internal/transport"
+	"github.com/F1zm0n/universal-gateaway/view"
+)
+
+const sessionKey = "web.session"
+
+type pageSession struct {
+	view.Session
+	Expires time.Time
+}
+
+// loadSession reads the session cookie into the context. Invalid or expired
+// tokens are treated as signed out.
+func loadSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		s := pageSession{Session: view.Session{CSRF: session.CSRFToken(c)}}
+		if cookie, err := c.Cookie(session.Cookie); err == nil {
+			if claims, err := transport.ParseToken(cookie.Value); err == nil {
+				s.Email, _ = claims["email"].(string)
+				if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+					s.Expires = exp.Time
+				}
+			}
+		}
+		c.Set(sessionKey, s)
+		return next(c)
+	}
+}
+
+func sessionFrom(c echo.Context) pageSession {
+	s, _ := c.Get(sessionKey).(pageSession)
+	return s
+}
@@ -1,24 +1,137 @@
 package main
 
 import (
-	"log"
+	"os"
 
+	"github.com/go-kit/log"
 	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
 
+	"github.com/F1zm0n/universal-gateaway/internal/proxy"
+	"github.com/F1zm0n/universal-gateaway/internal/ratelimit"
+	"github.com/F1zm0n/universal-gateaway/internal/session"
 	"github.com/F1zm0n/universal-gateaway/internal/transport"
+	"github.com/F1zm0n/universal-gateaway/internal/web"
+	"github.com/F1zm0n/universal-kit/introspect"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/signedurl"
 )
 
+var configPaths = []string{"./config", "/app/config/"}
+
 func main() {
+	parseConfig(configPaths)
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	cfg, err := proxy.LoadConfig()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+
+	pools := make(map[string]*proxy.Pool, len(cfg.Upstreams))
+	for name, u := range cfg.Upstreams {
+		pool, err := proxy.NewPool(u, log.With(logger, "upstream", name))
+		if err != nil {
+			logger.Log("upstream", name, "err", err)
+			os.Exit(1)
+		}
+		defer pool.Close()
+		pools[name] = pool
+	}
+
+	limits, err := ratelimit.LoadConfig()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+	store, err := ratelimit.NewStore(limits)
+	if err != nil {
+		logger.Log("during", "ratelimit", "err", err)
+		os.Exit(1)
+	}
+
+	var (
+		e        = echo.New()
+		rt       = proxy.NewTransport(cfg)
+		sessions = session.NewManager(viper.GetBool("session.cookie.secure"))
+		// must match chat's files.secret, chat signs the file urls
+		signer = signedurl.New(viper.GetString("files.secret"))
+	)
+	// introspection needs service tokens, nothing else the gateway calls
+	var tokens *oauth.TokenSource
+	if viper.GetBool("introspection.enabled") {
+		if tokens, err = introspect.NewTokenSource(); err != nil {
+			logger.Log("during", "config", "err", err)
+			os.Exit(1)
+		}
+	}
 	var (
-		e      = echo.New()
-		auth   = e.Group("/a")
-		unauth = e.Group("/u")
+		client  = web.NewClient(pools["producer"], pools["auth"], rt, tokens)
+		apiKeys = transport.NewAPIKeys(client, viper.GetInt("apikeys.cachesize"))
 	)
+	// tokens are checked with auth.secret, or by introspection with auth
+	authenticate := transport.JWTAuthentication
+	if viper.GetBool("introspection.enabled") {
+		authenticate = transport.Introspection(introspect.NewCache(
+			client,
+			viper.GetDuration("introspection.cachettl"),
+			viper.GetInt("introspection.cachesize"),
+		))
+	}
+	// only trust X-Forwarded-For from proxies on private networks, clients
+	// could dodge per IP limits otherwise
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	e.HTTPErrorHandler = transport.ErrorHandler
+	e.Use(transport.RequestID)
+	e.Use(ratelimit.Middleware(store, "global", limits.Global))
+	for _, route := range cfg.Routes {
+		var mw []echo.MiddlewareFunc
+		if route.Auth {
+			mw = append(mw,
+				apiKeys.Middleware,
+				sessions.CookieCSRF(),
+				authenticate,
+				transport.RequireScope(route.Scope),
+			)
+		}
+		if route.Signed {
+			mw = append(mw, transport.SignedURL(signer))
+		}
+		if route.MaxBody > 0 {
+			mw = append(mw, transport.BodyLimit(route.MaxBody))
+		}
+		mw = append(mw, ratelimit.Middleware(store, "route:"+route.Name, limits.Routes[route.Name]))
+		h := route.Handler(proxy.New(pools[route.Upstream], rt))
+		if route.Method == proxy.AnyMethod {
+			e.Any(route.Path, h, mw...)
+		} else {
+			e.Add(route.Method, route.Path, h, mw...)
+		}
+	}
 
-	auth.Use(transport.JWTAuthentication)
+	pages := web.NewHandler(client, sessions)
+	pages.Register(e, func(route string) echo.MiddlewareFunc {
+		return ratelimit.Middleware(store, "route:"+route, limits.Routes[route])
+	})
+	pages.RegisterAPI(e.Group("/u/session", ratelimit.Middleware(store, "route:session", limits.Routes["session"])))
+
+	logger.Log("err", e.Start(":"+viper.GetString("listen.http.port")))
+}
 
-	unauth.POST("/register", transport.HandleRegister)
-	unauth.GET("/verify", transport.HandleVerify)
-	unauth.GET("/login", transport.HandleLogin)
-	log.Fatal(e.Start(":3000"))
+func parseConfig(paths []string) {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	for _, path := range paths {
+		viper.AddConfigPath(path)
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		panic(err)
+	}
 }
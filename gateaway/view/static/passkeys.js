@@ -0,0 +1,123 @@
+// Passkey ceremonies of the pages. Buttons with data-passkey="login" log in
+// through the session API, data-passkey="register" adds a passkey to the
+// signed in account. Both report failures in the element named by
+// data-passkey-error.
+(function () {
+	function decode(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		return Uint8Array.from(atob(s), (c) => c.charCodeAt(0)).buffer;
+	}
+
+	function encode(buf) {
+		let s = "";
+		for (const b of new Uint8Array(buf)) {
+			s += String.fromCharCode(b);
+		}
+		return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	function csrf() {
+		const m = document.cookie.match(/(?:^|; )_csrf=([^;]*)/);
+		return m ? decodeURIComponent(m[1]) : "";
+	}
+
+	async function post(path, body) {
+		const resp = await fetch(path, {
+			method: "POST",
+			headers: { "Content-Type": "application/json", "X-CSRF-Token": csrf() },
+			body: JSON.stringify(body),
+		});
+		const data = await resp.json().catch(() => ({}));
+		if (!resp.ok) {
+			throw new Error(data.detail || "Something went wrong, please try again.");
+		}
+		return data;
+	}
+
+	// credential serializes the browser's answer as the auth service parses
+	// it, like PublicKeyCredential.toJSON where there is none.
+	function credential(cred) {
+		const r = cred.response;
+		const response = { clientDataJSON: encode(r.clientDataJSON) };
+		if (r.attestationObject) {
+			response.attestationObject = encode(r.attestationObject);
+			response.transports = r.getTransports ? r.getTransports() : [];
+		} else {
+			response.authenticatorData = encode(r.authenticatorData);
+			response.signature = encode(r.signature);
+			if (r.userHandle) {
+				response.userHandle = encode(r.userHandle);
+			}
+		}
+		return {
+			id: cred.id,
+			rawId: encode(cred.rawId),
+			type: cred.type,
+			response: response,
+			clientExtensionResults: cred.getClientExtensionResults(),
+		};
+	}
+
+	async function login() {
+		const challenge = await post("/u/session/passkey/begin", {});
+		const options = challenge.options.publicKey;
+		options.challenge = decode(options.challenge);
+		for (const c of options.allowCredentials || []) {
+			c.id = decode(c.id);
+		}
+		const cred = await navigator.credentials.get({ publicKey: options });
+		await post("/u/session/passkey/finish", {
+			challenge_id: challenge.challenge_id,
+			credential: credential(cred),
+		});
+		window.location.assign("/account");
+	}
+
+	async function register(button) {
+		const challenge = await post("/a/passkeys/register/begin", {});
+		const options = challenge.options.publicKey;
+		options.challenge = decode(options.challenge);
+		options.user.id = decode(options.user.id);
+		for (const c of options.excludeCredentials || []) {
+			c.id = decode(c.id);
+		}
+		const cred = await navigator.credentials.create({ publicKey: options });
+		await post("/a/passkeys/register/finish", {
+			challenge_id: challenge.challenge_id,
+			name: button.dataset.passkeyName || "passkey",
+			credential: credential(cred),
+		});
+		button.textContent = "Passkey added";
+		button.disabled = true;
+	}
+
+	document.addEventListener("click", async (e) => {
+		const button = e.target.closest("[data-passkey]");
+		if (!button) {
+			return;
+		}
+		const error = document.getElementById(button.dataset.passkeyError);
+		if (error) {
+			error.hidden = true;
+		}
+		if (!window.PublicKeyCredential) {
+			if (error) {
+				error.textContent = "This browser does not support passkeys.";
+				error.hidden = false;
+			}
+			return;
+		}
+		try {
+			if (button.dataset.passkey === "login") {
+				await login();
+			} else {
+				await register(button);
+			}
+		} catch (err) {
+			if (error) {
+				error.textContent = err.name === "NotAllowedError" ? "The passkey request was cancelled." : err.message;
+				error.hidden = false;
+			}
+		}
+	});
+})();
@@ -0,0 +1,39 @@
+// Package view holds the gateway's server rendered pages. The *_templ.go
+// files are generated from the .templ files with `templ generate ./view`.
+package view
+
+import "embed"
+
+// Static is served under /static.
+//
+//go:embed static
+var Static embed.FS
+
+// Session describes the signed in user, the zero value means signed out.
+type Session struct {
+	Email string
+	// CSRF is the token for forms outside the page's own, such as log out
+	// in the navbar.
+	CSRF string
+}
+
+func (s Session) SignedIn() bool {
+	return s.Email != ""
+}
+
+// Form carries submitted values and what is wrong with them back to a form.
+type Form struct {
+	CSRF   string
+	Values map[string]string
+	// Errors maps fields to their error, Error is for the form as a whole.
+	Errors map[string]string
+	Error  string
+}
+
+func (f Form) Value(field string) string {
+	return f.Values[field]
+}
+
+func (f Form) FieldError(field string) string {
+	return f.Errors[field]
+}
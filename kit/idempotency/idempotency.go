@@ -0,0 +1,124 @@
+// Package idempotency lets clients retry go-kit endpoints safely. A request
+// carrying an Idempotency-Key runs once; duplicates receive the stored
+// response of the first successful call, and duplicates arriving while the
+// first call is still running are rejected.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+const (
+	// Header is the HTTP header, and lowercased the gRPC metadata key,
+	// carrying the idempotency key.
+	Header = "Idempotency-Key"
+
+	// inFlightTTL bounds how long a crashed request can block its key.
+	inFlightTTL = time.Minute
+)
+
+var (
+	ErrInFlight    = errors.New("a request with this idempotency key is in progress")
+	ErrKeyMismatch = errors.New("idempotency key was used with a different request")
+)
+
+// Record is a completed request stored under its key.
+type Record struct {
+	RequestHash []byte
+	Response    []byte
+}
+
+// Fingerprinter is implemented by requests carrying secrets, like
+// passwords, that must not end up hashed in the store: the fingerprint is
+// hashed instead of the request, and should hold its other fields.
+type Fingerprinter interface {
+	IdempotencyFingerprint() interface{}
+}
+
+type Store interface {
+	// Begin claims key for a request with the given hash. It returns nil if
+	// the caller now owns the key, the stored Record if the key already
+	// completed, or ErrInFlight if another request holds it.
+	Begin(ctx context.Context, key string, requestHash []byte) (*Record, error)
+	// Complete stores the response of the request owning key.
+	Complete(ctx context.Context, key string, response []byte) error
+	// Release frees key after a failed request so that it can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(contextKey{}).(string)
+	return key, ok && key != ""
+}
+
+// Middleware makes an endpoint idempotent for requests whose context carries
+// a key. Only successful responses are stored; failures release the key.
+// name scopes keys so that different endpoints never share a record, and
+// Resp is the endpoint's response type used to decode replays.
+func Middleware[Resp any](store Store, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key, ok := FromContext(ctx)
+			if !ok {
+				return next(ctx, request)
+			}
+			key = name + ":" + key
+
+			hash, err := hashRequest(request)
+			if err != nil {
+				return nil, err
+			}
+			rec, err := store.Begin(ctx, key, hash)
+			if err != nil {
+				return nil, err
+			}
+			if rec != nil {
+				if string(rec.RequestHash) != string(hash) {
+					return nil, ErrKeyMismatch
+				}
+				var resp Resp
+				if err := json.Unmarshal(rec.Response, &resp); err != nil {
+					return nil, err
+				}
+				return resp, nil
+			}
+
+			response, err := next(ctx, request)
+			if f, ok := response.(endpoint.Failer); err != nil || (ok && f.Failed() != nil) {
+				store.Release(context.WithoutCancel(ctx), key)
+				return response, err
+			}
+			b, err := json.Marshal(response)
+			if err != nil {
+				store.Release(context.WithoutCancel(ctx), key)
+				return response, nil
+			}
+			store.Complete(context.WithoutCancel(ctx), key, b)
+			return response, nil
+		}
+	}
+}
+
+func hashRequest(request interface{}) ([]byte, error) {
+	if f, ok := request.(Fingerprinter); ok {
+		request = f.IdempotencyFingerprint()
+	}
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
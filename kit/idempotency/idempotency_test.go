@@ -0,0 +1,232 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type request struct {
+	Email string `json:"email"`
+}
+
+type response struct {
+	ID  int   `json:"id"`
+	Err error `json:"-"`
+}
+
+func (r response) Failed() error { return r.Err }
+
+// counter is an endpoint numbering its calls, so that replays are told
+// apart from new calls.
+type counter struct {
+	calls int
+	err   error
+	fail  error
+}
+
+func (c *counter) endpoint(_ context.Context, _ interface{}) (interface{}, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return response{ID: c.calls, Err: c.fail}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name string
+		// key is the idempotency key of both calls, none if empty
+		key    string
+		first  request
+		second request
+		// err and fail are returned by the first call only
+		err  error
+		fail error
+
+		wantCalls int
+		wantID    int
+		wantErr   error
+	}{
+		{
+			name:      "replay",
+			key:       "k",
+			first:     request{Email: "a@example.com"},
+			second:    request{Email: "a@example.com"},
+			wantCalls: 1,
+			wantID:    1,
+		},
+		{
+			name:      "no key",
+			first:     request{Email: "a@example.com"},
+			second:    request{Email: "a@example.com"},
+			wantCalls: 2,
+			wantID:    2,
+		},
+		{
+			name:      "different request",
+			key:       "k",
+			first:     request{Email: "a@example.com"},
+			second:    request{Email: "b@example.com"},
+			wantCalls: 1,
+			wantErr:   ErrKeyMismatch,
+		},
+		{
+			name:      "error releases key",
+			key:       "k",
+			first:     request{Email: "a@example.com"},
+			second:    request{Email: "a@example.com"},
+			err:       errBoom,
+			wantCalls: 2,
+			wantID:    2,
+		},
+		{
+			name:      "failed response releases key",
+			key:       "k",
+			first:     request{Email: "a@example.com"},
+			second:    request{Email: "a@example.com"},
+			fail:      errBoom,
+			wantCalls: 2,
+			wantID:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &counter{err: tt.err, fail: tt.fail}
+			ep := Middleware[response](NewMemoryStore(time.Hour), "register")(c.endpoint)
+			ctx := context.Background()
+			if tt.key != "" {
+				ctx = NewContext(ctx, tt.key)
+			}
+
+			ep(ctx, tt.first)
+			c.err, c.fail = nil, nil
+			resp, err := ep(ctx, tt.second)
+
+			if c.calls != tt.wantCalls {
+				t.Errorf("endpoint called %d times, want %d", c.calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("second call err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got := resp.(response).ID; got != tt.wantID {
+				t.Errorf("second call answered %d, want %d", got, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMiddlewareInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := func(context.Context, interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return response{ID: 1}, nil
+	}
+	ep := Middleware[response](NewMemoryStore(time.Hour), "register")(slow)
+	ctx := NewContext(context.Background(), "k")
+	req := request{Email: "a@example.com"}
+
+	done := make(chan error)
+	go func() {
+		_, err := ep(ctx, req)
+		done <- err
+	}()
+	<-started
+
+	// the retry arrives while the first call still runs
+	if _, err := ep(ctx, req); !errors.Is(err, ErrInFlight) {
+		t.Errorf("concurrent duplicate err = %v, want %v", err, ErrInFlight)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	resp, err := ep(ctx, req)
+	if err != nil {
+		t.Fatalf("retry after completion: %v", err)
+	}
+	if resp.(response).ID != 1 {
+		t.Errorf("retry after completion answered %d, want the stored 1", resp.(response).ID)
+	}
+}
+
+func TestMiddlewareScopesKeys(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	c := &counter{}
+	register := Middleware[response](store, "register")(c.endpoint)
+	mail := Middleware[response](store, "mail")(c.endpoint)
+	ctx := NewContext(context.Background(), "k")
+	req := request{Email: "a@example.com"}
+
+	register(ctx, req)
+	resp, err := mail(ctx, req)
+	if err != nil {
+		t.Fatalf("mail: %v", err)
+	}
+	if c.calls != 2 || resp.(response).ID != 2 {
+		t.Errorf("the same key on another endpoint was replayed, calls = %d", c.calls)
+	}
+}
+
+type signUp struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r signUp) IdempotencyFingerprint() interface{} {
+	return request{Email: r.Email}
+}
+
+// The password of a request never reaches the store, not even hashed.
+func TestMiddlewareFingerprint(t *testing.T) {
+	store := NewMemoryStore(time.Hour).(*MemoryStore)
+	c := &counter{}
+	mw := Middleware[response](store, "register")(c.endpoint)
+	ctx := NewContext(context.Background(), "k")
+
+	if _, err := mw(ctx, signUp{Email: "a@example.com", Password: "hunter2"}); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	want, _ := hashRequest(request{Email: "a@example.com"})
+	if got := store.entries["register:k"].record.RequestHash; string(got) != string(want) {
+		t.Errorf("stored hash %x, want the hash of the fingerprint %x", got, want)
+	}
+	if _, err := mw(ctx, signUp{Email: "b@example.com", Password: "hunter2"}); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("other fingerprint = %v, want %v", err, ErrKeyMismatch)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore(time.Millisecond).(*MemoryStore)
+	ctx := context.Background()
+	if _, err := store.Begin(ctx, "k", []byte("h")); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	store.Complete(ctx, "k", []byte(`{"id":1}`))
+	time.Sleep(5 * time.Millisecond)
+	rec, err := store.Begin(ctx, "k", []byte("h"))
+	if err != nil || rec != nil {
+		t.Errorf("Begin after expiry = %v, %v, want the key claimed again", rec, err)
+	}
+}
+
+func TestHTTPToContext(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "/register", nil)
+	r.Header.Set(Header, "k")
+	key, ok := FromContext(HTTPToContext()(context.Background(), r))
+	if !ok || key != "k" {
+		t.Errorf("FromContext = %q, %v, want k", key, ok)
+	}
+
+	r.Header.Del(Header)
+	if _, ok := FromContext(HTTPToContext()(context.Background(), r)); ok {
+		t.Error("a request without the header carries a key")
+	}
+}
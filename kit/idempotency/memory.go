@@ -0,0 +1,81 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	record    Record
+	done      bool
+	expiresAt time.Time
+}
+
+// MemoryStore keeps records in process. It suits single-replica services
+// and tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+}
+
+// NewMemoryStore returns a Store keeping completed responses for ttl.
+func NewMemoryStore(ttl time.Duration) Store {
+	return &MemoryStore{
+		ttl:     ttl,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (s *MemoryStore) Begin(_ context.Context, key string, requestHash []byte) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evict(now)
+	e, ok := s.entries[key]
+	if !ok {
+		s.entries[key] = memoryEntry{
+			record:    Record{RequestHash: requestHash},
+			expiresAt: now.Add(inFlightTTL),
+		}
+		return nil, nil
+	}
+	if !e.done {
+		return nil, ErrInFlight
+	}
+	rec := e.record
+	return &rec, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, response []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entries[key]
+	e.record.Response = response
+	e.done = true
+	e.expiresAt = time.Now().Add(s.ttl)
+	s.entries[key] = e
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && !e.done {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// evict drops expired entries; callers hold the lock.
+func (s *MemoryStore) evict(now time.Time) {
+	for key, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore shares records between replicas through the
+// idempotency_keys table, which the service's migrations must create.
+type PostgresStore struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewPostgresStore returns a Store keeping completed responses for ttl.
+func NewPostgresStore(db *sql.DB, ttl time.Duration) Store {
+	return &PostgresStore{
+		db:  db,
+		ttl: ttl,
+	}
+}
+
+func (s PostgresStore) Begin(ctx context.Context, key string, requestHash []byte) (*Record, error) {
+	_, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM idempotency_keys WHERE key=$1 AND expires_at < now()",
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO idempotency_keys (key,request_hash,expires_at) VALUES ($1,$2,$3)
+		ON CONFLICT DO NOTHING`,
+		key,
+		requestHash,
+		time.Now().Add(inFlightTTL),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if rows == 1 {
+		return nil, nil
+	}
+
+	var rec Record
+	err = s.db.QueryRowContext(
+		ctx,
+		"SELECT request_hash,response FROM idempotency_keys WHERE key=$1",
+		key,
+	).Scan(&rec.RequestHash, &rec.Response)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The holder released the key between our insert and select.
+		return nil, ErrInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.Response == nil {
+		return nil, ErrInFlight
+	}
+	return &rec, nil
+}
+
+func (s PostgresStore) Complete(ctx context.Context, key string, response []byte) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE idempotency_keys SET response=$2,expires_at=$3 WHERE key=$1",
+		key,
+		response,
+		time.Now().Add(s.ttl),
+	)
+	return err
+}
+
+func (s PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM idempotency_keys WHERE key=$1 AND response IS NULL",
+		key,
+	)
+	return err
+}
@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+)
+
+// HTTPToContext moves the Idempotency-Key header into the context.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if key := r.Header.Get(Header); key != "" {
+			return NewContext(ctx, key)
+		}
+		return ctx
+	}
+}
+
+// ContextToHTTP sets the Idempotency-Key header from the context.
+func ContextToHTTP() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if key, ok := FromContext(ctx); ok {
+			r.Header.Set(Header, key)
+		}
+		return ctx
+	}
+}
+
+// GRPCToContext moves the idempotency-key metadata into the context.
+func GRPCToContext() grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if keys := md.Get(strings.ToLower(Header)); len(keys) > 0 && keys[0] != "" {
+			return NewContext(ctx, keys[0])
+		}
+		return ctx
+	}
+}
+
+// ContextToGRPC sets the idempotency-key metadata from the context.
+func ContextToGRPC() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if key, ok := FromContext(ctx); ok {
+			md.Set(strings.ToLower(Header), key)
+		}
+		return ctx
+	}
+}
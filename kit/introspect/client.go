@@ -0,0 +1,78 @@
+package introspect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+)
+
+// NewTokenSource returns the token source for NewClient, with the
+// credentials of the oauth key.
+func NewTokenSource() (*oauth.TokenSource, error) {
+	return oauth.LoadTokenSource(Audience, Scope)
+}
+
+type client struct {
+	introspect endpoint.Endpoint
+}
+
+// NewClient introspects with POST /introspect of auth at instance,
+// attaching the tokens of src.
+func NewClient(instance string, src *oauth.TokenSource) (Introspector, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/introspect"
+	introspect := httptransport.NewClient(
+		http.MethodPost,
+		u,
+		encodeHTTPIntrospectRequest,
+		decodeHTTPIntrospectResponse,
+		httptransport.ClientBefore(
+			problem.ContextToHTTP(),
+			oauth.ContextToHTTP(),
+		),
+	).Endpoint()
+	return client{introspect: oauth.ClientMiddleware(src)(introspect)}, nil
+}
+
+func (c client) Introspect(ctx context.Context, token string) (Introspection, error) {
+	resp, err := c.introspect(ctx, token)
+	if err != nil {
+		return Introspection{}, err
+	}
+	return resp.(Introspection), nil
+}
+
+func encodeHTTPIntrospectRequest(_ context.Context, r *http.Request, request interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]string{"token": request.(string)}); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Body = io.NopCloser(&buf)
+	return nil
+}
+
+func decodeHTTPIntrospectResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var in Introspection
+	err := json.NewDecoder(r.Body).Decode(&in)
+	return in, err
+}
@@ -0,0 +1,109 @@
+// Package introspect checks tokens with the auth service's Introspect,
+// after RFC 7662, for services that should not hold auth.secret. Cache
+// keeps the answers for a short ttl, so that not every request calls auth.
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	// Audience and Scope are what the service tokens of callers must be
+	// issued for, see auth's oauth.clients.
+	Audience = "auth"
+	Scope    = "introspect"
+)
+
+// Introspection is the auth service's description of a token.
+type Introspection struct {
+	Active bool `json:"active"`
+	// Subject is the user id of user tokens and the client id of service
+	// tokens.
+	Subject string `json:"sub"`
+	// Username is the email of users.
+	Username string `json:"username"`
+	ClientID string `json:"client_id"`
+	// KeyID is set for the tokens of API keys, which are limited to Scope.
+	KeyID     string `json:"key_id"`
+	Scope     string `json:"scope"`
+	ExpiresAt int64  `json:"exp"`
+	// Revoked tells inactive tokens of revoked API keys and sessions from
+	// the others.
+	Revoked bool `json:"revoked"`
+}
+
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (Introspection, error)
+}
+
+// Cache keeps introspections for a short ttl, at the price of revocations
+// taking up to ttl to be seen. Failed calls are not cached. It is safe for
+// concurrent use.
+type Cache struct {
+	next Introspector
+	ttl  time.Duration
+	max  int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]entry
+}
+
+type entry struct {
+	in    Introspection
+	until time.Time
+}
+
+// NewCache caches the introspections of next for ttl, of at most max
+// tokens. Tokens are kept by their hash.
+func NewCache(next Introspector, ttl time.Duration, max int) *Cache {
+	if max <= 0 {
+		max = 10000
+	}
+	return &Cache{
+		next:    next,
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[[sha256.Size]byte]entry),
+	}
+}
+
+// Introspect returns the cached introspection of token, or asks next.
+// Active tokens are kept no longer than they are valid.
+func (c *Cache) Introspect(ctx context.Context, token string) (Introspection, error) {
+	sum := sha256.Sum256([]byte(token))
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.entries[sum]
+	c.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		return cached.in, nil
+	}
+
+	in, err := c.next.Introspect(ctx, token)
+	if err != nil {
+		return Introspection{}, err
+	}
+	until := now.Add(c.ttl)
+	if exp := time.Unix(in.ExpiresAt, 0); in.Active && exp.Before(until) {
+		until = exp
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.max {
+		for s, e := range c.entries {
+			if !now.Before(e.until) {
+				delete(c.entries, s)
+			}
+		}
+		// still full of live entries, they are cheap to ask for again
+		if len(c.entries) >= c.max {
+			clear(c.entries)
+		}
+	}
+	c.entries[sum] = entry{in: in, until: until}
+	return in, nil
+}
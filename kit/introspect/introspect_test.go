@@ -0,0 +1,146 @@
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/spf13/viper"
+
+	"github.com/F1zm0n/universal-kit/oauth"
+)
+
+// auth answers introspections from tokens, counting the calls.
+type auth struct {
+	tokens map[string]Introspection
+	err    error
+	calls  int
+}
+
+func (a *auth) Introspect(_ context.Context, token string) (Introspection, error) {
+	a.calls++
+	if a.err != nil {
+		return Introspection{}, a.err
+	}
+	return a.tokens[token], nil
+}
+
+func TestCache(t *testing.T) {
+	soon := time.Now().Add(time.Hour).Unix()
+	a := &auth{tokens: map[string]Introspection{
+		"alice": {Active: true, Username: "alice@example.com", ExpiresAt: soon},
+	}}
+	c := NewCache(a, time.Minute, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		in, err := c.Introspect(ctx, "alice")
+		if err != nil || in.Username != "alice@example.com" {
+			t.Fatalf("Introspect = %+v, %v", in, err)
+		}
+	}
+	if a.calls != 1 {
+		t.Errorf("%d calls to auth, want 1", a.calls)
+	}
+
+	// inactive answers are cached too, failures are not
+	c.Introspect(ctx, "revoked")
+	c.Introspect(ctx, "revoked")
+	if a.calls != 2 {
+		t.Errorf("%d calls to auth after an inactive token, want 2", a.calls)
+	}
+	a.err = errors.New("auth is down")
+	for i := 0; i < 2; i++ {
+		if _, err := c.Introspect(ctx, "bob"); !errors.Is(err, a.err) {
+			t.Fatalf("Introspect = %v, want %v", err, a.err)
+		}
+	}
+	if a.calls != 4 {
+		t.Errorf("%d calls to auth after failures, want 4", a.calls)
+	}
+}
+
+// Active tokens are not cached past their expiry.
+func TestCacheExpiry(t *testing.T) {
+	a := &auth{tokens: map[string]Introspection{
+		"expiring": {Active: true, Username: "alice@example.com", ExpiresAt: time.Now().Unix()},
+	}}
+	c := NewCache(a, time.Hour, 0)
+	c.Introspect(context.Background(), "expiring")
+	c.Introspect(context.Background(), "expiring")
+	if a.calls != 2 {
+		t.Errorf("%d calls to auth, want the expired token asked for again", a.calls)
+	}
+}
+
+func TestCacheBounded(t *testing.T) {
+	a := &auth{tokens: map[string]Introspection{}}
+	c := NewCache(a, time.Hour, 2)
+	for _, token := range []string{"a", "b", "c"} {
+		c.Introspect(context.Background(), token)
+	}
+	if n := len(c.entries); n > 2 {
+		t.Errorf("%d entries cached, want at most 2", n)
+	}
+}
+
+func TestClient(t *testing.T) {
+	viper.Set("auth.secret", "test-secret")
+	t.Cleanup(viper.Reset)
+	tokens := oauth.NewHTTPHandler(oauth.NewServer(oauth.Config{
+		Issuer:   "auth",
+		Audience: "auth",
+		TTL:      time.Hour,
+		Clients:  []oauth.Client{{ID: "chat", Secret: "s3cret", Audiences: []string{Audience}, Scopes: []string{Scope}}},
+	}), log.NewNopLogger())
+	introspect := oauth.Middleware(Audience, Scope)(func(_ context.Context, request interface{}) (interface{}, error) {
+		if request.(string) != "alice" {
+			return Introspection{}, nil
+		}
+		return Introspection{Active: true, Username: "alice@example.com"}, nil
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/oauth/token", tokens)
+	mux.HandleFunc("POST /introspect", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		in, err := introspect(oauth.HTTPToContext()(r.Context(), r), req.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(in)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	src := oauth.NewTokenSource(srv.URL+"/oauth/token", "chat", "s3cret", Audience, Scope)
+	c, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), src)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	in, err := c.Introspect(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("Introspect: %v", err)
+	}
+	if !in.Active || in.Username != "alice@example.com" {
+		t.Errorf("Introspect = %+v", in)
+	}
+	if in, _ := c.Introspect(context.Background(), "forged"); in.Active {
+		t.Errorf("forged token is active: %+v", in)
+	}
+
+	other := oauth.NewTokenSource(srv.URL+"/oauth/token", "chat", "guess", Audience, Scope)
+	c, _ = NewClient(srv.URL, other)
+	if _, err := c.Introspect(context.Background(), "alice"); err == nil {
+		t.Error("Introspect without a service token succeeded")
+	}
+}
@@ -0,0 +1,306 @@
+// Package migrate applies the services' SQL migrations. Every set of
+// tables has one set of *.sql files, embedded once and recorded under one
+// name in the schema_migrations table shared by all services of a
+// database. Services sharing tables apply the same set.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownCommand = errors.New("usage: migrate up|down|status")
+	ErrNoMigrations   = errors.New("no applied migrations to roll back")
+)
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	service    string
+	migrations []Migration
+}
+
+// New loads the migrations in fsys, files named
+// <version>_<name>.(up|down).sql, and applies them as service.
+func New(db *sql.DB, service string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		service:    service,
+		migrations: migrations,
+	}, nil
+}
+
+// Run executes the migrate subcommand given its arguments, e.g. ["up"].
+func (m *Migrator) Run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return ErrUnknownCommand
+	}
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return ErrUnknownCommand
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if err := execScript(ctx, tx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(
+					ctx,
+					"INSERT INTO schema_migrations (service,version,name) VALUES ($1,$2,$3)",
+					m.service,
+					mig.Version,
+					mig.Name,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		var version int64
+		err := conn.QueryRowContext(
+			ctx,
+			"SELECT version FROM schema_migrations WHERE service=$1 ORDER BY version DESC LIMIT 1",
+			m.service,
+		).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoMigrations
+		}
+		if err != nil {
+			return err
+		}
+		mig, ok := m.find(version)
+		if !ok {
+			return fmt.Errorf("applied migration %d has no source file", version)
+		}
+		err = inTx(ctx, conn, func(tx *sql.Tx) error {
+			if err := execScript(ctx, tx, mig.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(
+				ctx,
+				"DELETE FROM schema_migrations WHERE service=$1 AND version=$2",
+				m.service,
+				version,
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return nil
+	})
+}
+
+// Status reports every known migration and when it was applied, if at all.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			s := Status{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// locked runs fn on a single connection holding the migration advisory
+// lock, so that replicas starting at the same time apply migrations once.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext('schema_migrations'))"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(
+		context.Background(),
+		"SELECT pg_advisory_unlock(hashtext('schema_migrations'))",
+	)
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		service VARCHAR(100) NOT NULL,
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (service, version)
+	);
+	`
+	if _, err := conn.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(
+		ctx,
+		"SELECT version,applied_at FROM schema_migrations WHERE service=$1",
+		m.service,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version int64
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func execScript(ctx context.Context, tx *sql.Tx, script string) error {
+	if strings.TrimSpace(script) == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, script)
+	return err
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		match := fileRe.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
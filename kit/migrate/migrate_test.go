@@ -0,0 +1,255 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int64
+		wantErr  bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"2_second.up.sql":   {Data: []byte("up 2")},
+				"2_second.down.sql": {Data: []byte("down 2")},
+				"10_third.up.sql":   {Data: []byte("up 10")},
+				"1_first.up.sql":    {Data: []byte("up 1")},
+			},
+			versions: []int64{1, 2, 10},
+		},
+		{
+			name: "other files ignored",
+			files: fstest.MapFS{
+				"1_first.up.sql": {Data: []byte("up 1")},
+				"README.md":      {Data: []byte("docs")},
+				"migrations.go":  {Data: []byte("package migrations")},
+				"first.up.sql":   {Data: []byte("no version")},
+			},
+			versions: []int64{1},
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"1_first.up.sql":   {Data: []byte("up 1")},
+				"1_other.down.sql": {Data: []byte("down 1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "empty",
+			files:    fstest.MapFS{},
+			versions: []int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := load(tt.files)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("load succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			versions := make([]int64, 0, len(migrations))
+			for _, mig := range migrations {
+				versions = append(versions, mig.Version)
+			}
+			if fmt.Sprint(versions) != fmt.Sprint(tt.versions) {
+				t.Errorf("versions = %v, want %v", versions, tt.versions)
+			}
+		})
+	}
+}
+
+func TestLoadPairsUpAndDown(t *testing.T) {
+	migrations, err := load(fstest.MapFS{
+		"1_first.up.sql":   {Data: []byte("CREATE TABLE t()")},
+		"1_first.down.sql": {Data: []byte("DROP TABLE t")},
+	})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := Migration{Version: 1, Name: "first", Up: "CREATE TABLE t()", Down: "DROP TABLE t"}
+	if len(migrations) != 1 || migrations[0] != want {
+		t.Errorf("migrations = %+v, want [%+v]", migrations, want)
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	m, err := New(nil, "test", fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "down"}} {
+		if err := m.Run(context.Background(), args, &bytes.Buffer{}); !errors.Is(err, ErrUnknownCommand) {
+			t.Errorf("Run(%q) = %v, want %v", args, err, ErrUnknownCommand)
+		}
+	}
+}
+
+// openPostgres connects to the database named by TEST_POSTGRES_DSN, the
+// tests needing one are skipped without it.
+func openPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testMigrations creates a table unique to the test. Applying the first
+// migration twice fails, as its CREATE TABLE has no IF NOT EXISTS.
+func testMigrations() (string, fstest.MapFS) {
+	table := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	return table, fstest.MapFS{
+		"1_create.up.sql":       {Data: []byte("CREATE TABLE " + table + "(id INT)")},
+		"1_create.down.sql":     {Data: []byte("DROP TABLE " + table)},
+		"2_add_name.up.sql":     {Data: []byte("ALTER TABLE " + table + " ADD COLUMN name TEXT")},
+		"2_add_name.down.sql":   {Data: []byte("ALTER TABLE " + table + " DROP COLUMN name")},
+		"3_empty_down.up.sql":   {Data: []byte("SELECT 1")},
+		"3_empty_down.down.sql": {Data: []byte("")},
+	}
+}
+
+func cleanup(t *testing.T, db *sql.DB, service, table string) {
+	t.Cleanup(func() {
+		db.Exec("DROP TABLE IF EXISTS " + table)
+		db.Exec("DELETE FROM schema_migrations WHERE service=$1", service)
+	})
+}
+
+func TestPostgresUpDownStatus(t *testing.T) {
+	db := openPostgres(t)
+	ctx := context.Background()
+	service := t.Name()
+	table, files := testMigrations()
+	cleanup(t, db, service, table)
+
+	m, err := New(db, service, files)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	// a second boot applies nothing
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	var out bytes.Buffer
+	if err := m.Run(ctx, []string{"status"}, &out); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if n := strings.Count(out.String(), "pending"); n != 0 {
+		t.Errorf("status reports %d pending migrations after Up:\n%s", n, out.String())
+	}
+
+	for i := 3; i > 0; i-- {
+		if err := m.Down(ctx); err != nil {
+			t.Fatalf("Down %d: %v", i, err)
+		}
+	}
+	if err := m.Down(ctx); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("Down with nothing applied = %v, want %v", err, ErrNoMigrations)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			t.Errorf("migration %d still applied after rolling everything back", s.Version)
+		}
+	}
+}
+
+func TestPostgresServicesAreSeparate(t *testing.T) {
+	db := openPostgres(t)
+	ctx := context.Background()
+	table, files := testMigrations()
+	a, b := t.Name()+"_a", t.Name()+"_b"
+	cleanup(t, db, a, table)
+	cleanup(t, db, b, table)
+
+	ma, err := New(db, a, files)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := ma.Up(ctx); err != nil {
+		t.Fatalf("Up as %s: %v", a, err)
+	}
+	mb, err := New(db, b, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// b applied nothing, a's rows must not count as its own
+	if err := mb.Down(ctx); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("Down as %s = %v, want %v", b, err, ErrNoMigrations)
+	}
+}
+
+func TestPostgresConcurrentUpAppliesOnce(t *testing.T) {
+	db := openPostgres(t)
+	ctx := context.Background()
+	service := t.Name()
+	table, files := testMigrations()
+	cleanup(t, db, service, table)
+
+	// replicas booting at the same time each run Up, the advisory lock
+	// must serialize them so that the CREATE TABLE runs once
+	const replicas = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, replicas)
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := New(db, service, files)
+			if err == nil {
+				err = m.Up(ctx)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Up: %v", err)
+		}
+	}
+
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM schema_migrations WHERE service=$1", service).Scan(&n)
+	if err != nil {
+		t.Fatalf("counting applied migrations: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("%d migrations recorded, want 3", n)
+	}
+}
@@ -0,0 +1,149 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenSource fetches the access tokens of a client with the
+// client_credentials grant and caches them until shortly before they
+// expire. It is safe for concurrent use, concurrent callers share a fetch.
+type TokenSource struct {
+	url      string
+	id       string
+	secret   string
+	audience string
+	scope    string
+	http     *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewTokenSource fetches tokens from the token endpoint at tokenURL, for
+// audience and the space separated scope, or the client's defaults when
+// empty.
+func NewTokenSource(tokenURL, clientID, secret, audience, scope string) *TokenSource {
+	return &TokenSource{
+		url:      tokenURL,
+		id:       clientID,
+		secret:   secret,
+		audience: audience,
+		scope:    scope,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LoadTokenSource returns a TokenSource for audience and scope with the
+// credentials of the oauth key: tokenurl, clientid and secret, which must
+// match a client of auth's oauth.clients.
+func LoadTokenSource(audience, scope string) (*TokenSource, error) {
+	var (
+		tokenURL = viper.GetString("oauth.tokenurl")
+		id       = viper.GetString("oauth.clientid")
+		secret   = viper.GetString("oauth.secret")
+	)
+	if tokenURL == "" || id == "" || secret == "" {
+		return nil, errors.New("oauth.tokenurl, oauth.clientid and oauth.secret are required")
+	}
+	return NewTokenSource(tokenURL, id, secret, audience, scope), nil
+}
+
+// Token returns a cached token, or fetches a new one.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expiry) {
+		return s.token, nil
+	}
+	resp, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	ttl := time.Duration(resp.ExpiresIn) * time.Second
+	// refresh a tenth early, so that no token expires on its way
+	s.token, s.expiry = resp.AccessToken, time.Now().Add(ttl-ttl/10)
+	return s.token, nil
+}
+
+func (s *TokenSource) fetch(ctx context.Context) (TokenResponse, error) {
+	form := url.Values{"grant_type": {GrantClientCredentials}}
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+	if s.scope != "" {
+		form.Set("scope", s.scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.id), url.QueryEscape(s.secret))
+	res, err := s.http.Do(req)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e Error
+		if json.NewDecoder(res.Body).Decode(&e) == nil && e.Code != "" {
+			return TokenResponse{}, &e
+		}
+		return TokenResponse{}, fmt.Errorf("token endpoint: %s", res.Status)
+	}
+	var resp TokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return TokenResponse{}, err
+	}
+	return resp, nil
+}
+
+// ClientMiddleware puts a token of src into the context of every call, for
+// ContextToHTTP or ContextToGRPC to attach. Calls fail when no token can
+// be had.
+func ClientMiddleware(src *TokenSource) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, err := src.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return next(NewContext(ctx, token), request)
+		}
+	}
+}
+
+// ContextToHTTP sets the Authorization header from the context.
+func ContextToHTTP() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if token, ok := FromContext(ctx); ok {
+			r.Header.Set("Authorization", TokenTypeBearer+" "+token)
+		}
+		return ctx
+	}
+}
+
+// ContextToGRPC sets the authorization metadata from the context.
+func ContextToGRPC() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if token, ok := FromContext(ctx); ok {
+			md.Set("authorization", TokenTypeBearer+" "+token)
+		}
+		return ctx
+	}
+}
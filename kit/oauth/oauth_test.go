@@ -0,0 +1,190 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/spf13/viper"
+)
+
+var testConfig = Config{
+	Issuer:   "auth",
+	Audience: "auth",
+	TTL:      time.Hour,
+	Clients: []Client{
+		{ID: "consumer", Secret: "s3cret", Audiences: []string{"mailer", "auth"}, Scopes: []string{"mail", "register"}},
+		{ID: "gateway", Secret: "other", Audiences: []string{"auth"}, Scopes: []string{"introspect"}},
+	},
+}
+
+func setSecret(t *testing.T) {
+	viper.Set("auth.secret", "test-secret")
+	t.Cleanup(viper.Reset)
+}
+
+func TestToken(t *testing.T) {
+	setSecret(t)
+	s := NewServer(testConfig)
+	tests := []struct {
+		name    string
+		req     TokenRequest
+		wantErr error
+	}{
+		{"granted", TokenRequest{GrantType: GrantClientCredentials, ClientID: "consumer", ClientSecret: "s3cret", Audience: "auth", Scope: "register"}, nil},
+		{"default audience and scopes", TokenRequest{GrantType: GrantClientCredentials, ClientID: "consumer", ClientSecret: "s3cret"}, nil},
+		{"wrong secret", TokenRequest{GrantType: GrantClientCredentials, ClientID: "consumer", ClientSecret: "guess"}, ErrInvalidClient},
+		{"unknown client", TokenRequest{GrantType: GrantClientCredentials, ClientID: "nobody", ClientSecret: "s3cret"}, ErrInvalidClient},
+		{"other grant", TokenRequest{GrantType: "password", ClientID: "consumer", ClientSecret: "s3cret"}, ErrUnsupportedGrantType},
+		{"audience not allowed", TokenRequest{GrantType: GrantClientCredentials, ClientID: "gateway", ClientSecret: "other", Audience: "mailer"}, ErrInvalidTarget},
+		{"scope not allowed", TokenRequest{GrantType: GrantClientCredentials, ClientID: "gateway", ClientSecret: "other", Scope: "register"}, ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Token(context.Background(), tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Token = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (resp.AccessToken == "" || resp.ExpiresIn != 3600) {
+				t.Errorf("Token = %+v, want a token valid an hour", resp)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	setSecret(t)
+	s := NewServer(testConfig)
+	token := func(client, secret, audience, scope string) string {
+		resp, err := s.Token(context.Background(), TokenRequest{
+			GrantType: GrantClientCredentials, ClientID: client, ClientSecret: secret, Audience: audience, Scope: scope,
+		})
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		return resp.AccessToken
+	}
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	mw := Middleware("mailer", "mail")(ok)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"granted", token("consumer", "s3cret", "mailer", "mail"), nil},
+		{"no token", "", ErrInvalidToken},
+		{"other audience", token("consumer", "s3cret", "auth", "mail"), ErrInvalidToken},
+		{"other scope", token("consumer", "s3cret", "mailer", "register"), ErrInsufficientScope},
+		{"forged", "eyJhbGciOiJub25lIn0.e30.", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = NewContext(ctx, tt.token)
+			}
+			if _, err := mw(ctx, nil); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Middleware = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tokenServer serves the token endpoint of testConfig, counting the
+// tokens issued.
+func tokenServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	setSecret(t)
+	var issued atomic.Int32
+	h := NewHTTPHandler(NewServer(testConfig), log.NewNopLogger())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued.Add(1)
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func TestTokenSourceCaches(t *testing.T) {
+	srv, issued := tokenServer(t)
+	src := NewTokenSource(srv.URL, "consumer", "s3cret", "mailer", "mail")
+	first, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if token, _ := src.Token(context.Background()); token != first {
+			t.Fatalf("call %d got another token", i)
+		}
+	}
+	if n := issued.Load(); n != 1 {
+		t.Errorf("%d tokens fetched, want 1", n)
+	}
+
+	// past the refresh point a new token is fetched
+	src.expiry = time.Now().Add(-time.Second)
+	if _, err := src.Token(context.Background()); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("%d tokens fetched after expiry, want 2", n)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	srv, _ := tokenServer(t)
+	src := NewTokenSource(srv.URL, "consumer", "guess", "mailer", "mail")
+	_, err := src.Token(context.Background())
+	var e *Error
+	if !errors.As(err, &e) || e.Code != ErrInvalidClient.Code {
+		t.Errorf("Token = %v, want %v", err, ErrInvalidClient)
+	}
+}
+
+// A call through the client middleware reaches the endpoint behind the
+// server middleware with its token.
+func TestClientMiddleware(t *testing.T) {
+	srv, _ := tokenServer(t)
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	server := Middleware("mailer", "mail")(ok)
+	// stands in for the transport, ContextToHTTP then HTTPToContext
+	wire := func(ctx context.Context, request interface{}) (interface{}, error) {
+		r := httptest.NewRequest(http.MethodPost, "/mail", nil)
+		ContextToHTTP()(ctx, r)
+		return server(HTTPToContext()(context.Background(), r), request)
+	}
+
+	src := NewTokenSource(srv.URL, "consumer", "s3cret", "mailer", "mail")
+	if _, err := ClientMiddleware(src)(wire)(context.Background(), nil); err != nil {
+		t.Errorf("call with a token: %v", err)
+	}
+	if _, err := wire(context.Background(), nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("call without a token = %v, want %v", err, ErrInvalidToken)
+	}
+	other := NewTokenSource(srv.URL, "consumer", "s3cret", "auth", "register")
+	if _, err := ClientMiddleware(other)(wire)(context.Background(), nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("call with a token for auth = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestLoadTokenSource(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	if _, err := LoadTokenSource("auth", "register"); err == nil {
+		t.Error("LoadTokenSource without credentials succeeded")
+	}
+	viper.Set("oauth.tokenurl", "http://auth:8081/oauth/token")
+	viper.Set("oauth.clientid", "consumer")
+	viper.Set("oauth.secret", "s3cret")
+	src, err := LoadTokenSource("auth", "register")
+	if err != nil {
+		t.Fatalf("LoadTokenSource: %v", err)
+	}
+	if src.id != "consumer" || src.audience != "auth" || src.scope != "register" {
+		t.Errorf("LoadTokenSource = %+v", src)
+	}
+}
@@ -0,0 +1,163 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"google.golang.org/grpc/metadata"
+)
+
+// NewHTTPHandler serves the token endpoint. Unlike the rest of auth it
+// answers in the formats of RFC 6749: form encoded requests, with the
+// client's credentials in HTTP Basic authentication or the body, and
+// errors as {"error", "error_description"}.
+func NewHTTPHandler(s *Server, logger log.Logger) http.Handler {
+	return httptransport.NewServer(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.Token(ctx, request.(TokenRequest))
+		},
+		decodeHTTPTokenRequest,
+		encodeHTTPTokenResponse,
+		httptransport.ServerErrorEncoder(encodeHTTPError),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	)
+}
+
+func decodeHTTPTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, ErrInvalidRequest
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, 1<<12)
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrInvalidRequest
+	}
+	// the spec forbids repeating parameters, and credentials in the query
+	for _, v := range r.PostForm {
+		if len(v) > 1 {
+			return nil, ErrInvalidRequest
+		}
+	}
+	if r.URL.Query().Has("client_secret") {
+		return nil, ErrInvalidRequest
+	}
+	req := TokenRequest{
+		GrantType: r.PostForm.Get("grant_type"),
+		Scope:     r.PostForm.Get("scope"),
+		Audience:  r.PostForm.Get("audience"),
+	}
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	} else {
+		req.ClientID, req.ClientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if req.GrantType == "" || req.ClientID == "" {
+		return nil, ErrInvalidRequest
+	}
+	return req, nil
+}
+
+func encodeHTTPTokenResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	noStore(w.Header())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
+
+func encodeHTTPError(_ context.Context, err error, w http.ResponseWriter) {
+	var e *Error
+	if !errors.As(err, &e) {
+		// the cause is logged by the error handler
+		e = &Error{Code: "server_error"}
+	}
+	status := http.StatusBadRequest
+	switch e.Code {
+	case ErrInvalidClient.Code:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+	case "server_error":
+		status = http.StatusInternalServerError
+	}
+	noStore(w.Header())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func noStore(h http.Header) {
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+}
+
+type tokenKey struct{}
+
+// NewContext returns a context carrying an access token, to be checked by
+// Middleware on the server or attached by ContextToHTTP on the client.
+func NewContext(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok && token != ""
+}
+
+// HTTPToContext moves the bearer token of the Authorization header into
+// the context.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, TokenTypeBearer) {
+			return NewContext(ctx, token)
+		}
+		return ctx
+	}
+}
+
+// GRPCToContext moves the bearer token of the authorization metadata into
+// the context.
+func GRPCToContext() grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return ctx
+		}
+		scheme, token, ok := strings.Cut(values[0], " ")
+		if ok && strings.EqualFold(scheme, TokenTypeBearer) {
+			return NewContext(ctx, token)
+		}
+		return ctx
+	}
+}
+
+// ErrInsufficientScope is returned for valid tokens lacking the scope of
+// an endpoint.
+var ErrInsufficientScope = errors.New("service token lacks the scope of this endpoint")
+
+// Middleware refuses requests without a token of a client issued for
+// audience with scope.
+func Middleware(audience, scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, ok := FromContext(ctx)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			claims, err := Verify(token, audience)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(claims.Scopes, scope) {
+				return nil, ErrInsufficientScope
+			}
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package problem is the error model shared by the services' transports,
+// encoded as RFC 7807 problem details.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of encoded problems.
+const ContentType = "application/problem+json"
+
+// Machine readable codes. Clients should switch on these rather than on the
+// status or the message.
+const (
+	CodeInvalidArgument  = "invalid_argument"
+	CodeUnauthenticated  = "unauthenticated"
+	CodePermissionDenied = "permission_denied"
+	CodeNotFound         = "not_found"
+	CodeAlreadyExists    = "already_exists"
+	CodeConflict         = "conflict"
+	CodeUnprocessable    = "unprocessable"
+	CodeTooLarge         = "too_large"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeBadGateway       = "bad_gateway"
+	CodeUnavailable      = "unavailable"
+	CodeTimeout          = "timeout"
+)
+
+// Problem is an error as seen by clients.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail is the human readable message.
+	Detail    string         `json:"detail,omitempty"`
+	Code      string         `json:"code"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	// Service names the service that reported the problem, it is kept when
+	// a problem is passed on by the services calling it.
+	Service string `json:"service,omitempty"`
+}
+
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Wrap returns a problem carrying err's message.
+func Wrap(status int, code string, err error) *Problem {
+	return New(status, code, err.Error())
+}
+
+// Status returns a problem with the code conventionally matching status.
+func Status(status int, detail string) *Problem {
+	return New(status, codeFor(status), detail)
+}
+
+// Invalid reports a request that could not be decoded.
+func Invalid(err error) *Problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return New(
+			http.StatusRequestEntityTooLarge,
+			CodeTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit),
+		)
+	}
+	return New(http.StatusBadRequest, CodeInvalidArgument, "malformed request: "+err.Error())
+}
+
+// Internal hides err from clients, it should be logged instead.
+func Internal() *Problem {
+	return New(http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// WithDetails returns a copy of p with extra details merged in.
+func (p *Problem) WithDetails(details map[string]any) *Problem {
+	next := *p
+	next.Details = make(map[string]any, len(p.Details)+len(details))
+	for k, v := range p.Details {
+		next.Details[k] = v
+	}
+	for k, v := range details {
+		next.Details[k] = v
+	}
+	return &next
+}
+
+// WithService returns a copy of p reported by service, unless another
+// service reported it first.
+func (p *Problem) WithService(service string) *Problem {
+	next := *p
+	if next.Service == "" {
+		next.Service = service
+	}
+	return &next
+}
+
+// Is matches problems by code, so that errors.Is(err, p) holds for a
+// problem decoded from another service.
+func (p *Problem) Is(target error) bool {
+	t, ok := target.(*Problem)
+	return ok && t.Code == p.Code
+}
+
+// From returns err as a problem when it is one, or when it is a context
+// error, and an internal problem otherwise.
+func From(err error) *Problem {
+	var p *Problem
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, CodeTimeout, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		// nginx's non standard code for clients closing the request
+		return New(499, CodeUnavailable, "request canceled")
+	}
+	return Internal()
+}
+
+// Write encodes p as the response.
+func Write(w http.ResponseWriter, p *Problem) error {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+// Decode reads the problem from a failed response. Bodies that are not
+// problems, such as the {"error": "..."} envelope older services return,
+// are turned into one from the status code.
+func Decode(r *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var p Problem
+	if json.Unmarshal(body, &p) == nil && p.Code != "" {
+		if p.Status == 0 {
+			p.Status = r.StatusCode
+		}
+		return &p
+	}
+
+	detail := strings.TrimSpace(string(body))
+	var legacy struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &legacy) == nil {
+		detail = legacy.Error
+	}
+	if detail == "" {
+		detail = r.Status
+	}
+	p = *Status(r.StatusCode, detail)
+	p.RequestID = r.Header.Get(RequestIDHeader)
+	return &p
+}
+
+// Marshal encodes p for transports without a native error model, such as
+// the err fields of the gRPC responses.
+func Marshal(p *Problem) string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return p.Error()
+	}
+	return string(b)
+}
+
+// Unmarshal is the inverse of Marshal, plain messages become internal
+// problems.
+func Unmarshal(s string) *Problem {
+	var p Problem
+	if json.Unmarshal([]byte(s), &p) == nil && p.Code != "" {
+		return &p
+	}
+	return New(http.StatusInternalServerError, CodeInternal, s)
+}
+
+func codeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusBadGateway:
+		return CodeBadGateway
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	return CodeInternal
+}
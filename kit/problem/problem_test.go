@@ -0,0 +1,129 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errTaken = errors.New("taken")
+
+func toProblem(err error) *Problem {
+	if errors.Is(err, errTaken) {
+		return New(http.StatusConflict, CodeAlreadyExists, err.Error())
+	}
+	return From(err)
+}
+
+func TestErrorEncoder(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantService string
+	}{
+		{"own error", errTaken, http.StatusConflict, CodeAlreadyExists, "auth"},
+		{"unknown error", errors.New("boom"), http.StatusInternalServerError, CodeInternal, "auth"},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout, "auth"},
+		{
+			name:        "passed on",
+			err:         &Problem{Status: http.StatusNotFound, Code: CodeNotFound, Service: "mailer"},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    CodeNotFound,
+			wantService: "mailer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ctx := WithRequestID(context.Background(), "req-1")
+			ErrorEncoder("auth", toProblem)(ctx, tt.err, rec)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+			}
+			var p Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatalf("decoding the problem: %v", err)
+			}
+			if p.Code != tt.wantCode || p.Service != tt.wantService || p.RequestID != "req-1" {
+				t.Errorf("problem = %+v, want code %s from %s with request req-1", p, tt.wantCode, tt.wantService)
+			}
+		})
+	}
+}
+
+func TestWithServiceCopies(t *testing.T) {
+	p := New(http.StatusNotFound, CodeNotFound, "no such user")
+	if got := p.WithService("auth"); got.Service != "auth" || p.Service != "" {
+		t.Errorf("WithService = %q, original = %q, want auth on the copy only", got.Service, p.Service)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantCode string
+		wantSvc  string
+		wantText string
+	}{
+		{
+			name:     "problem",
+			status:   http.StatusConflict,
+			body:     `{"status":409,"code":"already_exists","detail":"taken","service":"auth"}`,
+			wantCode: CodeAlreadyExists,
+			wantSvc:  "auth",
+			wantText: "taken",
+		},
+		{
+			name:     "legacy envelope",
+			status:   http.StatusBadRequest,
+			body:     `{"error":"bad email"}`,
+			wantCode: CodeInvalidArgument,
+			wantText: "bad email",
+		},
+		{
+			name:     "plain text",
+			status:   http.StatusServiceUnavailable,
+			body:     "try later\n",
+			wantCode: CodeUnavailable,
+			wantText: "try later",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(tt.status)
+			rec.WriteString(tt.body)
+			var p *Problem
+			if !errors.As(Decode(rec.Result()), &p) {
+				t.Fatal("Decode did not return a problem")
+			}
+			if p.Status != tt.status || p.Code != tt.wantCode || p.Service != tt.wantSvc ||
+				!strings.Contains(p.Detail, tt.wantText) {
+				t.Errorf("problem = %+v, want %d %s from %q about %q", p, tt.status, tt.wantCode, tt.wantSvc, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	p := New(http.StatusForbidden, CodePermissionDenied, "nope").WithService("chat")
+	if got := Unmarshal(Marshal(p)); !reflect.DeepEqual(got, p) {
+		t.Errorf("Unmarshal(Marshal(p)) = %+v, want %+v", got, p)
+	}
+	if got := Unmarshal("plain message"); got.Code != CodeInternal || got.Detail != "plain message" {
+		t.Errorf("Unmarshal of a plain message = %+v, want an internal problem", got)
+	}
+}
@@ -0,0 +1,54 @@
+package problem
+
+import (
+	"context"
+	"net/http"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// RequestIDHeader is set by the gateway on every request.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// HTTPToContext moves the request id from the request into the context.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if id := r.Header.Get(RequestIDHeader); id != "" {
+			return WithRequestID(ctx, id)
+		}
+		return ctx
+	}
+}
+
+// ContextToHTTP forwards the request id to downstream services.
+func ContextToHTTP() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if id := RequestIDFromContext(ctx); id != "" {
+			r.Header.Set(RequestIDHeader, id)
+		}
+		return ctx
+	}
+}
+
+// ErrorEncoder writes errors as problems reported by service, using
+// toProblem to map the service's sentinel errors.
+func ErrorEncoder(service string, toProblem func(error) *Problem) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		p := *toProblem(err).WithService(service)
+		if p.RequestID == "" {
+			p.RequestID = RequestIDFromContext(ctx)
+		}
+		Write(w, &p)
+	}
+}
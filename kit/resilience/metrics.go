@@ -0,0 +1,27 @@
+package resilience
+
+import (
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+var (
+	breakerState = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "resilience",
+		Name:      "breaker_state",
+		Help:      "Circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	}, []string{"name"})
+	breakerTransitions = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "resilience",
+		Name:      "breaker_transitions_total",
+		Help:      "Circuit breaker state changes.",
+	}, []string{"name", "from", "to"})
+)
+
+func observeStateChange(name string, from, to gobreaker.State) {
+	breakerState.With("name", name).Set(float64(to))
+	if from != to {
+		breakerTransitions.With("name", name, "from", from.String(), "to", to.String()).Add(1)
+	}
+}
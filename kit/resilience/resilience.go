@@ -0,0 +1,183 @@
+// Package resilience wraps endpoints with a circuit breaker, a per-call
+// timeout and jittered retries, configured per endpoint under the
+// "resilience" config key.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/go-kit/log"
+	"github.com/sony/gobreaker"
+	"github.com/spf13/viper"
+)
+
+type BreakerPolicy struct {
+	// MaxRequests is the number of trial calls let through while half-open.
+	MaxRequests uint32
+	// Interval is how often closed-state failure counts reset; 0 never resets.
+	Interval time.Duration
+	// Timeout is how long the breaker stays open before going half-open.
+	Timeout time.Duration
+	// ConsecutiveFailures trips the breaker; 0 never trips it.
+	ConsecutiveFailures uint32
+}
+
+type Policy struct {
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// Attempts is the total number of tries, so 1 disables retries.
+	Attempts int
+	// RetryTimeout bounds all attempts together, backoff included.
+	RetryTimeout time.Duration
+	// BackoffBase and BackoffMax bound the full-jitter exponential backoff
+	// between attempts.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	Breaker     BreakerPolicy
+}
+
+var DefaultPolicy = Policy{
+	Timeout:      5 * time.Second,
+	Attempts:     1,
+	RetryTimeout: 15 * time.Second,
+	BackoffBase:  100 * time.Millisecond,
+	BackoffMax:   2 * time.Second,
+	Breaker: BreakerPolicy{
+		MaxRequests:         1,
+		Interval:            time.Minute,
+		Timeout:             30 * time.Second,
+		ConsecutiveFailures: 5,
+	},
+}
+
+// Load returns the policy at resilience.<key>, e.g. "endpoints.login",
+// with unset fields taken from resilience.default and then DefaultPolicy.
+func Load(key string) Policy {
+	p := DefaultPolicy
+	for _, prefix := range []string{"resilience.default", "resilience." + key} {
+		sub := viper.Sub(prefix)
+		if sub == nil {
+			continue
+		}
+		if sub.IsSet("timeout") {
+			p.Timeout = sub.GetDuration("timeout")
+		}
+		if sub.IsSet("attempts") {
+			p.Attempts = sub.GetInt("attempts")
+		}
+		if sub.IsSet("retrytimeout") {
+			p.RetryTimeout = sub.GetDuration("retrytimeout")
+		}
+		if sub.IsSet("backoff.base") {
+			p.BackoffBase = sub.GetDuration("backoff.base")
+		}
+		if sub.IsSet("backoff.max") {
+			p.BackoffMax = sub.GetDuration("backoff.max")
+		}
+		if sub.IsSet("breaker.maxrequests") {
+			p.Breaker.MaxRequests = sub.GetUint32("breaker.maxrequests")
+		}
+		if sub.IsSet("breaker.interval") {
+			p.Breaker.Interval = sub.GetDuration("breaker.interval")
+		}
+		if sub.IsSet("breaker.timeout") {
+			p.Breaker.Timeout = sub.GetDuration("breaker.timeout")
+		}
+		if sub.IsSet("breaker.consecutivefailures") {
+			p.Breaker.ConsecutiveFailures = sub.GetUint32("breaker.consecutivefailures")
+		}
+	}
+	return p
+}
+
+// Middleware applies p to an endpoint named name. Each attempt runs through
+// the breaker with its own timeout; failed attempts are retried with
+// jittered backoff unless the breaker is open or the caller gave up.
+func Middleware(name string, p Policy, logger log.Logger) endpoint.Middleware {
+	breaker := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: p.Breaker.MaxRequests,
+		Interval:    p.Breaker.Interval,
+		Timeout:     p.Breaker.Timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return p.Breaker.ConsecutiveFailures > 0 &&
+				counts.ConsecutiveFailures >= p.Breaker.ConsecutiveFailures
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			logger.Log("breaker", name, "from", from, "to", to)
+			observeStateChange(name, from, to)
+		},
+	})
+	observeStateChange(name, gobreaker.StateClosed, gobreaker.StateClosed)
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		e := timeout(p.Timeout)(next)
+		e = circuitbreaker.Gobreaker(breaker)(e)
+		if p.Attempts <= 1 {
+			return e
+		}
+		retry := lb.RetryWithCallback(
+			p.RetryTimeout,
+			lb.NewRoundRobin(sd.FixedEndpointer{e}),
+			retryCallback(p),
+		)
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := retry(ctx, request)
+			// Surface the last attempt's error so that transports can keep
+			// matching on service errors.
+			var retryErr lb.RetryError
+			if errors.As(err, &retryErr) {
+				return response, retryErr.Final
+			}
+			return response, err
+		}
+	}
+}
+
+func timeout(d time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if d <= 0 {
+				return next(ctx, request)
+			}
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
+
+func retryCallback(p Policy) lb.Callback {
+	return func(n int, err error) (bool, error) {
+		if n >= p.Attempts || !retryable(err) {
+			return false, nil
+		}
+		time.Sleep(backoff(p, n))
+		return true, nil
+	}
+}
+
+func retryable(err error) bool {
+	return !errors.Is(err, gobreaker.ErrOpenState) &&
+		!errors.Is(err, gobreaker.ErrTooManyRequests) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// backoff returns a full-jitter delay before attempt n+1.
+func backoff(p Policy, n int) time.Duration {
+	ceiling := p.BackoffBase << (n - 1)
+	if ceiling <= 0 || ceiling > p.BackoffMax {
+		ceiling = p.BackoffMax
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
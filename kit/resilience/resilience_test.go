@@ -0,0 +1,194 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/sony/gobreaker"
+	"github.com/spf13/viper"
+)
+
+var errDown = errors.New("down")
+
+// flaky fails its first failures calls and then succeeds.
+type flaky struct {
+	failures int
+	calls    int
+}
+
+func (f *flaky) endpoint(context.Context, interface{}) (interface{}, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errDown
+	}
+	return "ok", nil
+}
+
+// quiet is a policy without delays, so that the tests run fast.
+func quiet(attempts int, failures uint32) Policy {
+	return Policy{
+		Attempts:     attempts,
+		RetryTimeout: time.Second,
+		Breaker: BreakerPolicy{
+			MaxRequests:         1,
+			Timeout:             time.Hour,
+			ConsecutiveFailures: failures,
+		},
+	}
+}
+
+func TestBreakerTrips(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures uint32
+		calls    int
+		// wantNext is how often the endpoint ran, wantOpen whether the
+		// last call was refused by the open breaker
+		wantNext int
+		wantOpen bool
+	}{
+		{"below threshold", 3, 3, 3, false},
+		{"at threshold", 3, 4, 3, true},
+		{"stays open", 3, 10, 3, true},
+		{"never trips", 0, 10, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flaky{failures: 1 << 30}
+			e := Middleware(t.Name(), quiet(1, tt.failures), log.NewNopLogger())(f.endpoint)
+			var err error
+			for i := 0; i < tt.calls; i++ {
+				_, err = e(context.Background(), nil)
+			}
+			if f.calls != tt.wantNext {
+				t.Errorf("endpoint ran %d times, want %d", f.calls, tt.wantNext)
+			}
+			if open := errors.Is(err, gobreaker.ErrOpenState); open != tt.wantOpen {
+				t.Errorf("last err = %v, open = %v, want %v", err, open, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	p := quiet(1, 1)
+	p.Breaker.Timeout = 10 * time.Millisecond
+	f := &flaky{failures: 1}
+	e := Middleware(t.Name(), p, log.NewNopLogger())(f.endpoint)
+
+	if _, err := e(context.Background(), nil); !errors.Is(err, errDown) {
+		t.Fatalf("first call err = %v, want %v", err, errDown)
+	}
+	if _, err := e(context.Background(), nil); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("call while open err = %v, want %v", err, gobreaker.ErrOpenState)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// the trial call succeeds and closes the breaker again
+	for i := 0; i < 3; i++ {
+		if _, err := e(context.Background(), nil); err != nil {
+			t.Fatalf("call %d after the timeout: %v", i, err)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		attempts, failures int
+		wantCalls          int
+		wantErr            error
+	}{
+		{attempts: 1, failures: 1, wantCalls: 1, wantErr: errDown},
+		{attempts: 3, failures: 2, wantCalls: 3},
+		{attempts: 3, failures: 5, wantCalls: 3, wantErr: errDown},
+		{attempts: 3, failures: 0, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d attempts %d failures", tt.attempts, tt.failures), func(t *testing.T) {
+			f := &flaky{failures: tt.failures}
+			e := Middleware(t.Name(), quiet(tt.attempts, 0), log.NewNopLogger())(f.endpoint)
+			_, err := e(context.Background(), nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			// the final error is unwrapped from lb.RetryError
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("err = %#v, want the endpoint's own error", err)
+			}
+			if f.calls != tt.wantCalls {
+				t.Errorf("endpoint ran %d times, want %d", f.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNoRetryWhenOpen(t *testing.T) {
+	f := &flaky{failures: 1 << 30}
+	e := Middleware(t.Name(), quiet(5, 2), log.NewNopLogger())(f.endpoint)
+	_, err := e(context.Background(), nil)
+	if !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Errorf("err = %v, want %v", err, gobreaker.ErrOpenState)
+	}
+	// two failures trip the breaker, the remaining attempts are not made
+	if f.calls != 2 {
+		t.Errorf("endpoint ran %d times, want 2", f.calls)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	p := quiet(1, 0)
+	p.Timeout = 10 * time.Millisecond
+	slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, err := Middleware(t.Name(), p, log.NewNopLogger())(slow)(context.Background(), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{BackoffBase: 100 * time.Millisecond, BackoffMax: time.Second}
+	for n, ceiling := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		70: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := backoff(p, n); d < 0 || d >= ceiling {
+				t.Fatalf("backoff(%d) = %v, want in [0, %v)", n, d, ceiling)
+			}
+		}
+	}
+	if d := backoff(Policy{}, 3); d != 0 {
+		t.Errorf("backoff without bounds = %v, want 0", d)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("resilience.default.timeout", "2s")
+	viper.Set("resilience.default.breaker.consecutivefailures", 3)
+	viper.Set("resilience.endpoints.login.timeout", "7s")
+	viper.Set("resilience.endpoints.login.attempts", 4)
+
+	got := Load("endpoints.login")
+	want := DefaultPolicy
+	want.Timeout = 7 * time.Second
+	want.Attempts = 4
+	want.Breaker.ConsecutiveFailures = 3
+	if got != want {
+		t.Errorf("Load(endpoints.login) = %+v, want %+v", got, want)
+	}
+
+	other := Load("endpoints.register")
+	if other.Timeout != 2*time.Second || other.Attempts != DefaultPolicy.Attempts {
+		t.Errorf("Load(endpoints.register) = %+v, want the defaults with a 2s timeout", other)
+	}
+}
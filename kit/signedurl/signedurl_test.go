@@ -0,0 +1,88 @@
+package signedurl
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	s := New("secret")
+	now := time.Unix(1_700_000_000, 0)
+	signed := s.Sign(http.MethodGet, "att-1", now.Add(time.Minute))
+	with := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range signed {
+			q[k] = append([]string(nil), v...)
+		}
+		q.Set(key, value)
+		return q
+	}
+
+	tests := []struct {
+		name     string
+		signer   Signer
+		method   string
+		resource string
+		query    url.Values
+		now      time.Time
+		wantErr  error
+	}{
+		{"valid", s, http.MethodGet, "att-1", signed, now, nil},
+		{"head as get", s, http.MethodHead, "att-1", signed, now, nil},
+		{"at expiry", s, http.MethodGet, "att-1", signed, now.Add(time.Minute), nil},
+		{"expired", s, http.MethodGet, "att-1", signed, now.Add(time.Minute + time.Second), ErrExpired},
+		{"other method", s, http.MethodPut, "att-1", signed, now, ErrInvalidSignature},
+		{"other resource", s, http.MethodGet, "att-2", signed, now, ErrInvalidSignature},
+		{"other secret", New("other"), http.MethodGet, "att-1", signed, now, ErrInvalidSignature},
+		{
+			name:     "extended expiry",
+			signer:   s,
+			method:   http.MethodGet,
+			resource: "att-1",
+			query:    with(ExpiresParam, "9999999999"),
+			now:      now,
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "tampered signature",
+			signer:   s,
+			method:   http.MethodGet,
+			resource: "att-1",
+			query:    with(SignatureParam, signed.Get(SignatureParam)[1:]+"A"),
+			now:      now,
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "expiry not a number",
+			signer:   s,
+			method:   http.MethodGet,
+			resource: "att-1",
+			query:    with(ExpiresParam, "soon"),
+			now:      now,
+			wantErr:  ErrInvalidSignature,
+		},
+		{"unsigned", s, http.MethodGet, "att-1", url.Values{}, now, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.signer.Verify(tt.method, tt.resource, tt.query, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// An expired url with a forged signature must not tell its holder that only
+// the expiry is wrong.
+func TestVerifyChecksSignatureFirst(t *testing.T) {
+	s := New("secret")
+	now := time.Unix(1_700_000_000, 0)
+	q := New("other").Sign(http.MethodGet, "att-1", now.Add(-time.Minute))
+	if err := s.Verify(http.MethodGet, "att-1", q, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify = %v, want %v", err, ErrInvalidSignature)
+	}
+}
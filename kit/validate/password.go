@@ -0,0 +1,121 @@
+package validate
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// commonBreached are the most common leaked passwords, refused by every
+// policy LoadPasswordPolicy returns.
+//
+//go:embed breached-passwords.txt
+var commonBreached string
+
+// PasswordPolicy is the set of rules passwords must follow. The zero value
+// only requires a password to be present.
+type PasswordPolicy struct {
+	MinLength     int  `mapstructure:"minlength"`
+	MaxLength     int  `mapstructure:"maxlength"`
+	RequireUpper  bool `mapstructure:"upper"`
+	RequireLower  bool `mapstructure:"lower"`
+	RequireDigit  bool `mapstructure:"digit"`
+	RequireSymbol bool `mapstructure:"symbol"`
+	// Breached holds known leaked passwords, lower cased.
+	Breached map[string]struct{} `mapstructure:"-"`
+}
+
+// LoadPasswordPolicy reads the password key. Passwords of the embedded list
+// of common leaked ones are refused regardless of the other rules, and
+// breachedlist names a file of further ones, one per line. Relative paths
+// are resolved against the config file's directory. Every service checking
+// passwords loads the same policy, so that what passes at sign up also
+// passes at auth.
+func LoadPasswordPolicy() (PasswordPolicy, error) {
+	var p PasswordPolicy
+	if err := viper.UnmarshalKey("password", &p); err != nil {
+		return PasswordPolicy{}, err
+	}
+	p.Breached = make(map[string]struct{})
+	if err := readBreached(strings.NewReader(commonBreached), p.Breached); err != nil {
+		return PasswordPolicy{}, err
+	}
+	if path := viper.GetString("password.breachedlist"); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), path)
+		}
+		if err := loadBreached(path, p.Breached); err != nil {
+			return PasswordPolicy{}, fmt.Errorf("loading breached passwords: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func loadBreached(path string, breached map[string]struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readBreached(f, breached)
+}
+
+func readBreached(r io.Reader, breached map[string]struct{}) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	return s.Err()
+}
+
+// check returns what is wrong with password, or an empty string.
+func (p PasswordPolicy) check(password string) string {
+	n := utf8.RuneCountInString(password)
+	switch {
+	case n < p.MinLength:
+		return fmt.Sprintf("must be at least %d characters", p.MinLength)
+	case p.MaxLength > 0 && n > p.MaxLength:
+		return fmt.Sprintf("must be at most %d characters", p.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r), unicode.IsSymbol(r), unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return "must contain an upper case letter"
+	case p.RequireLower && !lower:
+		return "must contain a lower case letter"
+	case p.RequireDigit && !digit:
+		return "must contain a digit"
+	case p.RequireSymbol && !symbol:
+		return "must contain a symbol"
+	}
+
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		return "has appeared in a data breach, choose another one"
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+// Package validate checks endpoint requests before they reach the service.
+//
+// Request types describe their rules by implementing Validatable:
+//
+//	func (r RegisterRequest) Validate(v *validate.Validator) {
+//		v.Email("email", r.Email)
+//		v.Password("password", r.Password)
+//	}
+//
+// and Middleware rejects requests breaking them with Errors, one message
+// per field.
+package validate
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+)
+
+// Validatable is implemented by requests that have rules.
+type Validatable interface {
+	Validate(v *Validator)
+}
+
+// Errors maps request fields to what is wrong with them.
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	msgs := make([]string, 0, len(e))
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+e[field])
+	}
+	return "invalid request: " + strings.Join(msgs, ", ")
+}
+
+// Validator collects the first violation of each field.
+type Validator struct {
+	policy PasswordPolicy
+	errs   Errors
+}
+
+func New(policy PasswordPolicy) *Validator {
+	return &Validator{policy: policy}
+}
+
+// Err returns the collected violations, or nil if there were none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Check records msg for field unless ok holds.
+func (v *Validator) Check(ok bool, field, msg string) {
+	if ok {
+		return
+	}
+	if v.errs == nil {
+		v.errs = make(Errors)
+	}
+	if _, exists := v.errs[field]; !exists {
+		v.errs[field] = msg
+	}
+}
+
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+func (v *Validator) MaxLen(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must be at most %d characters", n))
+}
+
+func (v *Validator) Email(field, value string) {
+	v.Required(field, value)
+	v.MaxLen(field, value, 254)
+	addr, err := mail.ParseAddress(value)
+	// ParseAddress accepts display names, "Bob <bob@x.y>" is not an email
+	v.Check(err == nil && addr.Address == value, field, "must be a valid email address")
+}
+
+func (v *Validator) UUID(field string, id uuid.UUID) {
+	v.Check(id != uuid.Nil, field, "is required")
+}
+
+// Password checks value against the password policy.
+func (v *Validator) Password(field, value string) {
+	v.Required(field, value)
+	if msg := v.policy.check(value); msg != "" {
+		v.Check(false, field, msg)
+	}
+}
+
+// Middleware validates requests implementing Validatable, passwords only
+// have to be present. It should wrap the other endpoint middlewares, so
+// that invalid requests neither count as breaker failures nor take an
+// idempotency key.
+func Middleware() endpoint.Middleware {
+	return PolicyMiddleware(PasswordPolicy{})
+}
+
+// PolicyMiddleware is Middleware checking passwords against policy. The
+// services taking new passwords from users enforce it, the others pass
+// them on and only require them.
+func PolicyMiddleware(policy PasswordPolicy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if r, ok := request.(Validatable); ok {
+				v := New(policy)
+				r.Validate(v)
+				if err := v.Err(); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:    8,
+		MaxLength:    12,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+		Breached:     map[string]struct{}{"password1a": {}},
+	}
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"Secret123", false},
+		{"Sh0rt", true},
+		{"MuchTooLong1234", true},
+		{"nouppercase1", true},
+		{"NOLOWERCASE1", true},
+		{"NoDigitsHere", true},
+		{"Password1a", true},
+		{"Pässwort123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			v := New(policy)
+			v.Password("password", tt.password)
+			if err := v.Err(); (err != nil) != tt.wantErr {
+				t.Errorf("Err() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Common leaked passwords are refused by every loaded policy, breachedlist
+// adds to them.
+func TestLoadPasswordPolicy(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "more.txt"), []byte("# ours\nCompany2024\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(filepath.Join(dir, "config.yml"))
+	viper.Set("password.minlength", 8)
+	viper.Set("password.breachedlist", "more.txt")
+
+	policy, err := LoadPasswordPolicy()
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy: %v", err)
+	}
+	for _, password := range []string{"Password123", "company2024"} {
+		v := New(policy)
+		v.Password("password", password)
+		if v.Err() == nil {
+			t.Errorf("%s passed the policy", password)
+		}
+	}
+}
+
+type registerRequest struct {
+	Email, Password string
+}
+
+func (r registerRequest) Validate(v *Validator) {
+	v.Email("email", r.Email)
+	v.Password("password", r.Password)
+}
+
+func TestMiddleware(t *testing.T) {
+	next := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	strict := PasswordPolicy{MinLength: 8}
+	tests := []struct {
+		name       string
+		req        interface{}
+		wantFields []string
+	}{
+		{
+			name: "valid",
+			req:  registerRequest{Email: "a@example.com", Password: "secret"},
+		},
+		{
+			name:       "every field reported",
+			req:        registerRequest{Email: "Bob <a@example.com>"},
+			wantFields: []string{"email", "password"},
+		},
+		{
+			name: "not validatable",
+			req:  struct{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Middleware()(next)(context.Background(), tt.req)
+			var errs Errors
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("err = %v, want none", err)
+				}
+				return
+			}
+			if !errors.As(err, &errs) || len(errs) != len(tt.wantFields) {
+				t.Fatalf("err = %v, want errors for %v", err, tt.wantFields)
+			}
+			for _, field := range tt.wantFields {
+				if _, ok := errs[field]; !ok {
+					t.Errorf("no error for %s in %v", field, errs)
+				}
+			}
+		})
+	}
+
+	t.Run("policy only when passed", func(t *testing.T) {
+		req := registerRequest{Email: "a@example.com", Password: "short"}
+		if _, err := Middleware()(next)(context.Background(), req); err != nil {
+			t.Errorf("Middleware refused a present password: %v", err)
+		}
+		if _, err := PolicyMiddleware(strict)(next)(context.Background(), req); err == nil {
+			t.Error("PolicyMiddleware accepted a password breaking the policy")
+		}
+	})
+}
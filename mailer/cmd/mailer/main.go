@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/log"
 	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 
+	"github.com/F1zm0n/consume_mail/authclient"
+	"github.com/F1zm0n/consume_mail/migrations"
+	"github.com/F1zm0n/consume_mail/repository"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/resilience"
 	"github.com/F1zm0n/universal-mailer/pkg/mailendpoint"
 	"github.com/F1zm0n/universal-mailer/pkg/mailservice"
 	"github.com/F1zm0n/universal-mailer/pkg/mailtransport"
@@ -19,18 +28,67 @@ var configPaths = []string{"../mailer/config", "/app/config/"}
 func main() {
 	parseConfig(configPaths)
 	httpAddr := ":5001"
+	debugAddr := viper.GetString("listen.debug.port")
+	db := repository.MustNewPostgresDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.Run(context.Background(), db, os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	var logger log.Logger
 	{
 		logger = log.NewLogfmtLogger(os.Stderr)
 		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
+	if err := migrations.Up(context.Background(), db); err != nil {
+		logger.Log("during", "migrate", "err", err)
+		os.Exit(1)
+	}
+	tokens, err := authclient.NewTokenSource()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+	register, err := authclient.NewRegisterEndpoint(viper.GetString("auth.addr"), tokens)
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+	register = resilience.Middleware(
+		"client.register",
+		resilience.Load("clients.register"),
+		logger,
+	)(register)
+
+	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 	var (
-		service     = mailservice.New(logger)
-		endpoints   = mailendpoint.New(service, logger)
+		service   = mailservice.New(logger, repository.NewPostgresRepository(db), register)
+		endpoints = mailendpoint.New(
+			service,
+			logger,
+			func(scope string) endpoint.Middleware {
+				return oauth.Middleware(viper.GetString("oauth.audience"), scope)
+			},
+		)
 		httpHandler = mailtransport.NewHTTPHandler(endpoints, logger)
 	)
 	var g group.Group
+	{
+		debugListener, err := net.Listen("tcp", ":"+debugAddr)
+		if err != nil {
+			logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+		g.Add(func() error {
+			logger.Log("transport", "debug/HTTP", "addr", ":"+debugAddr)
+			return http.Serve(debugListener, http.DefaultServeMux)
+		}, func(error) {
+			debugListener.Close()
+		})
+	}
 	{
 		// The HTTP listener mounts the Go kit HTTP handler we created.
 		httpListener, err := net.Listen("tcp", httpAddr)
@@ -3,12 +3,12 @@ package mailendpoint
 import (
 	"context"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/log"
 	"github.com/google/uuid"
-	"github.com/sony/gobreaker"
 
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 	"github.com/F1zm0n/universal-mailer/pkg/mailservice"
 )
 
@@ -17,25 +17,43 @@ type Set struct {
 	VerifyEndpoint endpoint.Endpoint
 }
 
-func New(svc mailservice.Service, logger log.Logger) Set {
+// New returns the endpoints of svc, internal returning the middleware that
+// lets only services holding scope through.
+func New(
+	svc mailservice.Service,
+	logger log.Logger,
+	internal func(scope string) endpoint.Middleware,
+) Set {
+	// passwords were held to the policy by the producer already
+	validation := validate.Middleware()
+
 	var emailEndpoint endpoint.Endpoint
 	{
 		emailEndpoint = MakeEmailEndpoint(svc)
-		emailEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		emailEndpoint = resilience.Middleware(
+			"email",
+			resilience.Load("endpoints.email"),
+			logger,
 		)(
 			emailEndpoint,
 		)
+		emailEndpoint = validation(emailEndpoint)
+		// only the consumer queues verification mails
+		emailEndpoint = internal("mail")(emailEndpoint)
 		emailEndpoint = LoggingMiddleware(logger)(emailEndpoint)
 	}
 	var verifyEndpoint endpoint.Endpoint
 	{
 		verifyEndpoint = MakeVerifyEndpoint(svc)
-		verifyEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		verifyEndpoint = resilience.Middleware(
+			"verify",
+			resilience.Load("endpoints.verify"),
+			logger,
 		)(
 			verifyEndpoint,
 		)
+		verifyEndpoint = validation(verifyEndpoint)
+		verifyEndpoint = internal("mail")(verifyEndpoint)
 		verifyEndpoint = LoggingMiddleware(logger)(verifyEndpoint)
 	}
 	return Set{
@@ -91,8 +109,14 @@ type EmailRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+func (r EmailRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Required("password", r.Password)
+}
+
 type EmailResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
 }
 
 // Failed implements endpoint.Failer.
@@ -105,10 +129,14 @@ type (
 		VerId uuid.UUID `json:"ver_id"`
 	}
 	VerifyResponse struct {
-		Err error `json:"error"`
+		Err error `json:"-"`
 	}
 )
 
+func (r VerifyRequest) Validate(v *validate.Validator) {
+	v.UUID("ver_id", r.VerId)
+}
+
 // Failed implements endpoint.Failer.
 func (v VerifyResponse) Failed() error {
 	return v.Err
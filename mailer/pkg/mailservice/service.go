@@ -1,25 +1,26 @@
 package mailservice
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/smtp"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/log"
 	"github.com/google/uuid"
 	"github.com/jordan-wright/email"
 	"github.com/spf13/viper"
 
-	"github.com/F1zm0n/universal-mailer/repository"
+	"github.com/F1zm0n/consume_mail/authclient"
+	"github.com/F1zm0n/consume_mail/repository"
 )
 
-func New(log log.Logger) Service {
+// New returns the service, registering verified users with register, an
+// authclient register endpoint.
+func New(log log.Logger, db repository.Repository, register endpoint.Endpoint) Service {
 	var svc Service
 	{
-		svc = NewBaseService()
+		svc = NewBaseService(db, register)
 		svc = LoggingMiddleware(log)(svc)
 		svc = InstrumentingMiddleware()(svc)
 	}
@@ -32,8 +33,9 @@ type Service interface {
 }
 
 type baseService struct {
-	mailer MailSender
-	db     repository.Repository
+	mailer   MailSender
+	db       repository.Repository
+	register endpoint.Endpoint
 }
 
 type VerDto struct {
@@ -41,62 +43,20 @@ type VerDto struct {
 	Email    string    `json:"email"`
 	Password string    `json:"password"`
 }
-type AuthPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
 
-func NewBaseService() Service {
-	db := repository.NewPostgresRepository()
+func NewBaseService(db repository.Repository, register endpoint.Endpoint) Service {
 	mailer := NewGmailSender()
 	return &baseService{
-		db:     db,
-		mailer: mailer,
+		db:       db,
+		mailer:   mailer,
+		register: register,
 	}
 }
 
+// VerifyMail registers the user behind a verification link, see
+// authclient.Verify.
 func (s baseService) VerifyMail(ctx context.Context, id uuid.UUID) error {
-	user, err := s.db.GetByVerId(id)
-	if err != nil {
-		return err
-	}
-	// authLink := fmt.Sprintf("%s%s",
-	// 	viper.GetString("req.auth.addres"),
-	// 	viper.GetString("req.auth.create"),
-	// )
-	payload := AuthPayload{
-		Email:    user.Email,
-		Password: user.Password,
-	}
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequest(
-		http.MethodPost,
-		"http://auth:5000/register",
-		bytes.NewReader(b),
-	)
-	if err != nil {
-		return err
-	}
-	tx, err := s.db.DeleteByVerId(id)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer tx.Commit()
-	cli := http.DefaultClient
-	res, err := cli.Do(req)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if res.StatusCode != 200 {
-		tx.Rollback()
-		return fmt.Errorf("error status code is not 200:%s", res.Status)
-	}
-	return nil
+	return authclient.Verify(ctx, s.db, s.register, id)
 }
 
 func (s baseService) SendEmail(ctx context.Context, ver VerDto) error {
@@ -105,37 +65,29 @@ func (s baseService) SendEmail(ctx context.Context, ver VerDto) error {
 		Email:    ver.Email,
 		Password: ver.Password,
 	}
-	tx, err := s.db.CreateLink(user)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return s.db.WithTx(ctx, func(repo repository.Repository) error {
+		if err := repo.CreateLink(ctx, user); err != nil {
+			return err
+		}
 
-	subject := "verify your email addres"
-	// content := fmt.Sprintf(`
-	// 	<h1>verify your email</h1>
-	// 	<p>Link<a href="http://%s%s?id=%v">Verify</a></p>
-	// `, viper.GetString("req.http.mailer.addres"),
-	// 	viper.GetString("req.http.mailer.verify"),
-	// 	user.VerId,
-	// )
-	content := fmt.Sprintf(`
+		subject := "verify your email addres"
+		// content := fmt.Sprintf(`
+		// 	<h1>verify your email</h1>
+		// 	<p>Link<a href="http://%s%s?id=%v">Verify</a></p>
+		// `, viper.GetString("req.http.mailer.addres"),
+		// 	viper.GetString("req.http.mailer.verify"),
+		// 	user.VerId,
+		// )
+		content := fmt.Sprintf(`
 		<h1>verify your email</h1>
 		<p>Link<a href="http://%s%s?id=%v">Verify</a></p>
 		`, "localhost:5002",
-		"/verify",
-		user.VerId,
-	)
-	to := []string{user.Email}
-	err = s.mailer.SendMail(subject, content, to, nil, nil, nil)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-	return nil
+			"/verify",
+			user.VerId,
+		)
+		to := []string{user.Email}
+		return s.mailer.SendMail(subject, content, to, nil, nil, nil)
+	})
 }
 
 const (
@@ -9,15 +9,18 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
 	"github.com/sony/gobreaker"
 
+	"github.com/F1zm0n/consume_mail/repository"
+	"github.com/F1zm0n/universal-kit/oauth"
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 	"github.com/F1zm0n/universal-mailer/pkg/mailendpoint"
 	"github.com/F1zm0n/universal-mailer/pkg/mailservice"
 )
@@ -26,6 +29,7 @@ func NewHTTPHandler(endpoints mailendpoint.Set, logger log.Logger) http.Handler
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(problem.HTTPToContext(), oauth.HTTPToContext()),
 	}
 	m := http.NewServeMux()
 	m.Handle("/mail", httptransport.NewServer(
@@ -43,7 +47,9 @@ func NewHTTPHandler(endpoints mailendpoint.Set, logger log.Logger) http.Handler
 	return m
 }
 
-func NewHTTPClient(instance string, logger log.Logger) (mailservice.Service, error) {
+// NewHTTPClient calls the mailer at instance, with access tokens of tokens
+// for the mail scope.
+func NewHTTPClient(instance string, tokens *oauth.TokenSource, logger log.Logger) (mailservice.Service, error) {
 	if !strings.HasPrefix(instance, "http") {
 		instance = "http://" + instance
 	}
@@ -52,7 +58,10 @@ func NewHTTPClient(instance string, logger log.Logger) (mailservice.Service, err
 		return nil, err
 	}
 
-	var options []httptransport.ClientOption
+	options := []httptransport.ClientOption{
+		httptransport.ClientBefore(problem.ContextToHTTP(), oauth.ContextToHTTP()),
+	}
+
 	var emailEndpoint endpoint.Endpoint
 	{
 		emailEndpoint = httptransport.NewClient(
@@ -63,10 +72,12 @@ func NewHTTPClient(instance string, logger log.Logger) (mailservice.Service, err
 			options...,
 		).Endpoint()
 
-		emailEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Mail",
-			Timeout: 30 * time.Second,
-		}))(emailEndpoint)
+		emailEndpoint = resilience.Middleware(
+			"client.email",
+			resilience.Load("clients.email"),
+			logger,
+		)(emailEndpoint)
+		emailEndpoint = oauth.ClientMiddleware(tokens)(emailEndpoint)
 	}
 	var verifyEndpoint endpoint.Endpoint
 	{
@@ -77,11 +88,12 @@ func NewHTTPClient(instance string, logger log.Logger) (mailservice.Service, err
 			decodeHTTPVerifyResponse,
 			options...,
 		).Endpoint()
-		verifyEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Verify",
-			Timeout: 30 * time.Second,
-		}))(verifyEndpoint)
-
+		verifyEndpoint = resilience.Middleware(
+			"client.verify",
+			resilience.Load("clients.verify"),
+			logger,
+		)(verifyEndpoint)
+		verifyEndpoint = oauth.ClientMiddleware(tokens)(verifyEndpoint)
 	}
 	return mailendpoint.Set{
 		EmailEndpoint:  emailEndpoint,
@@ -89,15 +101,31 @@ func NewHTTPClient(instance string, logger log.Logger) (mailservice.Service, err
 	}, nil
 }
 
+// maxBodyBytes bounds request bodies, the requests here are a few fields.
+const maxBodyBytes = 1 << 16
+
+// decodeJSON decodes a request body, refusing unknown fields and bodies
+// over maxBodyBytes.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.Invalid(err)
+	}
+	return nil
+}
+
 func decodeHTTPEmailRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req mailendpoint.EmailRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func decodeHTTPEmailResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp mailendpoint.EmailResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
@@ -106,7 +134,7 @@ func decodeHTTPEmailResponse(_ context.Context, r *http.Response) (interface{},
 
 func decodeHTTPVerifyResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp mailendpoint.VerifyResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
@@ -124,8 +152,10 @@ func decodeHTTPVerifyRequest(_ context.Context, r *http.Request) (interface{}, e
 	// 	VerId: verId,
 	// }
 	var req mailendpoint.VerifyRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func copyURL(base *url.URL, path string) *url.URL {
@@ -134,25 +164,29 @@ func copyURL(base *url.URL, path string) *url.URL {
 	return &next
 }
 
-func err2code(_ error) int {
-	return http.StatusInternalServerError
-}
-
-func errorDecoder(r *http.Response) error {
-	var w errorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
-		return err
+// serviceName is reported in the problems of this service.
+const serviceName = "mailer"
+
+var errorEncoder = problem.ErrorEncoder(serviceName, err2problem)
+
+func err2problem(err error) *problem.Problem {
+	var fields validate.Errors
+	switch {
+	case errors.As(err, &fields):
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidArgument, "request validation failed").
+			WithDetails(map[string]any{"fields": fields})
+	case errors.Is(err, oauth.ErrInvalidToken):
+		return problem.Wrap(http.StatusUnauthorized, problem.CodeUnauthenticated, err)
+	case errors.Is(err, oauth.ErrInsufficientScope):
+		return problem.Wrap(http.StatusForbidden, problem.CodePermissionDenied, err)
+	case errors.Is(err, repository.ErrNotFound):
+		return problem.Wrap(http.StatusNotFound, problem.CodeNotFound, err)
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return problem.Wrap(http.StatusConflict, problem.CodeAlreadyExists, err)
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return problem.Wrap(http.StatusServiceUnavailable, problem.CodeUnavailable, err)
 	}
-	return errors.New(w.Error)
-}
-
-type errorWrapper struct {
-	Error string `json:"error"`
-}
-
-func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
-	w.WriteHeader(err2code(err))
-	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+	return problem.From(err)
 }
 
 // encodeHTTPGenericRequest is a transport/http.EncodeRequestFunc that
@@ -10,8 +10,11 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/validate"
 	"github.com/F1zm0n/universal-producer/pkg/prodendpoint"
 	"github.com/F1zm0n/universal-producer/pkg/prodservice"
 	"github.com/F1zm0n/universal-producer/pkg/prodtransport"
@@ -22,7 +25,10 @@ var configPaths = []string{"../producer/config", "/app/config/"}
 
 func main() {
 	parseConfig(configPaths)
-	httpAddr := viper.GetString("listen.http.port")
+	var (
+		httpAddr  = viper.GetString("listen.http.port")
+		debugAddr = viper.GetString("listen.debug.port")
+	)
 
 	var logger log.Logger
 	{
@@ -31,13 +37,34 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	policy, err := validate.LoadPasswordPolicy()
+	if err != nil {
+		logger.Log("during", "config", "err", err)
+		os.Exit(1)
+	}
+
+	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 	var (
 		service     = prodservice.New(logger)
-		endpoint    = prodendpoint.New(service, logger)
+		idemStore   = idempotency.NewMemoryStore(viper.GetDuration("idempotency.ttl"))
+		endpoint    = prodendpoint.New(service, logger, idemStore, policy)
 		httpHandler = prodtransport.NewHTTPHandler(endpoint, logger)
 	)
 
 	var g group.Group
+	{
+		debugListener, err := net.Listen("tcp", ":"+debugAddr)
+		if err != nil {
+			logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+		g.Add(func() error {
+			logger.Log("transport", "debug/HTTP", "addr", ":"+debugAddr)
+			return http.Serve(debugListener, http.DefaultServeMux)
+		}, func(error) {
+			debugListener.Close()
+		})
+	}
 	{
 		httpListener, err := net.Listen("tcp", ":"+httpAddr)
 		if err != nil {
@@ -2,59 +2,143 @@ package prodendpoint
 
 import (
 	"context"
+	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/log"
 	"github.com/google/uuid"
-	"github.com/sony/gobreaker"
 
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 	"github.com/F1zm0n/universal-producer/pkg/prodservice"
 )
 
 type Set struct {
-	MailEndpoint     endpoint.Endpoint
-	RegisterEndpoint endpoint.Endpoint
-	VerEndpoint      endpoint.Endpoint
+	MailEndpoint      endpoint.Endpoint
+	RegisterEndpoint  endpoint.Endpoint
+	VerEndpoint       endpoint.Endpoint
+	ResetEndpoint     endpoint.Endpoint
+	NewDeviceEndpoint endpoint.Endpoint
+	MagicLinkEndpoint endpoint.Endpoint
 }
 
-func New(svc prodservice.Service, logger log.Logger) Set {
+func New(
+	svc prodservice.Service,
+	logger log.Logger,
+	idem idempotency.Store,
+	policy validate.PasswordPolicy,
+) Set {
 	var mailEndpoint endpoint.Endpoint
 	{
 		mailEndpoint = MakeMailEndpoint(svc)
-		mailEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		mailEndpoint = idempotency.Middleware[MailResponse](
+			idem,
+			"mail",
 		)(
 			mailEndpoint,
 		)
+		mailEndpoint = resilience.Middleware(
+			"mail",
+			resilience.Load("endpoints.mail"),
+			logger,
+		)(
+			mailEndpoint,
+		)
+		// sign up enters here, refuse what auth would refuse on
+		// verification while the user can still fix it
+		mailEndpoint = validate.PolicyMiddleware(policy)(mailEndpoint)
 		mailEndpoint = LoggingMiddleware(logger)(mailEndpoint)
 	}
 
 	var registerEndpoint endpoint.Endpoint
 	{
 		registerEndpoint = MakeRegisterEndpoint(svc)
-		registerEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		registerEndpoint = idempotency.Middleware[RegisterResponse](
+			idem,
+			"register",
+		)(
+			registerEndpoint,
+		)
+		registerEndpoint = resilience.Middleware(
+			"register",
+			resilience.Load("endpoints.register"),
+			logger,
 		)(
 			registerEndpoint,
 		)
+		registerEndpoint = validate.PolicyMiddleware(policy)(registerEndpoint)
 		registerEndpoint = LoggingMiddleware(logger)(registerEndpoint)
 	}
 
 	var verEndpoint endpoint.Endpoint
 	{
 		verEndpoint = MakeVerEndpoint(svc)
-		verEndpoint = circuitbreaker.Gobreaker(
-			gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		verEndpoint = idempotency.Middleware[VerResponse](
+			idem,
+			"verify",
 		)(
 			verEndpoint,
 		)
+		verEndpoint = resilience.Middleware(
+			"verify",
+			resilience.Load("endpoints.verify"),
+			logger,
+		)(
+			verEndpoint,
+		)
+		verEndpoint = validate.Middleware()(verEndpoint)
 		verEndpoint = LoggingMiddleware(logger)(verEndpoint)
 	}
+
+	var resetEndpoint endpoint.Endpoint
+	{
+		resetEndpoint = MakeResetEndpoint(svc)
+		resetEndpoint = resilience.Middleware(
+			"reset",
+			resilience.Load("endpoints.reset"),
+			logger,
+		)(
+			resetEndpoint,
+		)
+		resetEndpoint = validate.Middleware()(resetEndpoint)
+		resetEndpoint = LoggingMiddleware(logger)(resetEndpoint)
+	}
+
+	var newDeviceEndpoint endpoint.Endpoint
+	{
+		newDeviceEndpoint = MakeNewDeviceEndpoint(svc)
+		newDeviceEndpoint = resilience.Middleware(
+			"newdevice",
+			resilience.Load("endpoints.newdevice"),
+			logger,
+		)(
+			newDeviceEndpoint,
+		)
+		newDeviceEndpoint = validate.Middleware()(newDeviceEndpoint)
+		newDeviceEndpoint = LoggingMiddleware(logger)(newDeviceEndpoint)
+	}
+
+	var magicLinkEndpoint endpoint.Endpoint
+	{
+		magicLinkEndpoint = MakeMagicLinkEndpoint(svc)
+		magicLinkEndpoint = resilience.Middleware(
+			"magiclink",
+			resilience.Load("endpoints.magiclink"),
+			logger,
+		)(
+			magicLinkEndpoint,
+		)
+		magicLinkEndpoint = validate.Middleware()(magicLinkEndpoint)
+		magicLinkEndpoint = LoggingMiddleware(logger)(magicLinkEndpoint)
+	}
 	return Set{
-		MailEndpoint:     mailEndpoint,
-		RegisterEndpoint: registerEndpoint,
-		VerEndpoint:      verEndpoint,
+		MailEndpoint:      mailEndpoint,
+		RegisterEndpoint:  registerEndpoint,
+		VerEndpoint:       verEndpoint,
+		ResetEndpoint:     resetEndpoint,
+		NewDeviceEndpoint: newDeviceEndpoint,
+		MagicLinkEndpoint: magicLinkEndpoint,
 	}
 }
 
@@ -77,7 +161,7 @@ func (s Set) ProduceVer(ctx context.Context, verId uuid.UUID) error {
 }
 
 func (s Set) ProduceRegister(ctx context.Context, password, email string) error {
-	resp, err := s.MailEndpoint(ctx, RegisterRequest{Email: email, Password: password})
+	resp, err := s.RegisterEndpoint(ctx, RegisterRequest{Email: email, Password: password})
 	if err != nil {
 		return err
 	}
@@ -101,6 +185,57 @@ func MakeVerEndpoint(s prodservice.Service) endpoint.Endpoint {
 	}
 }
 
+func (s Set) ProduceReset(ctx context.Context, email, token string) error {
+	resp, err := s.ResetEndpoint(ctx, ResetRequest{Email: email, Token: token})
+	if err != nil {
+		return err
+	}
+	response := resp.(ResetResponse)
+	return response.Err
+}
+
+func MakeResetEndpoint(s prodservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ResetRequest)
+		err = s.ProduceReset(ctx, req.Email, req.Token)
+		return ResetResponse{Err: err}, nil
+	}
+}
+
+func (s Set) ProduceMagicLink(ctx context.Context, email, token string) error {
+	resp, err := s.MagicLinkEndpoint(ctx, MagicLinkRequest{Email: email, Token: token})
+	if err != nil {
+		return err
+	}
+	response := resp.(MagicLinkResponse)
+	return response.Err
+}
+
+func MakeMagicLinkEndpoint(s prodservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(MagicLinkRequest)
+		err = s.ProduceMagicLink(ctx, req.Email, req.Token)
+		return MagicLinkResponse{Err: err}, nil
+	}
+}
+
+func (s Set) ProduceNewDevice(ctx context.Context, login prodservice.NewDevicePayload) error {
+	resp, err := s.NewDeviceEndpoint(ctx, NewDeviceRequest(login))
+	if err != nil {
+		return err
+	}
+	response := resp.(NewDeviceResponse)
+	return response.Err
+}
+
+func MakeNewDeviceEndpoint(s prodservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(NewDeviceRequest)
+		err = s.ProduceNewDevice(ctx, prodservice.NewDevicePayload(req))
+		return NewDeviceResponse{Err: err}, nil
+	}
+}
+
 func MakeRegisterEndpoint(s prodservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(RegisterRequest)
@@ -114,28 +249,102 @@ type MailRequest struct {
 	Password string `json:"password"`
 }
 
+// IdempotencyFingerprint leaves the password out of the stored hash.
+func (r MailRequest) IdempotencyFingerprint() interface{} {
+	return r.Email
+}
+
+func (r MailRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Password("password", r.Password)
+}
+
 type MailResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
 }
 type RegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+// IdempotencyFingerprint leaves the password out of the stored hash.
+func (r RegisterRequest) IdempotencyFingerprint() interface{} {
+	return r.Email
+}
+
+func (r RegisterRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Password("password", r.Password)
+}
+
 type RegisterResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
 }
 
 type VerRequest struct {
 	VerId uuid.UUID `json:"ver_id"`
 }
+
+func (r VerRequest) Validate(v *validate.Validator) {
+	v.UUID("ver_id", r.VerId)
+}
+
 type VerResponse struct {
-	Err error `json:"error"`
+	Err error `json:"-"`
+}
+
+type ResetRequest struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+func (r ResetRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Required("token", r.Token)
+}
+
+type ResetResponse struct {
+	Err error `json:"-"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+func (r MagicLinkRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Required("token", r.Token)
+}
+
+type MagicLinkResponse struct {
+	Err error `json:"-"`
+}
+
+type NewDeviceRequest struct {
+	Email     string    `json:"email"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	At        time.Time `json:"at"`
+}
+
+func (r NewDeviceRequest) Validate(v *validate.Validator) {
+	v.Email("email", r.Email)
+	v.Required("device", r.Device)
+}
+
+type NewDeviceResponse struct {
+	Err error `json:"-"`
 }
 
 var (
 	_ endpoint.Failer = MailResponse{}
 	_ endpoint.Failer = RegisterResponse{}
 	_ endpoint.Failer = VerResponse{}
+	_ endpoint.Failer = ResetResponse{}
+	_ endpoint.Failer = NewDeviceResponse{}
+	_ endpoint.Failer = MagicLinkResponse{}
 )
 
 func (r RegisterResponse) Failed() error {
@@ -149,3 +358,15 @@ func (v VerResponse) Failed() error {
 func (m MailResponse) Failed() error {
 	return m.Err
 }
+
+func (r ResetResponse) Failed() error {
+	return r.Err
+}
+
+func (r NewDeviceResponse) Failed() error {
+	return r.Err
+}
+
+func (r MagicLinkResponse) Failed() error {
+	return r.Err
+}
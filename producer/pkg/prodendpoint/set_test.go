@@ -0,0 +1,51 @@
+package prodendpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/validate"
+	"github.com/F1zm0n/universal-producer/pkg/prodservice"
+)
+
+// queue is a Service recording the sign ups it queued, the other methods
+// panic on the nil embedded Service.
+type queue struct {
+	prodservice.Service
+	mails []string
+}
+
+func (q *queue) ProduceMail(_ context.Context, email, _ string) error {
+	q.mails = append(q.mails, email)
+	return nil
+}
+
+// Sign up refuses passwords auth would refuse once the email is verified,
+// while the user can still pick another one.
+func TestMailEnforcesPasswordPolicy(t *testing.T) {
+	q := &queue{}
+	policy := validate.PasswordPolicy{MinLength: 8, RequireDigit: true}
+	set := New(q, log.NewNopLogger(), idempotency.NewMemoryStore(time.Hour), policy)
+	ctx := context.Background()
+
+	_, err := set.MailEndpoint(ctx, MailRequest{Email: "a@example.com", Password: "weak"})
+	var errs validate.Errors
+	if !errors.As(err, &errs) || errs["password"] == "" {
+		t.Fatalf("weak password = %v, want a password field error", err)
+	}
+	if len(q.mails) != 0 {
+		t.Errorf("a weak sign up was queued: %v", q.mails)
+	}
+
+	if _, err := set.MailEndpoint(ctx, MailRequest{Email: "a@example.com", Password: "str0nger"}); err != nil {
+		t.Fatalf("MailEndpoint: %v", err)
+	}
+	if len(q.mails) != 1 {
+		t.Errorf("%d sign ups queued, want 1", len(q.mails))
+	}
+}
@@ -78,6 +78,65 @@ func (mw loggingMiddleware) ProduceRegister(
 	return mw.next.ProduceRegister(ctx, email, password)
 }
 
+func (mw loggingMiddleware) ProduceReset(
+	ctx context.Context,
+	email, token string,
+) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"method",
+			"ProduceReset",
+			"email",
+			email,
+			"took",
+			time.Since(start),
+			"err",
+			err,
+		)
+	}(time.Now())
+	return mw.next.ProduceReset(ctx, email, token)
+}
+
+func (mw loggingMiddleware) ProduceMagicLink(
+	ctx context.Context,
+	email, token string,
+) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"method",
+			"ProduceMagicLink",
+			"email",
+			email,
+			"took",
+			time.Since(start),
+			"err",
+			err,
+		)
+	}(time.Now())
+	return mw.next.ProduceMagicLink(ctx, email, token)
+}
+
+func (mw loggingMiddleware) ProduceNewDevice(
+	ctx context.Context,
+	login NewDevicePayload,
+) (err error) {
+	defer func(start time.Time) {
+		mw.log.Log(
+			"method",
+			"ProduceNewDevice",
+			"email",
+			login.Email,
+			"device",
+			login.Device,
+			"took",
+			time.Since(start),
+			"err",
+			err,
+		)
+	}(time.Now())
+	return mw.next.ProduceNewDevice(ctx, login)
+}
+
 type instrumentingMiddleware struct {
 	next Service
 }
@@ -110,3 +169,24 @@ func (mw instrumentingMiddleware) ProduceVer(
 ) (err error) {
 	return mw.next.ProduceVer(ctx, verId)
 }
+
+func (mw instrumentingMiddleware) ProduceReset(
+	ctx context.Context,
+	email, token string,
+) (err error) {
+	return mw.next.ProduceReset(ctx, email, token)
+}
+
+func (mw instrumentingMiddleware) ProduceMagicLink(
+	ctx context.Context,
+	email, token string,
+) (err error) {
+	return mw.next.ProduceMagicLink(ctx, email, token)
+}
+
+func (mw instrumentingMiddleware) ProduceNewDevice(
+	ctx context.Context,
+	login NewDevicePayload,
+) (err error) {
+	return mw.next.ProduceNewDevice(ctx, login)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/go-kit/log"
@@ -18,6 +19,20 @@ type EmailPayload struct {
 type VerifyPayload struct {
 	VerId uuid.UUID `json:"ver_id"`
 }
+type ResetPayload struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+// NewDevicePayload is a login from a device its user had not logged in
+// from before.
+type NewDevicePayload struct {
+	Email     string    `json:"email"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	At        time.Time `json:"at"`
+}
 
 func New(logger log.Logger) Service {
 	var svc Service
@@ -36,6 +51,13 @@ type Service interface {
 	ProduceMail(ctx context.Context, email, password string) error
 	ProduceVer(ctx context.Context, verId uuid.UUID) error
 	ProduceRegister(ctx context.Context, email, password string) error
+	// ProduceReset queues a password reset mail carrying token.
+	ProduceReset(ctx context.Context, email, token string) error
+	// ProduceMagicLink queues a login link mail carrying token.
+	ProduceMagicLink(ctx context.Context, email, token string) error
+	// ProduceNewDevice queues a security notice about a login from a new
+	// device.
+	ProduceNewDevice(ctx context.Context, login NewDevicePayload) error
 }
 
 type kafkaService struct {
@@ -92,10 +114,47 @@ func (s kafkaService) ProduceRegister(ctx context.Context, email, password strin
 	// todo: make the thing with key
 }
 
+func (s kafkaService) ProduceReset(ctx context.Context, email, token string) error {
+	data := ResetPayload{
+		Email: email,
+		Token: token,
+	}
+	j, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.produceData(ctx, "reset", j)
+}
+
+func (s kafkaService) ProduceMagicLink(ctx context.Context, email, token string) error {
+	data := ResetPayload{
+		Email: email,
+		Token: token,
+	}
+	j, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.produceData(ctx, "magiclink", j)
+}
+
+func (s kafkaService) ProduceNewDevice(ctx context.Context, login NewDevicePayload) error {
+	j, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	return s.produceData(ctx, "newdevice", j)
+}
+
+// produceData publishes data to topic. Every message gets a unique
+// message-id header that consumers use to skip redeliveries.
 func (s kafkaService) produceData(_ context.Context, topic string, data []byte) error {
 	err := s.conn.Produce(&kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
 		Value:          data,
+		Headers: []kafka.Header{
+			{Key: "message-id", Value: []byte(uuid.NewString())},
+		},
 	}, nil)
 	if err != nil {
 		return ErrProducingToKafka
@@ -9,15 +9,18 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
+	"github.com/google/uuid"
 	"github.com/sony/gobreaker"
 
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-kit/problem"
+	"github.com/F1zm0n/universal-kit/resilience"
+	"github.com/F1zm0n/universal-kit/validate"
 	"github.com/F1zm0n/universal-producer/pkg/prodendpoint"
 	"github.com/F1zm0n/universal-producer/pkg/prodservice"
 )
@@ -26,6 +29,7 @@ func NewHTTPHandler(endpoints prodendpoint.Set, logger log.Logger) http.Handler
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(idempotency.HTTPToContext(), problem.HTTPToContext()),
 	}
 	m := http.NewServeMux()
 	m.Handle("/mail", httptransport.NewServer(
@@ -46,6 +50,24 @@ func NewHTTPHandler(endpoints prodendpoint.Set, logger log.Logger) http.Handler
 		encodeHTTPGenericResponse,
 		options...,
 	))
+	m.Handle("/reset", httptransport.NewServer(
+		endpoints.ResetEndpoint,
+		decodeHTTPResetRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("/newdevice", httptransport.NewServer(
+		endpoints.NewDeviceEndpoint,
+		decodeHTTPNewDeviceRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	m.Handle("/magiclink", httptransport.NewServer(
+		endpoints.MagicLinkEndpoint,
+		decodeHTTPMagicLinkRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
 	return m
 }
 
@@ -58,7 +80,9 @@ func NewHTTPClient(instance string, logger log.Logger) (prodservice.Service, err
 		return nil, err
 	}
 
-	var options []httptransport.ClientOption
+	options := []httptransport.ClientOption{
+		httptransport.ClientBefore(idempotency.ContextToHTTP(), problem.ContextToHTTP()),
+	}
 
 	var mailEndpoint endpoint.Endpoint
 	{
@@ -69,10 +93,11 @@ func NewHTTPClient(instance string, logger log.Logger) (prodservice.Service, err
 			decodeHTTPMailResponse,
 			options...,
 		).Endpoint()
-		mailEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Mail",
-			Timeout: 30 * time.Second,
-		}))(mailEndpoint)
+		mailEndpoint = resilience.Middleware(
+			"client.mail",
+			resilience.Load("clients.mail"),
+			logger,
+		)(mailEndpoint)
 	}
 
 	var registerEndpoint endpoint.Endpoint
@@ -84,10 +109,11 @@ func NewHTTPClient(instance string, logger log.Logger) (prodservice.Service, err
 			decodeHTTPRegisterResponse,
 			options...,
 		).Endpoint()
-		registerEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Register",
-			Timeout: 30 * time.Second,
-		}))(registerEndpoint)
+		registerEndpoint = resilience.Middleware(
+			"client.register",
+			resilience.Load("clients.register"),
+			logger,
+		)(registerEndpoint)
 	}
 
 	var verEndpoint endpoint.Endpoint
@@ -99,13 +125,67 @@ func NewHTTPClient(instance string, logger log.Logger) (prodservice.Service, err
 			decodeHTTPVerResponse,
 			options...,
 		).Endpoint()
-		verEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Verify",
-			Timeout: 30 * time.Second,
-		}))(verEndpoint)
+		verEndpoint = resilience.Middleware(
+			"client.verify",
+			resilience.Load("clients.verify"),
+			logger,
+		)(verEndpoint)
+	}
+
+	var resetEndpoint endpoint.Endpoint
+	{
+		resetEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, `/reset`),
+			encodeHTTPGenericRequest,
+			decodeHTTPResetResponse,
+			options...,
+		).Endpoint()
+		resetEndpoint = resilience.Middleware(
+			"client.reset",
+			resilience.Load("clients.reset"),
+			logger,
+		)(resetEndpoint)
+	}
+
+	var newDeviceEndpoint endpoint.Endpoint
+	{
+		newDeviceEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, `/newdevice`),
+			encodeHTTPGenericRequest,
+			decodeHTTPNewDeviceResponse,
+			options...,
+		).Endpoint()
+		newDeviceEndpoint = resilience.Middleware(
+			"client.newdevice",
+			resilience.Load("clients.newdevice"),
+			logger,
+		)(newDeviceEndpoint)
+	}
+
+	var magicLinkEndpoint endpoint.Endpoint
+	{
+		magicLinkEndpoint = httptransport.NewClient(
+			http.MethodPost,
+			copyURL(u, `/magiclink`),
+			encodeHTTPGenericRequest,
+			decodeHTTPMagicLinkResponse,
+			options...,
+		).Endpoint()
+		magicLinkEndpoint = resilience.Middleware(
+			"client.magiclink",
+			resilience.Load("clients.magiclink"),
+			logger,
+		)(magicLinkEndpoint)
 	}
 	return prodendpoint.Set{
-		MailEndpoint: mailEndpoint,
+		MailEndpoint:      mailEndpoint,
+		RegisterEndpoint:  registerEndpoint,
+		VerEndpoint:       verEndpoint,
+		ResetEndpoint:     resetEndpoint,
+		NewDeviceEndpoint: newDeviceEndpoint,
+		MagicLinkEndpoint: magicLinkEndpoint,
 	}, nil
 }
 
@@ -115,40 +195,54 @@ func copyURL(base *url.URL, path string) *url.URL {
 	return &next
 }
 
-func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
-	w.WriteHeader(err2code(err))
-	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
-}
+// serviceName is reported in the problems of this service.
+const serviceName = "producer"
 
-func err2code(err error) int {
-	switch err {
-	case prodservice.ErrProducingToKafka:
-		return http.StatusInternalServerError
-	}
-	return http.StatusInternalServerError
-}
+var errorEncoder = problem.ErrorEncoder(serviceName, err2problem)
 
-func errorDecoder(r *http.Response) error {
-	var w errorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
-		return err
+func err2problem(err error) *problem.Problem {
+	var fields validate.Errors
+	switch {
+	case errors.As(err, &fields):
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidArgument, "request validation failed").
+			WithDetails(map[string]any{"fields": fields})
+	case errors.Is(err, idempotency.ErrInFlight):
+		return problem.Wrap(http.StatusConflict, problem.CodeConflict, err)
+	case errors.Is(err, idempotency.ErrKeyMismatch):
+		return problem.Wrap(http.StatusUnprocessableEntity, problem.CodeUnprocessable, err)
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return problem.Wrap(http.StatusServiceUnavailable, problem.CodeUnavailable, err)
 	}
-	return errors.New(w.Error)
+	// ErrProducingToKafka and anything unexpected end up as internal
+	// problems without leaking the cause.
+	return problem.From(err)
 }
 
-type errorWrapper struct {
-	Error string `json:"error"`
+// maxBodyBytes bounds request bodies, the requests here are a few fields.
+const maxBodyBytes = 1 << 16
+
+// decodeJSON decodes a request body, refusing unknown fields and bodies
+// over maxBodyBytes.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.Invalid(err)
+	}
+	return nil
 }
 
 func decodeHTTPMailRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req prodendpoint.MailRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func decodeHTTPMailResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp prodendpoint.MailResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
@@ -158,13 +252,15 @@ func decodeHTTPMailResponse(_ context.Context, r *http.Response) (interface{}, e
 
 func decodeHTTPRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req prodendpoint.RegisterRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func decodeHTTPRegisterResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp prodendpoint.RegisterResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
@@ -174,13 +270,24 @@ func decodeHTTPRegisterResponse(_ context.Context, r *http.Response) (interface{
 
 func decodeHTTPVerRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req prodendpoint.VerRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	// links in verification mails are plain GETs carrying the id in the query
+	if id := r.URL.Query().Get("id"); id != "" {
+		verId, err := uuid.Parse(id)
+		if err != nil {
+			return nil, problem.Invalid(err)
+		}
+		req.VerId = verId
+		return req, nil
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func decodeHTTPVerResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, problem.Decode(r)
 	}
 	var resp prodendpoint.VerResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
@@ -188,6 +295,60 @@ func decodeHTTPVerResponse(_ context.Context, r *http.Response) (interface{}, er
 	return resp, err
 }
 
+func decodeHTTPResetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req prodendpoint.ResetRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPResetResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp prodendpoint.ResetResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+
+	return resp, err
+}
+
+func decodeHTTPNewDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req prodendpoint.NewDeviceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPNewDeviceResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp prodendpoint.NewDeviceResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+
+	return resp, err
+}
+
+func decodeHTTPMagicLinkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req prodendpoint.MagicLinkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHTTPMagicLinkResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, problem.Decode(r)
+	}
+	var resp prodendpoint.MagicLinkResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+
+	return resp, err
+}
+
 func encodeHTTPGenericRequest(_ context.Context, r *http.Request, request interface{}) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(request); err != nil {
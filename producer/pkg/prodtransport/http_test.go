@@ -0,0 +1,32 @@
+package prodtransport
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sony/gobreaker"
+
+	"github.com/F1zm0n/universal-kit/idempotency"
+	"github.com/F1zm0n/universal-producer/pkg/prodservice"
+)
+
+func TestErr2Problem(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{idempotency.ErrInFlight, http.StatusConflict},
+		{idempotency.ErrKeyMismatch, http.StatusUnprocessableEntity},
+		{gobreaker.ErrOpenState, http.StatusServiceUnavailable},
+		{prodservice.ErrProducingToKafka, http.StatusInternalServerError},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := err2problem(tt.err).Status; got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}